@@ -0,0 +1,237 @@
+package DHTCrawl
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errNoPool is returned by the calls which need the fetch pool on a
+// crawler without one, as the one of NewStoreServer.
+var errNoPool = errors.New("the crawler has no fetch pool")
+
+type (
+	// adminStatus is returned by every admin call, it shows the state the
+	// call left the crawler in.
+	adminStatus struct {
+		Paused     bool    `json:"paused"`
+		Workers    int     `json:"workers"`
+		MinWorkers int     `json:"min_workers,omitempty"` //set when the pool scales
+		MaxWorkers int     `json:"max_workers,omitempty"`
+		FetchRate  float64 `json:"fetch_rate"`
+		FetchBurst int     `json:"fetch_burst"`
+	}
+
+	workersRequest struct {
+		Size int `json:"size"`
+		Min  int `json:"min"`
+		Max  int `json:"max"`
+	}
+
+	rateRequest struct {
+		Rate  float64 `json:"rate"`
+		Burst int     `json:"burst"`
+	}
+)
+
+// Pause stops discovering new hashes on every node. The fetches already
+// queued go on, so do the retries of the refetcher.
+func (c *Crawler) Pause() {
+	for _, node := range c.Nodes {
+		node.Pause()
+	}
+}
+
+func (c *Crawler) Resume() {
+	for _, node := range c.Nodes {
+		node.Resume()
+	}
+}
+
+func (c *Crawler) Paused() bool {
+	for _, node := range c.Nodes {
+		if !node.Paused() {
+			return false
+		}
+	}
+	return len(c.Nodes) > 0
+}
+
+// SetWorkers resizes the pool, or changes the bounds of the scaler when the
+// pool scales. The running config is updated, a later reload of the config
+// file overrides it.
+func (c *Crawler) SetWorkers(size, min, max int) error {
+	if c.Pool == nil {
+		return errNoPool
+	}
+	if c.Scaler != nil {
+		if max <= 0 {
+			return errors.New("the pool scales, set min and max")
+		}
+		c.Scaler.SetBounds(min, max)
+		min, max = c.Scaler.Bounds()
+		c.updateConfig(func(cfg *DHTConfig) { cfg.MinJobSize, cfg.MaxJobSize = min, max })
+		return nil
+	}
+	if size <= 0 {
+		return errors.New("size must be positive")
+	}
+	c.Pool.Resize(size)
+	c.updateConfig(func(cfg *DHTConfig) { cfg.JobSize = size })
+	return nil
+}
+
+func (c *Crawler) SetFetchRate(rate float64, burst int) error {
+	if c.Pool == nil {
+		return errNoPool
+	}
+	if rate < 0 || burst < 0 {
+		return errors.New("rate and burst can't be negative")
+	}
+	c.Pool.Limiter.SetRate(rate, burst)
+	c.updateConfig(func(cfg *DHTConfig) { cfg.FetchRate, cfg.FetchBurst = rate, burst })
+	return nil
+}
+
+// FlushSinks writes out what the buffering sinks hold, the first error is
+// returned.
+func (c *Crawler) FlushSinks() (err error) {
+	c.mu.Lock()
+	sinks := c.Sinks
+	c.mu.Unlock()
+	for _, s := range sinks {
+		if f, ok := s.(Flusher); ok {
+			if e := f.Flush(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return
+}
+
+// ResetCaches forgets the announced peers, the failed hashes waiting for a
+// retry and the pieces of the partial downloads.
+func (c *Crawler) ResetCaches() {
+	if c.Pool == nil {
+		return
+	}
+	if c.Pool.Peers != nil {
+		c.Pool.Peers.Reset()
+	}
+	if c.Pool.Refetch != nil {
+		c.Pool.Refetch.Reset()
+	}
+	if partials := c.Pool.Partials(); partials != nil {
+		partials.Reset()
+	}
+}
+
+// SaveState writes the routing tables to StatePath now. The pending jobs are
+// only saved by Shutdown, once the pool has stopped.
+func (c *Crawler) SaveState() error {
+	if c.StatePath == "" {
+		return errors.New("the crawler has no state_path")
+	}
+	return saveState(c.StatePath, c.tables(), nil)
+}
+
+func (c *Crawler) adminStatus() adminStatus {
+	cfg := c.config()
+	st := adminStatus{Paused: c.Paused(), FetchRate: cfg.FetchRate, FetchBurst: cfg.FetchBurst}
+	if c.Pool != nil {
+		st.Workers, _ = c.Pool.Workers()
+	}
+	if c.Scaler != nil {
+		st.MinWorkers, st.MaxWorkers = c.Scaler.Bounds()
+	}
+	return st
+}
+
+// authorized checks the bearer token of r against token and answers 401
+// when it doesn't match.
+func authorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	return false
+}
+
+// adminHandler serves the /admin/ calls. They are disabled until the config
+// has an admin_token, which every call must then send as a bearer token.
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/status", s.handleAdmin(func(r *http.Request) error { return nil }))
+	mux.HandleFunc("POST /admin/pause", s.handleAdmin(func(r *http.Request) error {
+		s.Crawler.Pause()
+		return nil
+	}))
+	mux.HandleFunc("POST /admin/resume", s.handleAdmin(func(r *http.Request) error {
+		s.Crawler.Resume()
+		return nil
+	}))
+	mux.HandleFunc("POST /admin/workers", s.handleAdmin(func(r *http.Request) error {
+		req := workersRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		return s.Crawler.SetWorkers(req.Size, req.Min, req.Max)
+	}))
+	mux.HandleFunc("POST /admin/rate", s.handleAdmin(func(r *http.Request) error {
+		req := rateRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return err
+		}
+		return s.Crawler.SetFetchRate(req.Rate, req.Burst)
+	}))
+	mux.HandleFunc("POST /admin/flush", s.handleAdmin(func(r *http.Request) error {
+		return s.Crawler.FlushSinks()
+	}))
+	mux.HandleFunc("DELETE /admin/caches", s.handleAdmin(func(r *http.Request) error {
+		s.Crawler.ResetCaches()
+		return nil
+	}))
+	mux.HandleFunc("POST /admin/save", s.handleAdmin(func(r *http.Request) error {
+		return s.Crawler.SaveState()
+	}))
+	mux.HandleFunc("GET /admin/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if s.Crawler.Pool == nil {
+			writeError(w, http.StatusServiceUnavailable, errNoPool)
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="dhtcrawl-snapshot.tar.gz"`)
+		if err := s.Crawler.Snapshot(w); err != nil {
+			logServer.Warn("snapshot failed", "error", err)
+		}
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := s.Crawler.config().AdminToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if authorized(w, r, token) {
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
+
+// handleAdmin answers the admin status after fn, or 400 with its error, 503
+// when the crawler has no pool.
+func (s *Server) handleAdmin(fn func(*http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := fn(r); errors.Is(err, errNoPool) {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Crawler.adminStatus())
+	}
+}
@@ -0,0 +1,106 @@
+package DHTCrawl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Admin(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.AdminToken = "secret"
+	path := filepath.Join(t.TempDir(), "state.json")
+	c, err := NewCrawler(WithConfig(cfg), WithPort(0), WithNodes(2), WithWorkers(2), WithBootstraps(), WithStatePath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		c.Shutdown(ctx)
+	}()
+	go c.Run()
+	s := NewServer(c, "")
+	call := func(method, url, token, body string) (int, adminStatus) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.Mux.ServeHTTP(w, r)
+		st := adminStatus{}
+		json.Unmarshal(w.Body.Bytes(), &st)
+		return w.Code, st
+	}
+
+	if code, _ := call("POST", "/admin/pause", "", ""); code != 401 {
+		t.Error("no token", code)
+	}
+	if code, st := call("POST", "/admin/pause", "secret", ""); code != 200 || !st.Paused || !c.Nodes[1].Paused() {
+		t.Error("pause", code, st)
+	}
+	if code, st := call("POST", "/admin/resume", "secret", ""); code != 200 || st.Paused {
+		t.Error("resume", code, st)
+	}
+	if code, st := call("POST", "/admin/workers", "secret", `{"size":4}`); code != 200 || st.Workers != 4 || c.config().JobSize != 4 {
+		t.Error("workers", code, st)
+	}
+	if code, _ := call("POST", "/admin/workers", "secret", `{"size":0}`); code != 400 {
+		t.Error("zero workers", code)
+	}
+	if code, st := call("POST", "/admin/rate", "secret", `{"rate":20,"burst":40}`); code != 200 || st.FetchRate != 20 || c.Pool.Limiter.Rate() != 20 {
+		t.Error("rate", code, st)
+	}
+
+	c.Pool.Peers.Add(Hash(NewNodeIDFromHex(strings.Repeat("AB", 20))), &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 6881})
+	if code, _ := call("DELETE", "/admin/caches", "secret", ""); code != 200 || c.Pool.Peers.Len() != 0 {
+		t.Error("caches", code)
+	}
+	if code, _ := call("POST", "/admin/flush", "secret", ""); code != 200 {
+		t.Error("flush", code)
+	}
+	if code, _ := call("POST", "/admin/save", "secret", ""); code != 200 {
+		t.Error("save", code)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("state not saved", err)
+	}
+
+	c.updateConfig(func(cfg *DHTConfig) { cfg.AdminToken = "" })
+	if code, _ := call("GET", "/admin/status", "secret", ""); code != 404 {
+		t.Error("disabled", code)
+	}
+}
+
+func Test_AdminStoreServer(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.AdminToken = "secret"
+	s, err := NewStoreServer(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		method, url, body string
+		code              int
+	}{
+		{"GET", "/admin/status", "", 200},
+		{"POST", "/admin/workers", `{"size":4}`, 503},
+		{"POST", "/admin/rate", `{"rate":20}`, 503},
+		{"DELETE", "/admin/caches", "", 200},
+		{"GET", "/admin/snapshot", "", 503},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		r.Header.Set("Authorization", "Bearer secret")
+		s.Mux.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Error(c.method, c.url, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The defaults of AnnounceConfig.
+const (
+	DefaultAnnounceRate    = 1
+	DefaultAnnounceTimeout = 30
+)
+
+type (
+	// AnnounceConfig turns the announce mode on: the crawler announces
+	// itself as a peer of the hashes it starts fetching, on the port of the
+	// listener, so their swarms connect to it. Rare torrents whose announcing
+	// peer is gone can still be fetched from the peers which come.
+	AnnounceConfig struct {
+		Port    int     `json:"port"` //announced instead of the port of listen.addr, behind a port forward
+		Rate    float64 `json:"rate"` //lookups started per second, 0 is DefaultAnnounceRate
+		Burst   int     `json:"burst"`
+		Timeout int     `json:"timeout"` //seconds of a lookup, the announces are sent once it ends, 0 is DefaultAnnounceTimeout
+	}
+
+	// AnnounceStats counts the lookups of an Announcer.
+	AnnounceStats struct {
+		Started uint64 `json:"started"`
+		Limited uint64 `json:"limited"` //hashes not announced for the rate
+		Peers   uint64 `json:"peers"`   //found by the lookups and handed to the downloads
+	}
+
+	// Announcer runs an AnnouncePeer lookup for the hashes handed to it,
+	// one at a time per hash. The peers the lookups find join the running
+	// downloads of the pool.
+	Announcer struct {
+		Pool    *WireJob
+		Entries []string //where the lookups start
+		Port    int
+		Timeout time.Duration
+		Limiter *Limiter
+
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		active map[Hash]bool
+		stats  AnnounceStats
+	}
+)
+
+// NewAnnouncer applies the defaults of cfg, the announced port is cfg.Port,
+// or the one of the listen address when it is 0.
+func NewAnnouncer(pool *WireJob, entries []string, listen string, cfg *AnnounceConfig) *Announcer {
+	port := cfg.Port
+	if port == 0 {
+		_, p, _ := net.SplitHostPort(listen)
+		port, _ = strconv.Atoi(p)
+	}
+	rate, timeout := cfg.Rate, cfg.Timeout
+	if rate == 0 {
+		rate = DefaultAnnounceRate
+	}
+	if timeout == 0 {
+		timeout = DefaultAnnounceTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Announcer{
+		Pool:    pool,
+		Entries: entries,
+		Port:    port,
+		Timeout: time.Duration(timeout) * time.Second,
+		Limiter: NewLimiter(rate, cfg.Burst),
+		ctx:     ctx,
+		cancel:  cancel,
+		active:  map[Hash]bool{},
+	}
+}
+
+// Announce starts the lookup of hash unless one is running or the rate is
+// exceeded, it doesn't block.
+func (a *Announcer) Announce(hash Hash) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active[hash] || a.ctx.Err() != nil {
+		return false
+	}
+	if !a.Limiter.Allow() {
+		atomic.AddUint64(&a.stats.Limited, 1)
+		return false
+	}
+	a.active[hash] = true
+	atomic.AddUint64(&a.stats.Started, 1)
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer func() {
+			a.mu.Lock()
+			delete(a.active, hash)
+			a.mu.Unlock()
+		}()
+		protect("announce", func() { a.run(hash) })
+	}()
+	return true
+}
+
+func (a *Announcer) run(hash Hash) {
+	ctx, cancel := context.WithTimeout(a.ctx, a.Timeout)
+	defer cancel()
+	peers, err := AnnouncePeer(ctx, hash, a.Entries, a.Port)
+	if err != nil {
+		logDHT.Warn("announce lookup failed", "infohash", hash, "error", err)
+		return
+	}
+	for addr := range peers {
+		if a.Pool != nil && a.Pool.AddPeer(hash, addr) {
+			atomic.AddUint64(&a.stats.Peers, 1)
+		}
+	}
+}
+
+func (a *Announcer) Stats() AnnounceStats {
+	return AnnounceStats{
+		Started: atomic.LoadUint64(&a.stats.Started),
+		Limited: atomic.LoadUint64(&a.stats.Limited),
+		Peers:   atomic.LoadUint64(&a.stats.Peers),
+	}
+}
+
+// Close ends the running lookups, they still send their announces.
+func (a *Announcer) Close() {
+	a.mu.Lock()
+	a.cancel()
+	a.mu.Unlock()
+	a.wg.Wait()
+}
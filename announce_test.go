@@ -0,0 +1,74 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+	"github.com/zeebo/bencode"
+)
+
+func Test_AnnouncePeer(t *testing.T) {
+	n := testutil.StartNetwork(t, testutil.NetworkConfig{Nodes: 64, Seed: 9, Loopback: true})
+	hash := testHash("rare")
+	n.Store(hash, &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 6881})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	peers, err := AnnouncePeer(ctx, hash, n.Addrs(1), 51413)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for range peers {
+		found++
+	}
+	if found != 1 {
+		t.Error("peers found", found)
+	}
+	self := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 51413}
+	announced := 0
+	for deadline := time.Now().Add(time.Second); announced == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		for _, node := range n.Nodes() {
+			for _, p := range node.Peers(hash) {
+				if p.String() == self.String() {
+					announced++
+				}
+			}
+		}
+	}
+	if announced == 0 || announced > lookupAnnounce {
+		t.Error("nodes announced to", announced)
+	}
+}
+
+func Test_InboundFetch(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "inbound.iso", "length": 10, "piece length": 16384, "pieces": ""})
+	peer := testutil.NewPeer(info)
+	defer peer.Close()
+	hash := Hash(peer.InfoHash())
+
+	pool := NewWireJob(1, 0)
+	defer pool.Stop()
+	l := servePeers(t, &PeerListenerConfig{AcceptRate: -1})
+	l.OnConn = func(h Hash, conn net.Conn) bool {
+		job := NewJob(h, nil)
+		return job.AddConn(conn) && pool.Add(job)
+	}
+	if err := peer.Connect(l.Addr(), hash); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-pool.Results.C():
+		if r := v.(*MetadataResult); r.Name != "inbound.iso" {
+			t.Error("result", r.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing fetched over the inbound connection")
+	}
+	if st := l.Stats(); st.Handshakes != 1 {
+		t.Errorf("%+v", st)
+	}
+}
@@ -0,0 +1,239 @@
+package DHTCrawl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The kinds of the announce log records.
+const (
+	LogAnnounce = "announce" //an announce_peer, or the handshake of an inbound peer
+	LogSample   = "sample"   //the infohash of a get_peers query, without a peer
+
+	DefaultAnnounceLogSize = 64 //megabytes of a segment
+	DefaultReplayRate      = 50 //records fed a second
+)
+
+type (
+	// AnnounceLogConfig appends every announce the pipeline receives, and
+	// with Samples the infohashes of the get_peers queries, to a log of JSON
+	// lines segments. The current segment is Path, the full ones are moved
+	// to Path.<time> like the JSON lines sink does. dhtcrawl backfill feeds
+	// the log to the pipeline again.
+	AnnounceLogConfig struct {
+		Path        string `json:"path"`
+		MaxSize     int    `json:"max_size"`     //megabytes of a segment, 0 is DefaultAnnounceLogSize
+		RotateEvery int    `json:"rotate_every"` //seconds of a segment, 0 only rotates on size
+		Keep        int    `json:"keep"`         //segments kept, 0 keeps all
+		Gzip        bool   `json:"gzip"`         //compress the full segments
+		Samples     bool   `json:"samples"`      //log the get_peers infohashes too, many more than the announces
+	}
+
+	// AnnounceLogRecord is one line of the log.
+	AnnounceLogRecord struct {
+		Kind string    `json:"kind"`
+		Hash string    `json:"hash"`
+		Peer string    `json:"peer,omitempty"` //host:port, empty for a sample and an inbound peer
+		Time time.Time `json:"time"`
+	}
+
+	// AnnounceLog writes the records of a crawler.
+	AnnounceLog struct {
+		File    *RotatingFile
+		Samples bool
+	}
+
+	// ReplayOptions selects the records ReplayAnnounceLog feeds.
+	ReplayOptions struct {
+		Since   time.Time
+		Until   time.Time
+		Samples bool    //replay the samples too, as hashes without a peer
+		Rate    float64 //records a second, 0 is DefaultReplayRate
+	}
+)
+
+func OpenAnnounceLog(cfg *AnnounceLogConfig) (*AnnounceLog, error) {
+	size := cfg.MaxSize
+	if size == 0 {
+		size = DefaultAnnounceLogSize
+	}
+	f, err := OpenRotatingFile(cfg.Path, int64(size)<<20, time.Duration(cfg.RotateEvery)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	f.Gzip, f.Keep = cfg.Gzip, cfg.Keep
+	return &AnnounceLog{File: f, Samples: cfg.Samples}, nil
+}
+
+func (l *AnnounceLog) PutAnnounce(a *Announce) error {
+	rec := &AnnounceLogRecord{Kind: LogAnnounce, Hash: a.Hash.Hex(), Time: a.Time.UTC()}
+	if a.Peer != nil {
+		rec.Peer = a.Peer.String()
+	}
+	return l.write(rec)
+}
+
+// PutSample logs the infohash of a get_peers query, unless Samples is off.
+func (l *AnnounceLog) PutSample(hash Hash) error {
+	if !l.Samples {
+		return nil
+	}
+	return l.write(&AnnounceLogRecord{Kind: LogSample, Hash: hash.Hex(), Time: time.Now().UTC()})
+}
+
+func (l *AnnounceLog) write(rec *AnnounceLogRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = l.File.Write(append(data, '\n'))
+	return err
+}
+
+func (l *AnnounceLog) Flush() error {
+	return l.File.Flush()
+}
+
+func (l *AnnounceLog) Close() error {
+	return l.File.Close()
+}
+
+// AnnounceLogSegments returns the segments of the log at path, the oldest
+// first and path itself last.
+func AnnounceLogSegments(path string) ([]string, error) {
+	names, err := filepath.Glob(path + ".[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	segments := []string{}
+	for _, name := range names {
+		// a segment is being compressed, its copy is read once
+		if base := strings.TrimSuffix(name, ".gz"); base != name && InArray(names, base) {
+			continue
+		}
+		segments = append(segments, name)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return strings.TrimSuffix(segments[i], ".gz") < strings.TrimSuffix(segments[j], ".gz")
+	})
+	if _, err := os.Stat(path); err == nil {
+		segments = append(segments, path)
+	}
+	return segments, nil
+}
+
+// ReadAnnounceLog calls fn with every record of the segments of the log at
+// path in order until fn returns an error. The lines which don't decode,
+// like the torn last line of a crash, are skipped.
+func ReadAnnounceLog(path string, fn func(*AnnounceLogRecord) error) error {
+	segments, err := AnnounceLogSegments(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range segments {
+		if err := readAnnounceSegment(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readAnnounceSegment(name string, fn func(*AnnounceLogRecord) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		rec := &AnnounceLogRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			logPipeline.Warn("announce log record skipped", "segment", name, "error", err)
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	// a truncated gzip segment is read as far as it goes
+	if err := scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	return nil
+}
+
+// ReplayAnnounceLog feeds the records of the log at path selected by opts to
+// the pool of c as new announces, through the filters: the hashes stored
+// since are skipped by the store. A hash is queued once, its later peers
+// join the download if it still runs. Half a full fetch queue holds the
+// replay back, the queues drop what overflows. It returns the records fed.
+func (c *Crawler) ReplayAnnounceLog(ctx context.Context, path string, opts ReplayOptions) (int, error) {
+	rate := opts.Rate
+	if rate == 0 {
+		rate = DefaultReplayRate
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	seen := map[Hash]bool{}
+	n := 0
+	err := ReadAnnounceLog(path, func(rec *AnnounceLogRecord) error {
+		if (rec.Kind == LogSample && !opts.Samples) || (rec.Kind != LogSample && rec.Kind != LogAnnounce) {
+			return nil
+		}
+		if (!opts.Since.IsZero() && rec.Time.Before(opts.Since)) || (!opts.Until.IsZero() && !rec.Time.Before(opts.Until)) {
+			return nil
+		}
+		hash, err := HashFromHex(rec.Hash)
+		if err != nil {
+			return nil
+		}
+		var peer *net.TCPAddr
+		if rec.Peer != "" {
+			if peer, err = net.ResolveTCPAddr("tcp", rec.Peer); err != nil {
+				return nil
+			}
+		}
+		if seen[hash] {
+			if peer != nil {
+				c.Pool.AddPeer(hash, peer)
+			}
+			return nil
+		}
+		for c.Pool.Jobs.Len() > c.Pool.Jobs.Cap()/2 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		seen[hash] = true
+		if c.Pool.Add(NewJob(hash, peer)) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,86 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+	"github.com/zeebo/bencode"
+)
+
+func Test_AnnounceLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "announces.jsonl")
+	l, err := OpenAnnounceLog(&AnnounceLogConfig{Path: path, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	peer := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	for i := 0; i < 6; i++ {
+		l.PutAnnounce(&Announce{Hash: testHash(string(rune('a' + i))), Peer: peer, Time: start.Add(time.Duration(i) * time.Hour)})
+		if i%2 == 1 {
+			l.File.Rotate()
+		}
+	}
+	l.PutSample(testHash("sampled"))
+	l.Samples = true
+	l.PutSample(testHash("sampled"))
+	l.Close()
+	// a crash tore the last line
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"kind":"announce","ha`)
+	f.Close()
+
+	if segments, _ := AnnounceLogSegments(path); len(segments) != 4 {
+		t.Error("segments", segments)
+	}
+	var got []string
+	err = ReadAnnounceLog(path, func(r *AnnounceLogRecord) error {
+		got = append(got, r.Kind+" "+r.Hash[:2]+" "+r.Peer)
+		return nil
+	})
+	want := "announce 61 10.0.0.1:6881,announce 62 10.0.0.1:6881,announce 63 10.0.0.1:6881,announce 64 10.0.0.1:6881,announce 65 10.0.0.1:6881,announce 66 10.0.0.1:6881,sample 73 "
+	if err != nil || strings.Join(got, ",") != want {
+		t.Error(err, got)
+	}
+}
+
+func Test_ReplayAnnounceLog(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "backfill.iso", "length": 10, "piece length": 16384, "pieces": ""})
+	p := testutil.NewPeer(info)
+	addr := testutil.StartPeer(t, p)
+	hash := Hash(p.InfoHash())
+
+	path := filepath.Join(t.TempDir(), "announces.jsonl")
+	l, err := OpenAnnounceLog(&AnnounceLogConfig{Path: path, Samples: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	l.PutAnnounce(&Announce{Hash: testHash("old"), Peer: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, Time: now.Add(-48 * time.Hour)})
+	l.PutAnnounce(&Announce{Hash: hash, Peer: addr, Time: now})
+	l.PutAnnounce(&Announce{Hash: hash, Peer: addr, Time: now})
+	l.PutSample(testHash("sample"))
+	l.Close()
+
+	pool := NewWireJob(1, 16)
+	defer pool.Stop()
+	c := &Crawler{Pool: pool}
+	n, err := c.ReplayAnnounceLog(context.Background(), path, ReplayOptions{Since: now.Add(-time.Hour), Rate: 1000})
+	if err != nil || n != 1 {
+		t.Fatal("replayed", n, err)
+	}
+	select {
+	case v := <-pool.Results.C():
+		if r := v.(*MetadataResult); r.Name != "backfill.iso" {
+			t.Error("result", r.Name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the replayed announce was not fetched")
+	}
+}
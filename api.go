@@ -0,0 +1,191 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+var errNoStore = errors.New("the crawler has no store")
+
+type (
+	// apiTorrent encodes as the record of its result, which carries the
+	// magnet link, Magnet is there for the clients which decode it.
+	apiTorrent struct {
+		*MetadataResult
+		Magnet string `json:"magnet"`
+	}
+
+	apiTorrents struct {
+		Torrents []apiTorrent `json:"torrents"`
+		Offset   int          `json:"offset"`
+		Limit    int          `json:"limit"`
+		Next     int          `json:"next,omitempty"` //offset of the next page, absent on the last one
+	}
+
+	apiError struct {
+		Error string `json:"error"`
+	}
+)
+
+func newAPITorrent(r *MetadataResult) apiTorrent {
+	return apiTorrent{MetadataResult: r, Magnet: r.Magnet()}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// parseTime takes RFC 3339 or unix seconds.
+func parseTime(v string) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseTorrentQuery reads category, min_size, max_size, since, until,
+// alive_since, offset and limit from the query string. alive=true is
+// alive_since deadAfter ago.
+func parseTorrentQuery(r *http.Request, deadAfter time.Duration) (TorrentQuery, error) {
+	v := r.URL.Query()
+	q := TorrentQuery{Category: v.Get("category"), Limit: DefaultPageSize}
+	ints := map[string]*int{"offset": &q.Offset, "limit": &q.Limit}
+	for name, p := range ints {
+		if s := v.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return q, errors.New("invalid " + name)
+			}
+			*p = n
+		}
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+	sizes := map[string]*int64{"min_size": &q.MinSize, "max_size": &q.MaxSize}
+	for name, p := range sizes {
+		if s := v.Get(name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				return q, errors.New("invalid " + name)
+			}
+			*p = n
+		}
+	}
+	if s := v.Get("alive"); s != "" {
+		alive, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, errors.New("invalid alive")
+		}
+		if alive {
+			q.Alive = time.Now().Add(-deadAfter)
+		}
+	}
+	times := map[string]*time.Time{"since": &q.Since, "until": &q.Until, "alive_since": &q.Alive}
+	for name, p := range times {
+		if s := v.Get(name); s != "" {
+			t, err := parseTime(s)
+			if err != nil {
+				return q, errors.New("invalid " + name)
+			}
+			*p = t
+		}
+	}
+	return q, nil
+}
+
+func (s *Server) handleTorrents(w http.ResponseWriter, r *http.Request) {
+	store := s.Crawler.Store
+	if store == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoStore)
+		return
+	}
+	var health *HealthConfig
+	if s.Crawler.Config != nil {
+		health = s.Crawler.Config.Health
+	}
+	q, err := parseTorrentQuery(r, health.deadAfter())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	page := q
+	page.Limit++ // one more tells whether there is a next page
+	results, err := QueryTorrents(store, page)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	resp := apiTorrents{Torrents: []apiTorrent{}, Offset: q.Offset, Limit: q.Limit}
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+		resp.Next = q.Offset + q.Limit
+	}
+	for _, result := range results {
+		resp.Torrents = append(resp.Torrents, newAPITorrent(result))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleTorrent(w http.ResponseWriter, r *http.Request) {
+	store := s.Crawler.Store
+	if store == nil {
+		writeError(w, http.StatusServiceUnavailable, errNoStore)
+		return
+	}
+	hash, err := HashFromHex(r.PathValue("infohash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("infohash must be 40 hex characters"))
+		return
+	}
+	result, err := store.Get(hash)
+	if err == ErrNotFound {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPITorrent(result))
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Crawler.Stats())
+}
+
+// handleBandwidth reports the traffic by hour, by day and of the peers
+// exchanging the most bytes, ?peers=N of them.
+func (s *Server) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	if s.Crawler.Bandwidth == nil {
+		writeError(w, http.StatusNotFound, errors.New("no bandwidth accounting"))
+		return
+	}
+	peers := DefaultBandwidthPeers
+	if v := r.URL.Query().Get("peers"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid peers"))
+			return
+		}
+		peers = n
+	}
+	writeJSON(w, http.StatusOK, s.Crawler.Bandwidth.Report(peers))
+}
@@ -0,0 +1,85 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_API(t *testing.T) {
+	dir := t.TempDir()
+	bolt, err := OpenBoltStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	sqlite, err := OpenSQLiteStore(filepath.Join(dir, "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	start := time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		r := &MetadataResult{
+			Hash:     Hash(NewNodeIDFromHex(fmt.Sprintf("%040X", i+1))),
+			Name:     fmt.Sprintf("torrent %d", i),
+			Length:   int64(i * 100),
+			Category: []string{CategoryVideo, CategoryAudio}[i%2],
+			Create:   start.Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
+		}
+		bolt.Put(r)
+		sqlite.Put(r)
+	}
+
+	for _, store := range []Store{bolt, sqlite} {
+		pool := NewWireJob(1, 16)
+		s := NewServer(&Crawler{Config: NewDefaultConfig(), Store: store, Pool: pool}, "")
+		get := func(url string, v interface{}) int {
+			w := httptest.NewRecorder()
+			s.Mux.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+			json.Unmarshal(w.Body.Bytes(), v)
+			return w.Code
+		}
+
+		page := apiTorrents{}
+		get("/torrents?category="+CategoryVideo+"&min_size=100&limit=1", &page)
+		// videos are 0, 200 and 400 bytes
+		if len(page.Torrents) != 1 || page.Next != 1 {
+			t.Errorf("%T page %+v", store, page)
+		}
+		page = apiTorrents{}
+		get("/torrents?since="+start.Add(time.Hour*3).Format(time.RFC3339), &page)
+		if len(page.Torrents) != 2 || page.Next != 0 {
+			t.Errorf("%T since %+v", store, page)
+		}
+
+		one := apiTorrent{}
+		if code := get(fmt.Sprintf("/torrents/%040x", 3), &one); code != 200 || one.MetadataResult == nil || one.Name != "torrent 2" || one.Magnet == "" {
+			t.Errorf("%T get %d %+v", store, code, one)
+		}
+		if code := get(fmt.Sprintf("/torrents/%040x", 9), &one); code != 404 {
+			t.Errorf("%T unknown %d", store, code)
+		}
+		if code := get("/torrents/nothex", &one); code != 400 {
+			t.Errorf("%T bad hash %d", store, code)
+		}
+
+		stats := CrawlerStats{}
+		if get("/stats", &stats); stats.Stored != 5 {
+			t.Errorf("%T stats %+v", store, stats)
+		}
+		pool.Stop()
+	}
+}
+
+func Test_TorrentQueryLimit(t *testing.T) {
+	for url, limit := range map[string]int{"/torrents": DefaultPageSize, "/torrents?limit=0": DefaultPageSize, "/torrents?limit=10000": MaxPageSize, "/torrents?limit=7": 7} {
+		q, err := parseTorrentQuery(httptest.NewRequest("GET", url, nil), 0)
+		if err != nil || q.Limit != limit {
+			t.Error(url, q.Limit, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type (
+	// ArchiveConfig enables the object storage archive. Endpoint is a host
+	// such as s3.amazonaws.com or localhost:9000 for MinIO. With Raw the bare
+	// info dictionary is stored instead of a .torrent file.
+	ArchiveConfig struct {
+		Endpoint    string `json:"endpoint"`
+		AccessKey   string `json:"access_key"`
+		SecretKey   string `json:"secret_key"`
+		Region      string `json:"region"`
+		Bucket      string `json:"bucket"`
+		Prefix      string `json:"prefix"`
+		Secure      bool   `json:"secure"` //https
+		Raw         bool   `json:"raw"`
+		Compression string `json:"compression"` //zstd or snappy, empty uploads the torrents as they are
+	}
+
+	// ArchiveSink uploads the torrent of every result to S3 compatible object
+	// storage. Objects are content addressed by info hash, an object which
+	// already exists is not uploaded again. Results without the raw info
+	// dictionary are skipped. With a compression every object is a frame of
+	// its codec, named with its extension.
+	ArchiveSink struct {
+		Client *minio.Client
+		Config ArchiveConfig
+	}
+)
+
+func NewArchiveSink(cfg ArchiveConfig) (*ArchiveSink, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.Secure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, err
+		}
+	}
+	return &ArchiveSink{Client: client, Config: cfg}, nil
+}
+
+// ObjectName returns where the torrent of hash is archived, the first two
+// bytes of the hash fan the objects out: prefix/95/1B/951B...CED.torrent.
+func (s *ArchiveSink) ObjectName(hash Hash) string {
+	hex := hash.Hex()
+	ext := ".torrent"
+	if s.Config.Raw {
+		ext = ".info"
+	}
+	name := hex[:2] + "/" + hex[2:4] + "/" + hex + ext + compressExt(s.Config.Compression)
+	if s.Config.Prefix != "" {
+		name = s.Config.Prefix + "/" + name
+	}
+	return name
+}
+
+func (s *ArchiveSink) Put(r *MetadataResult) error {
+	data := r.Torrent()
+	if data == nil {
+		return nil
+	}
+	if s.Config.Raw {
+		data = r.Info
+	}
+	contentType := "application/x-bittorrent"
+	if s.Config.Compression != CompressNone {
+		var err error
+		if data, err = compressBlob(s.Config.Compression, data); err != nil {
+			return err
+		}
+		contentType = "application/" + s.Config.Compression
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	name := s.ObjectName(r.Hash)
+	if _, err := s.Client.StatObject(ctx, s.Config.Bucket, name, minio.StatObjectOptions{}); err == nil {
+		return nil
+	} else if minio.ToErrorResponse(err).Code != minio.NoSuchKey {
+		return err
+	}
+	_, err := s.Client.PutObject(ctx, s.Config.Bucket, name, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+func (s *ArchiveSink) Close() error {
+	return nil
+}
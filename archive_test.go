@@ -0,0 +1,29 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func Test_Torrent(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "test", "length": 5, "piece length": 16384, "pieces": "01234567890123456789"})
+	sum := sha1.Sum(info)
+	hash := Hash(sum[:])
+	r, err := decodeTorrentFile(bytes.NewReader((&MetadataResult{Info: info}).Torrent()), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "test" || r.Length != 5 {
+		t.Error("Decoded", r.Name, r.Length)
+	}
+	if s := sha1.Sum(r.Info); Hash(s[:]) != hash {
+		t.Error("Info hash changed")
+	}
+	a := &ArchiveSink{Config: ArchiveConfig{Prefix: "torrents"}}
+	if name := a.ObjectName(hash); name != "torrents/"+hash.Hex()[:2]+"/"+hash.Hex()[2:4]+"/"+hash.Hex()+".torrent" {
+		t.Error("Object name", name)
+	}
+}
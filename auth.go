@@ -0,0 +1,201 @@
+package DHTCrawl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errUnauthorized = errors.New("missing or unknown API key")
+	errRateLimited  = errors.New("API key rate limit exceeded")
+)
+
+type (
+	AuthConfig struct {
+		Keys []APIKey `json:"keys"`
+	}
+
+	// APIKey grants access to the HTTP, WebSocket and gRPC APIs. Rate is
+	// in requests per second, 0 is unlimited. A stream counts as one request.
+	APIKey struct {
+		Name  string  `json:"name"`
+		Key   string  `json:"key"`
+		Rate  float64 `json:"rate"`
+		Burst int     `json:"burst"`
+	}
+
+	TLSConfig struct {
+		Cert     string `json:"cert"`      //PEM certificate chain
+		Key      string `json:"key"`       //PEM private key
+		ClientCA string `json:"client_ca"` //PEM bundle, a client certificate it signed replaces an API key
+	}
+
+	// Authenticator checks the API key of every request to the served APIs
+	// and applies its rate limit. A client which presented a certificate
+	// verified against client_ca needs no key.
+	Authenticator struct {
+		mu   sync.RWMutex
+		keys map[string]*apiKey
+	}
+
+	apiKey struct {
+		APIKey
+		limiter *Limiter
+	}
+)
+
+func NewAuthenticator(keys []APIKey) *Authenticator {
+	a := &Authenticator{}
+	a.SetKeys(keys)
+	return a
+}
+
+// SetKeys replaces the accepted keys, a key which is kept keeps its bucket.
+func (a *Authenticator) SetKeys(keys []APIKey) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	old := a.keys
+	a.keys = make(map[string]*apiKey, len(keys))
+	for _, k := range keys {
+		if k.Key == "" {
+			continue
+		}
+		if o, ok := old[k.Key]; ok {
+			o.APIKey = k
+			o.limiter.SetRate(k.Rate, k.Burst)
+			a.keys[k.Key] = o
+			continue
+		}
+		a.keys[k.Key] = &apiKey{APIKey: k, limiter: NewLimiter(k.Rate, k.Burst)}
+	}
+}
+
+// Check returns errUnauthorized for an unknown key and errRateLimited when
+// the key has used up its rate.
+func (a *Authenticator) Check(key string) error {
+	a.mu.RLock()
+	k, ok := a.keys[key]
+	a.mu.RUnlock()
+	if !ok || key == "" {
+		return errUnauthorized
+	}
+	if !k.limiter.Allow() {
+		return errRateLimited
+	}
+	return nil
+}
+
+// requestKey reads the key from the X-API-Key header, a bearer token or the
+// api_key query parameter, which browsers need for WebSockets and feeds.
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+func verifiedCert(state *tls.ConnectionState) bool {
+	return state != nil && len(state.VerifiedChains) > 0
+}
+
+// public guards a handler of the served API, it is open when the crawler
+// has no authenticator.
+func (s *Server) public(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a := s.Crawler.Auth
+		if a == nil || verifiedCert(r.TLS) {
+			h(w, r)
+			return
+		}
+		switch err := a.Check(requestKey(r)); err {
+		case nil:
+			h(w, r)
+		case errRateLimited:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusTooManyRequests, err)
+		default:
+			writeError(w, http.StatusUnauthorized, err)
+		}
+	}
+}
+
+func (s *GRPCServer) authorize(ctx context.Context) error {
+	a := s.Crawler.Auth
+	if a == nil {
+		return nil
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && verifiedCert(&info.State) {
+			return nil
+		}
+	}
+	key := ""
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("x-api-key"); len(v) > 0 {
+		key = v[0]
+	} else if v := md.Get("authorization"); len(v) > 0 {
+		key = strings.TrimPrefix(v[0], "Bearer ")
+	}
+	switch err := a.Check(key); err {
+	case nil:
+		return nil
+	case errRateLimited:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+}
+
+func (s *GRPCServer) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *GRPCServer) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// LoadTLSConfig loads the server certificate and, with a client_ca, asks the
+// clients for a certificate. One is not required: the health probes and the
+// clients with an API key connect without.
+func LoadTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if cfg.ClientCA != "" {
+		data, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificate in " + cfg.ClientCA)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tc, nil
+}
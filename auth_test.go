@@ -0,0 +1,164 @@
+package DHTCrawl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dhtcrawlpb "bitbucket.org/AlanYang/DHTCrawl/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_APIKeys(t *testing.T) {
+	pool := NewWireJob(1, 16)
+	defer pool.Stop()
+	c := &Crawler{Config: NewDefaultConfig(), Pool: pool, Hub: NewHub()}
+	c.Auth = NewAuthenticator([]APIKey{{Name: "slow", Key: "k1", Rate: 1, Burst: 1}, {Name: "fast", Key: "k2"}})
+	s := NewServer(c, "")
+	get := func(url, key string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", url, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		s.Mux.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := get("/stats", ""); code != 401 {
+		t.Error("no key", code)
+	}
+	if code := get("/stats", "k1"); code != 200 {
+		t.Error("key", code)
+	}
+	if code := get("/stats", "k1"); code != 429 {
+		t.Error("rate", code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := get("/stats?api_key=k2", ""); code != 200 {
+			t.Error("unlimited key", code)
+		}
+	}
+	if code := get("/healthz", ""); code == 401 {
+		t.Error("healthz needs no key")
+	}
+
+	g := NewGRPCServer(c, "127.0.0.1:0")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Server.Serve(ln)
+	defer g.Server.Stop()
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := dhtcrawlpb.NewCrawlerClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if _, err := client.GetStats(ctx, &dhtcrawlpb.StatsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Error("grpc without key", err)
+	}
+	if _, err := client.GetStats(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer k2"), &dhtcrawlpb.StatsRequest{}); err != nil {
+		t.Error("grpc with key", err)
+	}
+}
+
+// writeCert writes a certificate signed by parent, self-signed when parent
+// is nil, and its key to dir.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func Test_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+	tc, err := LoadTLSConfig(&TLSConfig{
+		Cert:     filepath.Join(dir, "server.pem"),
+		Key:      filepath.Join(dir, "server.key"),
+		ClientCA: filepath.Join(dir, "ca.pem"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewWireJob(1, 16)
+	defer pool.Stop()
+	c := &Crawler{Config: NewDefaultConfig(), Pool: pool, Hub: NewHub(), Auth: NewAuthenticator(nil)}
+	s := NewServer(c, "127.0.0.1:0")
+	s.srv.TLSConfig = tc
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	defer s.Close(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(certs ...tls.Certificate) int {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := client.Get("https://" + ln.Addr().String() + "/stats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(); code != 401 {
+		t.Error("without a certificate", code)
+	}
+	if code := get(clientCert); code != 200 {
+		t.Error("with a certificate", code)
+	}
+}
@@ -0,0 +1,289 @@
+package DHTCrawl
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The subsystems of a Bandwidth.
+const (
+	BandwidthDHT  = "dht"  //UDP of the nodes
+	BandwidthWire = "wire" //TCP of the metadata downloads, inbound connections included
+	BandwidthHTTP = "http" //the REST API, the feeds and the dashboard
+
+	DefaultBandwidthPeers = 20 //of a report
+
+	bandwidthHours = 48
+	bandwidthDays  = 31
+	bandwidthPeers = 10000 //IPs counted apart, the traffic of the others goes to "other"
+	bandwidthOther = "other"
+)
+
+var bandwidthSubsystems = [...]string{BandwidthDHT, BandwidthWire, BandwidthHTTP}
+
+type (
+	// Traffic is the bytes received and sent.
+	Traffic struct {
+		In  uint64 `json:"in"`
+		Out uint64 `json:"out"`
+	}
+
+	// BandwidthPeriod is the traffic of an hour or a day, by subsystem.
+	BandwidthPeriod struct {
+		Start   time.Time          `json:"start"`
+		Traffic map[string]Traffic `json:"traffic"`
+	}
+
+	// PeerTraffic is the traffic exchanged with one IP, of every subsystem.
+	PeerTraffic struct {
+		Peer string `json:"peer"`
+		Traffic
+	}
+
+	// BandwidthReport is what GET /bandwidth returns.
+	BandwidthReport struct {
+		Since time.Time          `json:"since"`
+		Total map[string]Traffic `json:"total"`
+		Hours []BandwidthPeriod  `json:"hours"` //of the last 48 hours, the oldest first
+		Days  []BandwidthPeriod  `json:"days"`  //of the last 31 UTC days
+		Peers []PeerTraffic      `json:"peers"` //the IPs which exchanged the most bytes first
+	}
+
+	// Bandwidth counts the bytes of the crawler by subsystem, by hour, by
+	// day and by peer IP, at the level of the sockets. Past 10000 IPs, a new
+	// one makes the half which exchanged the least go to "other".
+	Bandwidth struct {
+		Clock Clock //nil is SystemClock
+
+		mu    sync.Mutex
+		since time.Time
+		total [len(bandwidthSubsystems)]Traffic
+		hours []bandwidthPeriod
+		days  []bandwidthPeriod
+		peers map[string]*Traffic
+	}
+
+	bandwidthPeriod struct {
+		start   time.Time
+		traffic [len(bandwidthSubsystems)]Traffic
+	}
+
+	// bandwidthSlot holds the Bandwidth of a session, nil counts nothing.
+	bandwidthSlot struct {
+		mu        sync.RWMutex
+		bandwidth *Bandwidth
+	}
+
+	// bandwidthListener counts the traffic of the connections it accepts.
+	bandwidthListener struct {
+		net.Listener
+		bandwidth *Bandwidth
+		subsystem string
+	}
+
+	bandwidthConn struct {
+		net.Conn
+		bandwidth *Bandwidth
+		subsystem string
+		ip        net.IP
+	}
+)
+
+func NewBandwidth() *Bandwidth {
+	return &Bandwidth{peers: map[string]*Traffic{}}
+}
+
+// Add counts the bytes received from and sent to ip by subsystem, ip may
+// be nil.
+func (b *Bandwidth) Add(subsystem string, ip net.IP, in, out int) {
+	if b == nil || (in <= 0 && out <= 0) {
+		return
+	}
+	i := bandwidthIndex(subsystem)
+	if i < 0 {
+		return
+	}
+	now := clockOr(b.Clock).Now()
+	hour := now.Truncate(time.Hour)
+	day := now.UTC().Truncate(24 * time.Hour)
+	t := Traffic{In: uint64(max(in, 0)), Out: uint64(max(out, 0))}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.since.IsZero() {
+		b.since = now
+	}
+	b.total[i].add(t)
+	b.hours = addPeriod(b.hours, hour, bandwidthHours, i, t)
+	b.days = addPeriod(b.days, day, bandwidthDays, i, t)
+	if ip != nil {
+		key := ip.String()
+		p, ok := b.peers[key]
+		if !ok {
+			if len(b.peers) >= bandwidthPeers {
+				b.prunePeers()
+			}
+			p = &Traffic{}
+			b.peers[key] = p
+		}
+		p.add(t)
+	}
+}
+
+// Total returns the traffic since the start by subsystem.
+func (b *Bandwidth) Total() map[string]Traffic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return trafficMap(&b.total)
+}
+
+// Report returns the totals, the hours, the days and the top peers, at
+// most peers of them.
+func (b *Bandwidth) Report(peers int) BandwidthReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := BandwidthReport{
+		Since: b.since,
+		Total: trafficMap(&b.total),
+		Hours: []BandwidthPeriod{},
+		Days:  []BandwidthPeriod{},
+		Peers: []PeerTraffic{},
+	}
+	for _, p := range b.hours {
+		r.Hours = append(r.Hours, BandwidthPeriod{Start: p.start, Traffic: trafficMap(&p.traffic)})
+	}
+	for _, p := range b.days {
+		r.Days = append(r.Days, BandwidthPeriod{Start: p.start, Traffic: trafficMap(&p.traffic)})
+	}
+	for peer, t := range b.peers {
+		r.Peers = append(r.Peers, PeerTraffic{Peer: peer, Traffic: *t})
+	}
+	sortPeerTraffic(r.Peers)
+	if len(r.Peers) > peers {
+		r.Peers = r.Peers[:peers]
+	}
+	return r
+}
+
+// prunePeers adds the half of the peers which exchanged the least to
+// "other", a new IP is counted apart again.
+func (b *Bandwidth) prunePeers() {
+	peers := make([]PeerTraffic, 0, len(b.peers))
+	for peer, t := range b.peers {
+		if peer != bandwidthOther {
+			peers = append(peers, PeerTraffic{Peer: peer, Traffic: *t})
+		}
+	}
+	sortPeerTraffic(peers)
+	other := b.peers[bandwidthOther]
+	if other == nil {
+		other = &Traffic{}
+		b.peers[bandwidthOther] = other
+	}
+	for _, p := range peers[bandwidthPeers/2:] {
+		other.add(p.Traffic)
+		delete(b.peers, p.Peer)
+	}
+}
+
+// sortPeerTraffic puts the peers which exchanged the most bytes first.
+func sortPeerTraffic(peers []PeerTraffic) {
+	sort.Slice(peers, func(i, j int) bool {
+		a, b := peers[i], peers[j]
+		if a.In+a.Out != b.In+b.Out {
+			return a.In+a.Out > b.In+b.Out
+		}
+		return a.Peer < b.Peer
+	})
+}
+
+// listener returns ln counting its connections as subsystem.
+func (b *Bandwidth) listener(ln net.Listener, subsystem string) net.Listener {
+	if b == nil {
+		return ln
+	}
+	return &bandwidthListener{Listener: ln, bandwidth: b, subsystem: subsystem}
+}
+
+// conn returns conn counted as subsystem.
+func (b *Bandwidth) conn(conn net.Conn, subsystem string) net.Conn {
+	if b == nil {
+		return conn
+	}
+	c := &bandwidthConn{Conn: conn, bandwidth: b, subsystem: subsystem}
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		c.ip = addr.IP
+	}
+	return c
+}
+
+func (t *Traffic) add(o Traffic) {
+	t.In += o.In
+	t.Out += o.Out
+}
+
+func bandwidthIndex(subsystem string) int {
+	for i, s := range bandwidthSubsystems {
+		if s == subsystem {
+			return i
+		}
+	}
+	return -1
+}
+
+func trafficMap(t *[len(bandwidthSubsystems)]Traffic) map[string]Traffic {
+	m := make(map[string]Traffic, len(t))
+	for i, s := range bandwidthSubsystems {
+		m[s] = t[i]
+	}
+	return m
+}
+
+// addPeriod adds t to the period starting at start, the last one, and
+// keeps the keep most recent periods.
+func addPeriod(periods []bandwidthPeriod, start time.Time, keep, i int, t Traffic) []bandwidthPeriod {
+	if n := len(periods); n == 0 || !periods[n-1].start.Equal(start) {
+		periods = append(periods, bandwidthPeriod{start: start})
+		if len(periods) > keep {
+			periods = append(periods[:0], periods[len(periods)-keep:]...)
+		}
+	}
+	periods[len(periods)-1].traffic[i].add(t)
+	return periods
+}
+
+func (s *bandwidthSlot) set(b *Bandwidth) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bandwidth = b
+}
+
+func (s *bandwidthSlot) get() *Bandwidth {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bandwidth
+}
+
+func (l *bandwidthListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.bandwidth.conn(conn, l.subsystem), nil
+}
+
+func (c *bandwidthConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bandwidth.Add(c.subsystem, c.ip, n, 0)
+	return n, err
+}
+
+func (c *bandwidthConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bandwidth.Add(c.subsystem, c.ip, 0, n)
+	return n, err
+}
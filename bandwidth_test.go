@@ -0,0 +1,102 @@
+package DHTCrawl
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_Bandwidth(t *testing.T) {
+	clock := NewManualClock(time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC))
+	b := NewBandwidth()
+	b.Clock = clock
+	a, c := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	b.Add(BandwidthDHT, a, 100, 50)
+	b.Add(BandwidthWire, c, 1000, 10)
+	b.Add("gopher", a, 1, 1)
+	clock.Advance(time.Hour)
+	b.Add(BandwidthHTTP, nil, 5, 500)
+
+	total := b.Total()
+	if total[BandwidthDHT] != (Traffic{100, 50}) || total[BandwidthWire] != (Traffic{1000, 10}) || total[BandwidthHTTP] != (Traffic{5, 500}) {
+		t.Error("total", total)
+	}
+	r := b.Report(1)
+	if len(r.Hours) != 2 || len(r.Days) != 2 {
+		t.Fatal("periods", r.Hours, r.Days)
+	}
+	if r.Hours[0].Traffic[BandwidthWire].In != 1000 || r.Hours[1].Traffic[BandwidthHTTP].Out != 500 || r.Hours[1].Traffic[BandwidthWire].In != 0 {
+		t.Error("hours", r.Hours)
+	}
+	if !r.Days[1].Start.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Error("day", r.Days[1].Start)
+	}
+	if len(r.Peers) != 1 || r.Peers[0].Peer != c.String() || r.Peers[0].In != 1000 {
+		t.Error("peers", r.Peers)
+	}
+
+	// the oldest hours go, and a new peer past the cap makes the half which
+	// exchanged the least count together
+	for i := 0; i < bandwidthHours+5; i++ {
+		clock.Advance(time.Hour)
+		b.Add(BandwidthDHT, nil, 1, 0)
+	}
+	for i := 0; i < bandwidthPeers+2; i++ {
+		b.Add(BandwidthDHT, net.ParseIP("fd00::"+strconv.FormatInt(int64(i), 16)), 1, 0)
+	}
+	r = b.Report(bandwidthPeers * 2)
+	if len(r.Hours) != bandwidthHours || len(r.Peers) != bandwidthPeers/2+5 {
+		t.Error("kept", len(r.Hours), len(r.Peers))
+	}
+	for _, p := range r.Peers {
+		if p.Peer == bandwidthOther && p.In != bandwidthPeers/2 {
+			t.Error("other", p)
+		}
+	}
+	kept := map[string]bool{}
+	for _, p := range r.Peers {
+		kept[p.Peer] = true
+	}
+	if last := net.ParseIP("fd00::" + strconv.FormatInt(bandwidthPeers+1, 16)).String(); !kept[a.String()] || !kept[c.String()] || !kept[last] {
+		t.Error("top or new peers not counted apart")
+	}
+}
+
+func Test_BandwidthConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewBandwidth()
+	ln = b.listener(ln, BandwidthHTTP)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 5)
+		io.ReadFull(conn, buf)
+		conn.Write([]byte("hi"))
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 2))
+	conn.Close()
+	for i := 0; i < 100 && b.Total()[BandwidthHTTP].Out != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := b.Total()[BandwidthHTTP]; got != (Traffic{5, 2}) {
+		t.Error("counted", got)
+	}
+	if r := b.Report(DefaultBandwidthPeers); len(r.Peers) != 1 || r.Peers[0].Peer != "127.0.0.1" {
+		t.Error("peers", r.Peers)
+	}
+}
@@ -0,0 +1,242 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/zeebo/bencode"
+)
+
+// bencodeMaxDepth bounds the nesting of what is scanned or decoded, the
+// decoder recurses and a deep enough value would overflow its stack, which
+// no recover catches.
+const bencodeMaxDepth = 64
+
+var (
+	errBencodeSyntax = errors.New("bencode: syntax error")
+	errBencodeDepth  = fmt.Errorf("%w: bencode nested deeper than %d", ErrLimitExceeded, bencodeMaxDepth)
+)
+
+// scanBencode returns the length of the bencoded value at the start of b,
+// reading nothing past it and copying nothing.
+func scanBencode(b []byte) (int, error) {
+	return scanValue(b, 0, 0)
+}
+
+// scanValue returns the end of the value at b[i:].
+func scanValue(b []byte, i, depth int) (int, error) {
+	if i >= len(b) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	switch c := b[i]; {
+	case c == 'i':
+		_, end, err := scanInt(b, i)
+		return end, err
+	case '0' <= c && c <= '9':
+		_, end, err := scanString(b, i)
+		return end, err
+	case c == 'l' || c == 'd':
+		if depth >= bencodeMaxDepth {
+			return 0, errBencodeDepth
+		}
+		i++
+		for {
+			if i >= len(b) {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if b[i] == 'e' {
+				return i + 1, nil
+			}
+			var err error
+			if c == 'd' {
+				if _, i, err = scanString(b, i); err != nil {
+					return 0, err
+				}
+			}
+			if i, err = scanValue(b, i, depth+1); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return 0, errBencodeSyntax
+}
+
+// scanInt parses the integer at b[i:], i is its 'i'.
+func scanInt(b []byte, i int) (n int64, end int, err error) {
+	i++
+	neg := i < len(b) && b[i] == '-'
+	if neg {
+		i++
+	}
+	start := i
+	for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+		if i-start >= 18 {
+			return 0, 0, errors.New("bencode: integer overflow")
+		}
+		n = n*10 + int64(b[i]-'0')
+	}
+	if i >= len(b) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	if i == start || b[i] != 'e' {
+		return 0, 0, errBencodeSyntax
+	}
+	if neg {
+		n = -n
+	}
+	return n, i + 1, nil
+}
+
+// scanString returns the string at b[i:] as a slice of b.
+func scanString(b []byte, i int) (s []byte, end int, err error) {
+	n := 0
+	start := i
+	for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+		if i-start >= 9 {
+			return nil, 0, errors.New("bencode: string too long")
+		}
+		n = n*10 + int(b[i]-'0')
+	}
+	if i >= len(b) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if i == start || b[i] != ':' {
+		return nil, 0, errBencodeSyntax
+	}
+	i++
+	if n > len(b)-i {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	return b[i : i+n], i + n, nil
+}
+
+// pieceHeader is the dictionary in front of a ut_metadata piece, the keys
+// it lacks are -1.
+type pieceHeader struct {
+	msgType   int64
+	piece     int64
+	totalSize int64
+}
+
+// readPieceHeader decodes the dictionary at the start of a ut_metadata
+// message, n is where the piece data begins.
+func readPieceHeader(b []byte) (h pieceHeader, n int, err error) {
+	h = pieceHeader{msgType: -1, piece: -1, totalSize: -1}
+	if len(b) == 0 || b[0] != 'd' {
+		return h, 0, errBencodeSyntax
+	}
+	i := 1
+	for {
+		if i >= len(b) {
+			return h, 0, io.ErrUnexpectedEOF
+		}
+		if b[i] == 'e' {
+			return h, i + 1, nil
+		}
+		var key []byte
+		if key, i, err = scanString(b, i); err != nil {
+			return h, 0, err
+		}
+		var field *int64
+		switch string(key) {
+		case "msg_type":
+			field = &h.msgType
+		case "piece":
+			field = &h.piece
+		case "total_size":
+			field = &h.totalSize
+		}
+		if field != nil && i < len(b) && b[i] == 'i' {
+			*field, i, err = scanInt(b, i)
+		} else {
+			i, err = scanValue(b, i, 1)
+		}
+		if err != nil {
+			return h, 0, err
+		}
+	}
+}
+
+// bencodeLookup returns the raw value of key in the dictionary at the
+// start of b, nil when the dictionary has no such key.
+func bencodeLookup(b []byte, key string) ([]byte, error) {
+	if len(b) == 0 || b[0] != 'd' {
+		return nil, errBencodeSyntax
+	}
+	i := 1
+	for {
+		if i >= len(b) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if b[i] == 'e' {
+			return nil, nil
+		}
+		k, start, err := scanString(b, i)
+		if err != nil {
+			return nil, err
+		}
+		if i, err = scanValue(b, start, 1); err != nil {
+			return nil, err
+		}
+		if string(k) == key {
+			return b[start:i], nil
+		}
+	}
+}
+
+// checkBencode fails with ErrLimitExceeded when the value at the start of b
+// nests deeper than bencodeMaxDepth or holds more than max values, keys
+// included, before a decoder allocates them. A max of 0 counts nothing.
+func checkBencode(b []byte, max int) error {
+	n, depth := 0, 0
+	for i := 0; i < len(b); {
+		var err error
+		switch c := b[i]; {
+		case c == 'e':
+			if depth == 0 {
+				return errBencodeSyntax
+			}
+			if depth--; depth == 0 {
+				return nil
+			}
+			i++
+			continue
+		case c == 'l' || c == 'd':
+			if depth++; depth > bencodeMaxDepth {
+				return errBencodeDepth
+			}
+			i++
+		case c == 'i':
+			_, i, err = scanInt(b, i)
+		case '0' <= c && c <= '9':
+			_, i, err = scanString(b, i)
+		default:
+			err = errBencodeSyntax
+		}
+		if err != nil {
+			return err
+		}
+		if n++; max > 0 && n > max {
+			return fmt.Errorf("%w: more than %d bencoded values", ErrLimitExceeded, max)
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// decodeBencode decodes data into v once checkBencode passed, a panic of the
+// decoder is returned as an error.
+func decodeBencode(data []byte, v interface{}, max int) (err error) {
+	if err := checkBencode(data, max); err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("bencode: decoder panic: %v", r)
+		}
+	}()
+	return bencode.DecodeBytes(data, v)
+}
@@ -0,0 +1,107 @@
+package DHTCrawl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func Test_ScanBencode(t *testing.T) {
+	for s, want := range map[string]int{
+		"i42e":                      4,
+		"i-7etail":                  4,
+		"4:spam":                    6,
+		"0:":                        2,
+		"le":                        2,
+		"l4:spami1eeee":             11,
+		"d3:cow3:moo4:spaml1:aeeee": 23,
+	} {
+		if n, err := scanBencode([]byte(s)); err != nil || n != want {
+			t.Error(s, n, err)
+		}
+	}
+	for _, s := range []string{"", "i42", "ie", "5:spam", "x", "d1:ae", "di1ei2ee", "i1234567890123456789e"} {
+		if _, err := scanBencode([]byte(s)); err == nil {
+			t.Error("no error for", s)
+		}
+	}
+	deep := make([]byte, 0, 200)
+	for i := 0; i < 100; i++ {
+		deep = append(deep, 'l')
+	}
+	if _, err := scanBencode(deep); err == nil {
+		t.Error("no error for deep nesting")
+	}
+}
+
+func Test_PieceHeader(t *testing.T) {
+	dict, _ := bencode.EncodeBytes(map[string]interface{}{"msg_type": 1, "piece": 3, "total_size": 50000, "extra": []interface{}{"ee", 1}})
+	data := append(dict, "d4:name2:eee"...)
+	h, n, err := readPieceHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(dict) || h.msgType != 1 || h.piece != 3 || h.totalSize != 50000 {
+		t.Error(h, n, len(dict))
+	}
+	h, _, err = readPieceHeader([]byte("d8:msg_typei2ee"))
+	if err != nil || h.msgType != 2 || h.piece != -1 {
+		t.Error(h, err)
+	}
+	if _, _, err = readPieceHeader([]byte("d5:piecei1e")); err == nil {
+		t.Error("no error for a truncated header")
+	}
+}
+
+func Test_BencodeItems(t *testing.T) {
+	for s, n := range map[string]int{"i1e": 1, "le": 1, "l1:a1:be": 3, "d1:ai1e1:bli2eee": 6} {
+		if err := checkBencode([]byte(s), n); err != nil {
+			t.Error(s, err)
+		}
+		if err := checkBencode([]byte(s), n-1); n > 1 && !errors.Is(err, ErrLimitExceeded) {
+			t.Error(s, n-1, err)
+		}
+	}
+	if err := checkBencode([]byte("l1:a"), 10); err == nil {
+		t.Error("no error for a truncated list")
+	}
+	bomb := "d1:t2:aa1:y1:q1:q4:ping1:ad2:id20:" + strings.Repeat("x", 20) + "1:x" + strings.Repeat("le", 5000) + "ee"
+	if _, err := NewRPC().parse([]byte(bomb), nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Error("krpc", err)
+	}
+}
+
+// panicky stands for a decoder bug reached by a malformed value.
+type panicky struct{}
+
+func (*panicky) UnmarshalBencode([]byte) error {
+	panic("decoder bug")
+}
+
+func Test_BencodeDepth(t *testing.T) {
+	deep := []byte(strings.Repeat("l", 100000) + strings.Repeat("e", 100000))
+	if err := checkBencode(deep, 0); !errors.Is(err, ErrLimitExceeded) {
+		t.Error("deep", err)
+	}
+	var v interface{}
+	if err := decodeBencode(deep, &v, 0); !errors.Is(err, ErrLimitExceeded) {
+		t.Error("decode deep", err)
+	}
+	ok := []byte(strings.Repeat("l", bencodeMaxDepth) + strings.Repeat("e", bencodeMaxDepth))
+	if err := decodeBencode(ok, &v, 0); err != nil {
+		t.Error("nesting at the limit", err)
+	}
+	if err := decodeBencode([]byte("d1:ai1ee"), &panicky{}, 0); err == nil {
+		t.Error("decoder panic not returned")
+	}
+
+	// a peer whose info dictionary nests too deep fails its download only
+	info := []byte("d4:name1:a6:lengthi1e1:x" + strings.Repeat("l", 1000) + strings.Repeat("e", 1000) + "e")
+	hash, addr := fakePeer(t, info)
+	if _, err := (&Wire{}).fromPeer(context.Background(), hash, addr); fetchFailure(err) != FailDecode {
+		t.Error("peer", err)
+	}
+}
@@ -0,0 +1,154 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltBucket = []byte("metadata")
+
+// BoltStore keeps results in a single BoltDB file, keyed by the raw hash
+// with the JSON encoded result as value, compressed with SetCompression.
+type BoltStore struct {
+	db          *bolt.DB
+	compression string
+
+	mu     sync.Mutex
+	failed error //of the last Put, nil once one succeeds
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// SetCompression compresses the results written from now on, it must not
+// be called concurrently with Put.
+func (s *BoltStore) SetCompression(codec string) {
+	s.compression = codec
+}
+
+func (s *BoltStore) Put(r *MetadataResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if data, err = compressBlob(s.compression, data); err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(r.Hash[:], data)
+	})
+	s.mu.Lock()
+	s.failed = err
+	s.mu.Unlock()
+	return err
+}
+
+func (s *BoltStore) Has(hash Hash) (has bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		has = tx.Bucket(boltBucket).Get(hash[:]) != nil
+		return nil
+	})
+	return
+}
+
+func (s *BoltStore) Get(hash Hash) (r *MetadataResult, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltBucket).Get(hash[:])
+		if data == nil {
+			return ErrNotFound
+		}
+		r, err = decodeStored(hash, data)
+		return err
+	})
+	return
+}
+
+func (s *BoltStore) Iterate(fn func(*MetadataResult) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			hash, err := HashFromBytes(k)
+			if err != nil {
+				continue
+			}
+			r, err := decodeStored(hash, v)
+			if err != nil {
+				return err
+			}
+			if !fn(r) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+// Len returns how many results are stored.
+func (s *BoltStore) Len() (n int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(boltBucket).Stats().KeyN
+		return nil
+	})
+	return
+}
+
+// Writable fails on a file opened read only and with the error of the last
+// Put, it writes nothing itself.
+func (s *BoltStore) Writable() error {
+	if s.db.IsReadOnly() {
+		return bolt.ErrDatabaseReadOnly
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failed
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// decodeStored restores a JSON encoded result, compressed or not, the hash
+// is taken from the key: the results stored before Hash was an array carry
+// its raw bytes, which do not survive JSON.
+func decodeStored(hash Hash, data []byte) (*MetadataResult, error) {
+	data, err := decompressBlob(data)
+	if err != nil {
+		return nil, err
+	}
+	r := new(MetadataResult)
+	stored := struct {
+		*MetadataResult
+		Hash json.RawMessage `json:"hash"` //shadows the one of the result
+	}{MetadataResult: r}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	r.Hash = hash
+	r.Hex = hash.Hex()
+	return r, nil
+}
+
+// decodeStoredHex is decodeStored for the stores keyed by the hex hash.
+func decodeStoredHex(hex string, data []byte) (*MetadataResult, error) {
+	hash, err := HashFromHex(hex)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStored(hash, data)
+}
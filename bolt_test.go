@@ -0,0 +1,37 @@
+package DHTCrawl
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func Test_BoltStore(t *testing.T) {
+	s, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	h := Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"))
+	if has, _ := s.Has(h); has {
+		t.Error("Empty store has hash")
+	}
+	if _, err := s.Get(h); err != ErrNotFound {
+		t.Error("Get unknown hash", err)
+	}
+	if err := s.Put(&MetadataResult{Hash: h, Name: "test", Length: 10}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Get(h)
+	if err != nil || r.Hash != h || r.Name != "test" {
+		t.Error("Get stored hash", r, err)
+	}
+	n := 0
+	s.Iterate(func(*MetadataResult) bool { n++; return true })
+	if n != 1 {
+		t.Error("Iterate count", n)
+	}
+	if !(storeFilter{s}).AllowHash(testHash("unknown"), nil) || (storeFilter{s}).AllowHash(h, nil) {
+		t.Error("Store filter has error")
+	}
+}
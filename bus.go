@@ -0,0 +1,139 @@
+package DHTCrawl
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// The events published on a Bus.
+type (
+	// AnnounceReceived is published for every announce the dedup stage
+	// sees, including the ones attached to a running download.
+	AnnounceReceived struct {
+		Announce *Announce
+	}
+
+	// FetchStarted is published when a worker picks a job up.
+	FetchStarted struct {
+		Hash Hash
+		Peer *net.TCPAddr //first candidate, more may be tried
+		Time time.Time
+	}
+
+	// FetchFailed is published when neither a peer nor the torrent cache
+	// had the metadata of a job.
+	FetchFailed struct {
+		Hash     Hash
+		Peers    int            //peers tried
+		Failure  string         //Fail reason of the last peer, empty when no peer was tried
+		Attempts []*FetchTiming //of the peers tried, in order
+		Err      error
+		Time     time.Time
+	}
+
+	// MetadataStored is published once a result passed the filters and was
+	// handed to every sink.
+	MetadataStored struct {
+		Result *MetadataResult
+	}
+)
+
+type (
+	// Bus carries the events of a crawler to any number of handlers, one
+	// event value of the types above at a time. Handlers run in the
+	// publishing goroutine and must be quick, a subscription decouples a
+	// slow consumer.
+	Bus struct {
+		mu       sync.RWMutex
+		next     int
+		handlers map[int]func(interface{})
+	}
+
+	// BusSubscription queues the events of a bus, its queue drops the
+	// oldest ones when the consumer falls behind.
+	BusSubscription struct {
+		Queue  *Queue
+		remove func()
+		mu     sync.Mutex //a publish may still be running when it is closed
+		closed bool
+	}
+)
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[int]func(interface{}))}
+}
+
+// Handle calls fn with every event until remove is called.
+func (b *Bus) Handle(fn func(ev interface{})) (remove func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.handlers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+// Subscribe queues every event for which want, when not nil, is true in a
+// queue of size events.
+func (b *Bus) Subscribe(size int, want func(ev interface{}) bool) *BusSubscription {
+	if size <= 0 {
+		size = DefaultSubscriptionSize
+	}
+	return b.subscribe(NewQueue("events", size, QueueDropOldest), want)
+}
+
+// subscribe queues the events want accepts in q, which is set up before
+// the first one is pushed.
+func (b *Bus) subscribe(q *Queue, want func(ev interface{}) bool) *BusSubscription {
+	s := &BusSubscription{Queue: q}
+	s.remove = b.Handle(func(ev interface{}) {
+		if want != nil && !want(ev) {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.closed {
+			s.Queue.Push(ev)
+		}
+	})
+	return s
+}
+
+// Publish hands ev to every handler, a handler which panics does not stop
+// the others. A nil bus drops it.
+func (b *Bus) Publish(ev interface{}) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	handlers := make([]func(interface{}), 0, len(b.handlers))
+	for _, fn := range b.handlers {
+		handlers = append(handlers, fn)
+	}
+	b.mu.RUnlock()
+	for _, fn := range handlers {
+		protect("event", func() { fn(ev) })
+	}
+}
+
+func (s *BusSubscription) C() <-chan interface{} {
+	return s.Queue.C()
+}
+
+// Dropped returns how many events the subscriber missed by falling behind.
+func (s *BusSubscription) Dropped() uint64 {
+	return s.Queue.Dropped()
+}
+
+func (s *BusSubscription) Close() {
+	s.remove()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.Queue.Close()
+}
@@ -0,0 +1,118 @@
+package DHTCrawl
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Bus(t *testing.T) {
+	defer func(h func(string, interface{}, []byte)) { PanicHandler = h }(PanicHandler)
+	panics := 0
+	PanicHandler = func(string, interface{}, []byte) { panics++ }
+	bus := NewBus()
+	var got []interface{}
+	remove := bus.Handle(func(ev interface{}) { got = append(got, ev) })
+	bus.Handle(func(ev interface{}) { panic("bad plugin") })
+	sub := bus.Subscribe(1, func(ev interface{}) bool {
+		_, ok := ev.(*FetchFailed)
+		return ok
+	})
+
+	bus.Publish(&FetchStarted{Hash: testHash("a")})
+	bus.Publish(&FetchFailed{Hash: testHash("a")})
+	bus.Publish(&FetchFailed{Hash: testHash("b")})
+	if len(got) != 3 || panics != 3 {
+		t.Error("handler got", len(got), "panics", panics)
+	}
+	//the queue of one keeps the newest
+	if ev := (<-sub.C()).(*FetchFailed); ev.Hash != testHash("b") || sub.Dropped() != 1 {
+		t.Error("subscription", ev.Hash, sub.Dropped())
+	}
+
+	remove()
+	sub.Close()
+	bus.Publish(&FetchStarted{Hash: testHash("c")})
+	if len(got) != 3 {
+		t.Error("removed handler called")
+	}
+	if _, ok := <-sub.C(); ok {
+		t.Error("closed subscription got an event")
+	}
+	var nilBus *Bus
+	nilBus.Publish(&FetchStarted{})
+}
+
+func Test_BusPipeline(t *testing.T) {
+	info := []byte("d6:lengthi1e4:name5:event12:piece lengthi16384e6:pieces0:e")
+	hash, addr := fakePeer(t, info)
+	pool := NewWireJob(1, 0)
+	defer pool.Stop()
+	sub := pool.Events.Subscribe(0, nil)
+	defer sub.Close()
+	pool.Announces.Push(NewJob(hash, addr))
+
+	want := []string{"announce", "started"}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case ev := <-sub.C():
+			name := ""
+			switch ev := ev.(type) {
+			case *AnnounceReceived:
+				name = "announce"
+				if ev.Announce.Hash != hash {
+					t.Error("announce", ev.Announce)
+				}
+			case *FetchStarted:
+				name = "started"
+				if ev.Peer.String() != addr.String() {
+					t.Error("started", ev.Peer)
+				}
+			case *FetchFailed:
+				t.Fatal("failed", ev.Err)
+			}
+			if name != want[0] {
+				t.Fatal("got", name, "want", want[0])
+			}
+			want = want[1:]
+		case <-timeout:
+			t.Fatal("no event", want)
+		}
+	}
+	select {
+	case v := <-pool.Results.C():
+		if r := v.(*MetadataResult); r.Name != "event" {
+			t.Error("result", r.Name)
+		}
+	case <-timeout:
+		t.Fatal("no result")
+	}
+}
+
+func Test_Hub(t *testing.T) {
+	hub := NewHub()
+	results, all := hub.Subscribe(1, false), hub.Subscribe(1, true)
+	hub.PutAnnounce(&Announce{Hash: testHash("a")})
+	hub.Put(&MetadataResult{Hash: testHash("a")})
+	if r := (<-results.C()).(*MetadataResult); r.Hash != testHash("a") || results.Dropped() != 0 {
+		t.Error("result subscriber", r.Hash, results.Dropped())
+	}
+	//the queue of one dropped the announce for the result
+	if _, ok := (<-all.C()).(*MetadataResult); !ok || all.DroppedAnnounces() != 1 || all.DroppedResults() != 0 {
+		t.Error("announce subscriber dropped", all.DroppedAnnounces(), all.DroppedResults())
+	}
+
+	results.SetAnnounces(true)
+	hub.PutAnnounce(&Announce{Hash: testHash("b")})
+	if a := (<-results.C()).(*Announce); a.Hash != testHash("b") {
+		t.Error("announces turned on", a.Hash)
+	}
+	all.Close()
+	if hub.Subscribers() != 1 {
+		t.Error("subscribers", hub.Subscribers())
+	}
+	hub.Close()
+	if _, ok := <-results.C(); ok || hub.Subscribers() != 0 {
+		t.Error("subscription open after the hub closed")
+	}
+}
@@ -0,0 +1,271 @@
+package DHTCrawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The kinds and directions of CaptureRecord.
+const (
+	CaptureWire = "wire"
+	CaptureKRPC = "krpc"
+	CaptureIn   = "in"
+	CaptureOut  = "out"
+)
+
+type (
+	// CaptureConfig records the raw bytes of the peer wire connections and,
+	// with KRPC, of the DHT packets. Peers restricts the capture to the
+	// connections and packets of these IPs.
+	CaptureConfig struct {
+		Path    string   `json:"path"`
+		MaxSize int      `json:"max_size"` //megabytes before the file is rotated, 0 never rotates
+		KRPC    bool     `json:"krpc"`
+		Peers   []string `json:"peers"`
+	}
+
+	// CaptureRecord is one read or write, a line of JSON in the capture file.
+	// The records of a wire connection share a Session.
+	CaptureRecord struct {
+		Time    time.Time `json:"time"`
+		Kind    string    `json:"kind"`
+		Session uint64    `json:"session,omitempty"`
+		Dir     string    `json:"dir"`
+		Peer    string    `json:"peer"`
+		Hash    string    `json:"hash,omitempty"` //of the wire connection
+		Data    []byte    `json:"data"`
+	}
+
+	// Capture appends the records to a rotating file, Replay and
+	// ReplayKRPC reproduce what was captured offline.
+	Capture struct {
+		File *RotatingFile
+
+		krpc     bool
+		peers    map[string]bool //nil captures every peer
+		sessions uint64
+	}
+
+	// captureSlot holds the capture of a pool or a session, nil captures
+	// nothing.
+	captureSlot struct {
+		mu      sync.RWMutex
+		capture *Capture
+	}
+
+	capturedConn struct {
+		net.Conn
+		capture *Capture
+		session uint64
+		hash    string
+	}
+)
+
+// OpenCapture appends to the capture file of cfg.
+func OpenCapture(cfg CaptureConfig) (*Capture, error) {
+	f, err := OpenRotatingFile(cfg.Path, int64(cfg.MaxSize)<<20, 0)
+	if err != nil {
+		return nil, err
+	}
+	c := &Capture{File: f, krpc: cfg.KRPC}
+	if len(cfg.Peers) > 0 {
+		c.peers = map[string]bool{}
+		for _, p := range cfg.Peers {
+			c.peers[net.ParseIP(p).String()] = true
+		}
+	}
+	return c, nil
+}
+
+// Close flushes the records and closes the file.
+func (c *Capture) Close() error {
+	return c.File.Close()
+}
+
+func (c *Capture) captures(ip net.IP) bool {
+	return c.peers == nil || c.peers[ip.String()]
+}
+
+// Record appends r, the data is written as base64.
+func (c *Capture) Record(r *CaptureRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = c.File.Write(append(data, '\n'))
+	return err
+}
+
+// conn records what is read from and written to the peer, every
+// connection is a new session.
+func (c *Capture) conn(conn net.Conn, hash Hash, addr *net.TCPAddr) net.Conn {
+	if c == nil || !c.captures(addr.IP) {
+		return conn
+	}
+	return &capturedConn{Conn: conn, capture: c, session: atomic.AddUint64(&c.sessions, 1), hash: hash.Hex()}
+}
+
+// packet records a KRPC packet received from or sent to addr.
+func (c *Capture) packet(dir string, addr *net.UDPAddr, data []byte) {
+	if c == nil || !c.krpc || !c.captures(addr.IP) {
+		return
+	}
+	c.Record(&CaptureRecord{Time: time.Now(), Kind: CaptureKRPC, Dir: dir, Peer: addr.String(), Data: data})
+}
+
+func (c *capturedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(CaptureIn, b[:n])
+	}
+	return n, err
+}
+
+func (c *capturedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record(CaptureOut, b[:n])
+	}
+	return n, err
+}
+
+func (c *capturedConn) record(dir string, data []byte) {
+	c.capture.Record(&CaptureRecord{
+		Time:    time.Now(),
+		Kind:    CaptureWire,
+		Session: c.session,
+		Dir:     dir,
+		Peer:    c.RemoteAddr().String(),
+		Hash:    c.hash,
+		Data:    data,
+	})
+}
+
+func (s *captureSlot) set(c *Capture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capture = c
+}
+
+func (s *captureSlot) get() *Capture {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.capture
+}
+
+// ReadCapture calls fn with every record of a capture file.
+func ReadCapture(r io.Reader, fn func(*CaptureRecord) error) error {
+	scanner := bufio.NewScanner(r)
+	//a record holds up to a whole message, base64 encoded
+	scanner.Buffer(make([]byte, 64*1024), 2*DefaultMaxMessage)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := new(CaptureRecord)
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// OpenCaptureFile reads the records of the capture file at path.
+func OpenCaptureFile(path string) ([]*CaptureRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []*CaptureRecord
+	err = ReadCapture(f, func(r *CaptureRecord) error {
+		records = append(records, r)
+		return nil
+	})
+	return records, err
+}
+
+// Replay feeds what the peer sent in a captured wire session through a
+// Processor, with the default limits. The download ends as it did live,
+// with the same result or FetchError; a capture which stops short of it
+// ends with a FailOther error.
+func Replay(records []*CaptureRecord, session uint64) (*MetadataResult, error) {
+	var hash Hash
+	var in [][]byte
+	for _, r := range records {
+		if r.Kind != CaptureWire || r.Session != session {
+			continue
+		}
+		if h, err := HashFromHex(r.Hash); err == nil {
+			hash = h
+		}
+		if r.Dir == CaptureIn {
+			in = append(in, r.Data)
+		}
+	}
+	if len(in) == 0 {
+		return nil, errors.New("no data received in the session")
+	}
+	p := NewProcessor()
+	p.Attach(io.Discard)
+	p.Start(hash)
+	for _, data := range in {
+		var err error
+		if protect("replay", func() { err = p.Feed(data) }) {
+			return nil, &FetchError{Failure: FailOther, Reason: "panic while reading from the peer"}
+		}
+		for {
+			event, _ := p.Next()
+			if event == nil {
+				break
+			}
+			switch event.Type {
+			case EventError:
+				return nil, &FetchError{Failure: event.Failure, Reason: event.Reason, Err: event.Err}
+			case EventDone:
+				return event.Result, nil
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return nil, &FetchError{Failure: FailOther, Reason: "the capture ends before the download"}
+}
+
+// ReplayKRPC parses the KRPC packets received in a capture, the results
+// are what the DHT handled, nil for the packets it dropped.
+func ReplayKRPC(records []*CaptureRecord) ([]*Result, []error) {
+	rpc := NewRPC()
+	var results []*Result
+	var errs []error
+	for _, rec := range records {
+		if rec.Kind != CaptureKRPC || rec.Dir != CaptureIn {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", rec.Peer)
+		if err != nil {
+			results, errs = append(results, nil), append(errs, err)
+			continue
+		}
+		var r *Result
+		if protect("packet", func() { r, err = rpc.parse(rec.Data, addr) }) {
+			err = errors.New("panic while parsing the packet")
+		}
+		results, errs = append(results, r), append(errs, err)
+	}
+	return results, errs
+}
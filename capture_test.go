@@ -0,0 +1,97 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+	"github.com/zeebo/bencode"
+)
+
+func Test_CaptureReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	c, err := OpenCapture(CaptureConfig{Path: path, KRPC: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Wire{capture: &captureSlot{capture: c}}
+
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "captured", "length": 10, "piece length": 16384, "pieces": strings.Repeat("x", PieceSize+100)})
+	hash, addr := fakePeer(t, info)
+	if _, err := w.fromPeer(context.Background(), hash, addr); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := testutil.NewPeer(info)
+	corrupt.Piece = func(i int, m []byte) []byte {
+		m[len(m)-1] ^= 0xff
+		return m
+	}
+	hash, addr = scriptedPeer(t, corrupt)
+	if _, err := w.fromPeer(context.Background(), hash, addr); fetchFailure(err) != FailHashMismatch {
+		t.Fatal("corrupt peer", err)
+	}
+	ping, _ := bencode.EncodeBytes(map[string]interface{}{"t": "aa", "y": "q", "q": "ping", "a": map[string]interface{}{"id": strings.Repeat("n", 20)}})
+	from := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 6881}
+	c.packet(CaptureIn, from, ping)
+	c.packet(CaptureIn, from, []byte("d1:t"))
+	c.packet(CaptureOut, from, ping)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := OpenCaptureFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := Replay(records, 1)
+	if err != nil || !bytes.Equal(r.Info, info) || r.Name != "captured" {
+		t.Fatal("replay of the download", err)
+	}
+	if _, err := Replay(records, 2); fetchFailure(err) != FailHashMismatch {
+		t.Error("replay of the corrupt peer", err)
+	}
+	if _, err := Replay(records, 3); err == nil {
+		t.Error("replay of a session not captured")
+	}
+	//a capture cut short of the download
+	var cut []*CaptureRecord
+	for _, rec := range records {
+		if rec.Session == 1 && rec.Dir == CaptureIn && len(cut) < 2 {
+			cut = append(cut, rec)
+		}
+	}
+	if _, err := Replay(cut, 1); fetchFailure(err) != FailOther {
+		t.Error("replay of a cut capture", err)
+	}
+
+	results, errs := ReplayKRPC(records)
+	if len(results) != 2 || results[0] == nil || results[0].Cmd != OP_PING || results[0].UDPAddr.String() != from.String() {
+		t.Fatal("replayed packets", results)
+	}
+	if errs[1] == nil {
+		t.Error("malformed packet parsed")
+	}
+}
+
+func Test_CapturePeers(t *testing.T) {
+	c, err := OpenCapture(CaptureConfig{Path: filepath.Join(t.TempDir(), "capture.jsonl"), Peers: []string{"192.0.2.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	if got := c.conn(conn, Hash{}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 2)}); got != conn {
+		t.Error("connection of another peer captured")
+	}
+	if got := c.conn(conn, Hash{}, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1)}); got == conn {
+		t.Error("connection of the peer not captured")
+	}
+	if got := (*Capture)(nil).conn(conn, Hash{}, &net.TCPAddr{}); got != conn {
+		t.Error("nil capture wraps the connection")
+	}
+}
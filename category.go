@@ -0,0 +1,75 @@
+package DHTCrawl
+
+import (
+	"strings"
+)
+
+const (
+	CategoryVideo    = "video"
+	CategoryAudio    = "audio"
+	CategoryImage    = "image"
+	CategoryDocument = "document"
+	CategoryArchive  = "archive"
+	CategorySoftware = "software"
+	CategoryOther    = "other"
+)
+
+var categoryNames = map[int]string{
+	MetaTypeVideo:    CategoryVideo,
+	MetaTypeAudio:    CategoryAudio,
+	MetaTypePicture:  CategoryImage,
+	MetaTypeDocument: CategoryDocument,
+	MetaTypeZip:      CategoryArchive,
+	MetaTypeExe:      CategorySoftware,
+	MetaTypeOther:    CategoryOther,
+}
+
+// CategoryName returns the category of a MetaType value.
+func CategoryName(metaType int) string {
+	if name, ok := categoryNames[metaType]; ok {
+		return name
+	}
+	return CategoryOther
+}
+
+// Categorize sets Type and Category from the file extensions. Every file
+// votes for its type with its size, so a movie wins over the sample images
+// and nfo shipped next to it. Files without a known extension only count
+// when nothing else matched.
+func (m *MetadataResult) Categorize() {
+	votes := make(map[int]int64)
+	vote := func(path string, length int64) {
+		ext := ""
+		if i := strings.LastIndex(path, "."); i >= 0 {
+			ext = strings.ToLower(path[i+1:])
+		}
+		if length <= 0 {
+			length = 1
+		}
+		votes[GetMetaType(ext)] += length
+	}
+	if len(m.Files) == 0 {
+		vote(m.Name, m.Length)
+	}
+	for _, f := range m.Files {
+		path := f.Path
+		if len(f.UPath) != 0 {
+			path = f.UPath
+		}
+		if len(path) != 0 {
+			vote(path[len(path)-1], f.Length)
+		}
+	}
+
+	m.Type = MetaTypeOther
+	var best int64
+	for t, size := range votes {
+		if t == MetaTypeOther {
+			continue
+		}
+		if size > best || (size == best && t < m.Type) {
+			m.Type, best = t, size
+		}
+	}
+	m.Category = CategoryName(m.Type)
+}
@@ -0,0 +1,27 @@
+package DHTCrawl
+
+import "testing"
+
+func Test_Categorize(t *testing.T) {
+	m := &MetadataResult{Name: "movie", Files: []*File{
+		{Path: []string{"movie.MKV"}, Length: 1 << 30},
+		{Path: []string{"cover.jpg"}, Length: 1 << 20},
+		{Path: []string{"readme"}, Length: 1 << 10},
+	}}
+	m.Categorize()
+	if m.Category != CategoryVideo || m.Type != MetaTypeVideo {
+		t.Error("Multi file category", m.Category)
+	}
+
+	m = &MetadataResult{Name: "setup.exe", Length: 1 << 20}
+	m.Categorize()
+	if m.Category != CategorySoftware {
+		t.Error("Single file category", m.Category)
+	}
+
+	m = &MetadataResult{Name: "unknown"}
+	m.Categorize()
+	if m.Category != CategoryOther {
+		t.Error("Unknown category", m.Category)
+	}
+}
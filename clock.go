@@ -0,0 +1,131 @@
+package DHTCrawl
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Clock is the time of the DHT walk, the token rotation, the limiters
+	// and the refetch backoff. A ManualClock with a seed makes a run
+	// reproducible, the deadlines of the sockets stay on the wall clock.
+	Clock interface {
+		Now() time.Time
+		AfterFunc(d time.Duration, f func()) Timer
+	}
+
+	// Timer is a function scheduled by Clock.AfterFunc.
+	Timer interface {
+		Stop() bool
+	}
+
+	systemClock struct{}
+
+	// ManualClock only moves when it is advanced, the functions which are
+	// due run in the order of their time, then of their scheduling.
+	ManualClock struct {
+		mu     sync.Mutex
+		now    time.Time
+		seq    uint64
+		timers []*manualTimer
+	}
+
+	manualTimer struct {
+		clock *ManualClock
+		at    time.Time
+		seq   uint64
+		f     func()
+	}
+)
+
+// SystemClock is the wall clock, the default of everything taking a Clock.
+var SystemClock Clock = systemClock{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// clockOr returns c, or SystemClock when c is nil.
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// after is time.After on c.
+func after(c Clock, d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+	return ch
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	t := &manualTimer{clock: c, at: c.now.Add(d), seq: c.seq, f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock d forward and runs the functions due meanwhile,
+// those they schedule too when they fall within d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.Slice(c.timers, func(i, j int) bool {
+			a, b := c.timers[i], c.timers[j]
+			return a.at.Before(b.at) || a.at.Equal(b.at) && a.seq < b.seq
+		})
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// Pending counts the functions waiting for their time.
+func (c *ManualClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *manualTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package DHTCrawl
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func Test_ManualClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+	var fired []int
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	c.AfterFunc(time.Second, func() {
+		fired = append(fired, 1)
+		//scheduled while advancing, still within it
+		c.AfterFunc(time.Second, func() { fired = append(fired, 3) })
+	})
+	stopped := c.AfterFunc(time.Second, func() { fired = append(fired, 0) })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("stop of a pending timer")
+	}
+	c.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 || !c.Now().Equal(start.Add(1500*time.Millisecond)) {
+		t.Fatal("after 1.5s", fired, c.Now())
+	}
+	c.Advance(time.Second)
+	if len(fired) != 3 || fired[1] != 2 || fired[2] != 3 || c.Pending() != 0 {
+		t.Error("after 2.5s", fired)
+	}
+	select {
+	case now := <-after(c, time.Minute):
+		t.Error("fired early", now)
+	default:
+	}
+}
+
+func Test_Deterministic(t *testing.T) {
+	c := NewManualClock(time.Unix(0, 0))
+	a := newToken(1, c, rand.NewSource(7))
+	b := newToken(1, c, rand.NewSource(7))
+	first := a.Value
+	if a.Value != b.Value {
+		t.Fatal("tokens of one seed differ")
+	}
+	c.Advance(time.Minute)
+	if a.Value == first || a.Value != b.Value || !a.IsValid(first) {
+		t.Error("token not rotated on the clock", first, a.Value, b.Value)
+	}
+	c.Advance(time.Minute)
+	if a.IsValid(first) {
+		t.Error("token valid after two rotations")
+	}
+
+	l := NewLimiter(1, 1)
+	l.SetClock(c)
+	if !l.Allow() || l.Allow() {
+		t.Fatal("burst of one")
+	}
+	c.Advance(time.Second)
+	if !l.Allow() {
+		t.Error("bucket not refilled on the clock")
+	}
+
+	cfg := NewDefaultConfig()
+	nodes := make([]*DHT, 2)
+	for i := range nodes {
+		d, err := newDHT(cfg, 0, 0, NewWireJob(1, 1), c, rand.NewSource(42))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Session.Close()
+		nodes[i] = d
+	}
+	if nodes[0].Table.Self.Hex() != nodes[1].Table.Self.Hex() || nodes[0].Token.Value != nodes[1].Token.Value {
+		t.Error("nodes of one source differ")
+	}
+	if nodes[0].newID().Hex() != nodes[1].newID().Hex() {
+		t.Error("walk targets of one source differ")
+	}
+}
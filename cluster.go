@@ -0,0 +1,277 @@
+package DHTCrawl
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// The defaults of ClusterConfig.
+const (
+	DefaultClusterHeartbeat = 5  //seconds between two registrations
+	DefaultClusterTTL       = 15 //seconds a member stays registered without one
+)
+
+type (
+	// ClusterConfig partitions the keyspace between the crawlers of several
+	// machines. They register in the Redis of the redis key, which already
+	// shares their seen hashes. Every member owns the hashes it wins by rendezvous
+	// hashing, the announces of the other hashes are forwarded over UDP to
+	// the Advertise address of their owner. A member joining or leaving
+	// only moves its share of the hashes. The forward sockets take any
+	// packet, keep them on a private network.
+	ClusterConfig struct {
+		Listen    string `json:"listen"`    //UDP address of the forward socket
+		Advertise string `json:"advertise"` //host:port the other members reach it at, Listen by default
+		Heartbeat int    `json:"heartbeat"` //seconds, 0 is DefaultClusterHeartbeat
+		TTL       int    `json:"ttl"`       //seconds, 0 is DefaultClusterTTL
+	}
+
+	// ClusterStats tells which members a crawler sees and counts the
+	// announces it kept, forwarded or got from the others.
+	ClusterStats struct {
+		Self      string   `json:"self"`
+		Members   []string `json:"members"`
+		Owned     uint64   `json:"owned"`
+		Forwarded uint64   `json:"forwarded"`
+		Received  uint64   `json:"received"`
+		Dropped   uint64   `json:"dropped"` //received for a hash of another member
+	}
+
+	// Cluster is the Filter of the hashes a member owns. The members are
+	// the keys of a sorted set scored by the time their registration ends,
+	// read again at every heartbeat. While Redis is unreachable the last
+	// members read are kept, a crawler alone owns every hash.
+	Cluster struct {
+		Self      string
+		Heartbeat time.Duration
+		TTL       time.Duration
+
+		client    *redis.Client
+		key       string
+		pool      *WireJob
+		conn      *net.UDPConn
+		mu        sync.RWMutex
+		members   []string
+		addrs     map[string]*net.UDPAddr
+		owned     uint64
+		forwarded uint64
+		received  uint64
+		dropped   uint64
+	}
+)
+
+// NewCluster binds the forward socket of cfg and registers it in the Redis
+// of seen, the announces received on it are added to pool.
+func NewCluster(cfg *ClusterConfig, seen *RedisSeen, pool *WireJob) (*Cluster, error) {
+	if seen == nil {
+		return nil, errors.New("cluster needs redis")
+	}
+	addr, err := net.ResolveUDPAddr("udp", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{
+		Self:      cfg.Advertise,
+		Heartbeat: time.Duration(cfg.Heartbeat) * time.Second,
+		TTL:       time.Duration(cfg.TTL) * time.Second,
+		client:    seen.Client,
+		key:       seen.Prefix + ":cluster",
+		pool:      pool,
+		addrs:     make(map[string]*net.UDPAddr),
+	}
+	if c.Heartbeat == 0 {
+		c.Heartbeat = DefaultClusterHeartbeat * time.Second
+	}
+	if c.TTL == 0 {
+		c.TTL = DefaultClusterTTL * time.Second
+	}
+	if c.conn, err = net.ListenUDP("udp", addr); err != nil {
+		return nil, err
+	}
+	if c.Self == "" {
+		c.Self = c.conn.LocalAddr().String()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Refresh(ctx); err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Refresh registers the member for TTL and reads the members again.
+func (c *Cluster) Refresh(ctx context.Context) error {
+	now := time.Now()
+	pipe := c.client.TxPipeline()
+	pipe.ZAdd(ctx, c.key, redis.Z{Score: float64(now.Add(c.TTL).Unix()), Member: c.Self})
+	pipe.ZRemRangeByScore(ctx, c.key, "-inf", strconv.FormatInt(now.Unix(), 10))
+	members := pipe.ZRange(ctx, c.key, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	c.setMembers(members.Val())
+	return nil
+}
+
+func (c *Cluster) setMembers(members []string) {
+	sort.Strings(members)
+	addrs := make(map[string]*net.UDPAddr, len(members))
+	for _, m := range members {
+		if m == c.Self {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", m)
+		if err != nil {
+			logPipeline.Warn("cluster member skipped", "member", m, "error", err)
+			continue
+		}
+		addrs[m] = addr
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !equalStrings(members, c.members) {
+		logPipeline.Info("cluster members", "members", members)
+	}
+	c.members, c.addrs = members, addrs
+}
+
+// Run registers the member every Heartbeat until stop is closed.
+func (c *Cluster) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.Heartbeat)
+			if err := c.Refresh(ctx); err != nil {
+				logPipeline.Warn("cluster heartbeat failed", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Owner returns the member hash belongs to: the one whose name hashed with
+// it scores highest.
+func (c *Cluster) Owner(hash Hash) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	owner, best := c.Self, uint64(0)
+	for _, m := range c.members {
+		h := fnv.New64a()
+		h.Write([]byte(m))
+		h.Write(hash[:])
+		if score := h.Sum64(); score >= best {
+			owner, best = m, score
+		}
+	}
+	return owner
+}
+
+// AllowHash keeps the hashes of this member and forwards the others. A hash
+// without a peer, from an inbound connection, is fetched where it arrived.
+func (c *Cluster) AllowHash(hash Hash, peer *net.TCPAddr) bool {
+	owner := c.Owner(hash)
+	c.mu.RLock()
+	addr := c.addrs[owner]
+	c.mu.RUnlock()
+	if owner == c.Self || addr == nil || peer == nil {
+		atomic.AddUint64(&c.owned, 1)
+		return true
+	}
+	if err := forwardAnnounce(c.conn, addr, hash, peer); err != nil {
+		// fetched here rather than lost
+		atomic.AddUint64(&c.owned, 1)
+		logPipeline.Debug("cluster forward failed", "infohash", hash, "member", owner, "error", err)
+		return true
+	}
+	atomic.AddUint64(&c.forwarded, 1)
+	metricShard.WithLabelValues("forwarded").Inc()
+	return false
+}
+
+func (c *Cluster) AllowResult(*MetadataResult) bool {
+	return true
+}
+
+// Serve adds the announces the other members forward to the pool until the
+// forward socket is closed. The hashes it doesn't own after a change of the
+// members are dropped, they would be forwarded back.
+func (c *Cluster) Serve() error {
+	buf := make([]byte, 64)
+	for {
+		n, _, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		hash, peer, err := parseForward(buf[:n])
+		if err != nil {
+			continue
+		}
+		if c.Owner(hash) != c.Self {
+			atomic.AddUint64(&c.dropped, 1)
+			metricShard.WithLabelValues("dropped").Inc()
+			continue
+		}
+		atomic.AddUint64(&c.received, 1)
+		metricShard.WithLabelValues("received").Inc()
+		c.pool.Add(NewJob(hash, peer))
+	}
+}
+
+// Close leaves the cluster, the other members take its hashes over at
+// their next heartbeat, and closes the forward socket.
+func (c *Cluster) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := c.client.ZRem(ctx, c.key, c.Self).Err()
+	if e := c.conn.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+func (c *Cluster) Addr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Cluster) Stats() ClusterStats {
+	c.mu.RLock()
+	members := append([]string(nil), c.members...)
+	c.mu.RUnlock()
+	return ClusterStats{
+		Self:      c.Self,
+		Members:   members,
+		Owned:     atomic.LoadUint64(&c.owned),
+		Forwarded: atomic.LoadUint64(&c.forwarded),
+		Received:  atomic.LoadUint64(&c.received),
+		Dropped:   atomic.LoadUint64(&c.dropped),
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
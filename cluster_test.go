@@ -0,0 +1,86 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func Test_Cluster(t *testing.T) {
+	srv := miniredis.RunT(t)
+	seen, err := NewRedisSeen(RedisConfig{Addr: srv.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seen.Close()
+	pool := NewWireJob(1, 16)
+	defer pool.Stop()
+	if _, err := NewCluster(&ClusterConfig{Listen: "127.0.0.1:0"}, nil, pool); err == nil {
+		t.Error("cluster without redis")
+	}
+	a, err := NewCluster(&ClusterConfig{Listen: "127.0.0.1:0"}, seen, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewCluster(&ClusterConfig{Listen: "127.0.0.1:0"}, seen, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve()
+	// a registered before b, it sees b at its next heartbeat
+	if len(a.Stats().Members) != 1 {
+		t.Error("members of a", a.Stats().Members)
+	}
+	a.Refresh(context.Background())
+	if len(a.Stats().Members) != 2 || len(b.Stats().Members) != 2 {
+		t.Fatal("members", a.Stats().Members, b.Stats().Members)
+	}
+
+	// both members agree on the owners, and both own some
+	var theirs Hash
+	owned := 0
+	for i := 0; i < 64; i++ {
+		h := testHash(string(rune('a' + i)))
+		if a.Owner(h) != b.Owner(h) {
+			t.Fatal("owners differ", h)
+		}
+		if a.Owner(h) == a.Self {
+			owned++
+		} else {
+			theirs = h
+		}
+	}
+	if owned == 0 || owned == 64 {
+		t.Fatal("hashes owned by a", owned)
+	}
+	peer := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	if a.AllowHash(theirs, peer) || !a.AllowHash(theirs, nil) {
+		t.Error("hash of b allowed")
+	}
+	for i := 0; i < 100 && b.Stats().Received == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := a.Stats(); st.Forwarded != 1 || st.Owned != 1 {
+		t.Errorf("%+v", st)
+	}
+	if st := b.Stats(); st.Received != 1 {
+		t.Errorf("%+v", st)
+	}
+
+	// b leaves, its hashes go back to a
+	b.Close()
+	a.Refresh(context.Background())
+	if a.Owner(theirs) != a.Self || !a.AllowHash(theirs, peer) {
+		t.Error("hash of b not taken over")
+	}
+	// a member which stopped its heartbeats expires
+	srv.ZAdd(seen.Prefix+":cluster", float64(time.Now().Add(-time.Second).Unix()), "10.0.0.9:6900")
+	a.Refresh(context.Background())
+	if members := a.Stats().Members; len(members) != 1 {
+		t.Error("expired member kept", members)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+func backfillCommand() *cobra.Command {
+	var (
+		path, since, until string
+		samples            bool
+		rate               float64
+		drain              time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Feed the announce log through the fetch pipeline again",
+		Long: `Backfill replays the announces of announce_log.path, oldest segment first,
+as if they were received now: they go through the current filters, the
+hashes missing from the current store are fetched from their logged peers
+and handed to the sinks. Run it after a change of the filters or of the
+store. The nodes of the backfill only look peers up, they don't discover
+hashes, and nothing is served.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if path == "" && cfg.AnnounceLog != nil {
+				path = cfg.AnnounceLog.Path
+			}
+			if path == "" {
+				return errors.New("no announce log, set announce_log.path or --log")
+			}
+			opts := dhtcrawl.ReplayOptions{Samples: samples, Rate: rate}
+			if opts.Since, err = parseDate(since); err != nil {
+				return err
+			}
+			if opts.Until, err = parseDate(until); err != nil {
+				return err
+			}
+			// next to a running crawler: another port, no state, nothing
+			// logged again, announced or served, and paced by --rate alone
+			cfg.Port, cfg.StatePath, cfg.FetchRate = 0, "", 0
+			cfg.AnnounceLog, cfg.Listen, cfg.Announce, cfg.StatsD, cfg.Health = nil, nil, nil, nil, nil
+			cfg.HTTPAddr, cfg.GRPCAddr = "", ""
+			crawler, err := dhtcrawl.NewCrawler(dhtcrawl.WithConfig(cfg))
+			if err != nil {
+				return err
+			}
+			crawler.Pause()
+			go crawler.Run()
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			n, err := crawler.ReplayAnnounceLog(ctx, path, opts)
+			fmt.Fprintf(os.Stderr, "replayed %d hashes\n", n)
+			// the queued hashes are dropped by Shutdown, wait for them
+			for deadline := time.Now().Add(drain); ctx.Err() == nil && crawler.Pool.InFlight() > 0 && time.Now().Before(deadline); {
+				time.Sleep(time.Second)
+			}
+			sctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if serr := crawler.Shutdown(sctx); serr != nil && serr != dhtcrawl.ErrCrawlerClosed && err == nil {
+				err = serr
+			}
+			st := crawler.Stats()
+			fmt.Fprintf(os.Stderr, "fetched %d, failed %d, filtered %d\n", st.Succeeded, st.Failed, st.Filtered)
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&path, "log", "", "announce log, announce_log.path by default")
+	f.StringVar(&since, "since", "", "only the records from this RFC 3339 time or date")
+	f.StringVar(&until, "until", "", "only the records before this RFC 3339 time or date")
+	f.BoolVar(&samples, "samples", false, "replay the get_peers samples too, as hashes without a peer")
+	f.Float64Var(&rate, "rate", dhtcrawl.DefaultReplayRate, "hashes fed a second")
+	f.DurationVar(&drain, "drain", 10*time.Minute, "wait this long at most for the last fetches")
+	return cmd
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// crawlFlags are shared by crawl and daemon.
+type crawlFlags struct {
+	port, nodes, workers int
+	httpAddr, grpcAddr   string
+	driver, path         string
+	restore              string
+	drain                time.Duration
+}
+
+func (o *crawlFlags) add(f *pflag.FlagSet) {
+	f.IntVar(&o.port, "port", 0, "UDP port of the first node, 0 picks random ports")
+	f.IntVar(&o.nodes, "nodes", 1, "DHT nodes to run on consecutive ports")
+	f.IntVar(&o.workers, "workers", 0, "concurrent metadata downloads")
+	f.StringVar(&o.httpAddr, "http", "", "listen address of the HTTP API")
+	f.StringVar(&o.grpcAddr, "grpc", "", "listen address of the gRPC API")
+	f.StringVar(&o.driver, "store", "", "store driver: bolt, sqlite or postgres")
+	f.StringVar(&o.path, "store-path", "", "store file, or DSN for postgres")
+	f.DurationVar(&o.drain, "drain", time.Second*10, "how long to wait for running downloads on shutdown")
+	f.StringVar(&o.restore, "restore", "", "snapshot of GET /admin/snapshot to start from")
+}
+
+// config loads --config, applies the flags which were given and logs to
+// stderr as the config says.
+func (o *crawlFlags) config(flags *pflag.FlagSet) (*dhtcrawl.DHTConfig, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if flags.Changed("port") {
+		cfg.Port = o.port
+	}
+	if flags.Changed("nodes") {
+		cfg.Nodes = o.nodes
+	}
+	if flags.Changed("workers") {
+		cfg.JobSize = o.workers
+	}
+	if flags.Changed("http") {
+		cfg.HTTPAddr = o.httpAddr
+	}
+	if flags.Changed("grpc") {
+		cfg.GRPCAddr = o.grpcAddr
+	}
+	if o.driver != "" {
+		cfg.StoreDriver = o.driver
+	}
+	if o.path != "" {
+		cfg.StorePath = o.path
+	}
+	return cfg, dhtcrawl.ConfigureLogging(cfg.Log, os.Stderr)
+}
+
+// run crawls until SIGINT or SIGTERM, then drains for o.drain.
+func (o *crawlFlags) run(crawler *dhtcrawl.Crawler) error {
+	if o.restore != "" {
+		f, err := os.Open(o.restore)
+		if err != nil {
+			return err
+		}
+		err = crawler.Restore(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("restore %s: %w", o.restore, err)
+		}
+	}
+	if configPath != "" {
+		crawler.WatchConfig(configPath)
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), o.drain)
+		defer cancel()
+		if err := crawler.Shutdown(ctx); err != nil {
+			crawler.Logger.Error("shutdown", "error", err)
+		}
+	}()
+	if err := crawler.Run(); err != dhtcrawl.ErrCrawlerClosed {
+		return err
+	}
+	return nil
+}
+
+func crawlCommand() *cobra.Command {
+	o := &crawlFlags{}
+	var tui bool
+	cmd := &cobra.Command{
+		Use:   "crawl",
+		Short: "Run the DHT nodes, the fetch pipeline and the sinks",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := o.config(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			crawler, err := dhtcrawl.NewCrawler(dhtcrawl.WithConfig(cfg))
+			if err != nil {
+				return err
+			}
+			if tui {
+				return o.runTUI(crawler)
+			}
+			return o.run(crawler)
+		},
+	}
+	o.add(cmd.Flags())
+	cmd.Flags().BoolVar(&tui, "tui", false, "show live counters and the latest torrents in the terminal")
+	return cmd
+}
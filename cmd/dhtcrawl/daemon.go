@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+// exitCrash is the exit code of a daemon which panicked.
+const exitCrash = 70
+
+func daemonCommand() *cobra.Command {
+	var (
+		logPath, crashDir string
+		logSize, logKeep  int
+		logAge            time.Duration
+	)
+	o := &crawlFlags{}
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Crawl as a long running service with rotated logs and crash reports",
+		Long: `Daemon runs crawl in the foreground for a service manager. The logs go to a
+rotated file. A panic in the handling of one peer, packet or result is logged
+and the crawler goes on. A panic which stops the crawler writes a crash report
+with the stats and every goroutine stack to --crash-dir and exits with 70,
+the runtime appends fatal errors to crash.log there.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			cfg, err := o.config(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(crashDir, 0755); err != nil {
+				return err
+			}
+			if err := dhtcrawl.SetCrashOutput(filepath.Join(crashDir, "crash.log")); err != nil {
+				return err
+			}
+			logs, err := dhtcrawl.OpenRotatingFile(logPath, int64(logSize)<<20, logAge)
+			if err != nil {
+				return err
+			}
+			logs.Keep = logKeep
+			defer logs.Close()
+			go func() {
+				for range time.Tick(time.Second) {
+					logs.Flush()
+				}
+			}()
+			if err := dhtcrawl.ConfigureLogging(cfg.Log, logs); err != nil {
+				return err
+			}
+			//the libraries which use the log package
+			log.SetOutput(logs)
+			logger := dhtcrawl.Logger("daemon")
+
+			crawler, err := dhtcrawl.NewCrawler(dhtcrawl.WithConfig(cfg))
+			if err != nil {
+				return err
+			}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				logger.Error("crawler panic", "panic", v)
+				path, e := crawler.WriteCrashReport(crashDir, v)
+				if e != nil {
+					logger.Error("write crash report", "error", e)
+				}
+				err = &exitError{code: exitCrash, err: fmt.Errorf("crashed: %v, report in %s", v, path)}
+			}()
+			logger.Info("daemon started", "pid", os.Getpid())
+			err = o.run(crawler)
+			logger.Info("daemon stopped")
+			return err
+		},
+	}
+	f := cmd.Flags()
+	o.add(f)
+	f.StringVar(&logPath, "log", "dhtcrawl.log", "log file")
+	f.IntVar(&logSize, "log-max-size", 100, "rotate the log after this many MB, 0 disables")
+	f.DurationVar(&logAge, "log-max-age", 24*time.Hour, "rotate the log after this long, 0 disables")
+	f.IntVar(&logKeep, "log-keep", 7, "rotated logs kept, 0 keeps all")
+	f.StringVar(&crashDir, "crash-dir", ".", "directory of the crash reports")
+	return cmd
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+func exportCommand() *cobra.Command {
+	var (
+		driver, path, out, format string
+		category, since, until    string
+		minSize                   int64
+		aliveWithin               time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Dump the stored torrents as JSON lines or CSV",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			q := dhtcrawl.TorrentQuery{Category: category, MinSize: minSize}
+			if q.Since, err = parseDate(since); err != nil {
+				return err
+			}
+			if q.Until, err = parseDate(until); err != nil {
+				return err
+			}
+			if aliveWithin > 0 {
+				q.Alive = time.Now().Add(-aliveWithin)
+			}
+			store, err := openStore(cfg, driver, path)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			if out == "" || out == "-" {
+				n, err := dhtcrawl.Export(store, os.Stdout, format, q)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stderr, "exported %d torrents\n", n)
+				return nil
+			}
+			// a failed export leaves no file which looks complete
+			f, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".*.tmp")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(f.Name())
+			f.Chmod(0644)
+			n, err := dhtcrawl.Export(store, f, format, q)
+			if err == nil {
+				err = f.Close()
+			} else {
+				f.Close()
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Rename(f.Name(), out); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "exported %d torrents\n", n)
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&driver, "store", "", "store driver: bolt, sqlite or postgres")
+	f.StringVar(&path, "store-path", "", "store file, or DSN for postgres")
+	f.StringVarP(&out, "out", "o", "-", "output file, - for stdout")
+	f.StringVar(&format, "format", "jsonl", "jsonl or csv")
+	f.StringVar(&category, "category", "", "only this category")
+	f.Int64Var(&minSize, "min-size", 0, "only torrents of at least this many bytes")
+	f.StringVar(&since, "since", "", "only torrents created from this RFC 3339 time or date")
+	f.StringVar(&until, "until", "", "only torrents created before this RFC 3339 time or date")
+	f.DurationVar(&aliveWithin, "alive-within", 0, "only torrents a peer was seen of in this duration, like 168h")
+	return cmd
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+// exit codes of fetch, for scripts
+const (
+	exitBadInput = 2 //not a magnet URI or infohash
+	exitTimeout  = 3 //--timeout passed before a peer sent the metadata
+	exitNotFound = 4 //every peer which was found failed
+)
+
+// The announces of fetch tell the trackers a port, fetch accepts no
+// connections, and hold the download for announceTimeout at most.
+const (
+	announcePort    = 6881
+	announceTimeout = 10 * time.Second
+)
+
+func fetchCommand() *cobra.Command {
+	var (
+		peers    []string
+		timeout  time.Duration
+		output   string
+		asJSON   bool
+		noDHT    bool
+		trackers []string
+	)
+	cmd := &cobra.Command{
+		Use:   "fetch <magnet or infohash>",
+		Short: "Fetch one torrent from its peers and write the .torrent file",
+		Long: `Fetch looks the peers of the torrent up in the DHT and asks its trackers,
+downloads the metadata from several of them at once and writes the first copy
+matching the infohash as <INFOHASH>.torrent.
+
+Exit codes: 0 written, 1 other error, 2 bad magnet or infohash, 3 timed out,
+4 no peer sent the metadata.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hash, addrs, err := dhtcrawl.ParseMagnet(args[0])
+			if err != nil {
+				return &exitError{code: exitBadInput, err: err}
+			}
+			for _, p := range peers {
+				addr, err := net.ResolveTCPAddr("tcp", p)
+				if err != nil {
+					return &exitError{code: exitBadInput, err: err}
+				}
+				addrs = append(addrs, addr)
+			}
+			var entries []string
+			trackers = append(trackers, dhtcrawl.MagnetTrackers(args[0])...)
+			if !noDHT {
+				cfg, err := loadConfig()
+				if err != nil {
+					return err
+				}
+				entries = cfg.Entries
+				if cfg.Trackers != nil {
+					trackers = append(trackers, cfg.Trackers.URLs...)
+				}
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+			if len(trackers) > 0 {
+				scraper, err := dhtcrawl.NewScraper(&dhtcrawl.TrackerConfig{URLs: trackers})
+				if err != nil {
+					return &exitError{code: exitBadInput, err: err}
+				}
+				actx, acancel := context.WithTimeout(ctx, announceTimeout)
+				addrs = append(addrs, scraper.Announce(actx, hash, announcePort)...)
+				acancel()
+			}
+			r, err := dhtcrawl.FetchMetadata(ctx, hash, addrs, entries)
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				return &exitError{code: exitTimeout, err: fmt.Errorf("no metadata after %s", timeout)}
+			case errors.Is(err, dhtcrawl.ErrNoMetadata):
+				return &exitError{code: exitNotFound, err: err}
+			case err != nil:
+				return err
+			}
+			r.Hex = hash.Hex()
+			if asJSON {
+				r.Categorize()
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(r)
+			}
+			switch output {
+			case "-":
+				_, err = os.Stdout.Write(r.Torrent())
+				return err
+			case "":
+				output = r.Hex + ".torrent"
+			}
+			if err := os.WriteFile(output, r.Torrent(), 0644); err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, output)
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&peers, "peer", nil, "host:port of a peer of the torrent, repeatable")
+	cmd.Flags().DurationVar(&timeout, "timeout", time.Minute, "give up after this long")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write, - for stdout (default <INFOHASH>.torrent)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the metadata as JSON instead")
+	cmd.Flags().BoolVar(&noDHT, "no-dht", false, "only ask the peers and the trackers of the magnet and the flags")
+	cmd.Flags().StringSliceVar(&trackers, "tracker", nil, "udp, http or https announce URL of a tracker to ask for peers, repeatable")
+	return cmd
+}
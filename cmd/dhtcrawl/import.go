@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+func importCommand() *cobra.Command {
+	var (
+		driver, path string
+		dryRun       bool
+	)
+	cmd := &cobra.Command{
+		Use:   "import <.torrent file or directory>...",
+		Short: "Add .torrent files to the store, so the crawler skips them",
+		Long: `Import reads .torrent files, directories are searched for them, and puts
+their metadata in the store. The infohash of every file is printed, the v2
+one follows for v2 and hybrid torrents. With --dry-run nothing is stored,
+which cross-checks an archive against the names of its files.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var store dhtcrawl.Store
+			if !dryRun {
+				cfg, err := loadConfig()
+				if err != nil {
+					return err
+				}
+				if store, err = openStore(cfg, driver, path); err != nil {
+					return err
+				}
+				defer store.Close()
+			}
+			imported, failed := 0, 0
+			for _, file := range torrentFiles(args) {
+				added, err := importTorrent(store, file)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+					failed++
+					continue
+				}
+				if added {
+					imported++
+				}
+			}
+			if !dryRun {
+				fmt.Fprintf(os.Stderr, "imported %d torrents\n", imported)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d files failed", failed)
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&driver, "store", "", "store driver: bolt, sqlite or postgres")
+	f.StringVar(&path, "store-path", "", "store file, or DSN for postgres")
+	f.BoolVar(&dryRun, "dry-run", false, "only print the infohashes")
+	return cmd
+}
+
+// torrentFiles expands the directories of args to the .torrent files in
+// them, files are kept whatever their name.
+func torrentFiles(args []string) []string {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil || !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		filepath.WalkDir(arg, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".torrent") {
+				files = append(files, p)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// importTorrent prints the infohash of file and, with a store, stores it
+// unless it already is.
+func importTorrent(store dhtcrawl.Store, file string) (added bool, err error) {
+	t, err := dhtcrawl.OpenTorrent(file)
+	if err != nil {
+		return false, err
+	}
+	if t.V2() {
+		fmt.Println(t.Hash.Hex(), t.HashV2.Hex(), file)
+	} else {
+		fmt.Println(t.Hash.Hex(), file)
+	}
+	if store == nil {
+		return false, nil
+	}
+	if has, err := store.Has(t.Hash); err != nil || has {
+		return false, err
+	}
+	r, err := t.Metadata()
+	if err != nil {
+		return false, err
+	}
+	r.Hex = t.Hash.Hex()
+	r.Create = time.Now().Format(time.RFC3339)
+	r.Categorize()
+	return true, store.Put(r)
+}
@@ -0,0 +1,71 @@
+// Command dhtcrawl crawls the DHT for torrent metadata, fetches single
+// torrents and serves or exports what was collected.
+package main
+
+import (
+	"errors"
+	"os"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+var configPath string
+
+func main() {
+	root := &cobra.Command{
+		Use:          "dhtcrawl",
+		Short:        "Crawl the BitTorrent DHT for torrent metadata",
+		SilenceUsage: true,
+	}
+	root.PersistentFlags().StringVarP(&configPath, "config", "c", "", "JSON, YAML or TOML config file, overridden by DHTCRAWL_ variables")
+	root.AddCommand(crawlCommand(), daemonCommand(), fetchCommand(), serveCommand(), exportCommand(), importCommand(), replayCommand(), reprocessCommand(), backfillCommand())
+	if err := root.Execute(); err != nil {
+		var exit *exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
+		os.Exit(1)
+	}
+}
+
+// exitError makes the process exit with code, cobra has printed err.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// loadConfig reads --config, or returns the defaults with the files in the
+// working directory.
+func loadConfig() (*dhtcrawl.DHTConfig, error) {
+	if configPath != "" {
+		return dhtcrawl.LoadConfig(configPath)
+	}
+	cfg := dhtcrawl.NewDefaultConfig()
+	cfg.StatePath = "dhtcrawl.state"
+	cfg.StorePath = "dhtcrawl.db"
+	return cfg, nil
+}
+
+// openStore opens the store of cfg, the store flags override it.
+func openStore(cfg *dhtcrawl.DHTConfig, driver, path string) (dhtcrawl.Store, error) {
+	if driver != "" {
+		cfg.StoreDriver = driver
+	}
+	if path != "" {
+		cfg.StorePath = path
+	}
+	store, err := dhtcrawl.OpenStore(cfg.StoreDriver, cfg.StorePath)
+	if cs, ok := store.(dhtcrawl.CompressedStore); ok {
+		cs.SetCompression(cfg.Compression)
+	}
+	return store, err
+}
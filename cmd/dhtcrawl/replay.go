@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+func replayCommand() *cobra.Command {
+	var (
+		session uint64
+		krpc    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "replay <capture file>",
+		Short: "Replay the peer connections of a capture file offline",
+		Long: `Replay feeds what each captured peer sent through the metadata download
+again and prints how it ends, the torrent name or the failure. The capture
+is recorded by the capture section of the config.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			records, err := dhtcrawl.OpenCaptureFile(args[0])
+			if err != nil {
+				return err
+			}
+			if krpc {
+				results, errs := dhtcrawl.ReplayKRPC(records)
+				for i, r := range results {
+					switch {
+					case errs[i] != nil:
+						fmt.Println("error", errs[i])
+					case r == nil:
+						fmt.Println("dropped")
+					default:
+						fmt.Println(r.Cmd, r.UDPAddr, r.Hash.Hex())
+					}
+				}
+				return nil
+			}
+			peers := map[uint64]string{}
+			for _, r := range records {
+				if r.Kind == dhtcrawl.CaptureWire && (session == 0 || r.Session == session) {
+					peers[r.Session] = r.Peer
+				}
+			}
+			if len(peers) == 0 {
+				return errors.New("no wire session in the capture")
+			}
+			sessions := make([]uint64, 0, len(peers))
+			for s := range peers {
+				sessions = append(sessions, s)
+			}
+			sort.Slice(sessions, func(i, j int) bool { return sessions[i] < sessions[j] })
+			for _, s := range sessions {
+				r, err := dhtcrawl.Replay(records, s)
+				var fe *dhtcrawl.FetchError
+				switch {
+				case errors.As(err, &fe):
+					fmt.Printf("%d %s %s: %s\n", s, peers[s], fe.Failure, fe.Reason)
+				case err != nil:
+					fmt.Printf("%d %s: %v\n", s, peers[s], err)
+				default:
+					fmt.Printf("%d %s ok %s %q\n", s, peers[s], r.Hash.Hex(), r.Name)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Uint64Var(&session, "session", 0, "replay only this session (default all)")
+	cmd.Flags().BoolVar(&krpc, "krpc", false, "parse the captured DHT packets instead")
+	return cmd
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+func reprocessCommand() *cobra.Command {
+	var (
+		driver, path, dir string
+		all               bool
+	)
+	cmd := &cobra.Command{
+		Use:   "reprocess",
+		Short: "Put the torrents of the info cache back in the store",
+		Long: `Reprocess decodes the raw info dictionaries of info_cache_path again and
+stores those the store is missing, so results lost by a store or rebuilt
+with new categories don't have to be downloaded again. With --all the
+stored ones are replaced too.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if dir == "" {
+				dir = cfg.InfoCachePath
+			}
+			if dir == "" {
+				return errors.New("no info cache, set info_cache_path or --cache")
+			}
+			cache := &dhtcrawl.InfoCache{Dir: dir}
+			store, err := openStore(cfg, driver, path)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			stored, failed := 0, 0
+			err = cache.Each(func(hash dhtcrawl.Hash) error {
+				if has, err := store.Has(hash); err != nil || (has && !all) {
+					return err
+				}
+				r, err := cache.Metadata(hash)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", hash.Hex(), err)
+					failed++
+					return nil
+				}
+				r.Hex = hash.Hex()
+				r.Create = time.Now().Format(time.RFC3339)
+				r.Categorize()
+				if err := store.Put(r); err != nil {
+					return err
+				}
+				stored++
+				return nil
+			})
+			fmt.Fprintf(os.Stderr, "stored %d torrents\n", stored)
+			if err == nil && failed > 0 {
+				err = fmt.Errorf("%d cached info dictionaries failed to decode", failed)
+			}
+			return err
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&driver, "store", "", "store driver: bolt, sqlite or postgres")
+	f.StringVar(&path, "store-path", "", "store file, or DSN for postgres")
+	f.StringVar(&dir, "cache", "", "info cache directory, info_cache_path by default")
+	f.BoolVar(&all, "all", false, "replace the torrents already stored")
+	return cmd
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"github.com/spf13/cobra"
+)
+
+func serveCommand() *cobra.Command {
+	var addr, driver, path string
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the query API over an existing store without crawling",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if addr != "" {
+				cfg.HTTPAddr = addr
+			}
+			if cfg.HTTPAddr == "" {
+				cfg.HTTPAddr = ":8080"
+			}
+			if err := dhtcrawl.ConfigureLogging(cfg.Log, os.Stderr); err != nil {
+				return err
+			}
+			store, err := openStore(cfg, driver, path)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			s, err := dhtcrawl.NewStoreServer(cfg, store)
+			if err != nil {
+				return err
+			}
+			if s.Crawler.Search != nil {
+				defer s.Crawler.Search.Close()
+			}
+			go func() {
+				sig := make(chan os.Signal, 1)
+				signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+				<-sig
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+				defer cancel()
+				s.Close(ctx)
+			}()
+			s.Crawler.Logger.Info("serving", "store", cfg.StorePath, "addr", s.Addr())
+			if err := s.ListenAndServe(); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&addr, "http", "", "listen address, the config http_addr or :8080 by default")
+	cmd.Flags().StringVar(&driver, "store", "", "store driver: bolt, sqlite or postgres")
+	cmd.Flags().StringVar(&path, "store-path", "", "store file, or DSN for postgres")
+	return cmd
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
+	"golang.org/x/term"
+)
+
+const (
+	monitorLatest = 50 //torrent names kept, the terminal height decides how many show
+	monitorLogs   = 5
+)
+
+// monitor draws the counters of a crawler on the terminal once a second.
+// q or Ctrl-C stops the crawl, p pauses and resumes it. The log lines are
+// captured and shown under the tables.
+type monitor struct {
+	crawler *dhtcrawl.Crawler
+	out     *os.File
+	started time.Time
+	prev    *dhtcrawl.CrawlerStats
+	prevAt  time.Time
+	latest  []string //newest first
+
+	mu   sync.Mutex
+	logs []string
+}
+
+func newMonitor(crawler *dhtcrawl.Crawler) *monitor {
+	return &monitor{crawler: crawler, out: os.Stdout, started: time.Now()}
+}
+
+// Write keeps the last log lines.
+func (m *monitor) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		m.logs = append(m.logs, line)
+	}
+	if len(m.logs) > monitorLogs {
+		m.logs = m.logs[len(m.logs)-monitorLogs:]
+	}
+	return len(p), nil
+}
+
+// runTUI crawls with the monitor in the foreground, the signals still stop
+// the crawl as without it.
+func (o *crawlFlags) runTUI(crawler *dhtcrawl.Crawler) error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("--tui needs a terminal")
+	}
+	m := newMonitor(crawler)
+	cfg := crawler.Config
+	if err := dhtcrawl.ConfigureLogging(cfg.Log, m); err != nil {
+		return err
+	}
+	log.SetOutput(m)
+
+	errc := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		errc <- o.run(crawler)
+		close(stopped)
+	}()
+	quit, err := m.run(stopped)
+	dhtcrawl.ConfigureLogging(cfg.Log, os.Stderr)
+	log.SetOutput(os.Stderr)
+	if err != nil || quit {
+		ctx, cancel := context.WithTimeout(context.Background(), o.drain)
+		defer cancel()
+		if e := crawler.Shutdown(ctx); e != nil && e != dhtcrawl.ErrCrawlerClosed {
+			crawler.Logger.Error("shutdown", "error", e)
+		}
+	}
+	if e := <-errc; err == nil {
+		err = e
+	}
+	return err
+}
+
+// run draws until a quit key, it returns false when the crawler stopped on
+// its own.
+func (m *monitor) run(stopped <-chan struct{}) (bool, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return false, err
+	}
+	defer term.Restore(fd, state)
+	//alternate screen without cursor, the shell is left as it was
+	fmt.Fprint(m.out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(m.out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	sub := m.crawler.Hub.Subscribe(64, false)
+	defer sub.Close()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	m.draw()
+	for {
+		select {
+		case <-stopped:
+			return false, nil
+		case v, ok := <-sub.C():
+			if !ok {
+				return false, nil
+			}
+			if r, ok := v.(*dhtcrawl.MetadataResult); ok {
+				m.latest = append([]string{printable(r.Name)}, m.latest...)
+				if len(m.latest) > monitorLatest {
+					m.latest = m.latest[:monitorLatest]
+				}
+			}
+		case k := <-keys:
+			switch k {
+			case 'q', 'Q', 3: //Ctrl-C does not raise SIGINT in raw mode
+				return true, nil
+			case 'p', 'P':
+				if m.crawler.Paused() {
+					m.crawler.Resume()
+				} else {
+					m.crawler.Pause()
+				}
+				m.draw()
+			}
+		case <-tick.C:
+			m.draw()
+		}
+	}
+}
+
+func (m *monitor) draw() {
+	width, height, err := term.GetSize(int(m.out.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	now := time.Now()
+	st := m.crawler.Stats()
+	queries, announces, success := "-", "-", "-"
+	if m.prev != nil {
+		secs := now.Sub(m.prevAt).Seconds()
+		queries = fmt.Sprintf("%.1f", float64(st.Queries-m.prev.Queries)/secs)
+		announces = fmt.Sprintf("%.1f", float64(st.Announces-m.prev.Announces)/secs)
+		ok, failed := st.Succeeded-m.prev.Succeeded, st.Failed-m.prev.Failed
+		if ok+failed == 0 {
+			//nothing finished this second, the overall rate
+			ok, failed = st.Succeeded, st.Failed
+		}
+		if ok+failed > 0 {
+			success = fmt.Sprintf("%.1f%%", 100*float64(ok)/float64(ok+failed))
+		}
+	}
+	m.prev, m.prevAt = st, now
+
+	state := "running"
+	if m.crawler.Paused() {
+		state = "paused"
+	}
+	lines := []string{
+		fmt.Sprintf("\x1b[1mDHTCrawl\x1b[0m  %s  up %s    q quit  p pause", state, now.Sub(m.started).Truncate(time.Second)),
+		"",
+		fmt.Sprintf("%-12s %-12s %-14s %-12s %-10s %s", "Queries/s", "Announces/s", "Fetch success", "Connections", "Fetched", "Stored"),
+		fmt.Sprintf("%-12s %-12s %-14s %-12s %-10d %s", queries, announces, success, fmt.Sprintf("%d/%d", st.Busy, st.Workers), st.Succeeded, stored(st.Stored)),
+		"",
+		"\x1b[1mNodes\x1b[0m",
+	}
+	for _, n := range st.Nodes {
+		lines = append(lines, fmt.Sprintf("  %-22s table %-6d queries %-10d announces %d", n.Addr, n.Nodes, n.Queries, n.Announces))
+	}
+	lines = append(lines, "", "\x1b[1mQueues\x1b[0m")
+	for _, q := range st.Queues {
+		lines = append(lines, fmt.Sprintf("  %-10s %7d/%-7d dropped %d", q.Name, q.Len, q.Cap, q.Dropped))
+	}
+	m.mu.Lock()
+	logs := append([]string{}, m.logs...)
+	m.mu.Unlock()
+	lines = append(lines, "", "\x1b[1mLatest torrents\x1b[0m")
+	room := height - len(lines) - len(logs) - 2
+	for i := 0; i < room && i < len(m.latest); i++ {
+		lines = append(lines, "  "+m.latest[i])
+	}
+	if len(logs) > 0 {
+		lines = append(lines, "", "\x1b[1mLog\x1b[0m")
+		lines = append(lines, logs...)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		if i > 0 {
+			//no newline after the last row, it would scroll
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString(truncate(line, width))
+		buf.WriteString("\x1b[K")
+	}
+	buf.WriteString("\x1b[J")
+	m.out.Write(buf.Bytes())
+}
+
+func stored(n int) string {
+	if n < 0 {
+		return "n/a"
+	}
+	return fmt.Sprint(n)
+}
+
+// truncate cuts line to width visible runes, the CSI sequences of the
+// headers take no room.
+func truncate(line string, width int) string {
+	visible, csi := 0, false
+	for i, r := range line {
+		switch {
+		case csi:
+			csi = r < '@' || r > '~' || r == '['
+		case r == '\x1b':
+			csi = true
+		default:
+			if visible == width {
+				return line[:i] + "\x1b[0m"
+			}
+			visible++
+		}
+	}
+	return line
+}
+
+// printable drops the control characters a torrent name may carry, they
+// would move the cursor.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -1,22 +1,56 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	dhtcrawl "bitbucket.org/AlanYang/DHTCrawl"
 )
 
 func main() {
+	path := flag.String("config", "", "JSON, YAML or TOML config file, reloaded on change or SIGHUP")
+	flag.Parse()
 
-	dht := dhtcrawl.NewDHT(nil)
+	cfg := dhtcrawl.NewDefaultConfig()
+	cfg.StatePath = "dhtcrawl.state"
+	cfg.StorePath = "dhtcrawl.db"
+	if *path != "" {
+		var err error
+		if cfg, err = dhtcrawl.LoadConfig(*path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	crawler, err := dhtcrawl.NewCrawler(dhtcrawl.WithConfig(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *path != "" {
+		crawler.WatchConfig(*path)
+	}
 
-	dht.HandleHash(func(hash dhtcrawl.Hash) bool {
+	crawler.HandleHash(func(hash dhtcrawl.Hash) bool {
 		log.Println(hash)
 		return false
 	})
 
-	dht.HandleMetadata(func(info *dhtcrawl.MetadataResult) {
+	crawler.HandleMetadata(func(info *dhtcrawl.MetadataResult) {
 		log.Println(info.String())
 	})
-	dht.Run()
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := crawler.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+	crawler.Run()
 }
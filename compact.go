@@ -0,0 +1,115 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// The sizes of the compact forms of BEP 5 and BEP 32: an address is the IP
+// followed by the port, big endian, a node is its ID followed by its address.
+const (
+	CompactPeerLen  = 4 + 2
+	CompactPeer6Len = 16 + 2
+	CompactNodeLen  = 20 + CompactPeerLen
+	CompactNode6Len = 20 + CompactPeer6Len
+)
+
+var errCompactLength = errors.New("compact info of a bad length")
+
+// EncodeCompactAddr returns the 6 byte form of an IPv4 address or the 18
+// byte one of an IPv6 address.
+func EncodeCompactAddr(ip net.IP, port int) ([]byte, error) {
+	if !IsValidPort(port) {
+		return nil, fmt.Errorf("invalid port %d", port)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if len(ip) != net.IPv6len {
+		return nil, fmt.Errorf("invalid IP %v", ip)
+	}
+	return append(append(make([]byte, 0, len(ip)+2), ip...), byte(port>>8), byte(port)), nil
+}
+
+// DecodeCompactAddr reads a 6 or 18 byte address, the IP is a copy.
+func DecodeCompactAddr(b []byte) (net.IP, int, error) {
+	if len(b) != CompactPeerLen && len(b) != CompactPeer6Len {
+		return nil, 0, errCompactLength
+	}
+	n := len(b) - 2
+	ip := append(net.IP(nil), b[:n]...)
+	port := int(b[n])<<8 | int(b[n+1])
+	if !IsValidPort(port) {
+		return nil, 0, fmt.Errorf("invalid port %d", port)
+	}
+	return ip, port, nil
+}
+
+// EncodeCompactPeer returns the compact peer info of addr, as found in the
+// values of a get_peers response.
+func EncodeCompactPeer(addr *net.TCPAddr) ([]byte, error) {
+	return EncodeCompactAddr(addr.IP, addr.Port)
+}
+
+// DecodeCompactPeer reads a 6 byte IPv4 or an 18 byte IPv6 peer.
+func DecodeCompactPeer(b []byte) (*net.TCPAddr, error) {
+	ip, port, err := DecodeCompactAddr(b)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// EncodeCompactNode returns the 26 byte compact node info of an IPv4 node
+// or the 38 byte one of an IPv6 node.
+func EncodeCompactNode(n *Node) ([]byte, error) {
+	if len(n.ID) != 20 {
+		return nil, fmt.Errorf("node ID of %d bytes", len(n.ID))
+	}
+	if n.Addr == nil {
+		return nil, errors.New("node without address")
+	}
+	addr, err := EncodeCompactAddr(n.Addr.IP, n.Addr.Port)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(make([]byte, 0, 20+len(addr)), n.ID...), addr...), nil
+}
+
+// EncodeCompactNodes concatenates the nodes of one family, the nodes key
+// takes IPv4 ones and the nodes6 key IPv6 ones. The others are left out.
+func EncodeCompactNodes(nodes []*Node, ipv6 bool) []byte {
+	size := CompactNodeLen
+	if ipv6 {
+		size = CompactNode6Len
+	}
+	b := make([]byte, 0, len(nodes)*size)
+	for _, n := range nodes {
+		if c, err := EncodeCompactNode(n); err == nil && len(c) == size {
+			b = append(b, c...)
+		}
+	}
+	return b
+}
+
+// DecodeCompactNodes reads the nodes, 26 bytes each, or the nodes6, 38
+// bytes each, of a response. Nodes with port 0 are skipped.
+func DecodeCompactNodes(b []byte, ipv6 bool) ([]*Node, error) {
+	size := CompactNodeLen
+	if ipv6 {
+		size = CompactNode6Len
+	}
+	if len(b)%size != 0 {
+		return nil, errCompactLength
+	}
+	nodes := make([]*Node, 0, len(b)/size)
+	for i := 0; i < len(b); i += size {
+		ip, port, err := DecodeCompactAddr(b[i+20 : i+size])
+		if err != nil {
+			continue
+		}
+		id := append(NodeID(nil), b[i:i+20]...)
+		nodes = append(nodes, &Node{ID: id, Addr: &net.UDPAddr{IP: ip, Port: port}})
+	}
+	return nodes, nil
+}
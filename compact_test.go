@@ -0,0 +1,71 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func Test_CompactPeer(t *testing.T) {
+	for _, c := range []struct {
+		addr *net.TCPAddr
+		want []byte
+	}{
+		{&net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 6881}, []byte{1, 2, 3, 4, 0x1a, 0xe1}},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, append(net.ParseIP("2001:db8::1"), 1, 0xbb)},
+	} {
+		b, err := EncodeCompactPeer(c.addr)
+		if err != nil || !bytes.Equal(b, c.want) {
+			t.Error("encode", c.addr, b, err)
+		}
+		addr, err := DecodeCompactPeer(b)
+		if err != nil || !addr.IP.Equal(c.addr.IP) || addr.Port != c.addr.Port {
+			t.Error("decode", addr, err)
+		}
+	}
+	if _, err := EncodeCompactPeer(&net.TCPAddr{IP: net.IPv4(1, 2, 3, 4)}); err == nil {
+		t.Error("encoded port 0")
+	}
+	for _, bad := range [][]byte{nil, {1, 2, 3, 4, 0}, {1, 2, 3, 4, 0, 0}} {
+		if _, err := DecodeCompactPeer(bad); err == nil {
+			t.Error("decoded", bad)
+		}
+	}
+}
+
+func Test_CompactNodes(t *testing.T) {
+	v4 := &Node{ID: NewNodeID(), Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6881}}
+	v6 := &Node{ID: NewNodeID(), Addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 6882}}
+	nodes := []*Node{v4, v6, {ID: NodeID{1}, Addr: v4.Addr}}
+
+	b := EncodeCompactNodes(nodes, false)
+	if len(b) != CompactNodeLen {
+		t.Fatal("nodes", len(b))
+	}
+	b6 := EncodeCompactNodes(nodes, true)
+	if len(b6) != CompactNode6Len {
+		t.Fatal("nodes6", len(b6))
+	}
+	got, err := DecodeCompactNodes(b, false)
+	if err != nil || len(got) != 1 || !bytes.Equal(got[0].ID, v4.ID) || got[0].Addr.String() != v4.Addr.String() {
+		t.Error("nodes", got, err)
+	}
+	got, err = DecodeCompactNodes(b6, true)
+	if err != nil || len(got) != 1 || !bytes.Equal(got[0].ID, v6.ID) || got[0].Addr.String() != v6.Addr.String() {
+		t.Error("nodes6", got, err)
+	}
+	// the decoded nodes do not alias the packet
+	got, _ = DecodeNodes(b)
+	b[0]++
+	if !bytes.Equal(got[0].ID, v4.ID) {
+		t.Error("DecodeNodes aliases")
+	}
+	if _, err := DecodeCompactNodes(b[:25], false); err == nil {
+		t.Error("decoded a short node")
+	}
+	// a node with port 0 is skipped
+	zero := append(append([]byte{}, v4.ID...), 10, 0, 0, 1, 0, 0)
+	if got, err := DecodeCompactNodes(zero, false); err != nil || len(got) != 0 {
+		t.Error("port 0", got, err)
+	}
+}
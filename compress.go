@@ -0,0 +1,100 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// The codecs of the stored blobs. Every blob is a zstd frame or a snappy
+// framed stream on its own, which carry their magic: blobs of either codec
+// and uncompressed JSON read back whatever the codec configured now.
+const (
+	CompressNone   = ""
+	CompressZstd   = "zstd"
+	CompressSnappy = "snappy"
+
+	maxDecompressed = 64 << 20 //bytes of a decompressed blob
+)
+
+var (
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// ValidCompression reports whether codec is one of the Compress codecs.
+func ValidCompression(codec string) bool {
+	switch codec {
+	case CompressNone, CompressZstd, CompressSnappy:
+		return true
+	}
+	return false
+}
+
+// compressExt is the suffix of the object names of codec.
+func compressExt(codec string) string {
+	switch codec {
+	case CompressZstd:
+		return ".zst"
+	case CompressSnappy:
+		return ".sz"
+	}
+	return ""
+}
+
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		// nil writers and readers only do the stateless EncodeAll and
+		// DecodeAll, which are safe for concurrent use
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressed))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// compressBlob frames data with codec, CompressNone returns it as is.
+func compressBlob(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case CompressNone:
+		return data, nil
+	case CompressZstd:
+		enc, _ := zstdCodec()
+		return enc.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+	case CompressSnappy:
+		var buf bytes.Buffer
+		w := snappy.NewBufferedWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", codec)
+}
+
+// decompressBlob returns the content of a blob written by compressBlob, of
+// any codec; a blob without a known magic is returned as is.
+func decompressBlob(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, zstdMagic):
+		_, dec := zstdCodec()
+		return dec.DecodeAll(data, nil)
+	case bytes.HasPrefix(data, snappyMagic):
+		out, err := io.ReadAll(io.LimitReader(snappy.NewReader(bytes.NewReader(data)), maxDecompressed+1))
+		if err == nil && len(out) > maxDecompressed {
+			err = fmt.Errorf("decompressed blob over %d bytes", maxDecompressed)
+		}
+		return out, err
+	}
+	return data, nil
+}
@@ -0,0 +1,76 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func Test_CompressBlob(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"ubuntu.iso","files":[{"path":["a"],"length":1}]}`), 100)
+	for _, codec := range []string{CompressNone, CompressZstd, CompressSnappy} {
+		blob, err := compressBlob(codec, data)
+		if err != nil {
+			t.Fatal(codec, err)
+		}
+		if codec != CompressNone && len(blob) > len(data)/2 {
+			t.Errorf("%s: %d bytes of %d", codec, len(blob), len(data))
+		}
+		back, err := decompressBlob(blob)
+		if err != nil || !bytes.Equal(back, data) {
+			t.Error(codec, "round trip", err)
+		}
+	}
+	if _, err := compressBlob("lz4", data); err == nil || ValidCompression("lz4") {
+		t.Error("unknown codec accepted")
+	}
+}
+
+func Test_CompressedStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	bolt, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+	files := make([]*File, 50)
+	for i := range files {
+		files[i] = &File{Path: []string{"season 1", fmt.Sprintf("episode %d.mkv", i)}, Length: 1 << 30}
+	}
+	// the codec may change between writes, every record reads back
+	plain, zstd, snappy := testHash("plain"), testHash("zstd"), testHash("snappy")
+	for _, s := range []Store{bolt, sqlite} {
+		cs := s.(CompressedStore)
+		for _, h := range []Hash{plain, zstd, snappy} {
+			cs.SetCompression(map[Hash]string{plain: CompressNone, zstd: CompressZstd, snappy: CompressSnappy}[h])
+			if err := s.Put(&MetadataResult{Hash: h, Name: "show", Files: files, Category: "video"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, h := range []Hash{plain, zstd, snappy} {
+			if r, err := s.Get(h); err != nil || len(r.Files) != 50 || r.Files[49].Path[1] != "episode 49.mkv" {
+				t.Errorf("%T %s: %v", s, h.Hex(), err)
+			}
+		}
+	}
+	if got, err := sqlite.Query(TorrentQuery{Category: "video"}); err != nil || len(got) != 3 {
+		t.Error("query of compressed rows", len(got), err)
+	}
+
+	// reopened without a codec the compressed records still read
+	bolt.Close()
+	if bolt, err = OpenBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	n := 0
+	bolt.Iterate(func(r *MetadataResult) bool { n += len(r.Files); return true })
+	if n != 150 {
+		t.Error("files iterated", n)
+	}
+}
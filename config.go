@@ -0,0 +1,140 @@
+package DHTCrawl
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+const ConfigPollInterval = time.Second * 2
+
+// LoadConfig reads a JSON, YAML (.yaml, .yml) or TOML (.toml) config file,
+// keys missing from the file keep their default value. The EnvPrefix
+// variables are applied over the file and the result is validated.
+func LoadConfig(path string) (*DHTConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := NewDefaultConfig()
+	if err := decodeConfig(path, data, cfg); err != nil {
+		return nil, err
+	}
+	if err := ApplyEnv(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Reload applies the runtime tunable part of cfg to the running crawler:
+// worker count or scaling bounds, fetch rate and timeouts, bootstrap nodes,
+// content rules, API keys, log levels and the pprof switch. Settings which
+// need a new socket or pipeline (port, nodes, queue size, token validity,
+// state path) are logged and left alone until the next restart.
+func (c *Crawler) Reload(cfg *DHTConfig) {
+	c.mu.Lock()
+	old := c.Config
+	c.Config = cfg
+	c.mu.Unlock()
+
+	pool := c.Pool
+	if c.Scaler != nil && cfg.MaxJobSize > 0 {
+		c.Scaler.SetBounds(cfg.MinJobSize, cfg.MaxJobSize)
+	} else if size, _ := pool.Workers(); cfg.JobSize != size {
+		c.Logger.Info("reload workers", "from", size, "to", cfg.JobSize)
+		pool.Resize(cfg.JobSize)
+	}
+	pool.Limiter.SetRate(cfg.FetchRate, cfg.FetchBurst)
+	pool.SetTimeouts(time.Duration(cfg.ConnectTimeout)*time.Second, time.Duration(cfg.FetchTimeout)*time.Second)
+	for _, node := range c.Nodes {
+		node.SetBootstraps(cfg.Entries)
+	}
+	if content, err := NewContentFilter(cfg.ContentRules...); err != nil {
+		c.Logger.Error("reload content rules, keep the old rules", "error", err)
+	} else {
+		c.mu.Lock()
+		c.content = content
+		c.mu.Unlock()
+		c.applyFilters()
+	}
+
+	if c.Auth != nil && cfg.Auth != nil {
+		c.Auth.SetKeys(cfg.Auth.Keys)
+	}
+	if cfg.Log != nil {
+		if err := applyLogLevels(cfg.Log); err != nil {
+			c.Logger.Error("reload log levels", "error", err)
+		}
+	}
+
+	if old == nil {
+		return
+	}
+	if old.Port != cfg.Port || old.Nodes != cfg.Nodes || old.QueueSize != cfg.QueueSize || old.TokenValidity != cfg.TokenValidity || old.StatePath != cfg.StatePath {
+		c.Logger.Warn("reload ignored port, nodes, queue_size, token_validity and state_path changes, restart to apply them")
+	}
+}
+
+// config returns the running config, the pointer is replaced on reload.
+func (c *Crawler) config() *DHTConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Config
+}
+
+// updateConfig applies fn to a copy of the running config and makes it the
+// running one.
+func (c *Crawler) updateConfig(fn func(*DHTConfig)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cfg := *c.Config
+	fn(&cfg)
+	c.Config = &cfg
+}
+
+// WatchConfig reloads path whenever it is modified or the process receives
+// SIGHUP, until the crawler is shut down. A file which fails to parse is
+// reported and the running config is kept.
+func (c *Crawler) WatchConfig(path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		var mtime time.Time
+		if fi, err := os.Stat(path); err == nil {
+			mtime = fi.ModTime()
+		}
+		ticker := time.NewTicker(ConfigPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.shutdown:
+				return
+			case <-hup:
+			case <-ticker.C:
+				fi, err := os.Stat(path)
+				if err != nil || !fi.ModTime().After(mtime) {
+					continue
+				}
+				mtime = fi.ModTime()
+			}
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				c.Logger.Error("reload config", "path", path, "error", err)
+				continue
+			}
+			c.mu.Lock()
+			same := reflect.DeepEqual(c.Config, cfg)
+			c.mu.Unlock()
+			if !same {
+				c.Reload(cfg)
+			}
+		}
+	}()
+}
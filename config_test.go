@@ -0,0 +1,120 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	ioutil.WriteFile(path, []byte(`{"port": 0, "job_size": 2, "entries": []}`), 0644)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TokenValidity != 5 || cfg.JobSize != 2 {
+		t.Error("Defaults not kept", cfg)
+	}
+
+	c, err := NewCrawler(WithConfig(cfg), WithWorkers(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.closeNodes()
+	if cfg.JobSize != 2 || c.Config == cfg {
+		t.Error("config of the caller changed", cfg.JobSize)
+	}
+	ioutil.WriteFile(path, []byte(`{"port": 0, "job_size": 4, "fetch_rate": 10, "entries": ["127.0.0.1:6881"]}`), 0644)
+	cfg, _ = LoadConfig(path)
+	c.Reload(cfg)
+	if size, _ := c.Pool.Workers(); size != 4 {
+		t.Error("Workers not resized", size)
+	}
+	if c.Pool.Limiter.Rate() != 10 || len(c.Nodes[0].Bootstraps) != 1 {
+		t.Error("Reload not applied")
+	}
+}
+
+func Test_Limiter(t *testing.T) {
+	l := NewLimiter(2, 2)
+	if !l.Allow() || !l.Allow() || l.Allow() {
+		t.Error("Limiter burst has error")
+	}
+	l.SetRate(0, 0)
+	if !l.Allow() {
+		t.Error("Unlimited limiter rejected")
+	}
+}
+
+func Test_ConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": "port: 7000\nnodes: 2\nhttp_addr: \":8080\"\nkafka:\n  brokers: [\"k1:9092\"]\ncontent_rules:\n  - keywords: [spam]\n",
+		"config.toml": "port = 7000\nnodes = 2\nhttp_addr = \":8080\"\n[kafka]\nbrokers = [\"k1:9092\"]\n[[content_rules]]\nkeywords = [\"spam\"]\n",
+		"config.json": `{"port": 7000, "nodes": 2, "http_addr": ":8080", "kafka": {"brokers": ["k1:9092"]}, "content_rules": [{"keywords": ["spam"]}]}`,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(data), 0644)
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Error(name, err)
+			continue
+		}
+		if cfg.Port != 7000 || cfg.Nodes != 2 || cfg.HTTPAddr != ":8080" || cfg.Kafka == nil || cfg.Kafka.Brokers[0] != "k1:9092" || len(cfg.ContentRules) != 1 || cfg.TokenValidity != 5 {
+			t.Errorf("%s %+v", name, cfg)
+		}
+	}
+
+	bad := map[string]string{
+		"typo.yaml":   "prot: 7000\n",
+		"nested.toml": "[kafka]\nbroker = [\"k1\"]\n",
+		"rules.json":  `{"content_rules": [{"keyword": ["x"]}]}`,
+		"type.yaml":   "nodes: many\n",
+		"range.yaml":  "port: 70000\n",
+		"kafka.yaml":  "kafka: {topic: t}\n",
+	}
+	keys := map[string]string{
+		"typo.yaml":   "prot",
+		"nested.toml": "kafka.broker",
+		"rules.json":  "content_rules.0.keyword",
+		"type.yaml":   "nodes",
+		"range.yaml":  "port",
+		"kafka.yaml":  "kafka.brokers",
+	}
+	for name, data := range bad {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(data), 0644)
+		_, err := LoadConfig(path)
+		var cerr *ConfigError
+		if !errors.As(err, &cerr) || cerr.Key != keys[name] {
+			t.Error(name, err)
+		}
+	}
+}
+
+func Test_ConfigEnv(t *testing.T) {
+	t.Setenv("DHTCRAWL_PORT", "7100")
+	t.Setenv("DHTCRAWL_HTTP_ADDR", ":9090")
+	t.Setenv("DHTCRAWL_ENTRIES", "a:1, b:2")
+	t.Setenv("DHTCRAWL_PPROF", "true")
+	t.Setenv("DHTCRAWL_KAFKA__BROKERS", "k1:9092,k2:9092")
+	cfg := NewDefaultConfig()
+	if err := ApplyEnv(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 7100 || cfg.HTTPAddr != ":9090" || len(cfg.Entries) != 2 || cfg.Entries[1] != "b:2" || !cfg.Pprof || cfg.Kafka == nil || len(cfg.Kafka.Brokers) != 2 {
+		t.Errorf("%+v", cfg)
+	}
+	if cfg.NATS != nil {
+		t.Error("section created without its variables")
+	}
+
+	t.Setenv("DHTCRAWL_NODES", "two")
+	var cerr *ConfigError
+	if err := ApplyEnv(NewDefaultConfig()); !errors.As(err, &cerr) || cerr.Key != "nodes" {
+		t.Error("bad value", err)
+	}
+}
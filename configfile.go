@@ -0,0 +1,424 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v3"
+)
+
+// EnvPrefix starts the environment variables which override the config
+// file. The key follows in upper case, nested keys are joined by a double
+// underscore: DHTCRAWL_HTTP_ADDR, DHTCRAWL_KAFKA__BROKERS. Lists are comma
+// separated.
+const EnvPrefix = "DHTCRAWL_"
+
+// ConfigError names the config key which has a wrong value.
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config key %s: %s", e.Key, e.Err.Error())
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+func configErr(key, format string, args ...interface{}) error {
+	return &ConfigError{Key: key, Err: fmt.Errorf(format, args...)}
+}
+
+// decodeConfig reads a JSON, YAML or TOML document, picked by the file
+// extension, over the defaults in cfg. Keys which are not config keys are
+// errors, a typo would otherwise silently keep the default.
+func decodeConfig(path string, data []byte, cfg *DHTConfig) error {
+	doc := map[string]interface{}{}
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if err := checkKeys(doc, reflect.TypeOf(*cfg), ""); err != nil {
+		return err
+	}
+	// the documents are turned into JSON so the json tags are the only key
+	// names to maintain
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return configErr(typeErr.Field, "expected %s, got %s", typeErr.Type, typeErr.Value)
+		}
+		return err
+	}
+	return nil
+}
+
+// jsonFields maps the json keys of struct type t to its fields.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+func checkKeys(doc map[string]interface{}, t reflect.Type, prefix string) error {
+	fields := jsonFields(t)
+	for key, v := range doc {
+		f, ok := fields[key]
+		if !ok {
+			return configErr(prefix+key, "unknown key")
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Kind() == reflect.Struct:
+			if m, ok := v.(map[string]interface{}); ok {
+				if err := checkKeys(m, ft, prefix+key+"."); err != nil {
+					return err
+				}
+			}
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			var list []map[string]interface{}
+			switch v := v.(type) {
+			case []map[string]interface{}: //TOML arrays of tables
+				list = v
+			case []interface{}:
+				for _, item := range v {
+					m, _ := item.(map[string]interface{})
+					list = append(list, m)
+				}
+			}
+			for i, m := range list {
+				if err := checkKeys(m, ft.Elem(), fmt.Sprintf("%s%s.%d.", prefix, key, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyEnv overrides cfg with the EnvPrefix variables. A section like kafka
+// is created when one of its keys is set.
+func ApplyEnv(cfg *DHTConfig) error {
+	return applyEnv(reflect.ValueOf(cfg).Elem(), "", EnvPrefix)
+}
+
+func applyEnv(v reflect.Value, prefix, env string) error {
+	for name, f := range jsonFields(v.Type()) {
+		key, name := prefix+name, env+strings.ToUpper(name)
+		field := v.FieldByIndex(f.Index)
+		ft := f.Type
+		switch {
+		case ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct:
+			if !hasEnvPrefix(name + "__") {
+				continue
+			}
+			if field.IsNil() {
+				field.Set(reflect.New(ft.Elem()))
+			}
+			if err := applyEnv(field.Elem(), key+".", name+"__"); err != nil {
+				return err
+			}
+		case ft.Kind() == reflect.Struct:
+			if err := applyEnv(field, key+".", name+"__"); err != nil {
+				return err
+			}
+		default:
+			s, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if err := setValue(field, s); err != nil {
+				return configErr(key, "%s=%q: %s", name, s, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func hasEnvPrefix(prefix string) bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// setValue parses s into the scalar or string list v.
+func setValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("expected a boolean")
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return errors.New("expected an integer")
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return errors.New("expected a positive integer")
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return errors.New("expected a number")
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return errors.New("can't be set from the environment")
+		}
+		items := []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("can't be set from the environment")
+	}
+	return nil
+}
+
+// Validate checks the ranges and the required keys of the enabled sections,
+// every problem is reported with its key.
+func (cfg *DHTConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, configErr(key, format, args...))
+		}
+	}
+	check(cfg.Port >= 0 && cfg.Port+cfg.Nodes <= 65536, "port", "%d is not a UDP port for %d nodes", cfg.Port, cfg.Nodes)
+	check(cfg.Nodes >= 1, "nodes", "at least one node is needed")
+	check(cfg.TokenValidity > 0, "token_validity", "must be positive")
+	check(cfg.JobSize >= 0, "job_size", "can't be negative")
+	check(cfg.MinJobSize >= 0, "min_job_size", "can't be negative")
+	check(cfg.MaxJobSize == 0 || cfg.MaxJobSize >= cfg.MinJobSize, "max_job_size", "smaller than min_job_size")
+	check(cfg.QueueSize >= 0, "queue_size", "can't be negative")
+	check(cfg.FetchRate >= 0, "fetch_rate", "can't be negative")
+	check(cfg.FetchBurst >= 0, "fetch_burst", "can't be negative")
+	check(cfg.RefetchEvery >= 0, "refetch_every", "can't be negative")
+	check(cfg.ConnectTimeout >= 0, "connect_timeout", "can't be negative")
+	check(cfg.FetchTimeout >= 0, "fetch_timeout", "can't be negative")
+	check(cfg.MaxMessage == 0 || cfg.MaxMessage >= PieceSize+64, "max_message_size", "must hold a %d byte piece", PieceSize)
+	check(cfg.MaxMetadata >= 0, "max_metadata_size", "can't be negative")
+	check(cfg.MaxItems >= 0, "max_bencode_items", "can't be negative")
+	check(cfg.PartialSize >= -1, "partial_store_size", "must be -1 to keep none, 0 for the default or a size")
+	check(cfg.PartialTTL >= 0, "partial_ttl", "can't be negative")
+	check(cfg.QueryBurst >= 0, "query_burst", "can't be negative")
+	check(cfg.ResponseBurst >= 0, "response_burst", "can't be negative")
+	switch cfg.StoreDriver {
+	case "", "bolt", "sqlite", "postgres":
+	default:
+		check(false, "store", "unknown driver %q, use bolt, sqlite or postgres", cfg.StoreDriver)
+	}
+	check(cfg.StoreDriver != "postgres" || cfg.StorePath != "", "store_path", "the postgres store needs a DSN")
+	check(ValidCompression(cfg.Compression), "store_compression", "unknown codec %q, use zstd or snappy", cfg.Compression)
+	check(cfg.Compression == CompressNone || cfg.StoreDriver != "postgres", "store_compression", "the postgres store keeps JSONB")
+	for i, rule := range cfg.ContentRules {
+		_, err := NewContentFilter(rule)
+		check(err == nil, fmt.Sprintf("content_rules.%d", i), "%v", err)
+	}
+	if cfg.Kafka != nil {
+		check(len(cfg.Kafka.Brokers) > 0, "kafka.brokers", "required")
+		check(checkFormat(cfg.Kafka.Format) == nil, "kafka.format", "use json or protobuf")
+	}
+	if cfg.NATS != nil {
+		check(checkFormat(cfg.NATS.Format) == nil, "nats.format", "use json or protobuf")
+	}
+	if cfg.JSONL != nil {
+		check(cfg.JSONL.Path != "", "jsonl.path", "required")
+	}
+	if cfg.Archive != nil {
+		check(cfg.Archive.Endpoint != "", "archive.endpoint", "required")
+		check(cfg.Archive.Bucket != "", "archive.bucket", "required")
+		check(ValidCompression(cfg.Archive.Compression), "archive.compression", "unknown codec %q, use zstd or snappy", cfg.Archive.Compression)
+	}
+	if cfg.Webhook != nil {
+		check(len(cfg.Webhook.URLs) > 0, "webhook.urls", "required")
+	}
+	if cfg.MQTT != nil {
+		check(cfg.MQTT.Broker != "", "mqtt.broker", "required")
+		check(cfg.MQTT.QoS <= 2, "mqtt.qos", "must be 0, 1 or 2")
+		check(checkFormat(cfg.MQTT.Format) == nil, "mqtt.format", "use json or protobuf")
+	}
+	if cfg.TLS != nil {
+		check(cfg.TLS.Cert != "", "tls.cert", "required")
+		check(cfg.TLS.Key != "", "tls.key", "required")
+	}
+	if cfg.Log != nil {
+		if _, err := cfg.Log.levels(); err != nil {
+			errs = append(errs, err)
+		}
+		check(cfg.Log.Format == "" || cfg.Log.Format == "text" || cfg.Log.Format == "json", "log.format", "use text or json")
+	}
+	if cfg.Tracing != nil {
+		check(cfg.Tracing.Endpoint != "", "tracing.endpoint", "required")
+		check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1")
+	}
+	if cfg.StatsD != nil {
+		_, _, err := net.SplitHostPort(cfg.StatsD.Addr)
+		check(err == nil, "statsd.addr", "%q is not a host:port", cfg.StatsD.Addr)
+		check(cfg.StatsD.Interval >= 0, "statsd.interval", "can't be negative")
+		for name, rate := range cfg.StatsD.SampleRates {
+			check(rate > 0 && rate <= 1, "statsd.sample_rates."+name, "must be over 0 and at most 1")
+		}
+	}
+	if cfg.GeoIP != nil {
+		check(cfg.GeoIP.Country != "" || cfg.GeoIP.ASN != "", "geoip", "set country, asn or both")
+	}
+	if cfg.Listen != nil {
+		_, _, err := net.SplitHostPort(cfg.Listen.Addr)
+		check(err == nil, "listen.addr", "%q is not a host:port", cfg.Listen.Addr)
+		check(cfg.Listen.ConnsPerIP >= 0, "listen.conns_per_ip", "can't be negative")
+		check(cfg.Listen.ConnsPerNet >= 0, "listen.conns_per_net", "can't be negative")
+		check(cfg.Listen.HandshakesPerIP >= 0, "listen.handshakes_per_ip", "can't be negative")
+		check(cfg.Listen.HandshakesPerNet >= 0, "listen.handshakes_per_net", "can't be negative")
+		check(cfg.Listen.AcceptBurst >= 0, "listen.accept_burst", "can't be negative")
+	}
+	if cfg.Announce != nil {
+		check(cfg.Listen != nil, "announce", "requires listen, the swarms connect to it")
+		switch {
+		case cfg.Announce.Port != 0:
+			check(IsValidPort(cfg.Announce.Port), "announce.port", "%d is not a port", cfg.Announce.Port)
+		case cfg.Listen != nil:
+			_, p, _ := net.SplitHostPort(cfg.Listen.Addr)
+			port, err := strconv.Atoi(p)
+			check(err == nil && IsValidPort(port), "announce.port", "required when listen.addr has no fixed port")
+		}
+		check(cfg.Announce.Rate >= 0, "announce.rate", "can't be negative")
+		check(cfg.Announce.Burst >= 0, "announce.burst", "can't be negative")
+		check(cfg.Announce.Timeout >= 0, "announce.timeout", "can't be negative")
+	}
+	if cfg.Memory != nil {
+		check(cfg.Memory.Limit > 0, "memory.limit", "must be positive")
+		check(cfg.Memory.Interval >= 0, "memory.interval", "can't be negative")
+		if err := cfg.Memory.validate(); err != nil {
+			check(false, "memory", "%v", err)
+		}
+	}
+	if cfg.Shard != nil {
+		check(cfg.Shard.Count > 0, "shard.count", "must be positive")
+		check(cfg.Shard.Index >= 0 && cfg.Shard.Index < cfg.Shard.Count, "shard.index", "must be between 0 and count-1")
+		if cfg.Shard.Forward != "" {
+			_, port, err := net.SplitHostPort(cfg.Shard.Forward)
+			n, perr := strconv.Atoi(port)
+			check(err == nil && perr == nil && IsValidPort(n) && IsValidPort(n+cfg.Shard.Count-1), "shard.forward", "must be a host:port with room for count ports")
+		}
+	}
+	if cfg.Cluster != nil {
+		check(cfg.Redis != nil, "cluster", "needs redis")
+		check(cfg.Shard == nil, "cluster", "can't be set with shard")
+		_, _, err := net.SplitHostPort(cfg.Cluster.Listen)
+		check(err == nil, "cluster.listen", "must be a host:port")
+		advertise := cfg.Cluster.Advertise
+		if advertise == "" {
+			advertise = cfg.Cluster.Listen
+		}
+		host, _, err := net.SplitHostPort(advertise)
+		check(err == nil && host != "" && !net.ParseIP(host).IsUnspecified(), "cluster.advertise", "must be a host:port the other members reach, required when listen has no host")
+		check(cfg.Cluster.Heartbeat >= 0, "cluster.heartbeat", "can't be negative")
+		check(cfg.Cluster.TTL >= 0, "cluster.ttl", "can't be negative")
+	}
+	if cfg.Scores != nil {
+		check(cfg.Scores.BanScore <= 0, "peer_score.ban_score", "can't be positive")
+		check(cfg.Scores.BanTime >= 0 && cfg.Scores.MaxBanTime >= 0, "peer_score.ban_time", "can't be negative")
+		check(cfg.Scores.Decay >= 0, "peer_score.decay", "can't be negative")
+		check(cfg.Scores.Size >= 0, "peer_score.size", "can't be negative")
+	}
+	if cfg.Honeypot != nil {
+		check(cfg.Listen != nil, "honeypot", "needs listen")
+		check(cfg.Honeypot.IP == "" || net.ParseIP(cfg.Honeypot.IP) != nil, "honeypot.ip", "must be an IP")
+		check(cfg.Honeypot.Port == 0 || IsValidPort(cfg.Honeypot.Port), "honeypot.port", "must be a port")
+		for _, s := range cfg.Honeypot.Hashes {
+			_, err := HashFromHex(s)
+			check(err == nil, "honeypot.hashes", "%q is not a hex infohash", s)
+		}
+	}
+	if cfg.LSD != nil {
+		check(cfg.LSD.Port == -1 || cfg.LSD.Port == 0 || IsValidPort(cfg.LSD.Port), "lsd.port", "must be a port, 0 or -1")
+		check(cfg.LSD.Interval >= 0, "lsd.interval", "can't be negative")
+	}
+	if cfg.Health != nil {
+		check(cfg.Health.Interval >= 0, "health.interval", "can't be negative")
+		check(cfg.Health.Batch >= 0, "health.batch", "can't be negative")
+		check(cfg.Health.Workers >= 0, "health.workers", "can't be negative")
+		check(cfg.Health.Timeout >= 0, "health.timeout", "can't be negative")
+		check(cfg.Health.DeadAfter >= 0, "health.dead_after", "can't be negative")
+	}
+	if cfg.AnnounceLog != nil {
+		check(cfg.AnnounceLog.Path != "", "announce_log.path", "required")
+		check(cfg.AnnounceLog.MaxSize >= 0, "announce_log.max_size", "can't be negative")
+		check(cfg.AnnounceLog.RotateEvery >= 0, "announce_log.rotate_every", "can't be negative")
+		check(cfg.AnnounceLog.Keep >= 0, "announce_log.keep", "can't be negative")
+	}
+	if cfg.Capture != nil {
+		check(cfg.Capture.Path != "", "capture.path", "required")
+		check(cfg.Capture.MaxSize >= 0, "capture.max_size", "can't be negative")
+		for i, p := range cfg.Capture.Peers {
+			check(net.ParseIP(p) != nil, fmt.Sprintf("capture.peers.%d", i), "%q is not an IP", p)
+		}
+	}
+	if cfg.Trackers != nil {
+		check(len(cfg.Trackers.URLs) > 0, "trackers.urls", "required")
+		for i, u := range cfg.Trackers.URLs {
+			_, err := NewTracker(u, cfg.Trackers)
+			check(err == nil, fmt.Sprintf("trackers.urls.%d", i), "%q is not a udp, http or https tracker", u)
+		}
+		check(cfg.Trackers.Timeout >= 0, "trackers.timeout", "can't be negative")
+		check(cfg.Trackers.Rate >= 0, "trackers.rate", "can't be negative")
+	}
+	if cfg.Auth != nil {
+		for i, k := range cfg.Auth.Keys {
+			check(k.Key != "", fmt.Sprintf("auth.keys.%d.key", i), "required")
+			check(k.Rate >= 0, fmt.Sprintf("auth.keys.%d.rate", i), "can't be negative")
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package DHTCrawl
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+type (
+	// ContentRule matches results by name or file path. Keywords match case
+	// insensitively anywhere in the text, Patterns are regular expressions.
+	// A matching result is tagged with Tag, or dropped when Tag is empty.
+	ContentRule struct {
+		Keywords []string `json:"keywords"`
+		Patterns []string `json:"patterns"`
+		Tag      string   `json:"tag"`
+	}
+
+	// ContentFilter is the built-in post-fetch filter made of ContentRules.
+	ContentFilter struct {
+		rules []*contentRule
+	}
+
+	contentRule struct {
+		keywords []string
+		patterns []*regexp.Regexp
+		tag      string
+	}
+)
+
+func NewContentFilter(rules ...ContentRule) (*ContentFilter, error) {
+	f := new(ContentFilter)
+	for _, r := range rules {
+		cr := &contentRule{tag: r.Tag}
+		for _, k := range r.Keywords {
+			if k != "" {
+				cr.keywords = append(cr.keywords, strings.ToLower(k))
+			}
+		}
+		for _, p := range r.Patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, err
+			}
+			cr.patterns = append(cr.patterns, re)
+		}
+		f.rules = append(f.rules, cr)
+	}
+	return f, nil
+}
+
+func (f *ContentFilter) AllowHash(Hash, *net.TCPAddr) bool {
+	return true
+}
+
+// AllowResult drops the result on the first matching drop rule and adds the
+// tag of every other matching rule.
+func (f *ContentFilter) AllowResult(r *MetadataResult) bool {
+	texts := r.texts()
+	for _, rule := range f.rules {
+		if !rule.match(texts) {
+			continue
+		}
+		if rule.tag == "" {
+			return false
+		}
+		if !InArray(r.Tags, rule.tag) {
+			r.Tags = append(r.Tags, rule.tag)
+		}
+	}
+	return true
+}
+
+func (r *contentRule) match(texts []string) bool {
+	for _, t := range texts {
+		lower := strings.ToLower(t)
+		for _, k := range r.keywords {
+			if strings.Contains(lower, k) {
+				return true
+			}
+		}
+		for _, re := range r.patterns {
+			if re.MatchString(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// texts returns the names and file paths a ContentRule looks at.
+func (m *MetadataResult) texts() []string {
+	texts := []string{m.Name}
+	if m.UName != "" {
+		texts = append(texts, m.UName)
+	}
+	for _, f := range m.Files {
+		if len(f.Path) != 0 {
+			texts = append(texts, strings.Join(f.Path, "/"))
+		}
+		if len(f.UPath) != 0 {
+			texts = append(texts, strings.Join(f.UPath, "/"))
+		}
+	}
+	return texts
+}
@@ -0,0 +1,23 @@
+package DHTCrawl
+
+import "testing"
+
+func Test_ContentFilter(t *testing.T) {
+	f, err := NewContentFilter(
+		ContentRule{Keywords: []string{"SAMPLE"}},
+		ContentRule{Patterns: []string{`(?i)\.iso$`}, Tag: "image"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.AllowResult(&MetadataResult{Name: "a sample video"}) {
+		t.Error("Keyword rule did not drop")
+	}
+	r := &MetadataResult{Name: "linux", Files: []*File{{Path: []string{"dist", "linux.ISO"}}}}
+	if !f.AllowResult(r) || len(r.Tags) != 1 || r.Tags[0] != "image" {
+		t.Error("Pattern rule did not tag", r.Tags)
+	}
+	if _, err := NewContentFilter(ContentRule{Patterns: []string{"("}}); err == nil {
+		t.Error("Invalid pattern accepted")
+	}
+}
@@ -0,0 +1,1048 @@
+package DHTCrawl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var ErrCrawlerClosed = errors.New("crawler closed")
+
+type (
+	// Sink receives every metadata result which leaves the pipeline.
+	Sink interface {
+		Put(*MetadataResult) error
+		Close() error
+	}
+
+	// Flusher is implemented by sinks which buffer writes.
+	Flusher interface {
+		Flush() error
+	}
+
+	// ContextCloser is implemented by sinks whose Close may wait, Shutdown
+	// closes them with its ctx instead.
+	ContextCloser interface {
+		CloseContext(ctx context.Context) error
+	}
+
+	NodeStats struct {
+		Addr      string `json:"addr"`
+		Nodes     int    `json:"nodes"`             //routing table size
+		Queries   uint64 `json:"queries"`           //KRPC queries received
+		Announces uint64 `json:"announces"`         //announce_peer queries with a valid token
+		Dropped   uint64 `json:"responses_dropped"` //queries over the response rate limit
+		Throttled uint64 `json:"queries_throttled"` //find_node queries over the query rate limit of their network
+	}
+
+	// CrawlerStats is a snapshot of the counters of a running crawler.
+	CrawlerStats struct {
+		Nodes     []NodeStats        `json:"nodes"`
+		Queries   uint64             `json:"queries"` //of every node
+		Announces uint64             `json:"announces"`
+		Workers   int                `json:"workers"`
+		Busy      int                `json:"busy"`
+		InFlight  int                `json:"in_flight"`
+		Succeeded uint64             `json:"succeeded"`
+		Failed    uint64             `json:"failed"`
+		Fetch     FetchStats         `json:"fetch"` //downloads from peers
+		Limited   uint64             `json:"limited"`
+		Filtered  uint64             `json:"filtered"`
+		Rejected  uint64             `json:"rejected"`
+		Refetch   int                `json:"refetch_pending"`
+		Stored    int                `json:"stored"` //-1 when the store can't count
+		Queues    []QueueStat        `json:"queues"`
+		Sinks     []SinkStats        `json:"sinks"`
+		Inbound   *ListenerStats     `json:"inbound,omitempty"`    //nil without listen
+		Announce  *AnnounceStats     `json:"announce,omitempty"`   //nil without announce
+		Health    *SwarmStats        `json:"health,omitempty"`     //nil without health
+		Memory    *MemoryStats       `json:"memory,omitempty"`     //nil without memory
+		Shard     *ShardStats        `json:"shard,omitempty"`      //nil without shard
+		Cluster   *ClusterStats      `json:"cluster,omitempty"`    //nil without cluster
+		LSD       *LSDStats          `json:"lsd,omitempty"`        //nil without lsd
+		Honeypot  *HoneypotStats     `json:"honeypot,omitempty"`   //nil without honeypot
+		Scores    *PeerScoreStats    `json:"peer_score,omitempty"` //nil without peer_score
+		Partial   *PartialStats      `json:"partial,omitempty"`    //nil with a partial_store_size of -1
+		Bandwidth map[string]Traffic `json:"bandwidth"`            //bytes since the start by subsystem
+	}
+
+	// SinkStats tells whether a sink keeps up, it is healthy until an error
+	// which no write has succeeded since.
+	SinkStats struct {
+		Name      string    `json:"name"`
+		Puts      uint64    `json:"puts"`
+		Errors    uint64    `json:"errors"`
+		LastError string    `json:"last_error,omitempty"`
+		ErrorTime time.Time `json:"error_time,omitzero"`
+		OKTime    time.Time `json:"ok_time,omitzero"`
+		Healthy   bool      `json:"healthy"`
+	}
+
+	// Crawler runs one or more DHT nodes feeding a shared metadata pipeline
+	// and sinks, and owns their lifecycle.
+	Crawler struct {
+		Nodes           []*DHT
+		Pool            *WireJob
+		Scaler          *Scaler        //nil when the pool size is fixed
+		Server          *Server        //nil without http_addr
+		GRPC            *GRPCServer    //nil without grpc_addr
+		Listener        *PeerListener  //inbound BitTorrent connections, nil without listen
+		Announcer       *Announcer     //announces the hashes being fetched, nil without announce
+		StatsD          *StatsD        //nil without statsd
+		AnnounceLog     *AnnounceLog   //every announce received, nil without announce_log
+		Memory          *MemoryGuard   //sheds load over the budget, nil without memory
+		Shard           *Shard         //filters the hashes of the other shards, nil without shard
+		Cluster         *Cluster       //filters the hashes of the other members, nil without cluster
+		LSD             *LSD           //finds and announces hashes on the local network, nil without lsd
+		Honeypot        *Honeypot      //hands out the listener to get_peers, nil without honeypot
+		Capture         *Capture       //of the pool and the nodes, nil without capture
+		Bandwidth       *Bandwidth     //the traffic of the nodes, the pool and Server
+		Scraper         *Scraper       //scrapes the results before they are stored, nil without trackers
+		Checker         *SwarmChecker  //re-checks the stored swarms, nil without health
+		Hub             *Hub           //live feed of results and announces, also in Sinks
+		Events          *Bus           //the events of the pipeline, shared with Pool
+		Search          *SearchIndex   //also in Sinks, nil without search_path
+		Auth            *Authenticator //nil when the served APIs are open
+		Sinks           []Sink
+		Store           Store //also in Sinks, nil when nothing is persisted
+		MetadataHandler ResultHandler
+		StatePath       string
+		Config          *DHTConfig
+		Logger          *slog.Logger //of the pipeline subsystem
+
+		mu       sync.Mutex
+		filters  []Filter
+		content  *ContentFilter
+		seen     *RedisSeen //nil without a shared seen set
+		sinks    map[string]*SinkStats
+		rejected uint64
+		running  bool
+		stored   chan struct{}
+		served   *sync.WaitGroup
+		shutdown chan struct{}
+
+		// delivery is held by put while it hands a result to the sinks,
+		// Shutdown sets undelivered under it before closing them
+		delivery    sync.RWMutex
+		undelivered bool
+
+		stopTracing func(context.Context) error //flushes the spans, nil without tracing
+	}
+)
+
+// NewCrawler binds the UDP sockets of every node and starts the worker pool,
+// the crawler is idle until Run is called.
+func NewCrawler(opts ...Option) (_ *Crawler, err error) {
+	o := newOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	cfg := o.cfg
+	var (
+		stopTracing func(context.Context) error
+		store       Store
+		opened      []Sink
+		pool        *WireJob
+		capture     *Capture
+		c           *Crawler
+	)
+	// a failed step releases whatever the steps before it opened
+	defer func() {
+		if err == nil {
+			return
+		}
+		if c != nil {
+			if c.LSD != nil {
+				c.LSD.Close()
+			}
+			if c.Cluster != nil {
+				c.Cluster.Close()
+			}
+			if c.Shard != nil {
+				c.Shard.Close()
+			}
+			if c.AnnounceLog != nil {
+				c.AnnounceLog.Close()
+			}
+			if c.StatsD != nil {
+				c.StatsD.Close()
+			}
+			c.closeNodes()
+		}
+		if pool != nil {
+			pool.Stop()
+			if pool.Geo != nil {
+				pool.Geo.Close()
+			}
+		}
+		if capture != nil {
+			capture.Close()
+		}
+		for _, s := range opened {
+			s.Close()
+		}
+		if o.store == nil && store != nil {
+			store.Close()
+		}
+		if stopTracing != nil {
+			stopTracing(context.Background())
+		}
+	}()
+	if cfg.Nodes < 1 {
+		cfg.Nodes = 1
+	}
+	if o.logger != nil {
+		SetLogHandler(o.logger.Handler())
+	}
+	if cfg.Log != nil {
+		if err := applyLogLevels(cfg.Log); err != nil {
+			return nil, err
+		}
+	}
+	content, err := NewContentFilter(cfg.ContentRules...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Tracing != nil {
+		// like the logs, the provider is process wide
+		if stopTracing, err = SetupTracing(context.Background(), cfg.Tracing); err != nil {
+			return nil, err
+		}
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil {
+		if tlsConfig, err = LoadTLSConfig(cfg.TLS); err != nil {
+			return nil, err
+		}
+	}
+	store = o.store
+	if store == nil && cfg.StorePath != "" {
+		if store, err = OpenStore(cfg.StoreDriver, cfg.StorePath); err != nil {
+			return nil, err
+		}
+		if cs, ok := store.(CompressedStore); ok {
+			cs.SetCompression(cfg.Compression)
+		}
+	}
+	if opened, err = configSinks(cfg); err != nil {
+		return nil, err
+	}
+	sinks := append(opened, o.sinks...)
+	if store != nil {
+		sinks = append([]Sink{store}, sinks...)
+	}
+
+	pool = NewWireJob(cfg.JobSize, cfg.QueueSize)
+	pool.Limiter.SetRate(cfg.FetchRate, cfg.FetchBurst)
+	pool.Limiter.SetClock(o.clock)
+	pool.Refetch.Clock = o.clock
+	if cfg.RefetchEvery > 0 {
+		pool.Refetch.Interval = time.Duration(cfg.RefetchEvery) * time.Second
+	}
+	pool.Refetch.Attempts = cfg.RefetchTries
+	pool.SetTimeouts(time.Duration(cfg.ConnectTimeout)*time.Second, time.Duration(cfg.FetchTimeout)*time.Second)
+	pool.SetLimits(cfg.MaxMessage, cfg.MaxMetadata, cfg.MaxItems)
+	if cfg.Scores != nil {
+		scores := NewPeerScores(cfg.Scores)
+		scores.Clock = o.clock
+		pool.SetScores(scores)
+	}
+	if cfg.PartialSize >= 0 {
+		partials := NewPartialStore(cfg.PartialSize, cfg.PartialTTL)
+		partials.Clock = o.clock
+		pool.SetPartials(partials)
+	}
+	bandwidth := NewBandwidth()
+	bandwidth.Clock = o.clock
+	pool.SetBandwidth(bandwidth)
+	if cfg.InfoCachePath != "" {
+		if pool.Cache, err = OpenInfoCache(cfg.InfoCachePath); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.GeoIP != nil {
+		if pool.Geo, err = OpenGeoIP(cfg.GeoIP); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Capture != nil {
+		if capture, err = OpenCapture(*cfg.Capture); err != nil {
+			return nil, err
+		}
+		pool.SetCapture(capture)
+	}
+	if cfg.PeerStoreSize > 0 || cfg.PeersPerHash > 0 {
+		pool.Peers = NewPeerStore(cfg.PeerStoreSize, cfg.PeersPerHash)
+		pool.Refetch.peers = pool.Peers
+	}
+	c = &Crawler{
+		Pool:            pool,
+		Sinks:           sinks,
+		Store:           store,
+		MetadataHandler: o.metadataHandler,
+		Hub:             NewHub(),
+		Bandwidth:       bandwidth,
+		Events:          pool.Events,
+		Capture:         capture,
+		StatePath:       cfg.StatePath,
+		Config:          cfg,
+		Logger:          logPipeline,
+		stored:          make(chan struct{}),
+		served:          new(sync.WaitGroup),
+		shutdown:        make(chan struct{}),
+		filters:         o.filters,
+		content:         content,
+		stopTracing:     stopTracing,
+	}
+	for _, s := range opened {
+		switch s := s.(type) {
+		case *RedisSeen:
+			c.seen = s
+		case *SearchIndex:
+			c.Search = s
+		}
+	}
+	c.Sinks = append(c.Sinks, c.Hub)
+	c.Auth = newAuthenticator(cfg)
+	if cfg.HTTPAddr != "" {
+		c.Server = NewServer(c, cfg.HTTPAddr)
+		c.Server.srv.TLSConfig = tlsConfig
+		c.Sinks = append(c.Sinks, c.Server.Recent)
+	}
+	if cfg.GRPCAddr != "" {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		c.GRPC = NewGRPCServer(c, cfg.GRPCAddr, opts...)
+	}
+	if cfg.Listen != nil {
+		c.Listener = NewPeerListener(cfg.Listen)
+		c.Listener.OnHash = func(hash Hash, _ *net.TCPAddr) {
+			// the peer connected from an ephemeral port, it can't be dialed back
+			if o.hashHandler == nil || o.hashHandler(hash) {
+				pool.Add(NewJob(hash, nil))
+			}
+		}
+		if cfg.Honeypot != nil {
+			c.Honeypot = NewHoneypot(cfg.Honeypot, cfg.Listen.Addr, store)
+		}
+		// the connection of a swarm peer fetches the metadata itself
+		c.Listener.OnConn = func(hash Hash, conn net.Conn) bool {
+			if c.Honeypot != nil {
+				c.Honeypot.Connected(hash)
+			}
+			if pool.AddConn(hash, conn) {
+				return true
+			}
+			if o.hashHandler != nil && !o.hashHandler(hash) {
+				return false
+			}
+			job := NewJob(hash, nil)
+			return job.AddConn(conn) && pool.Add(job)
+		}
+		if cfg.Announce != nil {
+			c.Announcer = NewAnnouncer(pool, cfg.Entries, cfg.Listen.Addr, cfg.Announce)
+		}
+	}
+	if cfg.Trackers != nil {
+		// validated, the URLs parse
+		c.Scraper, _ = NewScraper(cfg.Trackers)
+	}
+	if cfg.Health != nil {
+		if store != nil {
+			c.Checker = NewSwarmChecker(store, cfg.Entries, cfg.Health)
+			c.Checker.Clock = o.clock
+		} else {
+			logPipeline.Warn("health checks disabled, nothing is stored")
+		}
+	}
+	c.applyFilters()
+	c.Events.Handle(func(ev interface{}) {
+		switch e := ev.(type) {
+		case *AnnounceReceived:
+			c.announce(e.Announce)
+		case *FetchStarted:
+			if c.Announcer != nil {
+				c.Announcer.Announce(e.Hash)
+			}
+			if c.LSD != nil {
+				c.LSD.Announce(e.Hash)
+			}
+		case *FetchFailed:
+			// the Refetcher's retry must not be turned away by our own claim
+			c.seen.Release(e.Hash)
+		}
+	})
+	if cfg.MaxJobSize > 0 {
+		c.Scaler = NewScaler(pool, cfg.MinJobSize, cfg.MaxJobSize)
+	}
+	if cfg.Memory != nil {
+		c.Memory = NewMemoryGuard(c, cfg.Memory)
+	}
+	// the nodes share one host, they share the aggregate response budget
+	// and the query budgets of the networks
+	responses := newResponseLimiter(cfg)
+	responses.SetClock(o.clock)
+	throttle := newQueryLimiter(cfg)
+	throttle.SetClock(o.clock)
+	for i := 0; i < cfg.Nodes; i++ {
+		port := cfg.Port
+		if port != 0 {
+			port += i
+		}
+		node, err := newDHT(cfg, i, port, pool, o.clock, o.source)
+		if err != nil {
+			return nil, err
+		}
+		node.HashHandler = o.hashHandler
+		node.Session.SetCapture(capture)
+		node.Session.SetBandwidth(bandwidth)
+		node.responses = responses
+		node.throttle = throttle
+		node.Honeypot = c.Honeypot
+		c.Nodes = append(c.Nodes, node)
+	}
+	if cfg.StatsD != nil {
+		if c.StatsD, err = NewStatsD(cfg.StatsD, NewMetricsRegistry(c)); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.AnnounceLog != nil {
+		if c.AnnounceLog, err = OpenAnnounceLog(cfg.AnnounceLog); err != nil {
+			return nil, err
+		}
+		if cfg.AnnounceLog.Samples {
+			for _, node := range c.Nodes {
+				node.SampleHandler = c.sample
+			}
+		}
+	}
+	if cfg.Shard != nil {
+		if c.Shard, err = NewShard(cfg.Shard, pool); err != nil {
+			return nil, err
+		}
+		c.applyFilters()
+	}
+	if cfg.Cluster != nil {
+		if c.Cluster, err = NewCluster(cfg.Cluster, c.seen, pool); err != nil {
+			return nil, err
+		}
+		c.applyFilters()
+	}
+	if cfg.LSD != nil {
+		listen := ""
+		if cfg.Listen != nil {
+			listen = cfg.Listen.Addr
+		}
+		if c.LSD, err = NewLSD(cfg.LSD, listen, pool); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// newAuthenticator returns nil when the config leaves the APIs open.
+func newAuthenticator(cfg *DHTConfig) *Authenticator {
+	if cfg.Auth == nil && (cfg.TLS == nil || cfg.TLS.ClientCA == "") {
+		return nil
+	}
+	// with only a client_ca, the clients need a certificate
+	a := NewAuthenticator(nil)
+	if cfg.Auth != nil {
+		a.SetKeys(cfg.Auth.Keys)
+	}
+	return a
+}
+
+// configSinks opens the sinks enabled in cfg.
+func configSinks(cfg *DHTConfig) (sinks []Sink, err error) {
+	defer func() {
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+		}
+	}()
+	if cfg.SearchPath != "" {
+		s, err := OpenSearchIndex(cfg.SearchPath)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Elastic != nil {
+		s, err := NewElasticSink(*cfg.Elastic)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Kafka != nil {
+		s, err := NewKafkaSink(*cfg.Kafka)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.NATS != nil {
+		s, err := NewNATSSink(*cfg.NATS)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.JSONL != nil {
+		s, err := NewJSONLSink(*cfg.JSONL)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Redis != nil {
+		s, err := NewRedisSeen(*cfg.Redis)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Archive != nil {
+		s, err := NewArchiveSink(*cfg.Archive)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.Webhook != nil {
+		s, err := NewWebhookSink(*cfg.Webhook)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.MQTT != nil {
+		s, err := NewMQTTSink(*cfg.MQTT)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+func (c *Crawler) AddSink(s Sink) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Sinks = append(c.Sinks, s)
+}
+
+// SetFilters replaces the filter chain asked before fetching and before
+// storing, it is safe to call while crawling. The content rules of the
+// config always run after these filters.
+func (c *Crawler) SetFilters(fs ...Filter) {
+	c.mu.Lock()
+	c.filters = fs
+	c.mu.Unlock()
+	c.applyFilters()
+}
+
+func (c *Crawler) applyFilters() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fs := []Filter{}
+	if c.Shard != nil {
+		// the other shards ask their own store
+		fs = append(fs, c.Shard)
+	}
+	if c.Cluster != nil {
+		fs = append(fs, c.Cluster)
+	}
+	if c.Store != nil {
+		fs = append(fs, storeFilter{c.Store})
+	}
+	fs = append(fs, c.filters...)
+	if c.content != nil && len(c.content.rules) != 0 {
+		fs = append(fs, c.content)
+	}
+	if c.seen != nil {
+		// claims the hashes it allows, so it must be asked last
+		fs = append(fs, c.seen)
+	}
+	c.Pool.SetFilters(fs...)
+}
+
+// Rejected returns how many fetched results the filters kept from the sinks.
+func (c *Crawler) Rejected() uint64 {
+	return atomic.LoadUint64(&c.rejected)
+}
+
+// Stats collects the counters of the nodes, the pipeline and the store.
+func (c *Crawler) Stats() *CrawlerStats {
+	st := &CrawlerStats{Rejected: c.Rejected(), Stored: -1}
+	if c.Pool != nil {
+		st.InFlight = c.Pool.InFlight()
+		st.Limited = c.Pool.Limited()
+		st.Filtered = c.Pool.Filtered()
+		st.Refetch = c.Pool.Refetch.Pending()
+		st.Workers, st.Busy = c.Pool.Workers()
+		st.Succeeded, st.Failed = c.Pool.Fetched()
+		st.Fetch = c.Pool.Stats()
+	}
+	for _, node := range c.Nodes {
+		ns := NodeStats{
+			Addr:      node.Session.Conn.LocalAddr().String(),
+			Nodes:     node.Table.Len(),
+			Queries:   atomic.LoadUint64(&node.queries),
+			Announces: atomic.LoadUint64(&node.announces),
+			Dropped:   atomic.LoadUint64(&node.dropped),
+			Throttled: atomic.LoadUint64(&node.throttled),
+		}
+		st.Queries += ns.Queries
+		st.Announces += ns.Announces
+		st.Nodes = append(st.Nodes, ns)
+	}
+	if c.Listener != nil {
+		inbound := c.Listener.Stats()
+		st.Inbound = &inbound
+	}
+	if c.Announcer != nil {
+		announce := c.Announcer.Stats()
+		st.Announce = &announce
+	}
+	if c.Checker != nil {
+		health := c.Checker.Stats()
+		st.Health = &health
+	}
+	if c.Memory != nil {
+		memory := c.Memory.Stats()
+		st.Memory = &memory
+	}
+	if c.Shard != nil {
+		shard := c.Shard.Stats()
+		st.Shard = &shard
+	}
+	if c.Cluster != nil {
+		cluster := c.Cluster.Stats()
+		st.Cluster = &cluster
+	}
+	if c.LSD != nil {
+		lsd := c.LSD.Stats()
+		st.LSD = &lsd
+	}
+	if c.Honeypot != nil {
+		honeypot := c.Honeypot.Stats()
+		st.Honeypot = &honeypot
+	}
+	if scores := c.Pool.Scores(); scores != nil {
+		sst := scores.Stats()
+		st.Scores = &sst
+	}
+	if partials := c.Pool.Partials(); partials != nil {
+		pst := partials.Stats()
+		st.Partial = &pst
+	}
+	if c.Bandwidth != nil {
+		st.Bandwidth = c.Bandwidth.Total()
+	}
+	if counter, ok := c.Store.(interface{ Len() (int, error) }); ok {
+		if n, err := counter.Len(); err == nil {
+			st.Stored = n
+		}
+	}
+	for _, q := range c.Queues() {
+		st.Queues = append(st.Queues, q.Stat())
+	}
+	c.mu.Lock()
+	for _, s := range c.Sinks {
+		sink := SinkStats{Name: sinkName(s)}
+		if recorded, ok := c.sinks[sink.Name]; ok {
+			sink = *recorded
+		}
+		sink.Healthy = sink.ErrorTime.IsZero() || sink.OKTime.After(sink.ErrorTime)
+		st.Sinks = append(st.Sinks, sink)
+	}
+	c.mu.Unlock()
+	return st
+}
+
+func (c *Crawler) HandleHash(h HashHandler) {
+	for _, node := range c.Nodes {
+		node.HandleHash(h)
+	}
+}
+
+func (c *Crawler) HandleMetadata(h ResultHandler) {
+	c.MetadataHandler = h
+}
+
+// Queues returns the pipeline queues, the socket queue of every node first.
+func (c *Crawler) Queues() []*Queue {
+	qs := []*Queue{}
+	for _, node := range c.Nodes {
+		qs = append(qs, node.Session.Results)
+	}
+	if c.Pool == nil {
+		return qs
+	}
+	return append(qs, c.Pool.Queues()...)
+}
+
+func (c *Crawler) tables() []*Table {
+	ts := []*Table{}
+	for _, node := range c.Nodes {
+		ts = append(ts, node.Table)
+	}
+	return ts
+}
+
+func (c *Crawler) closeNodes() (err error) {
+	for _, node := range c.Nodes {
+		if e := node.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Run restores the saved state, starts crawling and blocks until Shutdown is
+// called, it then returns ErrCrawlerClosed.
+func (c *Crawler) Run() error {
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return errors.New("crawler is already running")
+	}
+	c.running = true
+	// counted before Shutdown can see running, it may wait on served at once
+	c.served.Add(len(c.Nodes))
+	c.mu.Unlock()
+
+	if c.StatePath != "" {
+		jobs, err := loadState(c.StatePath, c.tables())
+		if err != nil {
+			c.Logger.Error("load crawler state", "path", c.StatePath, "error", err)
+		}
+		for _, job := range jobs {
+			c.Pool.Add(job)
+		}
+	}
+
+	go c.store()
+	if c.StatsD != nil {
+		c.StatsD.Start()
+	}
+	if c.Server != nil {
+		go func() {
+			if err := c.Server.ListenAndServe(); err != http.ErrServerClosed {
+				logServer.Error("HTTP server stopped", "addr", c.Server.Addr(), "error", err)
+			}
+		}()
+	}
+	if c.GRPC != nil {
+		go func() {
+			if err := c.GRPC.ListenAndServe(); err != nil {
+				logServer.Error("gRPC server stopped", "addr", c.GRPC.Addr(), "error", err)
+			}
+		}()
+	}
+	if c.Listener != nil {
+		go func() {
+			if err := c.Listener.ListenAndServe(); !errors.Is(err, net.ErrClosed) {
+				logServer.Error("peer listener stopped", "addr", c.Listener.Addr(), "error", err)
+			}
+		}()
+	}
+	if c.Shard != nil {
+		go func() {
+			if err := c.Shard.Serve(); err != nil {
+				logServer.Error("shard forward stopped", "addr", c.Shard.Addr(), "error", err)
+			}
+		}()
+	}
+	if c.Cluster != nil {
+		go func() {
+			if err := c.Cluster.Serve(); err != nil {
+				logServer.Error("cluster forward stopped", "addr", c.Cluster.Addr(), "error", err)
+			}
+		}()
+		go c.Cluster.Run(c.shutdown)
+	}
+	if c.LSD != nil {
+		go func() {
+			if err := c.LSD.Serve(); err != nil {
+				logServer.Error("local service discovery stopped", "error", err)
+			}
+		}()
+		go c.LSD.Run(c.shutdown)
+	}
+	if c.Honeypot != nil {
+		go c.Honeypot.Run(c.shutdown)
+	}
+	go c.Pool.Refetch.Run(c.shutdown)
+	if c.Checker != nil {
+		go c.Checker.Run(c.shutdown)
+	}
+	if c.Memory != nil {
+		go c.Memory.Run(c.shutdown)
+	}
+	if c.Scaler != nil {
+		go c.Scaler.Run(c.shutdown)
+	}
+	for _, node := range c.Nodes {
+		go node.Walk()
+		go func(node *DHT) {
+			defer c.served.Done()
+			node.Serve()
+		}(node)
+	}
+	<-c.shutdown
+	return ErrCrawlerClosed
+}
+
+// announce hands a raw announce to the sinks which record them.
+func (c *Crawler) announce(a *Announce) {
+	if c.AnnounceLog != nil {
+		if err := c.AnnounceLog.PutAnnounce(a); err != nil {
+			logSink.Warn("announce log failed", "infohash", a.Hash, "error", err)
+		}
+	}
+	c.mu.Lock()
+	sinks := c.Sinks
+	c.mu.Unlock()
+	for _, s := range sinks {
+		if as, ok := s.(AnnounceSink); ok {
+			if err := as.PutAnnounce(a); err != nil {
+				c.sinkDone(s, err)
+				logSink.Warn("announce failed", "sink", sinkName(s), "infohash", a.Hash, "peer", a.Peer, "error", err)
+			}
+		}
+	}
+}
+
+// sample logs the infohash of a get_peers query.
+func (c *Crawler) sample(hash Hash) {
+	if err := c.AnnounceLog.PutSample(hash); err != nil {
+		logSink.Warn("announce log failed", "infohash", hash, "error", err)
+	}
+}
+
+// sinkDone records the outcome of a write to s for its health.
+func (c *Crawler) sinkDone(s Sink, err error) {
+	name := sinkName(s)
+	if err != nil {
+		metricSinkErrors.WithLabelValues(name).Inc()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sinks == nil {
+		c.sinks = make(map[string]*SinkStats)
+	}
+	st, ok := c.sinks[name]
+	if !ok {
+		st = &SinkStats{Name: name}
+		c.sinks[name] = st
+	}
+	if err != nil {
+		st.Errors++
+		st.LastError = err.Error()
+		st.ErrorTime = time.Now()
+	} else {
+		st.Puts++
+		st.OKTime = time.Now()
+	}
+}
+
+func (c *Crawler) store() {
+	defer close(c.stored)
+	for v := range c.Pool.Results.C() {
+		batch := []*MetadataResult{v.(*MetadataResult)}
+		if c.Scraper != nil {
+			// the results waiting already share a scrape
+		more:
+			for len(batch) < TrackerScrapeBatch {
+				select {
+				case v, ok := <-c.Pool.Results.C():
+					if !ok {
+						break more
+					}
+					batch = append(batch, v.(*MetadataResult))
+				default:
+					break more
+				}
+			}
+			protect("scrape", func() { c.Scraper.scrapeResults(context.Background(), batch) })
+		}
+		for _, result := range batch {
+			protect("store", func() { c.put(result) })
+		}
+	}
+}
+
+// put hands one result to the handler and every sink.
+func (c *Crawler) put(result *MetadataResult) {
+	c.delivery.RLock()
+	defer c.delivery.RUnlock()
+	if c.undelivered {
+		// fetched after the deadline of Shutdown, the job is saved instead
+		logPipeline.Debug("result after shutdown dropped", "infohash", result.Hash)
+		return
+	}
+	if !c.Pool.filters.get().AllowResult(result) {
+		atomic.AddUint64(&c.rejected, 1)
+		return
+	}
+	if c.MetadataHandler != nil {
+		c.MetadataHandler(result)
+	}
+	c.mu.Lock()
+	sinks := c.Sinks
+	c.mu.Unlock()
+	for _, s := range sinks {
+		err := s.Put(result)
+		c.sinkDone(s, err)
+		if err != nil {
+			logSink.Warn("put failed", "sink", sinkName(s), "infohash", result.Hash, "error", err)
+		}
+	}
+	c.Events.Publish(&MetadataStored{Result: result})
+}
+
+// Shutdown stops accepting new hashes and waits for the running downloads
+// until ctx is done. The results already fetched are handed to the sinks,
+// which are then flushed and closed. The routing table and the jobs which
+// did not finish are saved to StatePath before the HTTP and gRPC servers
+// and the sockets are closed. The first error is returned, ctx.Err() when
+// the deadline cut the drain short.
+func (c *Crawler) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	running := c.running
+	c.running = false
+	c.mu.Unlock()
+	if !running {
+		return ErrCrawlerClosed
+	}
+	defer close(c.shutdown)
+
+	var left error
+	if c.Cluster != nil {
+		// the other members stop forwarding while the pool drains
+		left = c.Cluster.Close()
+	}
+	unfinished, err := c.Pool.Close(ctx)
+	if err == nil {
+		// the pool closed Results, wait for the store stage to consume it
+		select {
+		case <-c.stored:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if left != nil && err == nil {
+		err = left
+	}
+
+	// the downloads which missed the deadline still finish, their results
+	// must not reach the closed sinks
+	c.delivery.Lock()
+	c.undelivered = true
+	c.delivery.Unlock()
+	c.mu.Lock()
+	sinks := c.Sinks
+	c.mu.Unlock()
+	for _, s := range sinks {
+		if f, ok := s.(Flusher); ok {
+			if e := f.Flush(); e != nil && err == nil {
+				err = e
+			}
+		}
+		closed := s.Close
+		if cc, ok := s.(ContextCloser); ok {
+			closed = func() error { return cc.CloseContext(ctx) }
+		}
+		if e := closed(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if c.StatePath != "" {
+		if e := saveState(c.StatePath, c.tables(), unfinished); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if c.Server != nil {
+		if e := c.Server.Close(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.GRPC != nil {
+		if e := c.GRPC.Close(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.Listener != nil {
+		if e := c.Listener.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.Announcer != nil {
+		c.Announcer.Close()
+	}
+	if c.Shard != nil {
+		if e := c.Shard.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.LSD != nil {
+		if e := c.LSD.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.StatsD != nil {
+		if e := c.StatsD.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if e := c.closeNodes(); e != nil && err == nil {
+		err = e
+	}
+	served := make(chan struct{})
+	go func() {
+		c.served.Wait()
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-ctx.Done():
+	}
+	if c.AnnounceLog != nil {
+		if e := c.AnnounceLog.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.Pool.Geo != nil {
+		if e := c.Pool.Geo.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.Capture != nil {
+		if e := c.Capture.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	if c.stopTracing != nil {
+		if e := c.stopTracing(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
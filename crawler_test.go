@@ -0,0 +1,160 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+	"github.com/zeebo/bencode"
+)
+
+func Test_CrawlerShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	c, err := NewCrawler(WithPort(0), WithNodes(2), WithWorkers(2), WithBootstraps(), WithStatePath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- c.Run() }()
+	time.Sleep(time.Millisecond * 100)
+	c.Nodes[1].Table.Add(&Node{ID: NewNodeID(), Addr: &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 6881}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+	if err := <-done; err != ErrCrawlerClosed {
+		t.Error("Run returned", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("State not saved", err)
+	}
+
+	tables := []*Table{NewTable(), NewTable()}
+	if _, err := loadState(path, tables); err != nil || tables[1].Len() == 0 {
+		t.Error("State not restored", err)
+	}
+}
+
+func Test_CrawlerCleanup(t *testing.T) {
+	// a free port for the node
+	l, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.LocalAddr().(*net.UDPAddr).Port
+	l.Close()
+	cfg := NewDefaultConfig()
+	cfg.Entries = nil
+	cfg.Port = port
+	cfg.StorePath = filepath.Join(t.TempDir(), "test.db")
+	cfg.Shard = &ShardConfig{Count: 2, Forward: "127.0.0.1:port"}
+	// the failed shard releases the node and the store, the next attempt
+	// fails the same way
+	for i := 0; i < 2; i++ {
+		if _, err := NewCrawler(WithConfig(cfg)); err == nil || err.Error() != `forward port "port"` {
+			t.Fatal(i, err)
+		}
+	}
+}
+
+func Test_CrawlerStatsCounters(t *testing.T) {
+	c, err := NewCrawler(WithPort(0), WithNodes(1), WithWorkers(1), WithBootstraps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	defer c.Shutdown(context.Background())
+
+	ping, _ := bencode.EncodeBytes(map[string]interface{}{
+		"t": "aa", "y": TYPE_QUERY, "q": OP_PING,
+		"a": map[string]string{"id": NewNodeID().String()},
+	})
+	port := c.Nodes[0].Session.Conn.LocalAddr().(*net.UDPAddr).Port
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(ping)
+	for i := 0; i < 50 && c.Stats().Queries == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := c.Stats(); st.Queries != 1 || st.Nodes[0].Queries != 1 || st.Announces != 0 {
+		t.Errorf("counters %+v", st)
+	}
+}
+
+func Test_CrawlerResponseLimit(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.ResponseRate, cfg.ResponseBurst = 1, 2
+	c, err := NewCrawler(WithConfig(cfg), WithPort(0), WithNodes(1), WithWorkers(1), WithBootstraps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	defer c.Shutdown(context.Background())
+
+	port := c.Nodes[0].Session.Conn.LocalAddr().(*net.UDPAddr).Port
+	conn, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hash := testHash("limited")
+	for i := 0; i < 5; i++ {
+		query, _ := bencode.EncodeBytes(map[string]interface{}{
+			"t": strconv.Itoa(i), "y": TYPE_QUERY, "q": OP_GET_PEERS,
+			"a": map[string]string{"id": NewNodeID().String(), "info_hash": string(hash[:])},
+		})
+		conn.Write(query)
+	}
+	for i := 0; i < 50 && c.Stats().Queries < 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := c.Stats(); st.Queries != 5 || st.Nodes[0].Dropped != 3 {
+		t.Errorf("%d queries, %d dropped, want 5 and 3", st.Queries, st.Nodes[0].Dropped)
+	}
+}
+
+func Test_CrawlerSimulated(t *testing.T) {
+	n := testutil.StartNetwork(t, testutil.NetworkConfig{Nodes: 32, Seed: 6, Loopback: true})
+	hashes := make(chan Hash, 1)
+	c, err := NewCrawler(WithPort(0), WithNodes(1), WithWorkers(1), WithBootstraps(n.Addrs(1)...),
+		WithHashHandler(func(h Hash) bool { hashes <- h; return false }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	defer c.Shutdown(context.Background())
+
+	node := c.Nodes[0]
+	for i := 0; i < 100 && !node.Table.Bootstrapped(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !node.Table.Bootstrapped() {
+		t.Fatal("the crawler did not join the simulated network")
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: node.Session.Conn.LocalAddr().(*net.UDPAddr).Port}
+	hash := testHash("announced")
+	if err := n.AnnounceTo(n.Nodes()[3], addr, hash, 6881); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case h := <-hashes:
+		if h != hash {
+			t.Error("hash", h)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("announce not handled")
+	}
+	if st := c.Stats(); st.Announces != 1 {
+		t.Error("announces", st.Announces)
+	}
+}
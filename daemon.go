@@ -0,0 +1,63 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// PanicHandler is called with the value and the stack of a panic recovered
+// from one piece of work, a peer connection, a packet or a result. The
+// default logs them.
+var PanicHandler = func(work string, v interface{}, stack []byte) {
+	logPipeline.Error("recovered panic", "work", work, "panic", v, "stack", string(stack))
+}
+
+// protect runs fn and recovers a panic so one bad peer or packet does not
+// kill the process, it reports whether fn panicked.
+func protect(work string, fn func()) (panicked bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicked = true
+			metricPanics.WithLabelValues(work).Inc()
+			PanicHandler(work, v, debug.Stack())
+		}
+	}()
+	fn()
+	return false
+}
+
+// WriteCrashReport writes why the crawler crashed, its stats and the stack
+// of every goroutine to a new file in dir and returns its path.
+func (c *Crawler) WriteCrashReport(dir string, v interface{}) (string, error) {
+	now := time.Now()
+	path := filepath.Join(dir, "crash-"+now.Format("20060102-150405.000")+".txt")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "time: %s\ngo: %s\npid: %d\npanic: %v\n\n", now.Format(time.RFC3339), runtime.Version(), os.Getpid(), v)
+	if stats, err := json.MarshalIndent(c.Stats(), "", "  "); err == nil {
+		fmt.Fprintf(f, "stats:\n%s\n\n", stats)
+	}
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	fmt.Fprintf(f, "goroutines:\n%s", buf)
+	return path, f.Sync()
+}
+
+// SetCrashOutput appends the fatal errors and the unrecovered panics of any
+// goroutine to path, the runtime writes them there before the process exits.
+func SetCrashOutput(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return debug.SetCrashOutput(f, debug.CrashOptions{})
+}
@@ -0,0 +1,46 @@
+package DHTCrawl
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Protect(t *testing.T) {
+	defer func(h func(string, interface{}, []byte)) { PanicHandler = h }(PanicHandler)
+	var got interface{}
+	PanicHandler = func(work string, v interface{}, stack []byte) {
+		if work != "test" || !strings.Contains(string(stack), "Test_Protect") {
+			t.Error("panic report", work, string(stack))
+		}
+		got = v
+	}
+	if protect("test", func() {}) {
+		t.Error("no panic reported as one")
+	}
+	if !protect("test", func() { panic("bad peer") }) || got != "bad peer" {
+		t.Error("panic not recovered", got)
+	}
+
+}
+
+func Test_CrashReport(t *testing.T) {
+	c, err := NewCrawler(WithPort(0), WithNodes(1), WithWorkers(1), WithBootstraps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	defer c.Shutdown(context.Background())
+
+	path, err := c.WriteCrashReport(t.TempDir(), "boom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{"panic: boom", `"workers": 1`, "goroutine ", "Test_CrashReport"} {
+		if !strings.Contains(string(data), want) {
+			t.Error("crash report misses", want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package DHTCrawl
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboard is a single page reading /stats every couple of seconds and the
+// new results from /ws, it has no dependency beyond the browser.
+//
+//go:embed web/dashboard.html
+var dashboard []byte
+
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboard)
+}
@@ -0,0 +1,30 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Dashboard(t *testing.T) {
+	c := &Crawler{Config: NewDefaultConfig(), Pool: NewWireJob(1, 16), Hub: NewHub()}
+	c.Sinks = []Sink{c.Hub, failingSink{}}
+	s := NewServer(c, "")
+	w := httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "Latest discoveries") {
+		t.Error("dashboard", w.Code)
+	}
+
+	c.sinkDone(c.Hub, nil)
+	c.sinkDone(failingSink{}, errors.New("down"))
+	w = httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+	st := CrawlerStats{}
+	json.Unmarshal(w.Body.Bytes(), &st)
+	if len(st.Sinks) != 2 || !st.Sinks[0].Healthy || st.Sinks[0].Puts != 1 || st.Sinks[1].Healthy || st.Sinks[1].LastError != "down" {
+		t.Errorf("sinks %+v", st.Sinks)
+	}
+}
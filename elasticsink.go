@@ -0,0 +1,229 @@
+package DHTCrawl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/olivere/elastic.v3"
+)
+
+const (
+	DefaultElasticUrl           = "http://127.0.0.1:9200"
+	DefaultElasticIndex         = "dhtcrawl"
+	DefaultElasticBulkSize      = 500
+	DefaultElasticFlushInterval = time.Second * 5
+	DefaultElasticRetries       = 3
+)
+
+type (
+	// ElasticConfig enables the Elasticsearch sink. IndexDate is an optional
+	// time layout appended to Index with the discovery time of each result,
+	// "2006.01" gives one index per month like dhtcrawl-2016.03.
+	ElasticConfig struct {
+		Url       string `json:"url"`
+		Index     string `json:"index"`
+		IndexDate string `json:"index_date"`
+		BulkSize  int    `json:"bulk_size"`
+		Retries   int    `json:"retries"` //attempts of a failed bulk item before it is dropped
+	}
+
+	// ElasticSink bulk indexes results into Elasticsearch or OpenSearch. The
+	// document id is the hex info hash, so refetched torrents replace their
+	// old document. Items which fail with a retriable status are kept for the
+	// next bulk, an error of the last flush is returned by Put and Flush.
+	ElasticSink struct {
+		Conn   *elastic.Client
+		Config ElasticConfig
+
+		mu       sync.Mutex
+		pending  []*elasticItem
+		err      error
+		flushing sync.Mutex
+		stop     chan struct{}
+		stopped  chan struct{}
+	}
+
+	ElasticDoc struct {
+		Name     string      `json:"name"`
+		Hex      string      `json:"hex"`
+		Length   int64       `json:"length"`
+		Category string      `json:"category,omitempty"`
+		Type     int         `json:"type,omitempty"`
+		Tags     []string    `json:"tags,omitempty"`
+		Create   string      `json:"create,omitempty"`
+		Peers    int         `json:"peers,omitempty"`
+		Files    []*MetaFile `json:"files,omitempty"`
+	}
+
+	elasticItem struct {
+		index    string
+		id       string
+		doc      *ElasticDoc
+		attempts int
+	}
+)
+
+// ElasticUrl is the Elasticsearch server of the command line tools,
+// DHTCRAWL_ELASTIC overrides the default.
+func ElasticUrl() string {
+	if url := os.Getenv("DHTCRAWL_ELASTIC"); url != "" {
+		return url
+	}
+	return DefaultElasticUrl
+}
+
+func NewElasticDoc(r *MetadataResult) *ElasticDoc {
+	doc := &ElasticDoc{
+		Name:     r.Name,
+		Hex:      r.Hash.Hex(),
+		Length:   r.TotalLength(),
+		Category: r.Category,
+		Type:     r.Type,
+		Tags:     r.Tags,
+		Create:   r.created().Format(time.RFC3339),
+		Peers:    r.Peers,
+	}
+	for _, f := range r.Files {
+		doc.Files = append(doc.Files, &MetaFile{Path: strings.Join(f.Path, "/"), Length: int(f.Length)})
+	}
+	return doc
+}
+
+func NewElasticSink(cfg ElasticConfig) (*ElasticSink, error) {
+	if cfg.Url == "" {
+		cfg.Url = DefaultElasticUrl
+	}
+	if cfg.Index == "" {
+		cfg.Index = DefaultElasticIndex
+	}
+	if cfg.BulkSize <= 0 {
+		cfg.BulkSize = DefaultElasticBulkSize
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = DefaultElasticRetries
+	}
+	// clusters behind a proxy or in a container advertise addresses we can't reach
+	conn, err := elastic.NewClient(elastic.SetURL(cfg.Url), elastic.SetSniff(false))
+	if err != nil {
+		return nil, err
+	}
+	s := &ElasticSink{
+		Conn:    conn,
+		Config:  cfg,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.flushLoop(DefaultElasticFlushInterval)
+	return s, nil
+}
+
+// IndexName returns the index r is written to.
+func (s *ElasticSink) IndexName(r *MetadataResult) string {
+	if s.Config.IndexDate == "" {
+		return s.Config.Index
+	}
+	return s.Config.Index + "-" + r.created().Format(s.Config.IndexDate)
+}
+
+func (s *ElasticSink) Put(r *MetadataResult) error {
+	item := &elasticItem{index: s.IndexName(r), id: r.Hash.Hex(), doc: NewElasticDoc(r)}
+	s.mu.Lock()
+	s.pending = append(s.pending, item)
+	full := len(s.pending) >= s.Config.BulkSize
+	err := s.err
+	s.err = nil
+	s.mu.Unlock()
+	if full {
+		if e := s.Flush(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *ElasticSink) flushLoop(interval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Flush sends the pending documents in one bulk request.
+func (s *ElasticSink) Flush() error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	s.mu.Lock()
+	items := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(items) == 0 {
+		return nil
+	}
+
+	retry, err := s.bulk(items)
+	kept := []*elasticItem{}
+	for _, item := range retry {
+		if item.attempts++; item.attempts < s.Config.Retries {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) != 0 {
+		s.mu.Lock()
+		s.pending = append(kept, s.pending...)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// bulk returns the items worth sending again.
+func (s *ElasticSink) bulk(items []*elasticItem) ([]*elasticItem, error) {
+	req := s.Conn.Bulk()
+	byId := make(map[string]*elasticItem, len(items))
+	for _, item := range items {
+		byId[item.id] = item
+		req.Add(elastic.NewBulkIndexRequest().Index(item.index).Type(Type).Id(item.id).Doc(item.doc))
+	}
+	resp, err := req.Do()
+	if err != nil {
+		return items, err
+	}
+	failed := resp.Failed()
+	if len(failed) == 0 {
+		return nil, nil
+	}
+	retry := []*elasticItem{}
+	for _, f := range failed {
+		// rejected documents (bad mapping) fail the same way every time
+		if item, ok := byId[f.Id]; ok && (f.Status == 429 || f.Status >= 500) {
+			retry = append(retry, item)
+		}
+	}
+	reason := ""
+	if f := failed[0]; f.Error != nil {
+		reason = f.Error.Reason
+	}
+	return retry, fmt.Errorf("elastic bulk %d of %d items failed: %s", len(failed), len(items), reason)
+}
+
+func (s *ElasticSink) Close() error {
+	close(s.stop)
+	<-s.stopped
+	err := s.Flush()
+	s.Conn.Stop()
+	return err
+}
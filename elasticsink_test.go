@@ -0,0 +1,48 @@
+package DHTCrawl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_ElasticSink(t *testing.T) {
+	var bulks int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			w.Write([]byte(`{"version":{"number":"2.4.0"}}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"_index":"torrents-2016.03"`) {
+			t.Error("Index name", string(body))
+		}
+		// the first bulk is rejected by a busy cluster, the retry succeeds
+		if atomic.AddInt32(&bulks, 1) == 1 {
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"_id":"951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED","status":429,"error":{"reason":"queue full"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[{"index":{"_id":"951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED","status":201}}]}`))
+	}))
+	defer srv.Close()
+
+	s, err := NewElasticSink(ElasticConfig{Url: srv.URL, Index: "torrents", IndexDate: "2006.01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &MetadataResult{Hash: Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED")), Name: "test", Create: "2016-03-01T10:00:00Z"}
+	s.Put(r)
+	if err := s.Flush(); err == nil {
+		t.Error("Flush should report the failed item")
+	}
+	if err := s.Flush(); err != nil {
+		t.Error("Retry", err)
+	}
+	if n := atomic.LoadInt32(&bulks); n != 2 {
+		t.Error("Bulk requests", n)
+	}
+	s.Close()
+}
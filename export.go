@@ -0,0 +1,69 @@
+package DHTCrawl
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ExportColumns is the header of a CSV export.
+var ExportColumns = []string{"hash", "name", "length", "files", "category", "created", "magnet", "swarm", "alive"}
+
+// Export streams every stored result matching q to w, as JSON lines or CSV,
+// and returns how many were written.
+func Export(s Store, w io.Writer, format string, q TorrentQuery) (int, error) {
+	bw := bufio.NewWriter(w)
+	var (
+		write func(*MetadataResult) error
+		flush = bw.Flush
+	)
+	switch format {
+	case "jsonl", "":
+		enc := json.NewEncoder(bw)
+		write = func(r *MetadataResult) error { return enc.Encode(r) }
+	case "csv":
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(ExportColumns); err != nil {
+			return 0, err
+		}
+		write = func(r *MetadataResult) error {
+			return cw.Write([]string{
+				r.Hash.Hex(), r.Name, strconv.FormatInt(r.TotalLength(), 10), strconv.Itoa(len(r.Files)),
+				r.Category, r.Create, r.Magnet(), strconv.Itoa(r.Swarm), r.alive().UTC().Format(time.RFC3339),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	default:
+		return 0, fmt.Errorf("unknown format %q, use jsonl or csv", format)
+	}
+
+	n := 0
+	var werr error
+	err := s.Iterate(func(r *MetadataResult) bool {
+		if !q.Match(r) {
+			return true
+		}
+		if werr = write(r); werr != nil {
+			return false
+		}
+		n++
+		return true
+	})
+	if err == nil {
+		err = werr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, flush()
+}
@@ -0,0 +1,48 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Export(t *testing.T) {
+	s, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i, cat := range []string{"video", "audio", "video"} {
+		h := Hash(NewNodeIDFromHex(fmt.Sprintf("%040X", i)))
+		s.Put(&MetadataResult{Hash: h, Name: fmt.Sprintf("name, %d", i), Length: int64(i * 100), Category: cat})
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := Export(s, buf, "jsonl", TorrentQuery{Category: "video"})
+	if err != nil || n != 2 {
+		t.Fatal("jsonl", n, err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r MetadataResult
+		if err := json.Unmarshal([]byte(line), &r); err != nil || r.Category != "video" {
+			t.Error("jsonl line", line, err)
+		}
+	}
+
+	buf.Reset()
+	if n, err = Export(s, buf, "csv", TorrentQuery{MinSize: 100}); err != nil || n != 2 {
+		t.Fatal("csv", n, err)
+	}
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil || len(records) != 3 || records[0][0] != "hash" || records[1][1] != "name, 1" {
+		t.Error("csv records", records, err)
+	}
+
+	if _, err := Export(s, buf, "xml", TorrentQuery{}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,162 @@
+package DHTCrawl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultFeedItems = 50
+	MaxFeedItems     = 500
+)
+
+type (
+	rssFeed struct {
+		XMLName xml.Name   `xml:"rss"`
+		Version string     `xml:"version,attr"`
+		Channel rssChannel `xml:"channel"`
+	}
+
+	rssChannel struct {
+		Title       string    `xml:"title"`
+		Link        string    `xml:"link"`
+		Description string    `xml:"description"`
+		Items       []rssItem `xml:"item"`
+	}
+
+	rssItem struct {
+		Title     string       `xml:"title"`
+		Link      string       `xml:"link"`
+		GUID      string       `xml:"guid"`
+		PubDate   string       `xml:"pubDate"`
+		Category  string       `xml:"category,omitempty"`
+		Enclosure rssEnclosure `xml:"enclosure"`
+	}
+
+	rssEnclosure struct {
+		Url    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	}
+
+	atomFeed struct {
+		XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+		Title   string      `xml:"title"`
+		ID      string      `xml:"id"`
+		Updated string      `xml:"updated"`
+		Links   []atomLink  `xml:"link"`
+		Entries []atomEntry `xml:"entry"`
+	}
+
+	atomEntry struct {
+		Title    string        `xml:"title"`
+		ID       string        `xml:"id"`
+		Updated  string        `xml:"updated"`
+		Links    []atomLink    `xml:"link"`
+		Category *atomCategory `xml:"category,omitempty"`
+		Summary  string        `xml:"summary"`
+	}
+
+	atomLink struct {
+		Href   string `xml:"href,attr"`
+		Rel    string `xml:"rel,attr,omitempty"`
+		Type   string `xml:"type,attr,omitempty"`
+		Length int64  `xml:"length,attr,omitempty"`
+	}
+
+	atomCategory struct {
+		Term string `xml:"term,attr"`
+	}
+)
+
+// feedItems reads ?n= and ?category= of a feed request.
+func (s *Server) feedItems(r *http.Request) []*MetadataResult {
+	n, err := strconv.Atoi(r.URL.Query().Get("n"))
+	if err != nil || n <= 0 {
+		n = DefaultFeedItems
+	}
+	if n > MaxFeedItems {
+		n = MaxFeedItems
+	}
+	var match func(*MetadataResult) bool
+	if category := r.URL.Query().Get("category"); category != "" {
+		match = func(m *MetadataResult) bool {
+			return m.Category == category
+		}
+	}
+	return s.Recent.Latest(n, match)
+}
+
+func requestBase(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func writeXML(w http.ResponseWriter, contentType string, v interface{}) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func (s *Server) handleRSS(w http.ResponseWriter, r *http.Request) {
+	feed := rssFeed{Version: "2.0", Channel: rssChannel{
+		Title:       "DHTCrawl",
+		Link:        requestBase(r) + r.URL.RequestURI(),
+		Description: "Torrents recently discovered in the DHT",
+	}}
+	for _, m := range s.feedItems(r) {
+		magnet := m.Magnet()
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:     m.Name,
+			Link:      magnet,
+			GUID:      m.Hash.Hex(),
+			PubDate:   m.created().Format(time.RFC1123Z),
+			Category:  m.Category,
+			Enclosure: rssEnclosure{Url: magnet, Length: m.TotalLength(), Type: "application/x-bittorrent"},
+		})
+	}
+	writeXML(w, "application/rss+xml; charset=utf-8", feed)
+}
+
+func (s *Server) handleAtom(w http.ResponseWriter, r *http.Request) {
+	self := requestBase(r) + r.URL.RequestURI()
+	feed := atomFeed{
+		Title:   "DHTCrawl",
+		ID:      self,
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Href: self, Rel: "self"}},
+	}
+	items := s.feedItems(r)
+	if len(items) != 0 {
+		feed.Updated = items[0].created().UTC().Format(time.RFC3339)
+	}
+	for _, m := range items {
+		files := len(m.Files)
+		if files == 0 {
+			files = 1
+		}
+		entry := atomEntry{
+			Title:   m.Name,
+			ID:      "urn:btih:" + m.Hash.Hex(),
+			Updated: m.created().UTC().Format(time.RFC3339),
+			Links:   []atomLink{{Href: m.Magnet(), Rel: "enclosure", Type: "application/x-bittorrent", Length: m.TotalLength()}},
+			Summary: fmt.Sprintf("%d files, %d bytes", files, m.TotalLength()),
+		}
+		if m.Category != "" {
+			entry.Category = &atomCategory{Term: m.Category}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	writeXML(w, "application/atom+xml; charset=utf-8", feed)
+}
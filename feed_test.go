@@ -0,0 +1,44 @@
+package DHTCrawl
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Feed(t *testing.T) {
+	s := NewServer(&Crawler{Config: NewDefaultConfig()}, "")
+	s.Recent = NewRecent(2)
+	for i, name := range []string{"old", "movie", "song"} {
+		category := CategoryVideo
+		if i == 2 {
+			category = CategoryAudio
+		}
+		s.Recent.Put(&MetadataResult{Hash: testHash(strings.Repeat(string(rune('a'+i)), 20)), Name: name, Category: category})
+	}
+
+	w := httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/feed.rss", nil))
+	feed := rssFeed{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	// the ring holds two results, newest first
+	if len(feed.Channel.Items) != 2 || feed.Channel.Items[0].Title != "song" || feed.Channel.Items[1].Title != "movie" {
+		t.Error("RSS items", feed.Channel.Items)
+	}
+	if !strings.HasPrefix(feed.Channel.Items[0].Enclosure.Url, "magnet:?xt=urn:btih:") {
+		t.Error("Enclosure", feed.Channel.Items[0].Enclosure)
+	}
+
+	w = httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/feed.atom?category="+CategoryVideo, nil))
+	atom := atomFeed{}
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	if len(atom.Entries) != 1 || atom.Entries[0].Title != "movie" {
+		t.Error("Atom entries", atom.Entries)
+	}
+}
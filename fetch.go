@@ -0,0 +1,226 @@
+package DHTCrawl
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errBadInfohash = errors.New("expected a magnet URI or a 40 character hex infohash")
+
+// ParseMagnet reads the infohash of a magnet URI, in hex or base32, and its
+// x.pe peers. A v2 urn:btmh infohash is truncated to the hash the DHT knows
+// it by. A bare hex infohash is accepted too.
+func ParseMagnet(s string) (Hash, []*net.TCPAddr, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "magnet:") {
+		hash, err := parseInfohash(s)
+		return hash, nil, err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+	v := u.Query()
+	var hash Hash
+	for _, xt := range v["xt"] {
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			hash, err = parseInfohash(strings.TrimPrefix(xt, "urn:btih:"))
+		case strings.HasPrefix(xt, "urn:btmh:1220"): //SHA-256 multihash
+			var v2 HashV2
+			v2, err = HashV2FromHex(strings.TrimPrefix(xt, "urn:btmh:1220"))
+			hash = v2.Truncated()
+		default:
+			continue
+		}
+		if err != nil {
+			return Hash{}, nil, err
+		}
+		//a hybrid magnet carries both, btih is the one its v1 peers know
+		if strings.HasPrefix(xt, "urn:btih:") {
+			break
+		}
+	}
+	if hash.IsZero() {
+		return Hash{}, nil, errors.New("magnet URI has no urn:btih or urn:btmh")
+	}
+	peers := []*net.TCPAddr{}
+	for _, pe := range v["x.pe"] {
+		if addr, err := net.ResolveTCPAddr("tcp", pe); err == nil && IsValidPort(addr.Port) {
+			peers = append(peers, addr)
+		}
+	}
+	return hash, peers, nil
+}
+
+// MagnetTrackers returns the tr trackers of a magnet URI.
+func MagnetTrackers(s string) []string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Scheme != "magnet" {
+		return nil
+	}
+	return u.Query()["tr"]
+}
+
+func parseInfohash(s string) (Hash, error) {
+	var (
+		hash Hash
+		err  error
+	)
+	switch len(s) {
+	case 40:
+		hash, err = HashFromHex(s)
+	case 32:
+		hash, err = HashFromBase32(s)
+	default:
+		return Hash{}, errBadInfohash
+	}
+	if err != nil {
+		return Hash{}, errBadInfohash
+	}
+	return hash, nil
+}
+
+// ErrNoMetadata is returned when every peer was tried without success.
+var ErrNoMetadata = errors.New("no peer sent the metadata")
+
+// FetchParallel is how many peers FetchMetadata downloads from at once.
+const FetchParallel = 8
+
+// FetchMetadata downloads the metadata of hash from the given peers and,
+// with entries, from the peers a get_peers lookup finds in the DHT. Up to
+// FetchParallel downloads race, the first whose info dictionary hashes to
+// hash wins. The torrent cache is the last resort. It returns ctx.Err() when
+// ctx is done first.
+func FetchMetadata(ctx context.Context, hash Hash, peers []*net.TCPAddr, entries []string) (*MetadataResult, error) {
+	ctx, span := spanTracer(ctx, tracer()).Start(ctx, "fetch", trace.WithAttributes(hashAttr(hash)))
+	defer span.End()
+	r, err := fetchMetadata(ctx, hash, peers, entries)
+	traceError(span, err)
+	return r, err
+}
+
+func fetchMetadata(ctx context.Context, hash Hash, peers []*net.TCPAddr, entries []string) (*MetadataResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	addrs := make(chan *net.TCPAddr)
+	var found <-chan *net.TCPAddr
+	if len(entries) > 0 {
+		var err error
+		if found, err = LookupPeers(ctx, hash, entries); err != nil {
+			return nil, err
+		}
+	}
+	go func() {
+		defer close(addrs)
+		for _, addr := range peers {
+			select {
+			case addrs <- addr:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if found == nil {
+			return
+		}
+		for addr := range found {
+			select {
+			case addrs <- addr:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	r, err := RaceMetadata(ctx, hash, addrs, FetchParallel)
+	if err == nil {
+		return r, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if r, e := (&Wire{}).fromHTTP(ctx, hash); e == nil && r.Verify() {
+		return r, nil
+	}
+	return nil, err
+}
+
+// RaceMetadata downloads the metadata of hash from up to parallel of the
+// peers at once until one succeeds, or returns ErrNoMetadata once peers is
+// closed and every download failed. The peers the others send over ut_pex
+// are tried too, before the next ones of peers.
+func RaceMetadata(ctx context.Context, hash Hash, peers <-chan *net.TCPAddr, parallel int) (*MetadataResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan *MetadataResult)
+	exchanged := make(chan []*net.TCPAddr)
+	seen := map[string]bool{}
+	var swarm []*net.TCPAddr //sent over ut_pex, not tried yet
+	running := 0
+	start := func(addr *net.TCPAddr) {
+		seen[addr.String()] = true
+		running++
+		go func() {
+			//every download gets its own wire, a wire holds one processor
+			w := &Wire{pex: func(peers []*net.TCPAddr) {
+				select {
+				case exchanged <- peers:
+				case <-ctx.Done():
+				}
+			}}
+			r, err := w.fromPeer(ctx, hash, addr)
+			if err != nil || !r.Verify() {
+				r = nil
+			}
+			select {
+			case results <- r:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	for peers != nil || running > 0 || len(swarm) > 0 {
+		if running < parallel && len(swarm) > 0 {
+			start(swarm[0])
+			swarm = swarm[1:]
+			continue
+		}
+		var next <-chan *net.TCPAddr
+		if running < parallel {
+			next = peers
+		}
+		select {
+		case addr, ok := <-next:
+			if !ok {
+				peers = nil
+				continue
+			}
+			start(addr)
+		case got := <-exchanged:
+			for _, addr := range got {
+				if !seen[addr.String()] && len(swarm) < pexMaxPeers {
+					seen[addr.String()] = true
+					swarm = append(swarm, addr)
+				}
+			}
+		case r := <-results:
+			running--
+			if r != nil {
+				return r, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, ErrNoMetadata
+}
+
+// Verify reports whether the info dictionary hashes to the infohash.
+func (m *MetadataResult) Verify() bool {
+	sum := sha1.Sum(m.Info)
+	return len(m.Info) > 0 && Hash(sum) == m.Hash
+}
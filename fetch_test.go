@@ -0,0 +1,147 @@
+package DHTCrawl
+
+import (
+	"context"
+	"crypto/sha1"
+	"net"
+	"testing"
+	"time"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+	"github.com/zeebo/bencode"
+)
+
+func Test_ParseMagnet(t *testing.T) {
+	hex := "0123456789ABCDEF0123456789ABCDEF01234567"
+	hash, peers, err := ParseMagnet("magnet:?xt=urn:btih:" + hex + "&dn=test&x.pe=1.2.3.4:6881&x.pe=5.6.7.8:0")
+	if err != nil || hash.Hex() != hex || len(peers) != 1 || peers[0].String() != "1.2.3.4:6881" {
+		t.Error("magnet", hash.Hex(), peers, err)
+	}
+	// the same hash in base32
+	if b32, _, err := ParseMagnet("magnet:?xt=urn:btih:AERUKZ4JVPG66AJDIVTYTK6N54ASGRLH"); err != nil || b32 != hash {
+		t.Error("base32", b32.Hex(), err)
+	}
+	if bare, _, err := ParseMagnet(hex); err != nil || bare != hash {
+		t.Error("bare", err)
+	}
+	for _, bad := range []string{"", "magnet:?dn=x", "zz23456789ABCDEF0123456789ABCDEF01234567"} {
+		if _, _, err := ParseMagnet(bad); err == nil {
+			t.Error("accepted", bad)
+		}
+	}
+
+	info := []byte("d4:name4:teste")
+	sum := sha1.Sum(info)
+	r := &MetadataResult{Hash: Hash(sum[:]), Info: info}
+	if !r.Verify() {
+		t.Error("Verify")
+	}
+	r.Info = []byte("d4:name5:othere")
+	if r.Verify() {
+		t.Error("Verify wrong info")
+	}
+}
+
+// fakeNode answers every get_peers query with one compact peer.
+func fakeNode(t *testing.T, peer *net.TCPAddr) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			q := map[string]interface{}{}
+			if bencode.DecodeBytes(buf[:n], &q) != nil || q["q"] != OP_GET_PEERS {
+				continue
+			}
+			value := append(peer.IP.To4(), byte(peer.Port>>8), byte(peer.Port))
+			resp, _ := bencode.EncodeBytes(map[string]interface{}{
+				"t": q["t"],
+				"y": TYPE_RESPONSE,
+				"r": map[string]interface{}{
+					"id":     NewNodeID().String(),
+					"token":  "token",
+					"values": []string{string(value)},
+				},
+			})
+			conn.WriteToUDP(resp, addr)
+		}
+	}()
+	return conn
+}
+
+func Test_LookupPeers(t *testing.T) {
+	peer := &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 6881}
+	node := fakeNode(t, peer)
+	defer node.Close()
+	hash := Hash(NewNodeIDFromHex("0123456789ABCDEF0123456789ABCDEF01234567"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peers, err := LookupPeers(ctx, hash, []string{node.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-peers:
+		if addr.String() != peer.String() {
+			t.Error("peer", addr)
+		}
+	case <-ctx.Done():
+		t.Fatal("no peer found")
+	}
+	cancel()
+	for range peers {
+	}
+}
+
+func Test_LookupPeersSimulated(t *testing.T) {
+	n := testutil.StartNetwork(t, testutil.NetworkConfig{Nodes: 64, Seed: 5, Loopback: true})
+	hash := testHash("simulated")
+	peer := &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 6881}
+	n.Store(hash, peer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peers, err := LookupPeers(ctx, hash, n.Addrs(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-peers:
+		if addr.String() != peer.String() {
+			t.Error("peer", addr)
+		}
+	case <-ctx.Done():
+		t.Fatal("no peer found")
+	}
+	cancel()
+	for range peers {
+	}
+}
+
+func Test_RaceMetadata(t *testing.T) {
+	hash := Hash(NewNodeIDFromHex("0123456789ABCDEF0123456789ABCDEF01234567"))
+	// nothing listens on these, every download fails at once
+	peers := make(chan *net.TCPAddr, 3)
+	for i := 0; i < 3; i++ {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		peers <- l.Addr().(*net.TCPAddr)
+		l.Close()
+	}
+	close(peers)
+	if _, err := RaceMetadata(context.Background(), hash, peers, 2); err != ErrNoMetadata {
+		t.Error("failed peers", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := RaceMetadata(ctx, hash, make(chan *net.TCPAddr), 2); err != context.DeadlineExceeded {
+		t.Error("timeout", err)
+	}
+}
@@ -0,0 +1,78 @@
+package DHTCrawl
+
+import (
+	"net"
+	"sync"
+)
+
+type (
+	// Filter decides what goes through the pipeline. AllowHash is asked in the
+	// dedup stage before a newly announced hash enters the fetch queue,
+	// AllowResult before a fetched result reaches the handler and sinks.
+	Filter interface {
+		AllowHash(hash Hash, peer *net.TCPAddr) bool
+		AllowResult(*MetadataResult) bool
+	}
+
+	// HashFilter adapts a function to a Filter which only looks at hashes.
+	HashFilter func(hash Hash, peer *net.TCPAddr) bool
+
+	// ResultFilter adapts a function to a Filter which only looks at results.
+	ResultFilter func(*MetadataResult) bool
+
+	// Filters passes only what every filter in it allows.
+	Filters []Filter
+
+	// filterSlot holds the filter chain of a running pipeline so it can be
+	// swapped on reload.
+	filterSlot struct {
+		mu      sync.RWMutex
+		filters Filters
+	}
+)
+
+func (f HashFilter) AllowHash(hash Hash, peer *net.TCPAddr) bool {
+	return f(hash, peer)
+}
+
+func (f HashFilter) AllowResult(*MetadataResult) bool {
+	return true
+}
+
+func (f ResultFilter) AllowHash(Hash, *net.TCPAddr) bool {
+	return true
+}
+
+func (f ResultFilter) AllowResult(r *MetadataResult) bool {
+	return f(r)
+}
+
+func (fs Filters) AllowHash(hash Hash, peer *net.TCPAddr) bool {
+	for _, f := range fs {
+		if !f.AllowHash(hash, peer) {
+			return false
+		}
+	}
+	return true
+}
+
+func (fs Filters) AllowResult(r *MetadataResult) bool {
+	for _, f := range fs {
+		if !f.AllowResult(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *filterSlot) set(fs Filters) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters = fs
+}
+
+func (s *filterSlot) get() Filters {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filters
+}
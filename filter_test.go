@@ -0,0 +1,29 @@
+package DHTCrawl
+
+import (
+	"net"
+	"testing"
+)
+
+func Test_Filters(t *testing.T) {
+	fs := Filters{
+		HashFilter(func(hash Hash, _ *net.TCPAddr) bool { return hash != testHash("blocked") }),
+		ResultFilter(func(r *MetadataResult) bool { return r.Name != "" }),
+	}
+	if fs.AllowHash(testHash("blocked"), nil) || !fs.AllowHash(testHash("allowed"), nil) {
+		t.Error("Hash filter has error")
+	}
+	if fs.AllowResult(&MetadataResult{}) || !fs.AllowResult(&MetadataResult{Name: "a"}) {
+		t.Error("Result filter has error")
+	}
+}
+
+func Test_PoolFilter(t *testing.T) {
+	pool := NewWireJob(0, 4)
+	defer pool.Stop()
+	pool.SetFilters(HashFilter(func(Hash, *net.TCPAddr) bool { return false }))
+	pool.addJob(NewJob(testHash("12345678901234567890"), nil))
+	if pool.Filtered() != 1 || pool.Jobs.Len() != 0 {
+		t.Error("Filtered hash reached the fetch queue")
+	}
+}
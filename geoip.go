@@ -0,0 +1,86 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+type (
+	// GeoIPConfig names the MaxMind databases the announcing and the sending
+	// peers are looked up in, either may be left out.
+	GeoIPConfig struct {
+		Country string `json:"country"` //GeoLite2-Country or GeoIP2-City .mmdb
+		ASN     string `json:"asn"`     //GeoLite2-ASN .mmdb
+	}
+
+	// PeerGeo is where a peer is, as far as the databases know.
+	PeerGeo struct {
+		Country string `json:"country,omitempty"` //ISO 3166 code
+		ASN     uint   `json:"asn,omitempty"`
+		Org     string `json:"org,omitempty"` //owner of the ASN
+	}
+
+	// GeoIP looks peers up in the MaxMind databases.
+	GeoIP struct {
+		country *geoip2.Reader
+		asn     *geoip2.Reader
+	}
+)
+
+// OpenGeoIP opens the databases of cfg.
+func OpenGeoIP(cfg *GeoIPConfig) (*GeoIP, error) {
+	if cfg.Country == "" && cfg.ASN == "" {
+		return nil, errors.New("geoip: no database")
+	}
+	g := &GeoIP{}
+	var err error
+	if cfg.Country != "" {
+		if g.country, err = geoip2.Open(cfg.Country); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ASN != "" {
+		if g.asn, err = geoip2.Open(cfg.ASN); err != nil {
+			g.Close()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Lookup returns the location of ip, nil when no database knows it. A nil
+// GeoIP knows nothing.
+func (g *GeoIP) Lookup(ip net.IP) *PeerGeo {
+	if g == nil || ip == nil {
+		return nil
+	}
+	geo := &PeerGeo{}
+	if g.country != nil {
+		if c, err := g.country.Country(ip); err == nil {
+			geo.Country = c.Country.IsoCode
+		}
+	}
+	if g.asn != nil {
+		if a, err := g.asn.ASN(ip); err == nil {
+			geo.ASN, geo.Org = a.AutonomousSystemNumber, a.AutonomousSystemOrganization
+		}
+	}
+	if *geo == (PeerGeo{}) {
+		return nil
+	}
+	return geo
+}
+
+func (g *GeoIP) Close() error {
+	var err error
+	for _, r := range []*geoip2.Reader{g.country, g.asn} {
+		if r != nil {
+			if e := r.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeMMDB writes an IPv4 MaxMind database of kind in which only cidr has
+// a record, the MaxMind writer is not a dependency.
+func writeMMDB(t *testing.T, kind, cidr string, record map[string]interface{}) string {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ones, _ := network.Mask.Size()
+	//one node per prefix bit, the bit of the prefix leads on, the other one
+	//to the empty record
+	nodes := uint32(ones)
+	buf := &bytes.Buffer{}
+	for i := 0; i < ones; i++ {
+		next := uint32(i + 1)
+		if i == ones-1 {
+			next = nodes + 16 //the record at the start of the data section
+		}
+		left, right := next, nodes
+		if network.IP.To4()[i/8]>>(7-i%8)&1 == 1 {
+			left, right = nodes, next
+		}
+		buf.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+	}
+	buf.Write(make([]byte, 16))
+	mmdbEncode(buf, record)
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+	mmdbEncode(buf, map[string]interface{}{
+		"node_count":    nodes,
+		"record_size":   uint16(24),
+		"ip_version":    uint16(4),
+		"database_type": kind,
+	})
+	path := filepath.Join(t.TempDir(), kind+".mmdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// mmdbEncode writes the strings, unsigned integers and maps of the MaxMind
+// DB data format, sizes up to 284.
+func mmdbEncode(buf *bytes.Buffer, v interface{}) {
+	control := func(kind byte, size int) {
+		if size < 29 {
+			buf.WriteByte(kind<<5 | byte(size))
+		} else {
+			buf.WriteByte(kind<<5 | 29)
+			buf.WriteByte(byte(size - 29))
+		}
+	}
+	unsigned := func(kind byte, n uint64) {
+		payload := []byte{}
+		for ; n > 0; n >>= 8 {
+			payload = append([]byte{byte(n)}, payload...)
+		}
+		control(kind, len(payload))
+		buf.Write(payload)
+	}
+	switch v := v.(type) {
+	case string:
+		control(2, len(v))
+		buf.WriteString(v)
+	case uint16:
+		unsigned(5, uint64(v))
+	case uint32:
+		unsigned(6, uint64(v))
+	case map[string]interface{}:
+		control(7, len(v))
+		for key, value := range v {
+			mmdbEncode(buf, key)
+			mmdbEncode(buf, value)
+		}
+	}
+}
+
+func Test_GeoIP(t *testing.T) {
+	cfg := &GeoIPConfig{
+		Country: writeMMDB(t, "GeoLite2-Country", "127.0.0.0/8", map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "NL"},
+		}),
+		ASN: writeMMDB(t, "GeoLite2-ASN", "127.0.0.0/16", map[string]interface{}{
+			"autonomous_system_number":       uint32(64500),
+			"autonomous_system_organization": "Example",
+		}),
+	}
+	geo, err := OpenGeoIP(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer geo.Close()
+	if g := geo.Lookup(net.ParseIP("127.0.0.1")); g == nil || *g != (PeerGeo{Country: "NL", ASN: 64500, Org: "Example"}) {
+		t.Error("lookup", g)
+	}
+	if g := geo.Lookup(net.ParseIP("127.1.0.1")); g == nil || g.Country != "NL" || g.ASN != 0 {
+		t.Error("country only", g)
+	}
+	if g := geo.Lookup(net.ParseIP("10.0.0.1")); g != nil {
+		t.Error("unknown network", g)
+	}
+	if _, err := OpenGeoIP(&GeoIPConfig{}); err == nil {
+		t.Error("opened without a database")
+	}
+
+	//the pool tags the announces and the source of the results
+	info := []byte("d6:lengthi1e4:name3:geo12:piece lengthi16384e6:pieces0:e")
+	hash, addr := fakePeer(t, info)
+	pool := NewWireJob(1, 0)
+	defer pool.Stop()
+	pool.Geo = geo
+	announces := make(chan *Announce, 1)
+	pool.OnAnnounce = func(a *Announce) { announces <- a }
+	pool.Announces.Push(NewJob(hash, addr))
+	if a := <-announces; a.Geo == nil || a.Geo.Country != "NL" {
+		t.Error("announce", a.Geo)
+	}
+	r := (<-pool.Results.C()).(*MetadataResult)
+	if r.Source != addr.String() || r.SourceGeo == nil || r.SourceGeo.ASN != 64500 {
+		t.Error("result", r.Source, r.SourceGeo)
+	}
+}
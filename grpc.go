@@ -0,0 +1,168 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"time"
+
+	dhtcrawlpb "bitbucket.org/AlanYang/DHTCrawl/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer serves the Crawler service of proto/dhtcrawl.proto. Streams
+// are fed by the crawler Hub, gRPC flow control holds back the sender when
+// a client reads slowly and the subscription queue absorbs the difference.
+type GRPCServer struct {
+	dhtcrawlpb.UnimplementedCrawlerServer
+
+	Crawler *Crawler
+	Server  *grpc.Server
+	addr    string
+}
+
+func NewGRPCServer(c *Crawler, addr string, opts ...grpc.ServerOption) *GRPCServer {
+	s := &GRPCServer{Crawler: c, addr: addr}
+	opts = append(opts, grpc.ChainUnaryInterceptor(s.unaryAuth), grpc.ChainStreamInterceptor(s.streamAuth))
+	s.Server = grpc.NewServer(opts...)
+	dhtcrawlpb.RegisterCrawlerServer(s.Server, s)
+	return s
+}
+
+func (s *GRPCServer) Addr() string {
+	return s.addr
+}
+
+// ListenAndServe blocks until Close.
+func (s *GRPCServer) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Server.Serve(ln)
+}
+
+// Close lets the unary calls finish until ctx is done, streams are ended
+// by the crawler closing its hub.
+func (s *GRPCServer) Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.Server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Server.Stop()
+		return ctx.Err()
+	}
+}
+
+func (s *GRPCServer) StreamAnnounces(req *dhtcrawlpb.StreamRequest, stream dhtcrawlpb.Crawler_StreamAnnouncesServer) error {
+	sub := s.Crawler.Hub.Subscribe(0, true)
+	defer sub.Close()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case v, ok := <-sub.C():
+			if !ok {
+				return nil
+			}
+			if a, ok := v.(*Announce); ok {
+				if err := stream.Send(a.Proto()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (s *GRPCServer) StreamMetadata(req *dhtcrawlpb.StreamRequest, stream dhtcrawlpb.Crawler_StreamMetadataServer) error {
+	sub := s.Crawler.Hub.Subscribe(0, false)
+	defer sub.Close()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case v, ok := <-sub.C():
+			if !ok {
+				return nil
+			}
+			r, ok := v.(*MetadataResult)
+			if !ok || (req.Category != "" && r.Category != req.Category) {
+				continue
+			}
+			if err := stream.Send(r.Proto()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *GRPCServer) GetTorrent(ctx context.Context, req *dhtcrawlpb.GetTorrentRequest) (*dhtcrawlpb.Metadata, error) {
+	if s.Crawler.Store == nil {
+		return nil, status.Error(codes.Unavailable, errNoStore.Error())
+	}
+	hash, err := HashFromHex(req.Hash)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "hash must be 40 hex characters")
+	}
+	r, err := s.Crawler.Store.Get(hash)
+	if err == ErrNotFound {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return r.Proto(), nil
+}
+
+func (s *GRPCServer) QueryTorrents(ctx context.Context, req *dhtcrawlpb.QueryRequest) (*dhtcrawlpb.TorrentList, error) {
+	if s.Crawler.Store == nil {
+		return nil, status.Error(codes.Unavailable, errNoStore.Error())
+	}
+	q := TorrentQuery{
+		Category: req.Category,
+		MinSize:  req.MinSize,
+		MaxSize:  req.MaxSize,
+		Offset:   int(req.Offset),
+		Limit:    int(req.Limit),
+	}
+	if req.Since > 0 {
+		q.Since = time.Unix(req.Since, 0)
+	}
+	if req.Until > 0 {
+		q.Until = time.Unix(req.Until, 0)
+	}
+	if q.Offset < 0 || q.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative offset or limit")
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		q.Limit = MaxPageSize
+	}
+	page := q
+	page.Limit++
+	results, err := QueryTorrents(s.Crawler.Store, page)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	list := &dhtcrawlpb.TorrentList{}
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+		list.Next = int32(q.Offset + q.Limit)
+	}
+	for _, r := range results {
+		list.Torrents = append(list.Torrents, r.Proto())
+	}
+	return list, nil
+}
+
+func (s *GRPCServer) GetStats(ctx context.Context, req *dhtcrawlpb.StatsRequest) (*dhtcrawlpb.Stats, error) {
+	return s.Crawler.Stats().Proto(), nil
+}
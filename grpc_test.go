@@ -0,0 +1,56 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	dhtcrawlpb "bitbucket.org/AlanYang/DHTCrawl/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func Test_GRPCStream(t *testing.T) {
+	pool := NewWireJob(1, 16)
+	defer pool.Stop()
+	c := &Crawler{Config: NewDefaultConfig(), Pool: pool, Hub: NewHub()}
+	s := NewGRPCServer(c, "127.0.0.1:0")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Server.Serve(ln)
+	defer s.Server.Stop()
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := dhtcrawlpb.NewCrawlerClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	stream, err := client.StreamMetadata(ctx, &dhtcrawlpb.StreamRequest{Category: CategoryVideo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the subscription exists once the stream is open on the server
+	for c.Hub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond * 10)
+	}
+	c.Hub.Put(&MetadataResult{Hash: testHash("01234567890123456789"), Name: "song", Category: CategoryAudio})
+	c.Hub.Put(&MetadataResult{Hash: testHash("01234567890123456789"), Name: "movie", Category: CategoryVideo})
+	m, err := stream.Recv()
+	if err != nil || m.Name != "movie" {
+		t.Error("Recv", m, err)
+	}
+
+	if _, err := client.GetStats(ctx, &dhtcrawlpb.StatsRequest{}); err != nil {
+		t.Error("GetStats", err)
+	}
+	if _, err := client.GetTorrent(ctx, &dhtcrawlpb.GetTorrentRequest{Hash: "00"}); err == nil {
+		t.Error("GetTorrent without a store")
+	}
+}
@@ -0,0 +1,118 @@
+package DHTCrawl
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Hash is a BitTorrent v1 infohash, the SHA-1 of the info dictionary. It
+	// is comparable and a map key, the zero Hash is no hash.
+	Hash [20]byte
+
+	// HashV2 is a BitTorrent v2 infohash, the SHA-256 of the info
+	// dictionary. The DHT and the peer handshake carry it truncated to a
+	// Hash.
+	HashV2 [32]byte
+)
+
+var errHashLength = errors.New("an infohash is 20 bytes")
+
+// HashFromBytes copies a raw 20 byte infohash, as carried by KRPC packets.
+func HashFromBytes(b []byte) (Hash, error) {
+	var h Hash
+	if len(b) != len(h) {
+		return Hash{}, errHashLength
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// HashFromHex parses a 40 character hex infohash, in either case.
+func HashFromHex(s string) (Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(Hash{}) {
+		return Hash{}, fmt.Errorf("%q is not a 40 character hex infohash", s)
+	}
+	return HashFromBytes(b)
+}
+
+// HashFromBase32 parses a 32 character base32 infohash, as found in old
+// magnet links.
+func HashFromBase32(s string) (Hash, error) {
+	b, err := base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	if err != nil || len(b) != len(Hash{}) {
+		return Hash{}, fmt.Errorf("%q is not a 32 character base32 infohash", s)
+	}
+	return HashFromBytes(b)
+}
+
+func (h Hash) IsZero() bool {
+	return h == Hash{}
+}
+
+// Hex is the upper case hex form the stores and the APIs use.
+func (h Hash) Hex() string {
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
+
+func (h Hash) Base32() string {
+	return base32.StdEncoding.EncodeToString(h[:])
+}
+
+func (h Hash) Magnet() string {
+	return "magnet:?xt=urn:btih:" + h.Hex()
+}
+
+func (h Hash) String() string {
+	return h.Hex()
+}
+
+// MarshalText encodes the hash in hex, in JSON as well.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.Hex()), nil
+}
+
+func (h *Hash) UnmarshalText(text []byte) error {
+	parsed, err := HashFromHex(string(text))
+	if err != nil {
+		return err
+	}
+	*h = parsed
+	return nil
+}
+
+// HashV2FromHex parses a 64 character hex v2 infohash.
+func HashV2FromHex(s string) (HashV2, error) {
+	var h HashV2
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return HashV2{}, fmt.Errorf("%q is not a 64 character hex v2 infohash", s)
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// Truncated is the hash the DHT and the peers know h by.
+func (h HashV2) Truncated() Hash {
+	var t Hash
+	copy(t[:], h[:])
+	return t
+}
+
+func (h HashV2) Hex() string {
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
+
+// Magnet uses the multihash form of a v2 infohash, 0x12 for SHA-256 and
+// 0x20 for its length.
+func (h HashV2) Magnet() string {
+	return "magnet:?xt=urn:btmh:1220" + h.Hex()
+}
+
+func (h HashV2) String() string {
+	return h.Hex()
+}
@@ -0,0 +1,67 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// testHash pads s into a hash, for tests which only need distinct ones.
+func testHash(s string) Hash {
+	var h Hash
+	copy(h[:], s)
+	return h
+}
+
+func Test_Hash(t *testing.T) {
+	const hex = "951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"
+	h, err := HashFromHex("951b8da3ab58b22d759f5fba6d22ffa9e6242ced")
+	if err != nil || h.Hex() != hex || h.String() != hex {
+		t.Fatal(h, err)
+	}
+	if h.Magnet() != "magnet:?xt=urn:btih:"+hex {
+		t.Error(h.Magnet())
+	}
+	b32, err := HashFromBase32(h.Base32())
+	if err != nil || b32 != h || len(h.Base32()) != 32 {
+		t.Error("base32", h.Base32(), err)
+	}
+	raw, err := HashFromBytes(h[:])
+	if err != nil || raw != h {
+		t.Error("bytes", err)
+	}
+	for _, bad := range []string{"", "951B", hex + "00", "Z51B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"} {
+		if _, err := HashFromHex(bad); err == nil {
+			t.Error("parsed", bad)
+		}
+	}
+	if _, err := HashFromBytes([]byte("short")); err == nil {
+		t.Error("parsed 5 bytes")
+	}
+	if !(Hash{}).IsZero() || h.IsZero() {
+		t.Error("IsZero")
+	}
+
+	//hex in JSON, also as a map key
+	data, _ := json.Marshal(map[Hash]Hash{h: h})
+	if string(data) != `{"`+hex+`":"`+hex+`"}` {
+		t.Error(string(data))
+	}
+	decoded := map[Hash]Hash{}
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded[h] != h {
+		t.Error("decode", decoded, err)
+	}
+
+	v2, err := HashV2FromHex("CB20E5AB6C3F8C1BCEE0A1F1E1C1A0D1E1F2A3B4C5D6E7F8091A2B3C4D5E6F70")
+	if err != nil || v2.Truncated().Hex() != "CB20E5AB6C3F8C1BCEE0A1F1E1C1A0D1E1F2A3B4" {
+		t.Error("v2", v2, err)
+	}
+	m, _, err := ParseMagnet(v2.Magnet())
+	if err != nil || m != v2.Truncated() {
+		t.Error("v2 magnet", m, err)
+	}
+	//a hybrid magnet is fetched by its v1 infohash
+	m, _, err = ParseMagnet(v2.Magnet() + "&xt=urn:btih:" + hex)
+	if err != nil || m != h {
+		t.Error("hybrid magnet", m, err)
+	}
+}
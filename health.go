@@ -0,0 +1,117 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+type (
+	// HealthCheck is the outcome of one probe, Error is empty when it passed.
+	HealthCheck struct {
+		Name  string `json:"name"`
+		Error string `json:"error,omitempty"`
+	}
+
+	healthReport struct {
+		Status string        `json:"status"` //ok or fail
+		Checks []HealthCheck `json:"checks"`
+	}
+)
+
+// Live reports whether the crawler is running at all: every node has its
+// UDP socket bound and the workers are started. A failure means the process
+// should be restarted. A store server has nothing to check.
+func (c *Crawler) Live() []HealthCheck {
+	if c.Pool == nil {
+		return []HealthCheck{}
+	}
+	return []HealthCheck{
+		newHealthCheck("socket", c.checkSockets()),
+		newHealthCheck("workers", c.checkWorkers()),
+	}
+}
+
+// Ready adds to Live whether the crawler is useful yet: every routing table
+// has bootstrapped and the store accepts writes.
+func (c *Crawler) Ready() []HealthCheck {
+	if c.Pool == nil {
+		return []HealthCheck{newHealthCheck("store", c.checkStore())}
+	}
+	return append(c.Live(),
+		newHealthCheck("table", c.checkTables()),
+		newHealthCheck("store", c.checkStore()),
+	)
+}
+
+func newHealthCheck(name string, err error) HealthCheck {
+	h := HealthCheck{Name: name}
+	if err != nil {
+		h.Error = err.Error()
+	}
+	return h
+}
+
+func (c *Crawler) checkSockets() error {
+	if len(c.Nodes) == 0 {
+		return errors.New("no DHT node")
+	}
+	for _, node := range c.Nodes {
+		if node.isClosed() || node.Session.Conn == nil {
+			return errors.New("a UDP socket is closed")
+		}
+	}
+	return nil
+}
+
+func (c *Crawler) isRunning() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+func (c *Crawler) checkWorkers() error {
+	if !c.isRunning() {
+		return errors.New("crawler is not running")
+	}
+	if size, _ := c.Pool.Workers(); size == 0 {
+		return errors.New("no fetch worker")
+	}
+	return nil
+}
+
+func (c *Crawler) checkTables() error {
+	for _, node := range c.Nodes {
+		if !node.Table.Bootstrapped() {
+			return fmt.Errorf("routing table of %s has not bootstrapped", node.Session.Conn.LocalAddr())
+		}
+	}
+	return nil
+}
+
+func (c *Crawler) checkStore() error {
+	if w, ok := c.Store.(WritableStore); ok {
+		return w.Writable()
+	}
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, checks []HealthCheck) {
+	report := healthReport{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, h := range checks {
+		if h.Error != "" {
+			report.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, report)
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.Crawler.Live())
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, s.Crawler.Ready())
+}
@@ -0,0 +1,79 @@
+package DHTCrawl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Health(t *testing.T) {
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCrawler(WithPort(0), WithNodes(1), WithWorkers(1), WithBootstraps(), WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(c, "")
+	get := func(url string) (int, healthReport) {
+		w := httptest.NewRecorder()
+		s.Mux.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		report := healthReport{}
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+
+	if code, report := get("/healthz"); code != 503 || report.Status != "fail" {
+		t.Error("before Run", code, report)
+	}
+	go c.Run()
+	for i := 0; i < 100 && !c.isRunning(); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if code, report := get("/healthz"); code != 200 {
+		t.Error("healthz", code, report)
+	}
+	if code, report := get("/readyz"); code != 503 || report.Checks[2].Error == "" {
+		t.Error("readyz without nodes", code, report)
+	}
+	c.Nodes[0].Table.Add(&Node{ID: NewNodeID(), Addr: &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 6881}})
+	if code, report := get("/readyz"); code != 200 {
+		t.Error("readyz", code, report)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Shutdown(ctx)
+	if code, _ := get("/healthz"); code != 503 {
+		t.Error("after Shutdown", code)
+	}
+}
+
+func Test_StoreWritable(t *testing.T) {
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Writable(); err != nil {
+		t.Error(err)
+	}
+
+	// bolt reports the last Put
+	bolt, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bolt.Writable(); err != nil {
+		t.Error("new bolt store", err)
+	}
+	bolt.Close()
+	if bolt.Put(&MetadataResult{Hash: testHash("closed")}) == nil || bolt.Writable() == nil {
+		t.Error("closed bolt store writable")
+	}
+}
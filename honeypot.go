@@ -0,0 +1,169 @@
+package DHTCrawl
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// honeypotRecent bounds the hashes remembered as handed out and the ones
+	// known to the store, each set starts over once it is full.
+	honeypotRecent  = 1 << 16
+	honeypotLookups = 1024 //hashes waiting for a store lookup, the others are skipped
+)
+
+type (
+	// HoneypotConfig makes the nodes answer get_peers with the listener as
+	// a peer of the interesting hashes, the downloaders which ask for them
+	// connect to it: their handshakes are counted by client in
+	// stats.inbound.clients and the metadata is fetched over their
+	// connections. It needs listen.
+	HoneypotConfig struct {
+		IP     string   `json:"ip"`     //handed out, the address of the first interface of the node by default, set it behind a NAT
+		Port   int      `json:"port"`   //handed out instead of the port of listen.addr, behind a port forward
+		Hashes []string `json:"hashes"` //hex infohashes answered for, empty is every hash which isn't stored
+	}
+
+	// HoneypotStats counts the get_peers answered with the listener and
+	// the connections they brought.
+	HoneypotStats struct {
+		Answered  uint64 `json:"answered"`
+		Connected uint64 `json:"connected"` //inbound handshakes for a hash handed out
+	}
+
+	// Honeypot decides which get_peers queries get the listener as a peer.
+	// The nodes share one. Without hashes, the store is asked off the
+	// packet loop by Run: a hash is answered for once the lookup told it
+	// isn't stored, the queries before it go unanswered.
+	Honeypot struct {
+		IP   net.IP //nil hands out the address of the node
+		Port int
+
+		hashes    map[Hash]bool //nil for every hash the store doesn't have
+		store     Store         //nil hands out every hash without hashes
+		mu        sync.Mutex
+		recent    map[Hash]bool
+		stored    map[Hash]bool //answers of the store, the lookups queued are false
+		lookups   chan Hash
+		answered  uint64
+		connected uint64
+	}
+)
+
+// NewHoneypot applies cfg, the port handed out is cfg.Port, or the one of
+// the listen address when it is 0. The hashes which don't parse are
+// skipped, Validate reports them.
+func NewHoneypot(cfg *HoneypotConfig, listen string, store Store) *Honeypot {
+	port := cfg.Port
+	if port == 0 {
+		_, p, _ := net.SplitHostPort(listen)
+		port, _ = strconv.Atoi(p)
+	}
+	h := &Honeypot{
+		IP:      net.ParseIP(cfg.IP),
+		Port:    port,
+		store:   store,
+		recent:  map[Hash]bool{},
+		stored:  map[Hash]bool{},
+		lookups: make(chan Hash, honeypotLookups),
+	}
+	if len(cfg.Hashes) > 0 {
+		h.hashes = make(map[Hash]bool, len(cfg.Hashes))
+		for _, s := range cfg.Hashes {
+			if hash, err := HashFromHex(s); err == nil {
+				h.hashes[hash] = true
+			}
+		}
+	}
+	return h
+}
+
+// Interesting tells whether hash is answered with the listener, it doesn't
+// block: a hash not looked up in the store yet is queued for Run.
+func (h *Honeypot) Interesting(hash Hash) bool {
+	if h.hashes != nil {
+		return h.hashes[hash]
+	}
+	if h.store == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stored, known := h.stored[hash]
+	if known {
+		return !stored
+	}
+	select {
+	case h.lookups <- hash:
+		if len(h.stored) >= honeypotRecent {
+			h.stored = map[Hash]bool{}
+		}
+		// stays stored until the lookup answers
+		h.stored[hash] = true
+	default:
+	}
+	return false
+}
+
+// Run looks the queued hashes up in the store until stop is closed.
+func (h *Honeypot) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case hash := <-h.lookups:
+			has, err := h.store.Has(hash)
+			h.mu.Lock()
+			if err != nil {
+				delete(h.stored, hash)
+			} else {
+				h.stored[hash] = has
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// values returns the compact peers of a get_peers response for hash, nil
+// when it isn't interesting or there is no address to hand out. external
+// is the address of the node.
+func (h *Honeypot) values(hash Hash, external string) []string {
+	if h == nil || !h.Interesting(hash) {
+		return nil
+	}
+	ip := h.IP
+	if ip == nil {
+		ip = net.ParseIP(external)
+	}
+	peer, err := EncodeCompactAddr(ip, h.Port)
+	if err != nil {
+		return nil
+	}
+	h.mu.Lock()
+	if len(h.recent) >= honeypotRecent {
+		h.recent = map[Hash]bool{}
+	}
+	h.recent[hash] = true
+	h.mu.Unlock()
+	atomic.AddUint64(&h.answered, 1)
+	return []string{string(peer)}
+}
+
+// Connected counts the inbound handshake of hash when it was handed out.
+func (h *Honeypot) Connected(hash Hash) {
+	h.mu.Lock()
+	handed := h.recent[hash]
+	h.mu.Unlock()
+	if handed {
+		atomic.AddUint64(&h.connected, 1)
+	}
+}
+
+func (h *Honeypot) Stats() HoneypotStats {
+	return HoneypotStats{
+		Answered:  atomic.LoadUint64(&h.answered),
+		Connected: atomic.LoadUint64(&h.connected),
+	}
+}
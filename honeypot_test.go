@@ -0,0 +1,72 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Honeypot(t *testing.T) {
+	listed, other := testHash("honey"), testHash("other")
+	cfg := NewDefaultConfig()
+	cfg.Listen = &PeerListenerConfig{Addr: "127.0.0.1:0"}
+	cfg.Honeypot = &HoneypotConfig{IP: "127.0.0.1", Port: 6999, Hashes: []string{listed.Hex()}}
+	c, err := NewCrawler(WithConfig(cfg), WithPort(0), WithNodes(1), WithWorkers(1), WithBootstraps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run()
+	defer c.Shutdown(context.Background())
+
+	entries := []string{c.Nodes[0].Session.Conn.LocalAddr().String()}
+	lookup := func(hash Hash) []*net.TCPAddr {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		peers, err := LookupPeers(ctx, hash, entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*net.TCPAddr
+		for p := range peers {
+			got = append(got, p)
+		}
+		return got
+	}
+	if got := lookup(listed); len(got) != 1 || got[0].String() != "127.0.0.1:6999" {
+		t.Error("peers of the listed hash", got)
+	}
+	if got := lookup(other); len(got) != 0 {
+		t.Error("peers of another hash", got)
+	}
+	c.Honeypot.Connected(listed)
+	c.Honeypot.Connected(other)
+	if st := c.Stats().Honeypot; st == nil || st.Answered != 1 || st.Connected != 1 {
+		t.Errorf("%+v", st)
+	}
+
+	// without hashes, the ones which aren't stored
+	store, err := OpenBoltStore(filepath.Join(t.TempDir(), "honeypot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.Put(&MetadataResult{Hash: listed, Name: "stored"})
+	h := NewHoneypot(&HoneypotConfig{}, ":6881", store)
+	if h.Interesting(listed) || h.Interesting(other) || h.Port != 6881 {
+		t.Error("answered before the store", h.Port)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go h.Run(stop)
+	for i := 0; i < 100 && !h.Interesting(other); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h.Interesting(listed) || !h.Interesting(other) {
+		t.Error("interesting", h.Interesting(listed), h.Interesting(other))
+	}
+	if h.values(other, "") != nil || len(h.values(other, "10.0.0.1")) != 1 {
+		t.Error("values without an address")
+	}
+}
@@ -2,7 +2,7 @@ package DHTCrawl
 
 import (
 	"fmt"
-	"github.com/zeebo/bencode"
+	"io"
 	"net/http"
 	"time"
 )
@@ -32,12 +32,21 @@ func HttpDownload(hash Hash) (*MetadataResult, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	dec := bencode.NewDecoder(resp.Body)
-	metadata := new(MetaData)
-	err = dec.Decode(metadata)
+	return decodeTorrentFile(resp.Body, hash)
+}
+
+// decodeTorrentFile decodes the info dictionary of a .torrent file and keeps
+// its raw bytes in Info. The result has hash, Verify tells whether the file
+// was the one asked for.
+func decodeTorrentFile(r io.Reader, hash Hash) (*MetadataResult, error) {
+	torrent, err := ReadTorrent(r)
+	if err != nil {
+		return nil, err
+	}
+	result, err := torrent.Metadata()
 	if err != nil {
 		return nil, err
 	}
-	metadata.MetaInfo.Hash = hash
-	return metadata.MetaInfo, nil
+	result.Hash = hash
+	return result, nil
 }
@@ -0,0 +1,215 @@
+package DHTCrawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTrackerRetryWait = time.Second //before the first retry of a failed HTTP request, doubled by the next ones
+
+	httpScrapeBatch    = 50 //hashes of an HTTP scrape, a longer URL is refused by some trackers
+	maxTrackerResponse = 1 << 20
+)
+
+// errNoScrape is returned by the trackers whose announce URL has no scrape
+// counterpart (BEP 48).
+var errNoScrape = errors.New("tracker has no scrape URL")
+
+type (
+	// HTTPTracker scrapes and announces to an HTTP or HTTPS tracker. A
+	// request failing on the network or with a 5xx status is retried.
+	HTTPTracker struct {
+		URL       string //of the announces
+		Client    *http.Client
+		Timeout   time.Duration //of the first attempt, doubled by every retry
+		Retries   int
+		RetryWait time.Duration
+		Limiter   *Limiter //of the requests
+	}
+
+	httpScrape struct {
+		Files   map[string]httpScrapeFile `bencode:"files"`
+		Failure string                    `bencode:"failure reason"`
+	}
+
+	httpScrapeFile struct {
+		Complete   int `bencode:"complete"`
+		Downloaded int `bencode:"downloaded"`
+		Incomplete int `bencode:"incomplete"`
+	}
+
+	// httpAnnounce has the peers in the compact form, or as a list of
+	// dictionaries from the trackers which ignore compact=1.
+	httpAnnounce struct {
+		Failure string      `bencode:"failure reason"`
+		Peers   interface{} `bencode:"peers"`
+		Peers6  string      `bencode:"peers6"`
+	}
+)
+
+// NewHTTPTracker returns the client of an http:// or https:// announce URL.
+func NewHTTPTracker(rawurl string) (*HTTPTracker, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http:// or https:// tracker", rawurl)
+	}
+	return &HTTPTracker{
+		URL:       rawurl,
+		Client:    http.DefaultClient,
+		Timeout:   DefaultTrackerTimeout,
+		Retries:   DefaultTrackerRetries,
+		RetryWait: DefaultTrackerRetryWait,
+		Limiter:   NewLimiter(0, 0),
+	}, nil
+}
+
+func (t *HTTPTracker) String() string {
+	return t.URL
+}
+
+// scrapeURL replaces the announce of the last path element by scrape.
+func (t *HTTPTracker) scrapeURL() (string, error) {
+	i := strings.LastIndex(t.URL, "/")
+	if i < 0 || !strings.HasPrefix(t.URL[i+1:], "announce") {
+		return "", errNoScrape
+	}
+	return t.URL[:i+1] + "scrape" + t.URL[i+1+len("announce"):], nil
+}
+
+// Scrape asks for the swarms of hashes, in batches.
+func (t *HTTPTracker) Scrape(ctx context.Context, hashes []Hash) (map[Hash]*ScrapeResult, error) {
+	base, err := t.scrapeURL()
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[Hash]*ScrapeResult, len(hashes))
+	for len(hashes) > 0 {
+		batch := hashes[:min(len(hashes), httpScrapeBatch)]
+		hashes = hashes[len(batch):]
+		if !t.Limiter.Allow() {
+			return results, ErrTrackerRateLimited
+		}
+		query := make([]string, len(batch))
+		for i, h := range batch {
+			query[i] = "info_hash=" + url.QueryEscape(string(h[:]))
+		}
+		var resp httpScrape
+		if err := t.get(ctx, withQuery(base, strings.Join(query, "&")), &resp); err != nil {
+			return results, err
+		}
+		if resp.Failure != "" {
+			return results, &TrackerError{Tracker: t.URL, Message: resp.Failure}
+		}
+		for _, h := range batch {
+			f := resp.Files[string(h[:])]
+			results[h] = &ScrapeResult{Seeders: f.Complete, Completed: f.Downloaded, Leechers: f.Incomplete}
+		}
+	}
+	return results, nil
+}
+
+// Announce asks for TrackerNumWant peers of hash in the compact form.
+func (t *HTTPTracker) Announce(ctx context.Context, hash Hash, port int) ([]*net.TCPAddr, error) {
+	if !t.Limiter.Allow() {
+		return nil, ErrTrackerRateLimited
+	}
+	v := url.Values{
+		"info_hash":  {string(hash[:])},
+		"peer_id":    {trackerPeerID},
+		"port":       {strconv.Itoa(port)},
+		"uploaded":   {"0"},
+		"downloaded": {"0"},
+		"left":       {"1"},
+		"compact":    {"1"},
+		"numwant":    {strconv.Itoa(TrackerNumWant)},
+	}
+	var resp httpAnnounce
+	if err := t.get(ctx, withQuery(t.URL, v.Encode()), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Failure != "" {
+		return nil, &TrackerError{Tracker: t.URL, Message: resp.Failure}
+	}
+	var peers []*net.TCPAddr
+	switch p := resp.Peers.(type) {
+	case string:
+		peers = decodeCompactPeers([]byte(p), CompactPeerLen)
+	case []interface{}:
+		for _, v := range p {
+			d, _ := v.(map[string]interface{})
+			ip, _ := d["ip"].(string)
+			port, _ := d["port"].(int64)
+			if addr := net.ParseIP(ip); addr != nil && IsValidPort(int(port)) {
+				peers = append(peers, &net.TCPAddr{IP: addr, Port: int(port)})
+			}
+		}
+	}
+	return append(peers, decodeCompactPeers([]byte(resp.Peers6), CompactPeer6Len)...), nil
+}
+
+// withQuery appends query to the URL, which may have a query already.
+func withQuery(u, query string) string {
+	if strings.Contains(u, "?") {
+		return u + "&" + query
+	}
+	return u + "?" + query
+}
+
+// get decodes the bencoded answer to u into v, retrying the network errors
+// and the 5xx statuses after a wait doubled every time.
+func (t *HTTPTracker) get(ctx context.Context, u string, v interface{}) error {
+	var err error
+	for attempt := 0; attempt <= t.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(t.RetryWait << uint(attempt-1)):
+			}
+		}
+		var body []byte
+		var retry bool
+		if body, retry, err = t.fetch(ctx, u, t.Timeout<<uint(attempt)); err == nil {
+			return decodeBencode(body, v, DefaultMaxBencodeItems)
+		}
+		if !retry || ctx.Err() != nil {
+			break
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// fetch does one request, it reports whether a failure is worth a retry.
+func (t *HTTPTracker) fetch(ctx context.Context, u string, timeout time.Duration) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode >= 500, fmt.Errorf("tracker %s: %s", t.URL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTrackerResponse))
+	return body, err != nil, err
+}
@@ -0,0 +1,114 @@
+package DHTCrawl
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultSubscriptionSize = 256
+
+type (
+	// Hub fans the results, and the announces for subscribers which ask for
+	// them, out to live subscribers. It is a sink, the crawler feeds it like
+	// any other, and publishes what it is fed on a Bus of its own: every
+	// subscriber is a BusSubscription of it, whose queue drops the oldest
+	// events when it falls behind, so it never slows the pipeline down.
+	Hub struct {
+		bus *Bus
+
+		mu   sync.Mutex
+		subs map[*Subscription]struct{}
+	}
+
+	// Subscription receives *MetadataResult and, with announces, *Announce
+	// values from C until it is closed.
+	Subscription struct {
+		*BusSubscription
+		announces atomic.Bool
+		hub       *Hub
+
+		droppedResults   uint64
+		droppedAnnounces uint64
+	}
+)
+
+func NewHub() *Hub {
+	return &Hub{bus: NewBus(), subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a subscriber with a queue of size events.
+func (h *Hub) Subscribe(size int, announces bool) *Subscription {
+	if size <= 0 {
+		size = DefaultSubscriptionSize
+	}
+	s := &Subscription{hub: h}
+	s.announces.Store(announces)
+	q := NewQueue("subscriber", size, QueueDropOldest)
+	q.OnDrop = func(v interface{}) {
+		if _, ok := v.(*Announce); ok {
+			atomic.AddUint64(&s.droppedAnnounces, 1)
+		} else {
+			atomic.AddUint64(&s.droppedResults, 1)
+		}
+	}
+	s.BusSubscription = h.bus.subscribe(q, func(ev interface{}) bool {
+		_, announce := ev.(*Announce)
+		return !announce || s.announces.Load()
+	})
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Subscribers returns how many subscriptions are open.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+func (h *Hub) Put(r *MetadataResult) error {
+	h.bus.Publish(r)
+	return nil
+}
+
+func (h *Hub) PutAnnounce(a *Announce) error {
+	h.bus.Publish(a)
+	return nil
+}
+
+// Close ends every subscription.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	subs := h.subs
+	h.subs = make(map[*Subscription]struct{})
+	h.mu.Unlock()
+	for s := range subs {
+		s.BusSubscription.Close()
+	}
+	return nil
+}
+
+// DroppedResults returns the results among Dropped.
+func (s *Subscription) DroppedResults() uint64 {
+	return atomic.LoadUint64(&s.droppedResults)
+}
+
+// DroppedAnnounces returns the announces among Dropped.
+func (s *Subscription) DroppedAnnounces() uint64 {
+	return atomic.LoadUint64(&s.droppedAnnounces)
+}
+
+// SetAnnounces turns the announces of the subscription on or off, the ones
+// already queued are still received.
+func (s *Subscription) SetAnnounces(on bool) {
+	s.announces.Store(on)
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+	s.BusSubscription.Close()
+}
@@ -0,0 +1,98 @@
+package DHTCrawl
+
+import (
+	"crypto/sha1"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	infoCacheExt = ".info"
+	cacheServed  = 100000 //hashes a pool remembers having delivered from the cache
+)
+
+// ErrInfoMismatch is returned by InfoCache.Put for an info dictionary which
+// doesn't hash to its infohash.
+var ErrInfoMismatch = errors.New("info dictionary doesn't match the infohash")
+
+// InfoCache keeps the raw info dictionaries of the fetched torrents on disk,
+// one file per infohash under a directory of its first byte. The pool writes
+// them before any sink sees the results, a hash the sinks lost is rebuilt
+// from the cache instead of being downloaded again.
+type InfoCache struct {
+	Dir string
+}
+
+// OpenInfoCache creates dir when it is missing.
+func OpenInfoCache(dir string) (*InfoCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &InfoCache{Dir: dir}, nil
+}
+
+func (c *InfoCache) path(hash Hash) string {
+	hex := hash.Hex()
+	return filepath.Join(c.Dir, hex[:2], hex+infoCacheExt)
+}
+
+// Put writes info unless hash is cached already, the file appears whole or
+// not at all.
+func (c *InfoCache) Put(hash Hash, info []byte) error {
+	if Hash(sha1.Sum(info)) != hash {
+		return ErrInfoMismatch
+	}
+	p := c.path(hash)
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(info); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// Get returns the info dictionary of hash, an error wrapping fs.ErrNotExist
+// when it isn't cached.
+func (c *InfoCache) Get(hash Hash) ([]byte, error) {
+	return os.ReadFile(c.path(hash))
+}
+
+// Metadata decodes the cached info dictionary of hash.
+func (c *InfoCache) Metadata(hash Hash) (*MetadataResult, error) {
+	info, err := c.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	return (&TorrentFile{Hash: hash, Info: info}).Metadata()
+}
+
+// Each calls fn with the hash of every cached info dictionary until fn
+// returns an error, which Each returns.
+func (c *InfoCache) Each(fn func(hash Hash) error) error {
+	return filepath.WalkDir(c.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, infoCacheExt) {
+			return err
+		}
+		hash, err := HashFromHex(strings.TrimSuffix(d.Name(), infoCacheExt))
+		if err != nil {
+			return nil
+		}
+		return fn(hash)
+	})
+}
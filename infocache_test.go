@@ -0,0 +1,64 @@
+package DHTCrawl
+
+import (
+	"crypto/sha1"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+func Test_InfoCache(t *testing.T) {
+	c, err := OpenInfoCache(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "cached.iso", "length": 10, "piece length": 16384, "pieces": ""})
+	hash := Hash(sha1.Sum(info))
+	if err := c.Put(testHash("other"), info); err != ErrInfoMismatch {
+		t.Error("mismatched info cached", err)
+	}
+	if err := c.Put(hash, info); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(hash, info); err != nil {
+		t.Error("second put", err)
+	}
+	if _, err := c.Get(testHash("missing")); err == nil {
+		t.Error("missing hash read")
+	}
+	var hashes []Hash
+	c.Each(func(h Hash) error {
+		hashes = append(hashes, h)
+		return nil
+	})
+	if len(hashes) != 1 || hashes[0] != hash {
+		t.Error("cached hashes", hashes)
+	}
+
+	// a cached hash is never downloaded, its peer doesn't listen
+	pool := NewWireJob(1, 0)
+	defer pool.Stop()
+	pool.Cache = c
+	pool.Add(NewJob(hash, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}))
+	select {
+	case v := <-pool.Results.C():
+		if r := v.(*MetadataResult); r.Name != "cached.iso" || pool.Stats().Attempts != 0 {
+			t.Errorf("%s after %d attempts", r.Name, pool.Stats().Attempts)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not served from the cache")
+	}
+	// the next announce delivers nothing and downloads nothing
+	pool.Add(NewJob(hash, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 1}))
+	select {
+	case v := <-pool.Results.C():
+		t.Error("delivered twice", v.(*MetadataResult).Name)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if n := pool.Stats().Attempts; n != 0 {
+		t.Error("attempts", n)
+	}
+}
@@ -1,25 +1,66 @@
 package DHTCrawl
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type (
 	Job struct {
-		Hash Hash
-		Addr *net.TCPAddr
+		Hash  Hash
+		Addr  *net.TCPAddr
+		peers []*net.TCPAddr
+		done  bool
+		retry bool                //queued by the Refetcher, not a fresh announce
+		conns map[string]net.Conn //inbound connections of candidates, by address
+		mu    *sync.Mutex
 	}
 	WireJob struct {
-		Size       int
-		jobChan    chan *Job
+		Size      int
+		Announces *Queue
+		Jobs      *Queue
+		Results   *Queue
+		Limiter   *Limiter //caps how many new hashes per second enter the fetch queue
+		Peers     *PeerStore
+		Refetch   *Refetcher
+		Geo       *GeoIP     //tags the announces and the sources of the results, nil leaves them untagged
+		Cache     *InfoCache //raw info dictionaries of the results, written before they are stored; nil caches nothing
+		// Events carries the announces and the fetches of the pool as
+		// AnnounceReceived, FetchStarted and FetchFailed.
+		Events *Bus
+		// OnAnnounce is called by the dedup stage with every announce, including
+		// the ones attached to a running download.
+		OnAnnounce func(*Announce)
 		resultChan chan *MetadataResult
-		Result     chan *MetadataResult
-		stopChan   chan int
 		worker     []*Wire
-		started    *Set
-		jobsQueue  *Set
+		retired    []*Wire //shrunk away but possibly still downloading
+		limited    uint64
+		filtered   uint64
+		shed       uint64 //new hashes dropped while shedding
+		shedding   int32
+		succeeded  uint64
+		failed     uint64
+		filters    filterSlot
+		inflight   map[Hash]*Job //queued or downloading, keyed by hash
+		served     map[Hash]bool //delivered from Cache
+		timeouts   wireTimeouts
+		limits     wireLimits
+		counters   fetchCounters
+		capture    captureSlot
+		scores     scoreSlot
+		bandwidth  bandwidthSlot
+		partials   partialSlot
+		mu         *sync.Mutex
+
+		intake  *sync.RWMutex //guards closed against Announces.Push
+		closed  bool
+		deduped chan struct{}
+		handled chan struct{}
 	}
 
 	Set struct {
@@ -74,66 +115,581 @@ func (s *Set) Delete(v interface{}) {
 }
 
 func NewJob(hash Hash, addr *net.TCPAddr) *Job {
-	return &Job{hash, addr}
+	return &Job{Hash: hash, Addr: addr, mu: new(sync.Mutex)}
+}
+
+// AddPeer attaches another peer announcing the same hash as a download candidate,
+// it returns false when the job has already given up on its candidates
+func (j *Job) AddPeer(addr *net.TCPAddr) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.done {
+		return false
+	}
+	if addr == nil {
+		return true
+	}
+	if j.Addr != nil && j.Addr.String() == addr.String() {
+		return true
+	}
+	for _, p := range j.peers {
+		if p.String() == addr.String() {
+			return true
+		}
+	}
+	j.peers = append(j.peers, addr)
+	return true
+}
+
+// AddConn attaches the inbound connection of a peer whose handshake asked
+// for the hash, it is the next candidate tried. It returns false when the
+// job has already given up on its candidates.
+func (j *Job) AddConn(conn net.Conn) bool {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.done || !ok {
+		return false
+	}
+	if j.conns == nil {
+		j.conns = map[string]net.Conn{}
+	}
+	j.conns[addr.String()] = conn
+	j.peers = append([]*net.TCPAddr{addr}, j.peers...)
+	return true
+}
+
+// takeConn returns the inbound connection of a candidate, nil when it has to
+// be dialed.
+func (j *Job) takeConn(addr *net.TCPAddr) net.Conn {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	conn := j.conns[addr.String()]
+	delete(j.conns, addr.String())
+	return conn
+}
+
+// handOver gives the inbound connections of a job merged into running to
+// it, they are closed when it is already done.
+func (j *Job) handOver(running *Job) {
+	j.mu.Lock()
+	conns := j.conns
+	j.conns = nil
+	j.mu.Unlock()
+	for _, conn := range conns {
+		if !running.AddConn(conn) {
+			conn.Close()
+		}
+	}
+}
+
+// NextPeer pops the next candidate peer, nil means all candidates were tried
+func (j *Job) NextPeer() (addr *net.TCPAddr) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.peers) == 0 {
+		j.done = true
+		return nil
+	}
+	addr, j.peers = j.peers[0], j.peers[1:]
+	return
+}
+
+// nextPeer is NextPeer taking the candidate of the best score first, in
+// order between equal scores. The banned candidates are dropped.
+func (j *Job) nextPeer(scores *PeerScores) *net.TCPAddr {
+	if scores == nil {
+		return j.NextPeer()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	kept := j.peers[:0]
+	for _, p := range j.peers {
+		if scores.Banned(p) {
+			scores.skip()
+			continue
+		}
+		kept = append(kept, p)
+	}
+	j.peers = kept
+	best, score := -1, 0.0
+	for i, p := range j.peers {
+		if s := scores.Score(p); best < 0 || s > score {
+			best, score = i, s
+		}
+	}
+	if best < 0 {
+		j.done = true
+		return nil
+	}
+	addr := j.peers[best]
+	j.peers = append(j.peers[:best], j.peers[best+1:]...)
+	return addr
+}
+
+// Finish marks the job as over, later announces for the hash start a new job
+func (j *Job) Finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.done = true
+	for _, conn := range j.conns {
+		conn.Close()
+	}
+	j.conns = nil
+}
+
+func (j *Job) isDone() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.done
 }
 
-func NewWireJob(size int) *WireJob {
+// Peers returns the candidates which have not been tried yet
+func (j *Job) Peers() []*net.TCPAddr {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]*net.TCPAddr{}, j.peers...)
+}
+
+// NewWireJob starts size workers fed by a three stage pipeline:
+//
+//	Announces (discovery -> dedup): drop newest, UDP handling must never block
+//	Jobs      (dedup -> fetch):     drop oldest, fresh announces have live peers
+//	Results   (fetch -> store):     block, a slow store stalls the workers and
+//	                                the overflow is shed by the Jobs queue
+//
+// queueSize bounds every stage, zero means DefaultQueueSize.
+func NewWireJob(size, queueSize int) *WireJob {
 	wj := &WireJob{
 		Size:       size,
-		Result:     make(chan *MetadataResult),
+		Announces:  NewQueue("announce", queueSize, QueueDropNewest),
+		Jobs:       NewQueue("fetch", queueSize, QueueDropOldest),
+		Results:    NewQueue("store", queueSize, QueueBlock),
+		Limiter:    NewLimiter(0, 0),
+		Peers:      NewPeerStore(0, 0),
+		Events:     NewBus(),
 		resultChan: make(chan *MetadataResult),
-		jobChan:    make(chan *Job),
 		worker:     []*Wire{},
-		started:    NewSet(),
-		jobsQueue:  NewSet(),
-	}
-	for i := 0; i < size; i++ {
-		wire := NewWire(wj.resultChan)
-		wj.worker = append(wj.worker, wire)
+		inflight:   make(map[Hash]*Job),
+		served:     make(map[Hash]bool),
+		mu:         new(sync.Mutex),
+		intake:     new(sync.RWMutex),
+		deduped:    make(chan struct{}),
+		handled:    make(chan struct{}),
 	}
+	wj.Jobs.OnDrop = wj.evict
+	wj.Refetch = NewRefetcher(wj, wj.Peers)
+	wj.Resize(size)
 	go func() {
-		for {
-			wj.handleResult(<-wj.resultChan)
+		defer close(wj.handled)
+		for r := range wj.resultChan {
+			protect("result", func() { wj.handleResult(r) })
 		}
+		wj.Results.Close()
 	}()
 	go func() {
-		for {
-			wj.addJob(<-wj.jobChan)
+		defer close(wj.deduped)
+		for v := range wj.Announces.C() {
+			wj.addJob(v.(*Job))
 		}
 	}()
 	return wj
 }
 
 func (j *WireJob) handleResult(r *MetadataResult) {
-	j.started.Delete(r.Hash)
+	j.mu.Lock()
+	// a finished job may already have been replaced by a newer one for the same hash
+	if job, ok := j.inflight[r.Hash]; ok && job.isDone() {
+		delete(j.inflight, r.Hash)
+	}
+	j.mu.Unlock()
+
 	if r.Name != "" {
-		j.Result <- r
+		atomic.AddUint64(&j.succeeded, 1)
+		metricFetches.WithLabelValues("success").Inc()
+	} else {
+		atomic.AddUint64(&j.failed, 1)
+		metricFetches.WithLabelValues("failure").Inc()
 	}
-	if v := j.jobsQueue.Pop(); v != nil {
-		if job, ok := v.(*Job); ok {
-			j.addJob(job)
+	if j.Refetch != nil {
+		j.Refetch.observe(r)
+	}
+	if r.Name != "" {
+		if j.Cache != nil && len(r.Info) > 0 {
+			if err := j.Cache.Put(r.Hash, r.Info); errors.Is(err, ErrInfoMismatch) {
+				logPipeline.Debug("info dictionary not cached", "infohash", r.Hash, "error", err)
+			} else if err != nil {
+				logPipeline.Warn("caching the info dictionary failed", "infohash", r.Hash, "error", err)
+			}
+		}
+		r.Hex = r.Hash.Hex()
+		if r.Create == "" {
+			r.Create = time.Now().Format(time.RFC3339)
 		}
+		r.Categorize()
+		if j.Peers != nil {
+			r.Peers = j.Peers.Announces(r.Hash)
+		}
+		if host, _, err := net.SplitHostPort(r.Source); err == nil {
+			r.SourceGeo = j.Geo.Lookup(net.ParseIP(host))
+		}
+		j.Results.Push(r)
 	}
 }
 
 func (j *WireJob) addJob(job *Job) {
-	if !j.started.Has(job.Hash) {
-		for _, w := range j.worker {
-			if w.IsIdle() {
-				j.started.Set(job.Hash)
-				w.Job <- job
-				return
+	if !job.retry {
+		metricAnnounces.Inc()
+		j.Peers.Add(job.Hash, job.Addr)
+		a := &Announce{Hash: job.Hash, Peer: job.Addr, Time: time.Now()}
+		if job.Addr != nil {
+			a.Geo = j.Geo.Lookup(job.Addr.IP)
+		}
+		if j.OnAnnounce != nil {
+			j.OnAnnounce(a)
+		}
+		j.Events.Publish(&AnnounceReceived{Announce: a})
+	}
+	j.mu.Lock()
+	if running, ok := j.inflight[job.Hash]; ok && running.AddPeer(job.Addr) {
+		// the hash is already queued or downloading, the peer becomes one more candidate
+		j.mu.Unlock()
+		job.handOver(running)
+		return
+	}
+	j.mu.Unlock()
+
+	if !job.retry && atomic.LoadInt32(&j.shedding) == 1 {
+		atomic.AddUint64(&j.shed, 1)
+		metricShed.WithLabelValues("announce").Inc()
+		logPipeline.Debug("hash shed", "infohash", job.Hash, "peer", job.Addr)
+		job.Finish()
+		return
+	}
+	// filters may call out to external services, keep them outside the lock
+	if !j.filters.get().AllowHash(job.Hash, job.Addr) {
+		atomic.AddUint64(&j.filtered, 1)
+		logPipeline.Debug("hash filtered", "infohash", job.Hash, "peer", job.Addr)
+		j.rejectRetry(job)
+		job.Finish()
+		return
+	}
+	if j.Cache != nil {
+		if r, err := j.Cache.Metadata(job.Hash); err == nil {
+			// fetched before: nothing to download, the sinks get it once
+			job.Finish()
+			if j.serveCached(job.Hash) {
+				j.resultChan <- r
 			}
+			return
 		}
-		fmt.Printf("Not has idle worker %s[%s]\n", job.Hash.Hex(), job.Addr.String())
-		j.jobsQueue.Set(job)
+	}
+	if !j.Limiter.Allow() {
+		atomic.AddUint64(&j.limited, 1)
+		logPipeline.Debug("hash rate limited", "infohash", job.Hash, "peer", job.Addr)
+		j.rejectRetry(job)
+		job.Finish()
+		return
+	}
+	j.mu.Lock()
+	j.inflight[job.Hash] = job
+	j.mu.Unlock()
+	j.Jobs.Push(job)
+}
+
+// serveCached tells whether the cached result of hash is to be delivered,
+// only the first announce of a hash delivers it. The hashes served are
+// forgotten together past cacheServed of them.
+func (j *WireJob) serveCached(hash Hash) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.served[hash] {
+		return false
+	}
+	if len(j.served) >= cacheServed {
+		j.served = make(map[Hash]bool)
+	}
+	j.served[hash] = true
+	return true
+}
+
+// rejectRetry counts a retry which won't run as an attempt of the Refetcher.
+func (j *WireJob) rejectRetry(job *Job) {
+	if job.retry && j.Refetch != nil {
+		j.Refetch.rejected(job.Hash)
 	}
 }
 
+// SetFilters replaces the filter chain new hashes have to pass.
+func (j *WireJob) SetFilters(fs ...Filter) {
+	j.filters.set(fs)
+}
+
+// Filtered returns how many new hashes the filters turned away.
+func (j *WireJob) Filtered() uint64 {
+	return atomic.LoadUint64(&j.filtered)
+}
+
+// Resize grows or shrinks the worker pool to size, retired workers finish
+// their current download first.
+func (j *WireJob) Resize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for len(j.worker) < size {
+		j.worker = append(j.worker, newWire(j.Jobs, j.resultChan, j))
+	}
+	for len(j.worker) > size {
+		w := j.worker[len(j.worker)-1]
+		j.worker = j.worker[:len(j.worker)-1]
+		w.Retire()
+		j.retired = append(j.retired, w)
+		go j.forget(w)
+	}
+	j.Size = size
+}
+
+// forget drops a retired wire from the pool once its loop exited.
+func (j *WireJob) forget(w *Wire) {
+	<-w.stopped
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i, r := range j.retired {
+		if r == w {
+			j.retired = append(j.retired[:i], j.retired[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetTimeouts changes how long the workers wait for a peer to accept the
+// connection and for the whole metadata download, zero keeps the default.
+func (j *WireJob) SetTimeouts(connect, fetch time.Duration) {
+	j.timeouts.set(connect, fetch)
+}
+
+// SetLimits bounds the size of a peer wire message, of the metadata and
+// the number of values of a bencoded message, zero keeps the default. A
+// peer breaking one is disconnected with FailTooLarge.
+func (j *WireJob) SetLimits(message, metadata, items int) {
+	j.limits.set(message, metadata, items)
+}
+
+// SetCapture records the peer connections to c from now on, nil stops
+// recording.
+func (j *WireJob) SetCapture(c *Capture) {
+	j.capture.set(c)
+}
+
+// SetScores orders the candidates of the jobs by s and skips the banned
+// ones from now on, nil tries them in order.
+func (j *WireJob) SetScores(s *PeerScores) {
+	j.scores.set(s)
+}
+
+// Scores returns the scores of SetScores, nil without or on a nil pool.
+func (j *WireJob) Scores() *PeerScores {
+	if j == nil {
+		return nil
+	}
+	return j.scores.get()
+}
+
+// SetBandwidth counts the traffic of the downloads and of the inbound
+// connections in b from now on, nil stops counting.
+func (j *WireJob) SetBandwidth(b *Bandwidth) {
+	j.bandwidth.set(b)
+}
+
+// SetPartials keeps the pieces of the failed downloads in s from now on,
+// the next attempt on their hash only requests the missing ones. nil starts
+// every attempt from scratch.
+func (j *WireJob) SetPartials(s *PartialStore) {
+	j.partials.set(s)
+}
+
+// Partials returns the store of SetPartials, nil without or on a nil pool.
+func (j *WireJob) Partials() *PartialStore {
+	if j == nil {
+		return nil
+	}
+	return j.partials.get()
+}
+
+// Workers returns the current pool size and how many workers are busy.
+func (j *WireJob) Workers() (size, busy int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, w := range j.worker {
+		if !w.IsIdle() {
+			busy++
+		}
+	}
+	return len(j.worker), busy
+}
+
+// Fetched returns how many downloads succeeded and failed so far.
+func (j *WireJob) Fetched() (succeeded, failed uint64) {
+	return atomic.LoadUint64(&j.succeeded), atomic.LoadUint64(&j.failed)
+}
+
+// Stats returns the counters of the downloads from peers, a job tries
+// one or more peers.
+func (j *WireJob) Stats() FetchStats {
+	return j.counters.stats()
+}
+
+// SetShedding turns the dropping of the new hashes on or off, the peers of
+// the hashes in flight and the retries are still taken.
+func (j *WireJob) SetShedding(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&j.shedding, v)
+}
+
+// Shed returns how many new hashes were dropped while shedding.
+func (j *WireJob) Shed() uint64 {
+	return atomic.LoadUint64(&j.shed)
+}
+
+// Limited returns how many new hashes the rate limiter turned away.
+func (j *WireJob) Limited() uint64 {
+	return atomic.LoadUint64(&j.limited)
+}
+
+// evict forgets a job the fetch queue had to drop, so the hash can be announced again
+func (j *WireJob) evict(v interface{}) {
+	job, ok := v.(*Job)
+	if !ok {
+		return
+	}
+	job.Finish()
+	logPipeline.Debug("fetch queue full, job dropped", "infohash", job.Hash)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.inflight[job.Hash] == job {
+		delete(j.inflight, job.Hash)
+	}
+}
+
+// InFlight returns the number of hashes queued or downloading
+func (j *WireJob) InFlight() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.inflight)
+}
+
+// Pending returns the jobs queued or downloading, they keep running.
+func (j *WireJob) Pending() []*Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	jobs := make([]*Job, 0, len(j.inflight))
+	for _, job := range j.inflight {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// Queues returns the stage queues in pipeline order
+func (j *WireJob) Queues() []*Queue {
+	return []*Queue{j.Announces, j.Jobs, j.Results}
+}
+
+// Stop closes the pool without waiting for running downloads.
 func (j *WireJob) Stop() {
-	j.stopChan <- 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	j.Close(ctx)
+}
+
+// Close stops accepting announces and lets the running downloads finish until
+// ctx is done. Queued jobs which never started and downloads which missed
+// the deadline are returned so the caller can persist them. Results is closed
+// once every worker has exited.
+func (j *WireJob) Close(ctx context.Context) ([]*Job, error) {
+	j.intake.Lock()
+	if j.closed {
+		j.intake.Unlock()
+		return nil, nil
+	}
+	j.closed = true
+	j.Announces.Close()
+	j.intake.Unlock()
+	<-j.deduped
+
+	// nothing pushes to Jobs any more, whatever is left was never started
+	unfinished := []*Job{}
+	queued := map[Hash]bool{}
+	for drained := false; !drained; {
+		select {
+		case v := <-j.Jobs.C():
+			unfinished = append(unfinished, v.(*Job))
+			queued[v.(*Job).Hash] = true
+		default:
+			drained = true
+		}
+	}
+	j.Jobs.Close()
+
+	var err error
+	j.mu.Lock()
+	workers := append(append([]*Wire{}, j.worker...), j.retired...)
+	j.mu.Unlock()
+	for _, w := range workers {
+		select {
+		case <-w.stopped:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		close(j.resultChan)
+		<-j.handled
+	}
+
+	j.mu.Lock()
+	for hash, job := range j.inflight {
+		// the queued jobs are still in flight
+		if !job.isDone() && !queued[hash] {
+			unfinished = append(unfinished, job)
+		}
+	}
+	j.mu.Unlock()
+	return unfinished, err
+}
+
+// AddConn attaches the inbound connection of a peer to the download of hash
+// when one is queued or running, see Job.AddConn.
+func (j *WireJob) AddConn(hash Hash, conn net.Conn) bool {
+	j.mu.Lock()
+	running, ok := j.inflight[hash]
+	j.mu.Unlock()
+	return ok && running.AddConn(conn)
+}
+
+// AddPeer attaches a peer to the download of hash when one is queued or
+// running, it doesn't count as an announce.
+func (j *WireJob) AddPeer(hash Hash, addr *net.TCPAddr) bool {
+	j.mu.Lock()
+	running, ok := j.inflight[hash]
+	j.mu.Unlock()
+	return ok && running.AddPeer(addr)
 }
 
-func (j *WireJob) Add(job *Job) {
-	j.jobChan <- job
+// Add hands a freshly announced hash to the dedup stage, it returns false when
+// the announce queue is full or the pool is closed and the job was dropped.
+func (j *WireJob) Add(job *Job) bool {
+	j.intake.RLock()
+	defer j.intake.RUnlock()
+	if j.closed {
+		return false
+	}
+	return j.Announces.Push(job)
 }
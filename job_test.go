@@ -1,6 +1,11 @@
 package DHTCrawl
 
-import "testing"
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
 
 func Test_Set(t *testing.T) {
 	s := NewSet()
@@ -15,3 +20,49 @@ func Test_Set(t *testing.T) {
 		t.Error("Delete has error")
 	}
 }
+
+func Test_JobPeers(t *testing.T) {
+	job := NewJob(testHash("12345678901234567890"), &net.TCPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 1})
+	job.AddPeer(&net.TCPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 1})
+	job.AddPeer(&net.TCPAddr{IP: net.IPv4(2, 2, 2, 2), Port: 2})
+	if len(job.Peers()) != 1 {
+		t.Error("Duplicate peer attached")
+	}
+	if job.NextPeer() == nil || job.NextPeer() != nil {
+		t.Error("NextPeer has error")
+	}
+	if job.AddPeer(&net.TCPAddr{IP: net.IPv4(3, 3, 3, 3), Port: 3}) {
+		t.Error("Finished job accepted peer")
+	}
+}
+
+func Test_Resize(t *testing.T) {
+	pool := NewWireJob(2, 16)
+	defer pool.Stop()
+	for i := 0; i < 50; i++ {
+		pool.Resize(4)
+		pool.Resize(0)
+	}
+	retired := -1
+	for i := 0; i < 100 && retired != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		pool.mu.Lock()
+		retired = len(pool.retired)
+		pool.mu.Unlock()
+	}
+	if size, _ := pool.Workers(); size != 0 || retired != 0 {
+		t.Error("retired wires kept", size, retired)
+	}
+}
+
+func Test_CloseUnfinished(t *testing.T) {
+	pool := NewWireJob(0, 16)
+	pool.Add(NewJob(testHash("queued"), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}))
+	for i := 0; i < 100 && pool.Jobs.Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	unfinished, err := pool.Close(context.Background())
+	if err != nil || len(unfinished) != 1 {
+		t.Error("unfinished", len(unfinished), err)
+	}
+}
@@ -0,0 +1,49 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type (
+	// JSONLConfig enables the JSON lines sink. The file is rotated once it
+	// reaches MaxSize megabytes or RotateEvery seconds, zero disables either.
+	JSONLConfig struct {
+		Path        string `json:"path"`
+		MaxSize     int    `json:"max_size"`
+		RotateEvery int    `json:"rotate_every"`
+		Gzip        bool   `json:"gzip"`
+	}
+
+	// JSONLSink appends one JSON object per result to a rotating file which
+	// can be tailed or bulk imported.
+	JSONLSink struct {
+		File *RotatingFile
+	}
+)
+
+func NewJSONLSink(cfg JSONLConfig) (*JSONLSink, error) {
+	f, err := OpenRotatingFile(cfg.Path, int64(cfg.MaxSize)<<20, time.Duration(cfg.RotateEvery)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	f.Gzip = cfg.Gzip
+	return &JSONLSink{File: f}, nil
+}
+
+func (s *JSONLSink) Put(r *MetadataResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.File.Write(append(data, '\n'))
+	return err
+}
+
+func (s *JSONLSink) Flush() error {
+	return s.File.Flush()
+}
+
+func (s *JSONLSink) Close() error {
+	return s.File.Close()
+}
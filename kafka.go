@@ -0,0 +1,124 @@
+package DHTCrawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	DefaultKafkaTopic   = "dhtcrawl.metadata"
+	DefaultKafkaRetries = 10
+)
+
+type (
+	// KafkaConfig enables the Kafka sink. Format is "json" (the default) or
+	// "protobuf", the messages of proto/dhtcrawl.proto. Announces are only
+	// published when AnnounceTopic is set.
+	KafkaConfig struct {
+		Brokers       []string `json:"brokers"`
+		Topic         string   `json:"topic"`
+		AnnounceTopic string   `json:"announce_topic"`
+		Format        string   `json:"format"`
+		Retries       int      `json:"retries"` //delivery attempts of a message before it is dropped
+	}
+
+	// KafkaSink publishes results, and optionally announces, to Kafka. The
+	// message key is the info hash, so every event of a torrent lands in the
+	// same partition. Writes are asynchronous, a delivery which failed every
+	// retry is returned by the next Put.
+	KafkaSink struct {
+		Writer *kafka.Writer
+		Config KafkaConfig
+
+		mu  sync.Mutex
+		err error
+	}
+)
+
+func NewKafkaSink(cfg KafkaConfig) (*KafkaSink, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka sink needs at least one broker")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = DefaultKafkaTopic
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = DefaultKafkaRetries
+	}
+	if err := checkFormat(cfg.Format); err != nil {
+		return nil, err
+	}
+	s := &KafkaSink{Config: cfg}
+	s.Writer = &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Balancer:     &kafka.Hash{},
+		MaxAttempts:  cfg.Retries,
+		BatchTimeout: time.Millisecond * 100,
+		RequiredAcks: kafka.RequireOne,
+		Async:        true,
+		Completion:   s.completed,
+	}
+	return s, nil
+}
+
+func (s *KafkaSink) completed(msgs []kafka.Message, err error) {
+	if err != nil {
+		s.mu.Lock()
+		s.err = fmt.Errorf("kafka dropped %d messages: %s", len(msgs), err.Error())
+		s.mu.Unlock()
+	}
+}
+
+func (s *KafkaSink) lastError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	s.err = nil
+	return err
+}
+
+func (s *KafkaSink) message(r *MetadataResult) (kafka.Message, error) {
+	value, err := encodeResult(s.Config.Format, r)
+	return kafka.Message{Topic: s.Config.Topic, Key: r.Hash[:], Value: value}, err
+}
+
+func (s *KafkaSink) announceMessage(a *Announce) (kafka.Message, error) {
+	value, err := encodeAnnounce(s.Config.Format, a)
+	return kafka.Message{Topic: s.Config.AnnounceTopic, Key: a.Hash[:], Time: a.Time, Value: value}, err
+}
+
+func (s *KafkaSink) Put(r *MetadataResult) error {
+	msg, err := s.message(r)
+	if err != nil {
+		return err
+	}
+	if err := s.Writer.WriteMessages(context.Background(), msg); err != nil {
+		return err
+	}
+	return s.lastError()
+}
+
+func (s *KafkaSink) PutAnnounce(a *Announce) error {
+	if s.Config.AnnounceTopic == "" {
+		return nil
+	}
+	msg, err := s.announceMessage(a)
+	if err != nil {
+		return err
+	}
+	return s.Writer.WriteMessages(context.Background(), msg)
+}
+
+// Close waits for the messages in flight to be delivered.
+func (s *KafkaSink) Close() error {
+	err := s.Writer.Close()
+	if e := s.lastError(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,229 @@
+package DHTCrawl
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket, its rate can be changed while it is in use.
+// A zero rate means unlimited.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  Clock
+}
+
+func NewLimiter(rate float64, burst int) *Limiter {
+	l := new(Limiter)
+	l.SetRate(rate, burst)
+	return l
+}
+
+// SetRate changes the refill rate (per second) and the bucket size, a burst
+// smaller than one second of traffic is raised to it.
+func (l *Limiter) SetRate(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.burst = float64(burst)
+	if l.burst < rate {
+		l.burst = rate
+	}
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	l.tokens = l.burst
+	l.last = clockOr(l.clock).Now()
+}
+
+// SetClock refills the bucket on c instead of the wall clock.
+func (l *Limiter) SetClock(c Clock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = c
+	l.last = clockOr(c).Now()
+}
+
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Allow takes a token if one is available.
+func (l *Limiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return true
+	}
+	now := clockOr(l.clock).Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	l.last = now
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// The defaults of the DHT response budget.
+const (
+	DefaultResponseRate  = 5 //responses per second to one IP
+	DefaultResponseBurst = 20
+	DefaultResponseTotal = 2000 //responses per second to everyone
+
+	maxResponseSources = 1 << 16
+)
+
+// ResponseLimiter bounds the responses a node sends, per source IP and in
+// aggregate, so a spoofed source can't use us to amplify its traffic. A
+// zero rate is unlimited. It guards the get_peers responses, which carry
+// nodes: find_node queries are not answered and pongs are no larger than
+// the ping.
+type ResponseLimiter struct {
+	total *Limiter
+
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	sources map[string]*responseBucket
+	clock   Clock
+}
+
+type responseBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewResponseLimiter(rate float64, burst int, total float64) *ResponseLimiter {
+	return &ResponseLimiter{
+		total:   NewLimiter(total, 0),
+		rate:    rate,
+		burst:   max(float64(burst), 1),
+		sources: map[string]*responseBucket{},
+	}
+}
+
+// newResponseLimiter applies the defaults to the config, negative values
+// are unlimited.
+func newResponseLimiter(cfg *DHTConfig) *ResponseLimiter {
+	rate, burst, total := cfg.ResponseRate, cfg.ResponseBurst, cfg.ResponseTotal
+	if rate == 0 {
+		rate, burst = DefaultResponseRate, max(burst, DefaultResponseBurst)
+	}
+	if total == 0 {
+		total = DefaultResponseTotal
+	}
+	return NewResponseLimiter(max(rate, 0), burst, max(total, 0))
+}
+
+// SetClock refills the buckets on c instead of the wall clock.
+func (l *ResponseLimiter) SetClock(c Clock) {
+	l.total.SetClock(c)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = c
+}
+
+// Allow takes a token of ip and one of the aggregate budget.
+func (l *ResponseLimiter) Allow(ip net.IP) bool {
+	if l.rate > 0 && !l.allowSource(ip.String()) {
+		return false
+	}
+	return l.total.Allow()
+}
+
+func (l *ResponseLimiter) allowSource(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := clockOr(l.clock).Now()
+	b, ok := l.sources[key]
+	if !ok {
+		if len(l.sources) >= maxResponseSources {
+			l.prune(now)
+		}
+		b = &responseBucket{tokens: l.burst}
+		l.sources[key] = b
+	} else {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*l.rate, l.burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets the sources whose bucket has refilled, they are as good as
+// new. When every source is recent the map is reset, the aggregate budget
+// still holds.
+func (l *ResponseLimiter) prune(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.sources {
+		if now.Sub(b.last) >= refill {
+			delete(l.sources, key)
+		}
+	}
+	if len(l.sources) >= maxResponseSources {
+		l.sources = map[string]*responseBucket{}
+	}
+}
+
+// The defaults of the DHT query budget.
+const (
+	DefaultQueryRate  = 20 //queries per second to one network
+	DefaultQueryBurst = 50
+)
+
+// QueryLimiter bounds the find_node queries the nodes of a crawler send to
+// one network, a /24 for IPv4 and a /48 for IPv6, so walking the neighbors
+// in a dense range doesn't get our addresses blacklisted there. Loopback
+// destinations are not limited. A zero rate is unlimited.
+type QueryLimiter struct {
+	subnets *ResponseLimiter //keyed by network, without an aggregate budget
+}
+
+func NewQueryLimiter(rate float64, burst int) *QueryLimiter {
+	return &QueryLimiter{subnets: NewResponseLimiter(rate, burst, 0)}
+}
+
+// newQueryLimiter applies the defaults to the config, a negative rate is
+// unlimited.
+func newQueryLimiter(cfg *DHTConfig) *QueryLimiter {
+	rate, burst := cfg.QueryRate, cfg.QueryBurst
+	if rate == 0 {
+		rate, burst = DefaultQueryRate, max(burst, DefaultQueryBurst)
+	}
+	return NewQueryLimiter(max(rate, 0), burst)
+}
+
+// SetClock refills the buckets on c instead of the wall clock.
+func (l *QueryLimiter) SetClock(c Clock) {
+	l.subnets.SetClock(c)
+}
+
+// Allow takes a token of the network of ip.
+func (l *QueryLimiter) Allow(ip net.IP) bool {
+	if l.subnets.rate <= 0 || ip.IsLoopback() {
+		return true
+	}
+	return l.subnets.allowSource(querySubnet(ip))
+}
+
+// querySubnet returns the /24 of an IPv4 address, the /48 of an IPv6 one.
+func querySubnet(ip net.IP) string {
+	bits := 48
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 24
+	}
+	return ip.Mask(net.CIDRMask(bits, len(ip)*8)).String()
+}
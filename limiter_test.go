@@ -0,0 +1,82 @@
+package DHTCrawl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_ResponseLimiter(t *testing.T) {
+	l := NewResponseLimiter(1, 3, 5)
+	a, b, c := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")
+	for i := 0; i < 3; i++ {
+		if !l.Allow(a) {
+			t.Fatal("response within the burst dropped", i)
+		}
+	}
+	if l.Allow(a) {
+		t.Error("source over its burst answered")
+	}
+	if !l.Allow(b) || !l.Allow(b) {
+		t.Error("other source dropped")
+	}
+	// 5 of the aggregate budget are spent
+	if l.Allow(c) {
+		t.Error("aggregate budget exceeded")
+	}
+
+	unlimited := NewResponseLimiter(0, 0, 0)
+	for i := 0; i < 100; i++ {
+		if !unlimited.Allow(a) {
+			t.Fatal("zero rates dropped a response")
+		}
+	}
+}
+
+func Test_ResponseLimiterPrune(t *testing.T) {
+	l := NewResponseLimiter(1000, 1, 0)
+	for i := 0; i < maxResponseSources+10; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		if !l.Allow(ip) {
+			t.Fatal("new source dropped", ip)
+		}
+	}
+	if n := len(l.sources); n > maxResponseSources {
+		t.Errorf("%d sources remembered, at most %d", n, maxResponseSources)
+	}
+}
+
+func Test_QueryLimiter(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	l := NewQueryLimiter(1, 2)
+	l.SetClock(clock)
+	for _, s := range []string{"10.0.0.1", "10.0.0.200"} {
+		if !l.Allow(net.ParseIP(s)) {
+			t.Fatal("query within the burst throttled", s)
+		}
+	}
+	if l.Allow(net.ParseIP("10.0.0.3")) {
+		t.Error("/24 over its burst queried")
+	}
+	if !l.Allow(net.ParseIP("10.0.1.1")) {
+		t.Error("other /24 throttled")
+	}
+	if !l.Allow(net.ParseIP("2001:db8:1::1")) || !l.Allow(net.ParseIP("2001:db8:1:ffff::1")) || l.Allow(net.ParseIP("2001:db8:1:2::1")) {
+		t.Error("budget of a /48")
+	}
+	for i := 0; i < 10; i++ {
+		if !l.Allow(net.ParseIP("127.0.0.1")) {
+			t.Fatal("loopback throttled")
+		}
+	}
+	clock.Advance(time.Second)
+	if !l.Allow(net.ParseIP("10.0.0.3")) {
+		t.Error("/24 not refilled")
+	}
+
+	d := &DHT{throttle: NewQueryLimiter(1, 1)}
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 6881}
+	if !d.allowQuery(addr) || d.allowQuery(addr) || d.throttled != 1 {
+		t.Error("throttled queries", d.throttled)
+	}
+}
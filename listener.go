@@ -0,0 +1,301 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The defaults of PeerListenerConfig.
+const (
+	DefaultConnsPerIP       = 4
+	DefaultConnsPerNet      = 16
+	DefaultHandshakesPerIP  = 2
+	DefaultHandshakesPerNet = 8
+	DefaultAcceptRate       = 50
+
+	ListenerHandshakeTimeout = 5 * time.Second
+	ListenerHoldTimeout      = time.Minute //a connection taken by OnConn is closed after it
+)
+
+type (
+	// PeerListenerConfig configures the listener for inbound BitTorrent
+	// connections. A network is a /24, a /48 for IPv6. Zero values are the
+	// defaults, a negative accept rate is unlimited.
+	PeerListenerConfig struct {
+		Addr             string  `json:"addr"` //TCP listen address, like :6881
+		ConnsPerIP       int     `json:"conns_per_ip"`
+		ConnsPerNet      int     `json:"conns_per_net"`
+		HandshakesPerIP  int     `json:"handshakes_per_ip"` //connections which have not sent their handshake yet
+		HandshakesPerNet int     `json:"handshakes_per_net"`
+		AcceptRate       float64 `json:"accept_rate"` //connections accepted per second
+		AcceptBurst      int     `json:"accept_burst"`
+	}
+
+	// ListenerStats counts the inbound connections, the refused ones are
+	// closed as soon as they are accepted.
+	ListenerStats struct {
+		Accepted      uint64            `json:"accepted"`
+		RateLimited   uint64            `json:"rate_limited"` //refused by the accept rate
+		IPLimited     uint64            `json:"ip_limited"`   //refused by a per IP cap
+		NetLimited    uint64            `json:"net_limited"`  //refused by a per network cap
+		Handshakes    uint64            `json:"handshakes"`
+		BadHandshakes uint64            `json:"bad_handshakes"`    //not BitTorrent, or too slow
+		Clients       map[string]uint64 `json:"clients,omitempty"` //handshakes by client of the peer id
+	}
+
+	// PeerListener accepts the BitTorrent connections of peers and reports
+	// the infohash of their handshake to OnHash. The caps keep one host or
+	// network from holding the listener and the rate keeps the accept loop
+	// from being flooded.
+	PeerListener struct {
+		OnHash func(hash Hash, peer *net.TCPAddr)
+		// OnConn is offered the connection first, reading it starts over
+		// with the handshake. Returning true takes it over: its slots are
+		// held until it is closed, or for ListenerHoldTimeout, and OnHash
+		// is not called.
+		OnConn func(hash Hash, conn net.Conn) bool
+
+		ln      net.Listener
+		cfg     PeerListenerConfig
+		limiter *Limiter
+		stats   ListenerStats
+
+		mu         sync.Mutex
+		closed     bool
+		conns      map[string]int //by IP and by network
+		handshakes map[string]int
+		clients    map[string]uint64
+	}
+)
+
+// NewPeerListener applies the defaults of cfg, ListenAndServe binds it.
+func NewPeerListener(cfg *PeerListenerConfig) *PeerListener {
+	c := *cfg
+	defaults := []struct {
+		v *int
+		d int
+	}{
+		{&c.ConnsPerIP, DefaultConnsPerIP},
+		{&c.ConnsPerNet, DefaultConnsPerNet},
+		{&c.HandshakesPerIP, DefaultHandshakesPerIP},
+		{&c.HandshakesPerNet, DefaultHandshakesPerNet},
+	}
+	for _, d := range defaults {
+		if *d.v <= 0 {
+			*d.v = d.d
+		}
+	}
+	if c.AcceptRate == 0 {
+		c.AcceptRate = DefaultAcceptRate
+	}
+	return &PeerListener{
+		cfg:        c,
+		limiter:    NewLimiter(max(c.AcceptRate, 0), c.AcceptBurst),
+		conns:      map[string]int{},
+		handshakes: map[string]int{},
+		clients:    map[string]uint64{},
+	}
+}
+
+func (l *PeerListener) Addr() string {
+	return l.cfg.Addr
+}
+
+// ListenAndServe blocks until Close.
+func (l *PeerListener) ListenAndServe() error {
+	ln, err := net.Listen("tcp", l.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(ln)
+}
+
+// Serve accepts the connections of ln until Close.
+func (l *PeerListener) Serve(ln net.Listener) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	l.ln = ln
+	l.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		if !l.limiter.Allow() {
+			atomic.AddUint64(&l.stats.RateLimited, 1)
+			conn.Close()
+			continue
+		}
+		addr, _ := conn.RemoteAddr().(*net.TCPAddr)
+		if addr == nil || !l.acquire(addr.IP) {
+			conn.Close()
+			continue
+		}
+		atomic.AddUint64(&l.stats.Accepted, 1)
+		go func() {
+			defer l.release(addr.IP)
+			protect("inbound", func() { l.handle(conn, addr) })
+		}()
+	}
+}
+
+// Close stops Serve, the connections being read end with their deadline.
+func (l *PeerListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.ln == nil {
+		return nil
+	}
+	return l.ln.Close()
+}
+
+func (l *PeerListener) Stats() ListenerStats {
+	l.mu.Lock()
+	clients := make(map[string]uint64, len(l.clients))
+	for client, n := range l.clients {
+		clients[client] = n
+	}
+	l.mu.Unlock()
+	return ListenerStats{
+		Accepted:      atomic.LoadUint64(&l.stats.Accepted),
+		RateLimited:   atomic.LoadUint64(&l.stats.RateLimited),
+		IPLimited:     atomic.LoadUint64(&l.stats.IPLimited),
+		NetLimited:    atomic.LoadUint64(&l.stats.NetLimited),
+		Handshakes:    atomic.LoadUint64(&l.stats.Handshakes),
+		BadHandshakes: atomic.LoadUint64(&l.stats.BadHandshakes),
+		Clients:       clients,
+	}
+}
+
+// handle reads the handshake of an accepted connection, its handshake slot
+// is given back once it is read.
+func (l *PeerListener) handle(conn net.Conn, addr *net.TCPAddr) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ListenerHandshakeTimeout))
+	handshake := make([]byte, 68)
+	_, err := io.ReadFull(conn, handshake)
+	l.handshaken(addr.IP)
+	if err != nil || handshake[0] != byte(len(BtProtocol)) || string(handshake[1:20]) != BtProtocol {
+		atomic.AddUint64(&l.stats.BadHandshakes, 1)
+		return
+	}
+	atomic.AddUint64(&l.stats.Handshakes, 1)
+	l.handshake(peerClient(handshake[48:68]))
+	hash, _ := HashFromBytes(handshake[28:48])
+	if l.OnConn != nil {
+		c := &inboundConn{Conn: conn, head: handshake, closed: make(chan struct{})}
+		if l.OnConn(hash, c) {
+			hold := time.NewTimer(ListenerHoldTimeout)
+			defer hold.Stop()
+			select {
+			case <-c.closed:
+			case <-hold.C:
+			}
+			return
+		}
+	}
+	if l.OnHash != nil {
+		l.OnHash(hash, addr)
+	}
+}
+
+// inboundConn gives the handshake read by the listener back to the first
+// reads.
+type inboundConn struct {
+	net.Conn
+	head   []byte
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *inboundConn) Read(b []byte) (int, error) {
+	if len(c.head) > 0 {
+		n := copy(b, c.head)
+		c.head = c.head[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *inboundConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// handshake counts a handshake of client, the clients after the first
+// fetchGroupsMax are counted as "other".
+func (l *PeerListener) handshake(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.clients[client]; !ok && len(l.clients) >= fetchGroupsMax {
+		client = "other"
+	}
+	l.clients[client]++
+}
+
+// acquire takes a connection and a handshake slot of ip and of its network.
+func (l *PeerListener) acquire(ip net.IP) bool {
+	host, network := ip.String(), subnet(ip)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.conns[host] >= l.cfg.ConnsPerIP || l.handshakes[host] >= l.cfg.HandshakesPerIP:
+		atomic.AddUint64(&l.stats.IPLimited, 1)
+		return false
+	case l.conns[network] >= l.cfg.ConnsPerNet || l.handshakes[network] >= l.cfg.HandshakesPerNet:
+		atomic.AddUint64(&l.stats.NetLimited, 1)
+		return false
+	}
+	l.conns[host]++
+	l.conns[network]++
+	l.handshakes[host]++
+	l.handshakes[network]++
+	return true
+}
+
+// handshaken gives the handshake slots of ip back.
+func (l *PeerListener) handshaken(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	decrement(l.handshakes, ip.String())
+	decrement(l.handshakes, subnet(ip))
+}
+
+// release gives the connection slots of ip back.
+func (l *PeerListener) release(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	decrement(l.conns, ip.String())
+	decrement(l.conns, subnet(ip))
+}
+
+func decrement(m map[string]int, key string) {
+	if m[key] <= 1 {
+		delete(m, key)
+	} else {
+		m[key]--
+	}
+}
+
+// subnet returns the /24 of an IPv4 address, the /48 of an IPv6 one.
+func subnet(ip net.IP) string {
+	bits := 48
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 24
+	}
+	n := &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, len(ip)*8)), Mask: net.CIDRMask(bits, len(ip)*8)}
+	return n.String()
+}
@@ -0,0 +1,122 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func servePeers(t *testing.T, cfg *PeerListenerConfig) *PeerListener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Addr = ln.Addr().String()
+	l := NewPeerListener(cfg)
+	go l.Serve(ln)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+// dialFrom connects to l from the loopback address ip.
+func dialFrom(t *testing.T, l *PeerListener, ip string) net.Conn {
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+	conn, err := d.Dial("tcp", l.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// refused reports whether the listener closed conn instead of reading it.
+func refused(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	var ne net.Error
+	return !(errors.As(err, &ne) && ne.Timeout())
+}
+
+func Test_PeerListener(t *testing.T) {
+	hashes := make(chan Hash, 1)
+	l := servePeers(t, &PeerListenerConfig{ConnsPerIP: 2, ConnsPerNet: 3, AcceptRate: -1})
+	l.OnHash = func(hash Hash, _ *net.TCPAddr) { hashes <- hash }
+
+	// the handshakes per IP default to 2
+	a, b := dialFrom(t, l, "127.0.0.1"), dialFrom(t, l, "127.0.0.1")
+	if c := dialFrom(t, l, "127.0.0.1"); !refused(c) {
+		t.Error("third connection of an IP was accepted")
+	}
+	if refused(a) || refused(b) {
+		t.Fatal("connections under the cap were closed")
+	}
+	if c := dialFrom(t, l, "127.0.0.2"); refused(c) || l.Stats().Accepted != 3 {
+		t.Errorf("%d accepted, want 3", l.Stats().Accepted)
+	}
+	if c := dialFrom(t, l, "127.0.0.3"); !refused(c) {
+		t.Error("fourth connection of a /24 was accepted")
+	}
+	if st := l.Stats(); st.IPLimited != 1 || st.NetLimited != 1 {
+		t.Errorf("%+v, want one refused by IP and one by network", st)
+	}
+
+	hash := testHash("listener")
+	p := &Processor{Hash: hash}
+	a.Write(p.packetHandshakeData())
+	select {
+	case got := <-hashes:
+		if got != hash {
+			t.Errorf("got %v, want %v", got, hash)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handshake not reported")
+	}
+	b.Write([]byte("GET / HTTP/1.1\r\n\r\n" + string(make([]byte, 50))))
+	deadline := time.Now().Add(time.Second)
+	for l.Stats().BadHandshakes == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := l.Stats(); st.Handshakes != 1 || st.BadHandshakes != 1 || st.Clients["unknown"] != 1 {
+		t.Errorf("%+v, want a handshake of an unknown client and a bad one", st)
+	}
+	// the slots of the closed connections are free again
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		l.mu.Lock()
+		n := l.conns["127.0.0.1"]
+		l.mu.Unlock()
+		if n == 0 {
+			break
+		}
+	}
+	if c := dialFrom(t, l, "127.0.0.1"); refused(c) {
+		t.Error("slot not given back")
+	}
+}
+
+func Test_PeerListenerRate(t *testing.T) {
+	l := servePeers(t, &PeerListenerConfig{AcceptRate: 1})
+	first := dialFrom(t, l, "127.0.0.1")
+	if second := dialFrom(t, l, "127.0.0.2"); !refused(second) {
+		t.Error("connection over the accept rate was accepted")
+	}
+	if refused(first) {
+		t.Error("first connection was refused")
+	}
+	if st := l.Stats(); st.RateLimited != 1 || st.Accepted != 1 {
+		t.Errorf("%+v, want one accepted and one rate limited", st)
+	}
+}
+
+func Test_Subnet(t *testing.T) {
+	for ip, want := range map[string]string{
+		"10.1.2.3":          "10.1.2.0/24",
+		"::ffff:10.1.2.3":   "10.1.2.0/24",
+		"2001:db8:1:2::3":   "2001:db8:1::/48",
+		"2001:db8:1:ffff::": "2001:db8:1::/48",
+	} {
+		if got := subnet(net.ParseIP(ip)); got != want {
+			t.Errorf("subnet(%s) = %s, want %s", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package DHTCrawl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The subsystems log apart, each has its own level.
+const (
+	LogDHT      = "dht"      //KRPC packets and the routing tables
+	LogWire     = "wire"     //metadata downloads from peers
+	LogPipeline = "pipeline" //jobs, queues, refetches and the crawler lifecycle
+	LogSink     = "sink"     //stores, brokers and the other sinks
+	LogServer   = "server"   //HTTP and gRPC APIs
+)
+
+// LogSubsystems lists the subsystems a LogConfig may set a level for.
+var LogSubsystems = []string{LogDHT, LogWire, LogPipeline, LogSink, LogServer}
+
+// LogConfig sets the format of the process wide logs and the level of every
+// subsystem.
+type LogConfig struct {
+	Level  string            `json:"level"`  //debug, info, warn or error, info when empty
+	Format string            `json:"format"` //text or json
+	Levels map[string]string `json:"levels"` //level by subsystem, overrides level
+}
+
+type logHandlerBox struct {
+	h slog.Handler
+}
+
+var (
+	logBase   atomic.Value //logHandlerBox
+	logMu     sync.Mutex
+	logLevels = map[string]*slog.LevelVar{}
+
+	logDHT      = Logger(LogDHT)
+	logWire     = Logger(LogWire)
+	logPipeline = Logger(LogPipeline)
+	logSink     = Logger(LogSink)
+	logServer   = Logger(LogServer)
+)
+
+func init() {
+	logBase.Store(logHandlerBox{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})})
+}
+
+// Logger returns the logger of subsystem, its records carry a subsystem
+// attribute and pass its level before they reach the handler set by
+// SetLogHandler.
+func Logger(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{subsystem: subsystem, level: logLevel(subsystem)})
+}
+
+func logLevel(subsystem string) *slog.LevelVar {
+	logMu.Lock()
+	defer logMu.Unlock()
+	level, ok := logLevels[subsystem]
+	if !ok {
+		level = new(slog.LevelVar)
+		logLevels[subsystem] = level
+	}
+	return level
+}
+
+// SetLogHandler makes h the handler of every subsystem, its own level
+// applies after theirs.
+func SetLogHandler(h slog.Handler) {
+	logBase.Store(logHandlerBox{h})
+}
+
+// SetLogLevel sets the level of subsystem, of all of them when it is empty.
+func SetLogLevel(subsystem string, level slog.Level) {
+	if subsystem != "" {
+		logLevel(subsystem).Set(level)
+		return
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	for _, l := range logLevels {
+		l.Set(level)
+	}
+}
+
+// ConfigureLogging writes the logs to w in the format of cfg and applies its
+// levels, a nil cfg is text at info.
+func ConfigureLogging(cfg *LogConfig, w io.Writer) error {
+	if cfg == nil {
+		cfg = &LogConfig{}
+	}
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch cfg.Format {
+	case "", "text":
+		SetLogHandler(slog.NewTextHandler(w, opts))
+	case "json":
+		SetLogHandler(slog.NewJSONHandler(w, opts))
+	default:
+		return configErr("log.format", "unknown format %q, use text or json", cfg.Format)
+	}
+	return applyLogLevels(cfg)
+}
+
+// applyLogLevels sets the levels of cfg, the subsystems it does not name get
+// cfg.Level.
+func applyLogLevels(cfg *LogConfig) error {
+	if cfg == nil {
+		cfg = &LogConfig{}
+	}
+	levels, err := cfg.levels()
+	if err != nil {
+		return err
+	}
+	for _, subsystem := range LogSubsystems {
+		logLevel(subsystem).Set(levels[subsystem])
+	}
+	return nil
+}
+
+func (cfg *LogConfig) levels() (map[string]slog.Level, error) {
+	var all slog.Level
+	if err := parseLogLevel(cfg.Level, &all); err != nil {
+		return nil, configErr("log.level", "%s", err.Error())
+	}
+	levels := map[string]slog.Level{}
+	for _, subsystem := range LogSubsystems {
+		levels[subsystem] = all
+	}
+	for subsystem, s := range cfg.Levels {
+		if _, ok := levels[subsystem]; !ok {
+			return nil, configErr("log.levels."+subsystem, "unknown subsystem, use %s", strings.Join(LogSubsystems, ", "))
+		}
+		var level slog.Level
+		if err := parseLogLevel(s, &level); err != nil {
+			return nil, configErr("log.levels."+subsystem, "%s", err.Error())
+		}
+		levels[subsystem] = level
+	}
+	return levels, nil
+}
+
+func parseLogLevel(s string, level *slog.Level) error {
+	if s == "" {
+		*level = slog.LevelInfo
+		return nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return fmt.Errorf("unknown level %q, use debug, info, warn or error", s)
+	}
+	return nil
+}
+
+// subsystemHandler filters by the level of its subsystem and hands the
+// records to the current base handler, which may change after the loggers
+// were made.
+type subsystemHandler struct {
+	subsystem string
+	level     *slog.LevelVar
+	with      []func(slog.Handler) slog.Handler //WithAttrs and WithGroup, in order
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	base := logBase.Load().(logHandlerBox).h
+	if !base.Enabled(ctx, r.Level) {
+		return nil
+	}
+	base = base.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	for _, with := range h.with {
+		base = with(base)
+	}
+	return base.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.clone(func(b slog.Handler) slog.Handler { return b.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.clone(func(b slog.Handler) slog.Handler { return b.WithGroup(name) })
+}
+
+func (h *subsystemHandler) clone(with func(slog.Handler) slog.Handler) *subsystemHandler {
+	c := *h
+	c.with = append(append([]func(slog.Handler) slog.Handler{}, h.with...), with)
+	return &c
+}
+
+// LogValue logs a hash in hex.
+func (h Hash) LogValue() slog.Value {
+	return slog.StringValue(h.Hex())
+}
@@ -0,0 +1,58 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_Logging(t *testing.T) {
+	defer ConfigureLogging(nil, os.Stderr)
+	buf := &bytes.Buffer{}
+	err := ConfigureLogging(&LogConfig{Format: "json", Level: "warn", Levels: map[string]string{LogWire: "debug"}}, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"))
+	logWire.With("peer", "1.2.3.4:6881").Debug("fetch from peer failed", "infohash", hash)
+	logDHT.Info("query", "tid", "aa")
+	logDHT.Warn("query", "tid", "bb")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("levels", lines)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["subsystem"] != LogWire || record["infohash"] != hash.Hex() || record["peer"] != "1.2.3.4:6881" || record["level"] != "DEBUG" {
+		t.Error("record", record)
+	}
+	if !strings.Contains(lines[1], `"tid":"bb"`) {
+		t.Error("dht warn", lines[1])
+	}
+
+	// a reload may turn a subsystem up, the others keep the default
+	applyLogLevels(&LogConfig{Levels: map[string]string{LogDHT: "debug"}})
+	buf.Reset()
+	logDHT.Debug("query")
+	logWire.Debug("fetched")
+	if n := strings.Count(buf.String(), "\n"); n != 1 || !strings.Contains(buf.String(), `"subsystem":"dht"`) {
+		t.Error("reloaded levels", buf.String())
+	}
+
+	var cerr *ConfigError
+	if err := applyLogLevels(&LogConfig{Levels: map[string]string{"wires": "debug"}}); !errors.As(err, &cerr) || cerr.Key != "log.levels.wires" {
+		t.Error("unknown subsystem", err)
+	}
+	if err := ConfigureLogging(&LogConfig{Level: "loud"}, buf); !errors.As(err, &cerr) || cerr.Key != "log.level" {
+		t.Error("unknown level", err)
+	}
+	if err := ConfigureLogging(&LogConfig{Format: "xml"}, buf); !errors.As(err, &cerr) || cerr.Key != "log.format" {
+		t.Error("unknown format", err)
+	}
+}
@@ -0,0 +1,204 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"sort"
+	"time"
+)
+
+const (
+	lookupAlpha         = 8    //queries sent for every response
+	lookupMaxQueries    = 1000 //nodes asked before the lookup gives up
+	lookupMaxCandidates = 256  //closest nodes kept for later rounds
+	lookupIdle          = time.Second * 10
+	lookupAnnounce      = 8 //closest nodes sent the announce_peer of AnnouncePeer
+)
+
+// LookupPeers asks the DHT for the peers of hash, walking from the entries
+// towards the nodes closest to it with get_peers queries. Every new peer is
+// sent on the channel, it is closed once ctx is done or no node answered for
+// a while.
+func LookupPeers(ctx context.Context, hash Hash, entries []string) (<-chan *net.TCPAddr, error) {
+	l, err := startLookup(ctx, hash, entries, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	return l.peers, nil
+}
+
+// AnnouncePeer is LookupPeers announcing that we are a peer of hash on the
+// TCP port: once the lookup ends the closest nodes which answered with a
+// token are sent an announce_peer.
+func AnnouncePeer(ctx context.Context, hash Hash, entries []string, port int) (<-chan *net.TCPAddr, error) {
+	l, err := startLookup(ctx, hash, entries, port, false)
+	if err != nil {
+		return nil, err
+	}
+	return l.peers, nil
+}
+
+// ScrapeSwarm runs a get_peers lookup of hash until it ends and estimates
+// the swarm from the distinct peers found and the BEP 33 bloom filters of
+// the nodes which support them.
+func ScrapeSwarm(ctx context.Context, hash Hash, entries []string) (*Swarm, error) {
+	l, err := startLookup(ctx, hash, entries, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	swarm := &Swarm{}
+	for range l.peers {
+		swarm.Peers++
+	}
+	// the channel is closed once run returned, the filters are complete
+	if l.filters > 0 {
+		swarm.Seeders, swarm.Leechers = l.seeds.Estimate(), l.leechers.Estimate()
+	}
+	swarm.Responses = len(l.tokens)
+	return swarm, nil
+}
+
+func startLookup(ctx context.Context, hash Hash, entries []string, port int, scrape bool) (*lookup, error) {
+	session, err := NewSession(0)
+	if err != nil {
+		return nil, err
+	}
+	l := &lookup{
+		session: session,
+		self:    NewNodeID(),
+		hash:    hash,
+		queried: map[string]bool{},
+		found:   map[string]bool{},
+		peers:   make(chan *net.TCPAddr, 64),
+		port:    port,
+		scrape:  scrape,
+	}
+	for _, e := range entries {
+		if addr, err := net.ResolveUDPAddr("udp", e); err == nil {
+			l.query(addr)
+		}
+	}
+	go l.run(ctx)
+	return l, nil
+}
+
+type lookup struct {
+	session    *Session
+	self       NodeID
+	hash       Hash
+	queried    map[string]bool
+	found      map[string]bool
+	candidates []*Node
+	peers      chan *net.TCPAddr
+	port       int           //announced once the lookup ends, 0 doesn't announce
+	tokens     []lookupToken //of the nodes which answered
+	scrape     bool          //asks for the BEP 33 filters
+	seeds      swarmFilter   //union of the filters of the responses
+	leechers   swarmFilter
+	filters    int //responses which had them
+}
+
+type lookupToken struct {
+	node  *Node
+	token string
+}
+
+func (l *lookup) run(ctx context.Context) {
+	defer close(l.peers)
+	defer l.session.Close()
+	defer l.announce()
+	idle := time.NewTimer(lookupIdle)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-idle.C:
+			return
+		case v, ok := <-l.session.Results.C():
+			if !ok {
+				return
+			}
+			r := v.(*Result)
+			if r.Cmd != OP_FIND_NODE {
+				continue
+			}
+			idle.Reset(lookupIdle)
+			if r.Token != "" && len(r.ID) == 20 {
+				l.tokens = append(l.tokens, lookupToken{&Node{ID: r.ID, Addr: r.UDPAddr}, r.Token})
+			}
+			if l.scrape && len(r.BFsd) == len(l.seeds) && len(r.BFpe) == len(l.leechers) {
+				l.seeds.Union(r.BFsd)
+				l.leechers.Union(r.BFpe)
+				l.filters++
+			}
+			for _, addr := range r.Peers {
+				if l.found[addr.String()] {
+					continue
+				}
+				l.found[addr.String()] = true
+				select {
+				case l.peers <- addr:
+				case <-ctx.Done():
+					return
+				}
+			}
+			l.next(r.Nodes)
+		}
+	}
+}
+
+// next adds the nodes of a response to the candidates and queries the
+// closest ones which were not asked yet.
+func (l *lookup) next(nodes []*Node) {
+	for _, node := range nodes {
+		if !l.queried[node.Addr.String()] {
+			l.candidates = append(l.candidates, node)
+		}
+	}
+	target := NodeID(l.hash[:])
+	sort.Slice(l.candidates, func(i, j int) bool {
+		return CompareDistance(l.candidates[i].ID, l.candidates[j].ID, target) < 0
+	})
+	sent := 0
+	rest := l.candidates[:0]
+	for _, node := range l.candidates {
+		switch {
+		case l.queried[node.Addr.String()]:
+		case sent < lookupAlpha && len(l.queried) < lookupMaxQueries:
+			l.query(node.Addr)
+			sent++
+		default:
+			rest = append(rest, node)
+		}
+	}
+	if len(rest) > lookupMaxCandidates {
+		rest = rest[:lookupMaxCandidates]
+	}
+	l.candidates = rest
+}
+
+func (l *lookup) query(addr *net.UDPAddr) {
+	l.queried[addr.String()] = true
+	if l.scrape {
+		l.session.SendTo(PacketQueryScrape(l.self, l.hash), addr)
+		return
+	}
+	l.session.SendTo(PacketQueryGetPeers(l.self, l.hash), addr)
+}
+
+// announce sends announce_peer to the lookupAnnounce closest nodes which
+// gave a token.
+func (l *lookup) announce() {
+	if l.port == 0 {
+		return
+	}
+	target := NodeID(l.hash[:])
+	sort.Slice(l.tokens, func(i, j int) bool {
+		return CompareDistance(l.tokens[i].node.ID, l.tokens[j].node.ID, target) < 0
+	})
+	for _, t := range l.tokens[:min(len(l.tokens), lookupAnnounce)] {
+		l.session.SendTo(PacketQueryAnnouncePeer(l.self, l.hash, l.port, t.token), t.node.Addr)
+	}
+	metricSelfAnnounces.Add(float64(min(len(l.tokens), lookupAnnounce)))
+}
@@ -0,0 +1,278 @@
+package DHTCrawl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The multicast groups of BEP 14.
+var (
+	LSDGroup4 = &net.UDPAddr{IP: net.IPv4(239, 192, 152, 143), Port: 6771}
+	LSDGroup6 = &net.UDPAddr{IP: net.ParseIP("ff15::efc0:988f"), Port: 6771}
+)
+
+const (
+	DefaultLSDInterval = 60 //seconds between two announces of the queued hashes
+
+	lsdMaxHashes = 20 //of a BT-SEARCH message, which stays under 1400 bytes
+	lsdMaxQueued = 1000
+)
+
+type (
+	// LSDConfig turns BEP 14 Local Service Discovery on: the crawler
+	// listens on the multicast groups of the local network segment and
+	// fetches the hashes the peers there announce from them. With a port,
+	// the one of listen by default, it announces the hashes being fetched
+	// too, the local peers of their swarms connect to it.
+	LSDConfig struct {
+		Port     int  `json:"port"`     //announced instead of the port of listen.addr, -1 only listens
+		Interval int  `json:"interval"` //seconds between two announces, 0 is DefaultLSDInterval
+		IPv6     bool `json:"ipv6"`     //join the IPv6 group as well
+	}
+
+	// LSDStats counts the announces seen and sent on the local network.
+	LSDStats struct {
+		Received  uint64 `json:"received"`  //hashes announced by the local peers
+		Ignored   uint64 `json:"ignored"`   //malformed messages and our own
+		Announced uint64 `json:"announced"` //hashes sent
+		Dropped   uint64 `json:"dropped"`   //not queued, the queue was full
+	}
+
+	// LSD is the BEP 14 peer of a crawler. The hashes to announce are
+	// queued and sent by Run in batches, at most once every Interval.
+	LSD struct {
+		Port     int //announced, 0 only listens
+		Interval time.Duration
+
+		pool      *WireJob
+		cookie    string //tells our own announces apart
+		conns     []*net.UDPConn
+		groups    []*net.UDPAddr
+		mu        sync.Mutex
+		queued    []Hash
+		pending   map[Hash]bool
+		received  uint64
+		ignored   uint64
+		announced uint64
+		dropped   uint64
+	}
+)
+
+// NewLSD joins the groups of cfg, the hashes announced there are added to
+// pool. The announced port is cfg.Port, or the one of the listen address
+// when it is 0.
+func NewLSD(cfg *LSDConfig, listen string, pool *WireJob) (*LSD, error) {
+	groups := []*net.UDPAddr{LSDGroup4}
+	if cfg.IPv6 {
+		groups = append(groups, LSDGroup6)
+	}
+	l := newLSD(cfg, listen, pool)
+	for _, group := range groups {
+		network := "udp4"
+		if group.IP.To4() == nil {
+			network = "udp6"
+		}
+		conn, err := net.ListenMulticastUDP(network, nil, group)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.conns, l.groups = append(l.conns, conn), append(l.groups, group)
+	}
+	return l, nil
+}
+
+func newLSD(cfg *LSDConfig, listen string, pool *WireJob) *LSD {
+	port := cfg.Port
+	if port == 0 && listen != "" {
+		_, p, _ := net.SplitHostPort(listen)
+		port, _ = strconv.Atoi(p)
+	}
+	if port < 0 {
+		port = 0
+	}
+	interval := time.Duration(cfg.Interval) * time.Second
+	if interval == 0 {
+		interval = DefaultLSDInterval * time.Second
+	}
+	return &LSD{
+		Port:     port,
+		Interval: interval,
+		pool:     pool,
+		cookie:   NewNodeID().Hex()[:8],
+		pending:  map[Hash]bool{},
+	}
+}
+
+// Announce queues hash for the next announce, it doesn't block.
+func (l *LSD) Announce(hash Hash) bool {
+	if l.Port == 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending[hash] {
+		return false
+	}
+	if len(l.queued) >= lsdMaxQueued {
+		atomic.AddUint64(&l.dropped, 1)
+		return false
+	}
+	l.pending[hash] = true
+	l.queued = append(l.queued, hash)
+	return true
+}
+
+// Flush sends the queued hashes to the groups.
+func (l *LSD) Flush() error {
+	l.mu.Lock()
+	queued := l.queued
+	l.queued, l.pending = nil, map[Hash]bool{}
+	l.mu.Unlock()
+	var err error
+	for len(queued) > 0 {
+		n := len(queued)
+		if n > lsdMaxHashes {
+			n = lsdMaxHashes
+		}
+		for i, conn := range l.conns {
+			msg := formatLSD(l.groups[i], l.Port, l.cookie, queued[:n])
+			if _, e := conn.WriteToUDP(msg, l.groups[i]); e != nil && err == nil {
+				err = e
+			}
+		}
+		atomic.AddUint64(&l.announced, uint64(n))
+		queued = queued[n:]
+	}
+	return err
+}
+
+// Run announces the queued hashes every Interval until stop is closed.
+func (l *LSD) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := l.Flush(); err != nil {
+				logDHT.Debug("lsd announce failed", "error", err)
+			}
+		}
+	}
+}
+
+// Serve adds the hashes the local peers announce to the pool until the
+// sockets are closed.
+func (l *LSD) Serve() error {
+	errs := make(chan error, len(l.conns))
+	for _, conn := range l.conns {
+		go func(conn *net.UDPConn) {
+			errs <- l.serve(conn)
+		}(conn)
+	}
+	var err error
+	for range l.conns {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (l *LSD) serve(conn *net.UDPConn) error {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		port, hashes, cookie, err := parseLSD(buf[:n])
+		if err != nil || cookie == l.cookie {
+			atomic.AddUint64(&l.ignored, 1)
+			continue
+		}
+		peer := &net.TCPAddr{IP: from.IP, Port: port, Zone: from.Zone}
+		for _, hash := range hashes {
+			atomic.AddUint64(&l.received, 1)
+			l.pool.Add(NewJob(hash, peer))
+		}
+	}
+}
+
+func (l *LSD) Close() error {
+	var err error
+	for _, conn := range l.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (l *LSD) Stats() LSDStats {
+	return LSDStats{
+		Received:  atomic.LoadUint64(&l.received),
+		Ignored:   atomic.LoadUint64(&l.ignored),
+		Announced: atomic.LoadUint64(&l.announced),
+		Dropped:   atomic.LoadUint64(&l.dropped),
+	}
+}
+
+// formatLSD returns the BT-SEARCH message announcing hashes on port.
+func formatLSD(group *net.UDPAddr, port int, cookie string, hashes []Hash) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "BT-SEARCH * HTTP/1.1\r\nHost: %s\r\nPort: %d\r\n", group, port)
+	for _, hash := range hashes {
+		fmt.Fprintf(&b, "Infohash: %s\r\n", hash.Hex())
+	}
+	if cookie != "" {
+		fmt.Fprintf(&b, "cookie: %s\r\n", cookie)
+	}
+	b.WriteString("\r\n\r\n")
+	return b.Bytes()
+}
+
+// parseLSD reads a BT-SEARCH message, the headers are case insensitive.
+func parseLSD(b []byte) (port int, hashes []Hash, cookie string, err error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	line, err := r.ReadLine()
+	if err != nil {
+		return 0, nil, "", err
+	}
+	if !strings.HasPrefix(line, "BT-SEARCH * HTTP/1.") {
+		return 0, nil, "", fmt.Errorf("not a BT-SEARCH message: %q", line)
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return 0, nil, "", err
+	}
+	port, err = strconv.Atoi(header.Get("Port"))
+	if err != nil || !IsValidPort(port) {
+		return 0, nil, "", fmt.Errorf("port %q", header.Get("Port"))
+	}
+	for _, v := range header.Values("Infohash") {
+		hash, err := HashFromHex(strings.TrimSpace(v))
+		if err != nil {
+			return 0, nil, "", err
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) == 0 {
+		return 0, nil, "", errors.New("no infohash")
+	}
+	return port, hashes, header.Get("Cookie"), nil
+}
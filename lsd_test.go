@@ -0,0 +1,83 @@
+package DHTCrawl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_ParseLSD(t *testing.T) {
+	hashes := []Hash{testHash("lsd1"), testHash("lsd2")}
+	port, got, cookie, err := parseLSD(formatLSD(LSDGroup6, 6881, "abcd", hashes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 6881 || cookie != "abcd" || len(got) != 2 || got[0] != hashes[0] || got[1] != hashes[1] {
+		t.Error("parsed", port, cookie, got)
+	}
+	msg := "BT-SEARCH * HTTP/1.1\r\nhost: 239.192.152.143:6771\r\nport: 51413\r\ninfohash: " + hashes[0].Hex() + "\r\n\r\n\r\n"
+	if port, got, _, err := parseLSD([]byte(msg)); err != nil || port != 51413 || len(got) != 1 {
+		t.Error("lower case headers", port, got, err)
+	}
+	for _, msg := range []string{
+		"M-SEARCH * HTTP/1.1\r\nPort: 1\r\nInfohash: " + hashes[0].Hex() + "\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 0\r\nInfohash: " + hashes[0].Hex() + "\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 1\r\nInfohash: xyz\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nPort: 1\r\n\r\n",
+	} {
+		if _, _, _, err := parseLSD([]byte(msg)); err == nil {
+			t.Errorf("%q parsed", msg)
+		}
+	}
+}
+
+func Test_LSD(t *testing.T) {
+	// two peers over the loopback, each sending to the other instead of a group
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	pool := NewWireJob(0, 16)
+	defer pool.Stop()
+	a, b := newLSD(&LSDConfig{}, ":6881", pool), newLSD(&LSDConfig{Port: -1}, "", pool)
+	ca, cb := listen(), listen()
+	a.conns, a.groups = []*net.UDPConn{ca}, []*net.UDPAddr{cb.LocalAddr().(*net.UDPAddr)}
+	b.conns, b.groups = []*net.UDPConn{cb}, []*net.UDPAddr{ca.LocalAddr().(*net.UDPAddr)}
+	defer a.Close()
+	defer b.Close()
+	go b.Serve()
+
+	if a.Port != 6881 || b.Port != 0 {
+		t.Fatal("ports", a.Port, b.Port)
+	}
+	if b.Announce(testHash("x")) {
+		t.Error("announced without a port")
+	}
+	for i := 0; i < lsdMaxHashes+5; i++ {
+		a.Announce(Hash(NewNodeID()))
+	}
+	if !a.Announce(Hash(NewNodeID())) || a.Flush() != nil {
+		t.Fatal("flush")
+	}
+	for i := 0; i < 100 && b.Stats().Received < lsdMaxHashes+6; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := a.Stats(); st.Announced != lsdMaxHashes+6 {
+		t.Errorf("%+v", st)
+	}
+	if st := b.Stats(); st.Received != lsdMaxHashes+6 || st.Ignored != 0 {
+		t.Errorf("%+v", st)
+	}
+
+	// our own announce looped back is ignored
+	b.conns[0].WriteToUDP(formatLSD(LSDGroup4, 6881, b.cookie, []Hash{testHash("own")}), cb.LocalAddr().(*net.UDPAddr))
+	for i := 0; i < 100 && b.Stats().Ignored == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := b.Stats(); st.Ignored != 1 || st.Received != lsdMaxHashes+6 {
+		t.Errorf("%+v", st)
+	}
+}
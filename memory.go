@@ -0,0 +1,210 @@
+package DHTCrawl
+
+import (
+	"fmt"
+	"runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+// The defaults of MemoryConfig, the shares of the limit each shedding
+// level starts at.
+const (
+	DefaultMemoryInterval = 1 //seconds between two readings
+	DefaultMemoryDrop     = 0.8
+	DefaultMemoryShrink   = 0.9
+	DefaultMemoryPause    = 0.95
+
+	memoryHysteresis = 0.05 //share of the limit under its start a level ends at
+)
+
+// The shedding levels, each one does what the ones below do too.
+const (
+	MemoryOK     = iota
+	MemoryDrop   //new announces are dropped
+	MemoryShrink //and the caches were emptied
+	MemoryPause  //and discovery is paused
+)
+
+var memoryLevels = []string{"ok", "drop", "shrink", "pause"}
+
+type (
+	// MemoryConfig bounds the memory of the process. The limit is the soft
+	// limit of the garbage collector too. Over shares of it load is shed in
+	// order: the new announces are dropped, then the caches of peers and
+	// failed hashes are emptied, then the nodes stop discovering hashes. A
+	// level ends once the memory is 5% of the limit under where it started.
+	MemoryConfig struct {
+		Limit    int     `json:"limit"`    //megabytes
+		Interval int     `json:"interval"` //seconds between two readings, 0 is DefaultMemoryInterval
+		Drop     float64 `json:"drop"`     //share of the limit new announces are dropped over, 0 is DefaultMemoryDrop
+		Shrink   float64 `json:"shrink"`   //0 is DefaultMemoryShrink
+		Pause    float64 `json:"pause"`    //0 is DefaultMemoryPause
+	}
+
+	// MemoryStats tells how close the process is to its budget and what
+	// was shed.
+	MemoryStats struct {
+		Limit   uint64 `json:"limit"` //bytes
+		Used    uint64 `json:"used"`
+		Level   string `json:"level"`   //ok, drop, shrink or pause
+		Dropped uint64 `json:"dropped"` //announces
+		Shrinks uint64 `json:"shrinks"`
+		Pauses  uint64 `json:"pauses"`
+	}
+
+	// MemoryGuard reads the memory of the process and sheds the load of a
+	// crawler over the levels of its config.
+	MemoryGuard struct {
+		Limit    uint64
+		Interval time.Duration
+		Levels   [3]float64 //shares of Limit the drop, shrink and pause levels start at
+
+		crawler *Crawler
+		read    func() uint64 //memory used, replaced by the tests
+		mu      sync.Mutex
+		level   int
+		used    uint64
+		paused  bool //the nodes were paused by the guard, not by the admin
+		shrinks uint64
+		pauses  uint64
+	}
+)
+
+// NewMemoryGuard applies the defaults of cfg.
+func NewMemoryGuard(c *Crawler, cfg *MemoryConfig) *MemoryGuard {
+	g := &MemoryGuard{
+		Limit:    uint64(cfg.Limit) << 20,
+		Interval: time.Duration(cfg.Interval) * time.Second,
+		Levels:   [3]float64{cfg.Drop, cfg.Shrink, cfg.Pause},
+		crawler:  c,
+		read:     memoryUsed,
+	}
+	if g.Interval == 0 {
+		g.Interval = DefaultMemoryInterval * time.Second
+	}
+	for i, d := range []float64{DefaultMemoryDrop, DefaultMemoryShrink, DefaultMemoryPause} {
+		if g.Levels[i] == 0 {
+			g.Levels[i] = d
+		}
+	}
+	return g
+}
+
+// Run reads the memory every Interval until stop is closed, the load shed
+// is taken back then. Meanwhile Limit is the soft limit of the garbage
+// collector, which is process wide.
+func (g *MemoryGuard) Run(stop <-chan struct{}) {
+	defer debug.SetMemoryLimit(debug.SetMemoryLimit(int64(g.Limit)))
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			g.setLevel(MemoryOK)
+			return
+		case <-ticker.C:
+			g.Check()
+		}
+	}
+}
+
+// Check reads the memory once and moves to the level it is at: up to the
+// highest level started, down to the lowest one it is under the end of.
+func (g *MemoryGuard) Check() int {
+	used := g.read()
+	g.mu.Lock()
+	g.used = used
+	level := g.level
+	g.mu.Unlock()
+	for level < MemoryPause && float64(used) >= g.Levels[level]*float64(g.Limit) {
+		level++
+	}
+	for level > MemoryOK && float64(used) < (g.Levels[level-1]-memoryHysteresis)*float64(g.Limit) {
+		level--
+	}
+	g.setLevel(level)
+	return level
+}
+
+// setLevel does and undoes what the levels between the current one and
+// level do.
+func (g *MemoryGuard) setLevel(level int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if level == g.level {
+		return
+	}
+	c := g.crawler
+	logPipeline.Warn("memory budget", "level", memoryLevels[level], "used_mb", g.used>>20, "limit_mb", g.Limit>>20)
+	up := level > g.level
+	for l := g.level; l != level; {
+		if up {
+			l++
+		}
+		switch l {
+		case MemoryDrop:
+			c.Pool.SetShedding(up)
+		case MemoryShrink:
+			if up {
+				c.ResetCaches()
+				debug.FreeOSMemory()
+				g.shrinks++
+				metricShed.WithLabelValues("caches").Inc()
+			}
+		case MemoryPause:
+			switch {
+			case up && !c.Paused():
+				c.Pause()
+				g.paused = true
+				g.pauses++
+				metricShed.WithLabelValues("discovery").Inc()
+			case !up && g.paused:
+				c.Resume()
+				g.paused = false
+			}
+		}
+		if !up {
+			l--
+		}
+	}
+	g.level = level
+}
+
+func (g *MemoryGuard) Stats() MemoryStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return MemoryStats{
+		Limit:   g.Limit,
+		Used:    g.used,
+		Level:   memoryLevels[g.level],
+		Dropped: g.crawler.Pool.Shed(),
+		Shrinks: g.shrinks,
+		Pauses:  g.pauses,
+	}
+}
+
+// memoryUsed is the memory the runtime holds from the OS, the heap it
+// released excluded.
+func memoryUsed() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+func (cfg *MemoryConfig) validate() error {
+	levels := []float64{cfg.Drop, cfg.Shrink, cfg.Pause}
+	for i, d := range []float64{DefaultMemoryDrop, DefaultMemoryShrink, DefaultMemoryPause} {
+		if levels[i] == 0 {
+			levels[i] = d
+		}
+	}
+	if levels[0] <= memoryHysteresis || levels[0] > levels[1] || levels[1] > levels[2] || levels[2] > 1 {
+		return fmt.Errorf("drop, shrink and pause must rise, over %g and up to 1", memoryHysteresis)
+	}
+	return nil
+}
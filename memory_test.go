@@ -0,0 +1,67 @@
+package DHTCrawl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_MemoryGuard(t *testing.T) {
+	c, err := NewCrawler(WithPort(0), WithNodes(1), WithWorkers(1), WithBootstraps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.closeNodes()
+	defer c.Pool.Stop()
+	g := NewMemoryGuard(c, &MemoryConfig{Limit: 100})
+	var used uint64
+	g.read = func() uint64 { return used }
+	mb := func(n uint64) uint64 { return n << 20 }
+	peer := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	used = mb(50)
+	if level := g.Check(); level != MemoryOK {
+		t.Error("level at 50%", level)
+	}
+	used = mb(85)
+	if level := g.Check(); level != MemoryDrop {
+		t.Error("level at 85%", level)
+	}
+	c.Pool.Peers.Add(testHash("cached"), peer)
+	c.Pool.Add(NewJob(testHash("new"), peer))
+	for i := 0; i < 100 && c.Pool.Shed() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.Pool.Shed() != 1 {
+		t.Error("new hash not shed", c.Pool.Shed())
+	}
+
+	// straight to the top, the caches are emptied on the way
+	used = mb(97)
+	if level := g.Check(); level != MemoryPause || !c.Paused() || c.Pool.Peers.Len() != 0 {
+		t.Error("level at 97%", level, c.Paused(), c.Pool.Peers.Len())
+	}
+	// under the start of pause but not 5% under, nothing changes
+	used = mb(92)
+	if level := g.Check(); level != MemoryPause {
+		t.Error("level at 92%", level)
+	}
+	used = mb(70)
+	if level := g.Check(); level != MemoryOK || c.Paused() {
+		t.Error("level at 70%", level, c.Paused())
+	}
+	st := g.Stats()
+	if st.Level != "ok" || st.Dropped != 1 || st.Shrinks != 1 || st.Pauses != 1 || st.Used != mb(70) {
+		t.Errorf("%+v", st)
+	}
+
+	// a pause of the admin is left to the admin
+	c.Pause()
+	used = mb(99)
+	g.Check()
+	used = 0
+	g.Check()
+	if !c.Paused() || g.Stats().Pauses != 1 {
+		t.Error("admin pause resumed")
+	}
+}
@@ -0,0 +1,145 @@
+package DHTCrawl
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The event counters are shared by every crawler of the process, the gauges
+// are read from the crawler when /metrics is scraped.
+var (
+	metricQueries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhtcrawl_dht_queries_total",
+		Help: "KRPC queries received, by type.",
+	}, []string{"type"})
+	metricResponses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dhtcrawl_dht_find_node_responses_total",
+		Help: "find_node responses received while walking the DHT.",
+	})
+	metricResponsesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dhtcrawl_dht_responses_dropped_total",
+		Help: "get_peers queries left unanswered by the response rate limit.",
+	})
+	metricQueriesThrottled = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dhtcrawl_dht_queries_throttled_total",
+		Help: "find_node queries not sent, their network was over the query rate limit.",
+	})
+	metricAnnounces = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dhtcrawl_announces_total",
+		Help: "Announces accepted for fetching.",
+	})
+	metricFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhtcrawl_fetches_total",
+		Help: "Metadata fetches by result, success or failure.",
+	}, []string{"result"})
+	metricHandshake = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "dhtcrawl_handshake_seconds",
+		Help:    "Time from dialing a peer to its BitTorrent handshake.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2, 5, 10},
+	})
+	metricFetchPhase = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dhtcrawl_fetch_phase_seconds",
+		Help:    "Duration of the steps of the fetch attempts: dial, handshake, extended, piece and total.",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1, 2, 5, 10},
+	}, []string{"phase"})
+	metricPexPeers = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dhtcrawl_pex_peers_total",
+		Help: "Peers received over ut_pex while fetching.",
+	})
+	metricSelfAnnounces = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dhtcrawl_self_announces_total",
+		Help: "announce_peer queries sent for the hashes being fetched, in announce mode.",
+	})
+	metricScrapes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhtcrawl_tracker_scrapes_total",
+		Help: "Tracker scrapes by result, success or failure.",
+	}, []string{"result"})
+	metricSinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhtcrawl_sink_errors_total",
+		Help: "Errors returned by the sinks, by sink.",
+	}, []string{"sink"})
+	metricShed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhtcrawl_memory_shed_total",
+		Help: "Load shed over the memory budget, by action: announce, caches or discovery.",
+	}, []string{"action"})
+	metricShard = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhtcrawl_shard_announces_total",
+		Help: "Announces of the hashes of other shards or cluster members, by action: forwarded, received, dropped or rejected.",
+	}, []string{"action"})
+	metricPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dhtcrawl_panics_total",
+		Help: "Panics recovered, by the work which panicked.",
+	}, []string{"work"})
+
+	descQueueLen     = prometheus.NewDesc("dhtcrawl_queue_length", "Items waiting in a pipeline queue.", []string{"queue"}, nil)
+	descQueueCap     = prometheus.NewDesc("dhtcrawl_queue_capacity", "Capacity of a pipeline queue.", []string{"queue"}, nil)
+	descQueueDropped = prometheus.NewDesc("dhtcrawl_queue_dropped_total", "Items discarded by a full queue.", []string{"queue"}, nil)
+	descNodes        = prometheus.NewDesc("dhtcrawl_table_nodes", "Nodes in the routing table of a DHT node.", []string{"addr"}, nil)
+	descWorkers      = prometheus.NewDesc("dhtcrawl_workers", "Metadata fetch workers.", nil, nil)
+	descBusy         = prometheus.NewDesc("dhtcrawl_workers_busy", "Workers running a fetch.", nil, nil)
+	descInFlight     = prometheus.NewDesc("dhtcrawl_in_flight", "Hashes queued or being fetched.", nil, nil)
+	descStored       = prometheus.NewDesc("dhtcrawl_stored_torrents", "Torrents in the store.", nil, nil)
+	descBytes        = prometheus.NewDesc("dhtcrawl_bytes_total", "Bytes exchanged, by subsystem: dht, wire or http, and direction: in or out.", []string{"subsystem", "direction"}, nil)
+)
+
+// crawlerCollector exports the state of a crawler at scrape time.
+type crawlerCollector struct {
+	c *Crawler
+}
+
+func (cc crawlerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{descQueueLen, descQueueCap, descQueueDropped, descNodes, descWorkers, descBusy, descInFlight, descStored, descBytes} {
+		ch <- d
+	}
+}
+
+func (cc crawlerCollector) Collect(ch chan<- prometheus.Metric) {
+	st := cc.c.Stats()
+	for _, q := range st.Queues {
+		ch <- prometheus.MustNewConstMetric(descQueueLen, prometheus.GaugeValue, float64(q.Len), q.Name)
+		ch <- prometheus.MustNewConstMetric(descQueueCap, prometheus.GaugeValue, float64(q.Cap), q.Name)
+		ch <- prometheus.MustNewConstMetric(descQueueDropped, prometheus.CounterValue, float64(q.Dropped), q.Name)
+	}
+	for _, n := range st.Nodes {
+		ch <- prometheus.MustNewConstMetric(descNodes, prometheus.GaugeValue, float64(n.Nodes), n.Addr)
+	}
+	ch <- prometheus.MustNewConstMetric(descWorkers, prometheus.GaugeValue, float64(st.Workers))
+	ch <- prometheus.MustNewConstMetric(descBusy, prometheus.GaugeValue, float64(st.Busy))
+	ch <- prometheus.MustNewConstMetric(descInFlight, prometheus.GaugeValue, float64(st.InFlight))
+	if st.Stored >= 0 {
+		ch <- prometheus.MustNewConstMetric(descStored, prometheus.GaugeValue, float64(st.Stored))
+	}
+	for subsystem, t := range st.Bandwidth {
+		ch <- prometheus.MustNewConstMetric(descBytes, prometheus.CounterValue, float64(t.In), subsystem, "in")
+		ch <- prometheus.MustNewConstMetric(descBytes, prometheus.CounterValue, float64(t.Out), subsystem, "out")
+	}
+}
+
+// NewMetricsRegistry returns a registry with the process wide counters, the
+// Go runtime metrics and the gauges of c.
+func NewMetricsRegistry(c *Crawler) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metricQueries, metricResponses, metricResponsesDropped, metricQueriesThrottled, metricAnnounces, metricFetches, metricHandshake, metricFetchPhase, metricPexPeers, metricSelfAnnounces, metricScrapes, metricSinkErrors, metricShed, metricShard, metricPanics,
+		crawlerCollector{c},
+	)
+	return reg
+}
+
+func metricsHandler(c *Crawler) http.Handler {
+	return promhttp.HandlerFor(NewMetricsRegistry(c), promhttp.HandlerOpts{})
+}
+
+// sinkName labels the errors of a sink with its type, *DHTCrawl.KafkaSink is kafka.
+func sinkName(s interface{}) string {
+	name := fmt.Sprintf("%T", s)
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.ToLower(strings.TrimSuffix(name, "Sink"))
+}
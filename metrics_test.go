@@ -0,0 +1,41 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type failingSink struct{}
+
+func (failingSink) Put(r *MetadataResult) error { return errors.New("down") }
+func (failingSink) Close() error                { return nil }
+
+func Test_Metrics(t *testing.T) {
+	if name := sinkName(&KafkaSink{}); name != "kafka" {
+		t.Error("sink name", name)
+	}
+	c := &Crawler{Config: NewDefaultConfig(), Pool: NewWireJob(1, 16), Hub: NewHub()}
+	s := NewServer(c, "")
+	metricFetches.WithLabelValues("success").Inc()
+	metricSinkErrors.WithLabelValues(sinkName(failingSink{})).Inc()
+
+	w := httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != 200 {
+		t.Fatal(w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`dhtcrawl_fetches_total{result="success"}`,
+		`dhtcrawl_sink_errors_total{sink="failing"}`,
+		`dhtcrawl_queue_capacity{queue="fetch"}`,
+		"dhtcrawl_workers 1",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Error("missing", want)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+ALTER TABLE announces ADD COLUMN country TEXT NOT NULL DEFAULT '';
+ALTER TABLE announces ADD COLUMN asn BIGINT NOT NULL DEFAULT 0;
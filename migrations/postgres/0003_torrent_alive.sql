@@ -0,0 +1,4 @@
+ALTER TABLE torrents ADD COLUMN alive TIMESTAMPTZ;
+UPDATE torrents SET alive = created;
+ALTER TABLE torrents ALTER COLUMN alive SET NOT NULL;
+CREATE INDEX torrents_alive ON torrents (alive);
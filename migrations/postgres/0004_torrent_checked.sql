@@ -0,0 +1,3 @@
+ALTER TABLE torrents ADD COLUMN checked TIMESTAMPTZ;
+UPDATE torrents SET checked = (data->>'checked')::timestamptz WHERE data ? 'checked';
+CREATE INDEX torrents_checked ON torrents (checked NULLS FIRST);
@@ -0,0 +1,28 @@
+CREATE TABLE torrents (
+	hash     TEXT PRIMARY KEY,
+	name     TEXT NOT NULL,
+	length   INTEGER NOT NULL,
+	category TEXT NOT NULL DEFAULT '',
+	type     INTEGER NOT NULL DEFAULT 0,
+	created  INTEGER NOT NULL,
+	data     TEXT NOT NULL
+);
+
+CREATE INDEX torrents_category ON torrents (category);
+CREATE INDEX torrents_created ON torrents (created);
+
+CREATE TABLE files (
+	hash   TEXT NOT NULL REFERENCES torrents (hash) ON DELETE CASCADE,
+	idx    INTEGER NOT NULL,
+	path   TEXT NOT NULL,
+	length INTEGER NOT NULL,
+	PRIMARY KEY (hash, idx)
+);
+
+CREATE TABLE announces (
+	hash    TEXT NOT NULL,
+	peer    TEXT NOT NULL,
+	seen_at INTEGER NOT NULL
+);
+
+CREATE INDEX announces_hash ON announces (hash);
@@ -0,0 +1,3 @@
+ALTER TABLE torrents ADD COLUMN alive INTEGER NOT NULL DEFAULT 0;
+UPDATE torrents SET alive = created;
+CREATE INDEX torrents_alive ON torrents (alive);
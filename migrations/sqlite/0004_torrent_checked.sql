@@ -0,0 +1,3 @@
+ALTER TABLE torrents ADD COLUMN checked INTEGER NOT NULL DEFAULT 0;
+UPDATE torrents SET checked = COALESCE(CAST(strftime('%s', json_extract(data, '$.checked')) AS INTEGER), 0) WHERE json_valid(data);
+CREATE INDEX torrents_checked ON torrents (checked);
@@ -0,0 +1,175 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	DefaultMQTTMetadataTopic = "dht/metadata"
+	DefaultMQTTAnnounceTopic = "dht/announce"
+
+	mqttTimeout     = 10 * time.Second //of the acks of a publish and of Close
+	mqttOfflinePoll = 100 * time.Millisecond
+)
+
+type (
+	// MQTTConfig enables the MQTT sink. Broker is a url like
+	// tcp://localhost:1883, QoS is 0, 1 or 2. An empty AnnounceTopic keeps
+	// the default, "-" disables announce events.
+	MQTTConfig struct {
+		Broker        string `json:"broker"`
+		ClientID      string `json:"client_id"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		MetadataTopic string `json:"metadata_topic"`
+		AnnounceTopic string `json:"announce_topic"`
+		QoS           byte   `json:"qos"`
+		Format        string `json:"format"` //json or protobuf
+	}
+
+	// MQTTSink publishes metadata and announce events to an MQTT broker in
+	// the background, a QoS 1 or 2 ack never stalls the pipeline. The client
+	// reconnects on its own, the messages waiting for the broker are held in
+	// Queue, which drops the oldest when full.
+	MQTTSink struct {
+		Client mqtt.Client
+		Config MQTTConfig
+		Queue  *Queue
+
+		stop chan struct{} //closed when Close runs out of time
+		done chan struct{} //closed when send returned
+	}
+
+	mqttMessage struct {
+		topic    string
+		data     []byte
+		announce bool
+	}
+)
+
+func NewMQTTSink(cfg MQTTConfig) (*MQTTSink, error) {
+	if cfg.Broker == "" {
+		return nil, errors.New("mqtt sink needs a broker")
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("invalid mqtt qos %d", cfg.QoS)
+	}
+	if err := checkFormat(cfg.Format); err != nil {
+		return nil, err
+	}
+	if cfg.MetadataTopic == "" {
+		cfg.MetadataTopic = DefaultMQTTMetadataTopic
+	}
+	switch cfg.AnnounceTopic {
+	case "":
+		cfg.AnnounceTopic = DefaultMQTTAnnounceTopic
+	case "-":
+		cfg.AnnounceTopic = ""
+	}
+	if cfg.ClientID == "" {
+		host, _ := os.Hostname()
+		cfg.ClientID = fmt.Sprintf("dhtcrawl-%s-%d", host, os.Getpid())
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetMaxReconnectInterval(time.Minute)
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	// with ConnectRetry the first connection keeps retrying in the background
+	if token.WaitTimeout(time.Second*10) && token.Error() != nil {
+		return nil, token.Error()
+	}
+	s := &MQTTSink{Client: client, Config: cfg}
+	s.start()
+	return s, nil
+}
+
+func (s *MQTTSink) start() {
+	s.Queue = NewQueue("mqtt", DefaultQueueSize, QueueDropOldest)
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.send()
+}
+
+// send publishes the queued messages one at a time. Offline they wait in
+// the queue rather than in the unbounded store of the client.
+func (s *MQTTSink) send() {
+	defer close(s.done)
+	for v := range s.Queue.C() {
+		m := v.(*mqttMessage)
+		for !s.Client.IsConnectionOpen() {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(mqttOfflinePoll):
+			}
+		}
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		if err := s.publish(m); err != nil {
+			if m.announce {
+				logSink.Debug("mqtt publish failed", "topic", m.topic, "error", err)
+			} else {
+				logSink.Warn("mqtt publish failed", "topic", m.topic, "error", err)
+			}
+		}
+	}
+}
+
+func (s *MQTTSink) publish(m *mqttMessage) error {
+	token := s.Client.Publish(m.topic, s.Config.QoS, false, m.data)
+	if s.Config.QoS == 0 {
+		return nil
+	}
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("mqtt publish %s timeout", m.topic)
+	}
+	return token.Error()
+}
+
+func (s *MQTTSink) Put(r *MetadataResult) error {
+	data, err := encodeResult(s.Config.Format, r)
+	if err != nil {
+		return err
+	}
+	s.Queue.Push(&mqttMessage{topic: s.Config.MetadataTopic, data: data})
+	return nil
+}
+
+func (s *MQTTSink) PutAnnounce(a *Announce) error {
+	if s.Config.AnnounceTopic == "" {
+		return nil
+	}
+	data, err := encodeAnnounce(s.Config.Format, a)
+	if err != nil {
+		return err
+	}
+	s.Queue.Push(&mqttMessage{topic: s.Config.AnnounceTopic, data: data, announce: true})
+	return nil
+}
+
+// Close publishes the queued messages for up to 10 seconds, the ones left
+// then are lost.
+func (s *MQTTSink) Close() error {
+	s.Queue.Close()
+	select {
+	case <-s.done:
+	case <-time.After(mqttTimeout):
+		close(s.stop)
+	}
+	s.Client.Disconnect(1000)
+	return nil
+}
@@ -0,0 +1,68 @@
+package DHTCrawl
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeMQTT is a client whose broker comes and goes.
+type fakeMQTT struct {
+	mqtt.Client
+	online    atomic.Bool
+	mu        sync.Mutex
+	published []string
+}
+
+type fakeToken struct{ mqtt.Token }
+
+func (t fakeToken) WaitTimeout(time.Duration) bool { return true }
+
+func (t fakeToken) Error() error { return nil }
+
+func (c *fakeMQTT) IsConnectionOpen() bool { return c.online.Load() }
+
+func (c *fakeMQTT) Disconnect(uint) {}
+
+func (c *fakeMQTT) Publish(topic string, _ byte, _ bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, string(payload.([]byte)))
+	return fakeToken{}
+}
+
+func (c *fakeMQTT) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.published)
+}
+
+func Test_MQTTOffline(t *testing.T) {
+	client := &fakeMQTT{}
+	s := &MQTTSink{Client: client, Config: MQTTConfig{MetadataTopic: "m", AnnounceTopic: "a", QoS: 1, Format: "json"}}
+	s.start()
+
+	// offline the queue holds the messages and drops the oldest, Put never waits
+	start := time.Now()
+	for i := 0; i < DefaultQueueSize+10; i++ {
+		s.PutAnnounce(&Announce{Hash: testHash("announce"), Time: time.Now()})
+	}
+	if d := time.Since(start); d > time.Second || client.count() != 0 {
+		t.Error("offline publish", d, client.count())
+	}
+	// the sender may hold one message out of the queue
+	st := s.Queue.Stat()
+	if st.Len != DefaultQueueSize || st.Dropped < 9 {
+		t.Errorf("%+v", st)
+	}
+
+	// back online, Close publishes what is left
+	client.online.Store(true)
+	s.Close()
+	if n := client.count(); n != DefaultQueueSize+10-int(st.Dropped) {
+		t.Error("published", n)
+	}
+}
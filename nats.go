@@ -0,0 +1,151 @@
+package DHTCrawl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	DefaultNATSSubject = "dhtcrawl.metadata"
+	DefaultNATSStream  = "DHTCRAWL"
+)
+
+type (
+	// NATSConfig enables the NATS sink. With JetStream the subjects are
+	// captured by Stream, which is created when it does not exist, and the
+	// results survive subscribers which are offline.
+	NATSConfig struct {
+		Url             string `json:"url"`
+		Subject         string `json:"subject"`
+		AnnounceSubject string `json:"announce_subject"` //announces are only published when set
+		Format          string `json:"format"`           //json or protobuf
+		JetStream       bool   `json:"jetstream"`
+		Stream          string `json:"stream"`
+	}
+
+	// NATSSink publishes results, and optionally announces, to NATS. JetStream
+	// publishes are asynchronous and deduplicated on the info hash, a failed
+	// one is returned by the next Put.
+	NATSSink struct {
+		Conn      *nats.Conn
+		JetStream jetstream.JetStream //nil for core NATS
+		Config    NATSConfig
+
+		mu  sync.Mutex
+		err error
+	}
+)
+
+func NewNATSSink(cfg NATSConfig) (*NATSSink, error) {
+	if cfg.Url == "" {
+		cfg.Url = nats.DefaultURL
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = DefaultNATSSubject
+	}
+	if cfg.Stream == "" {
+		cfg.Stream = DefaultNATSStream
+	}
+	if err := checkFormat(cfg.Format); err != nil {
+		return nil, err
+	}
+	conn, err := nats.Connect(cfg.Url, nats.Name("dhtcrawl"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	s := &NATSSink{Conn: conn, Config: cfg}
+	if !cfg.JetStream {
+		return s, nil
+	}
+
+	js, err := jetstream.New(conn, jetstream.WithPublishAsyncErrHandler(s.failed))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	subjects := []string{cfg.Subject}
+	if cfg.AnnounceSubject != "" {
+		subjects = append(subjects, cfg.AnnounceSubject)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{Name: cfg.Stream, Subjects: subjects}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.JetStream = js
+	return s, nil
+}
+
+func (s *NATSSink) failed(js jetstream.JetStream, msg *nats.Msg, err error) {
+	s.mu.Lock()
+	s.err = fmt.Errorf("nats publish %s error %s", msg.Subject, err.Error())
+	s.mu.Unlock()
+}
+
+func (s *NATSSink) lastError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	s.err = nil
+	return err
+}
+
+func (s *NATSSink) publish(subject, id string, data []byte) error {
+	if s.JetStream == nil {
+		return s.Conn.Publish(subject, data)
+	}
+	_, err := s.JetStream.PublishMsgAsync(&nats.Msg{Subject: subject, Data: data}, jetstream.WithMsgID(id))
+	return err
+}
+
+func (s *NATSSink) Put(r *MetadataResult) error {
+	data, err := encodeResult(s.Config.Format, r)
+	if err != nil {
+		return err
+	}
+	if err := s.publish(s.Config.Subject, r.Hash.Hex(), data); err != nil {
+		return err
+	}
+	return s.lastError()
+}
+
+func (s *NATSSink) PutAnnounce(a *Announce) error {
+	if s.Config.AnnounceSubject == "" {
+		return nil
+	}
+	data, err := encodeAnnounce(s.Config.Format, a)
+	if err != nil {
+		return err
+	}
+	peer := ""
+	if a.Peer != nil {
+		peer = a.Peer.String()
+	}
+	return s.publish(s.Config.AnnounceSubject, a.Hash.Hex()+"-"+peer, data)
+}
+
+// Flush waits until the server has the published messages.
+func (s *NATSSink) Flush() error {
+	if s.JetStream != nil {
+		select {
+		case <-s.JetStream.PublishAsyncComplete():
+		case <-time.After(time.Second * 10):
+			return fmt.Errorf("nats flush timeout, %d publishes pending", s.JetStream.PublishAsyncPending())
+		}
+	} else if err := s.Conn.Flush(); err != nil {
+		return err
+	}
+	return s.lastError()
+}
+
+func (s *NATSSink) Close() error {
+	err := s.Flush()
+	s.Conn.Close()
+	return err
+}
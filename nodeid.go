@@ -0,0 +1,87 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"math/bits"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Distance is the XOR metric of the DHT between n and target, the shorter
+// ID is padded with zeros.
+func (n NodeID) Distance(target NodeID) NodeID {
+	d := make(NodeID, max(len(n), len(target)))
+	for i := range d {
+		var a, b byte
+		if i < len(n) {
+			a = n[i]
+		}
+		if i < len(target) {
+			b = target[i]
+		}
+		d[i] = a ^ b
+	}
+	return d
+}
+
+// CompareDistance returns -1 when a is closer to target than b, 1 when it
+// is further and 0 when both are as close.
+func CompareDistance(a, b, target NodeID) int {
+	return bytes.Compare(a.Distance(target), b.Distance(target))
+}
+
+// CommonPrefixLen returns how many leading bits a and b share, the bucket
+// of b in a routing table centred on a.
+func CommonPrefixLen(a, b NodeID) int {
+	n := 0
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+// NodeIDSource makes node IDs from a seed, the same seed gives the same
+// IDs in the same order, so a crawl can be reproduced.
+type NodeIDSource struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func NewNodeIDSource(seed int64) *NodeIDSource {
+	return &NodeIDSource{r: rand.New(rand.NewSource(seed))}
+}
+
+// NodeID returns the next random ID.
+func (s *NodeIDSource) NodeID() NodeID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := make(NodeID, 20)
+	s.r.Read(id)
+	return id
+}
+
+// Near returns a random ID whose first prefix bits are the ones of
+// target, it lands in the bucket of target's neighbours which spoofing
+// crawlers pose as.
+func (s *NodeIDSource) Near(target NodeID, prefix int) NodeID {
+	id := s.NodeID()
+	prefix = min(max(prefix, 0), len(target)*8, len(id)*8)
+	full := prefix / 8
+	copy(id, target[:full])
+	if rest := prefix % 8; rest > 0 {
+		mask := byte(0xff) << (8 - rest)
+		id[full] = target[full]&mask | id[full]&^mask
+	}
+	return id
+}
+
+var defaultNodeIDs = NewNodeIDSource(time.Now().UnixNano())
+
+// NewNodeIDNear is NodeIDSource.Near with an unseeded source.
+func NewNodeIDNear(target NodeID, prefix int) NodeID {
+	return defaultNodeIDs.Near(target, prefix)
+}
@@ -0,0 +1,50 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_NodeID(t *testing.T) {
+	a := NewNodeIDFromHex("0000000000000000000000000000000000000000")
+	b := NewNodeIDFromHex("00000000000000000000000000000000000000FF")
+	c := NewNodeIDFromHex("8000000000000000000000000000000000000000")
+	if d := b.Distance(c); d.Hex() != "80000000000000000000000000000000000000FF" {
+		t.Error("distance", d.Hex())
+	}
+	if CompareDistance(b, c, a) != -1 || CompareDistance(c, b, a) != 1 || CompareDistance(b, b, a) != 0 {
+		t.Error("compare distance")
+	}
+	if n := CommonPrefixLen(a, b); n != 152 {
+		t.Error("prefix", n)
+	}
+	if n := CommonPrefixLen(a, c); n != 0 {
+		t.Error("prefix", n)
+	}
+	if n := CommonPrefixLen(a, a); n != 160 {
+		t.Error("prefix", n)
+	}
+
+	s1, s2 := NewNodeIDSource(42), NewNodeIDSource(42)
+	for i := 0; i < 3; i++ {
+		if x, y := s1.NodeID(), s2.NodeID(); !bytes.Equal(x, y) || len(x) != 20 {
+			t.Fatal("seeded ids differ", x.Hex(), y.Hex())
+		}
+	}
+	target := NewNodeID()
+	for _, prefix := range []int{0, 7, 8, 13, 160, 200} {
+		id := s1.Near(target, prefix)
+		if n := CommonPrefixLen(id, target); n < min(prefix, 160) {
+			t.Error("near", prefix, n)
+		}
+	}
+
+	n := NewNodeIDFromHex("0102030405060708090A0B0C0D0E0F1011121314")
+	before := n.Hex()
+	if id := n.Neighbor(target); !bytes.Equal(id[:8], n[:8]) || !bytes.Equal(id[8:], target[8:]) {
+		t.Error("neighbor", id.Hex())
+	}
+	if n.Hex() != before {
+		t.Error("neighbor modified n")
+	}
+}
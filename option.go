@@ -0,0 +1,163 @@
+package DHTCrawl
+
+import (
+	"log/slog"
+	"math/rand"
+)
+
+type (
+	// Option configures a Crawler in NewCrawler.
+	Option func(*options)
+
+	options struct {
+		cfg             *DHTConfig
+		store           Store
+		sinks           []Sink
+		filters         []Filter
+		logger          *slog.Logger
+		hashHandler     HashHandler
+		metadataHandler ResultHandler
+		clock           Clock
+		source          rand.Source
+	}
+)
+
+func newOptions() *options {
+	return &options{
+		cfg:   NewDefaultConfig(),
+		clock: SystemClock,
+	}
+}
+
+// WithConfig replaces the whole config with a copy of cfg, put it before
+// the options which tune single settings.
+func WithConfig(cfg *DHTConfig) Option {
+	return func(o *options) {
+		if cfg != nil {
+			copied := *cfg
+			o.cfg = &copied
+		}
+	}
+}
+
+// WithNodes runs n DHT nodes on consecutive ports starting at the UDP port.
+func WithNodes(n int) Option {
+	return func(o *options) {
+		o.cfg.Nodes = n
+	}
+}
+
+// WithPort sets the UDP port of the first node, 0 picks random ports.
+func WithPort(port int) Option {
+	return func(o *options) {
+		o.cfg.Port = port
+	}
+}
+
+// WithWorkers sets how many metadata downloads run concurrently.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		o.cfg.JobSize = n
+	}
+}
+
+// WithWorkerBounds lets the pool scale between min and max workers with the
+// fetch queue depth, the pool starts at the WithWorkers size.
+func WithWorkerBounds(min, max int) Option {
+	return func(o *options) {
+		o.cfg.MinJobSize = min
+		o.cfg.MaxJobSize = max
+	}
+}
+
+// WithQueueSize bounds every pipeline queue.
+func WithQueueSize(n int) Option {
+	return func(o *options) {
+		o.cfg.QueueSize = n
+	}
+}
+
+// WithBootstraps replaces the entry nodes.
+func WithBootstraps(entries ...string) Option {
+	return func(o *options) {
+		o.cfg.Entries = entries
+	}
+}
+
+// WithStatePath saves and restores the routing table and pending jobs.
+func WithStatePath(path string) Option {
+	return func(o *options) {
+		o.cfg.StatePath = path
+	}
+}
+
+// WithFetchRate limits how many new hashes per second enter the fetch queue.
+func WithFetchRate(rate float64, burst int) Option {
+	return func(o *options) {
+		o.cfg.FetchRate = rate
+		o.cfg.FetchBurst = burst
+	}
+}
+
+// WithStore persists results in s instead of the store opened from the
+// config.
+func WithStore(s Store) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// WithSink adds a storage backend which receives every fetched result.
+func WithSink(s Sink) Option {
+	return func(o *options) {
+		o.sinks = append(o.sinks, s)
+	}
+}
+
+// WithFilter adds a filter asked before fetching and again before storing.
+func WithFilter(f Filter) Option {
+	return func(o *options) {
+		o.filters = append(o.filters, f)
+	}
+}
+
+// WithHashHandler decides which announced hashes are fetched.
+func WithHashHandler(h HashHandler) Option {
+	return func(o *options) {
+		o.hashHandler = h
+	}
+}
+
+func WithMetadataHandler(h ResultHandler) Option {
+	return func(o *options) {
+		o.metadataHandler = h
+	}
+}
+
+// WithLogger hands the logs of every subsystem to the handler of l, they
+// are process wide like the metrics.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		if l != nil {
+			o.logger = l
+		}
+	}
+}
+
+// WithClock runs the walk, the token rotation, the limiters and the refetch
+// backoff on c, a ManualClock with WithRandSource makes a run reproducible.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
+
+// WithRandSource seeds the node IDs and the tokens of every node from src,
+// in the order of the nodes, instead of the seed of the config.
+func WithRandSource(src rand.Source) Option {
+	return func(o *options) {
+		o.source = src
+	}
+}
@@ -0,0 +1,180 @@
+package DHTCrawl
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPartialStoreSize = 64 //megabytes of partial metadata
+	DefaultPartialTTL       = 60 //minutes a partial download is kept
+)
+
+type (
+	// PartialMetadata is what a download received before its connection
+	// died: the pieces of the metadata of Size bytes which Received marks.
+	PartialMetadata struct {
+		Hash     Hash
+		Size     int
+		Data     []byte //Size bytes, the missing pieces are zero
+		Received []bool //by piece
+		Time     time.Time
+	}
+
+	// PartialStats counts the downloads resumed from a PartialStore.
+	PartialStats struct {
+		Hashes  int    `json:"hashes"`  //kept
+		Bytes   int    `json:"bytes"`   //of metadata kept
+		Resumed uint64 `json:"resumed"` //attempts which started with pieces received before
+		Reused  uint64 `json:"reused"`  //pieces not requested again
+	}
+
+	// PartialStore keeps the partial metadata of the failed attempts until
+	// the next attempt on the hash, from the same or another peer, takes it
+	// and requests only the missing pieces. It holds at most Size bytes of
+	// metadata, the least recent download is forgotten first, for TTL.
+	PartialStore struct {
+		Size  int //bytes
+		TTL   time.Duration
+		Clock Clock //nil is SystemClock
+
+		mu      sync.Mutex
+		hashes  map[Hash]*list.Element
+		lru     *list.List
+		bytes   int
+		resumed uint64
+		reused  uint64
+	}
+
+	// partialSlot holds the store of a pool, nil resumes nothing.
+	partialSlot struct {
+		mu       sync.RWMutex
+		partials *PartialStore
+	}
+)
+
+// NewPartialStore keeps size megabytes for ttl minutes, zero keeps the
+// default.
+func NewPartialStore(size, ttl int) *PartialStore {
+	if size <= 0 {
+		size = DefaultPartialStoreSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultPartialTTL
+	}
+	return &PartialStore{
+		Size:   size << 20,
+		TTL:    time.Duration(ttl) * time.Minute,
+		hashes: make(map[Hash]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+// Missing returns the pieces not received yet.
+func (m *PartialMetadata) Missing() int {
+	n := 0
+	for _, ok := range m.Received {
+		if !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Put keeps m for the next attempt on its hash, replacing what was kept.
+// m is owned by the store from then on.
+func (s *PartialStore) Put(m *PartialMetadata) {
+	if s == nil || m == nil || m.Size > s.Size {
+		return
+	}
+	m.Time = clockOr(s.Clock).Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(m.Hash)
+	s.hashes[m.Hash] = s.lru.PushFront(m)
+	s.bytes += m.Size
+	for s.bytes > s.Size {
+		s.remove(s.lru.Back().Value.(*PartialMetadata).Hash)
+	}
+}
+
+// Take returns the partial metadata of hash and forgets it, nil when there
+// is none or it expired. The attempt which takes it puts it back if it fails
+// too.
+func (s *PartialStore) Take(hash Hash) *PartialMetadata {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.hashes[hash]
+	if !ok {
+		return nil
+	}
+	m := el.Value.(*PartialMetadata)
+	s.remove(hash)
+	if clockOr(s.Clock).Now().Sub(m.Time) > s.TTL {
+		return nil
+	}
+	return m
+}
+
+// Delete forgets the partial metadata of hash, whose pieces turned out bad.
+func (s *PartialStore) Delete(hash Hash) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(hash)
+}
+
+// Reset forgets every partial download.
+func (s *PartialStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashes = make(map[Hash]*list.Element)
+	s.lru.Init()
+	s.bytes = 0
+}
+
+func (s *PartialStore) Stats() PartialStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return PartialStats{Hashes: len(s.hashes), Bytes: s.bytes, Resumed: s.resumed, Reused: s.reused}
+}
+
+// resume counts an attempt which didn't request pieces again.
+func (s *PartialStore) resume(pieces int) {
+	if s == nil || pieces == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resumed++
+	s.reused += uint64(pieces)
+}
+
+func (s *PartialStore) remove(hash Hash) {
+	if el, ok := s.hashes[hash]; ok {
+		s.lru.Remove(el)
+		delete(s.hashes, hash)
+		s.bytes -= el.Value.(*PartialMetadata).Size
+	}
+}
+
+func (s *partialSlot) set(partials *PartialStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partials = partials
+}
+
+func (s *partialSlot) get() *PartialStore {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.partials
+}
@@ -0,0 +1,89 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+	"github.com/zeebo/bencode"
+)
+
+func Test_PartialStore(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	s := NewPartialStore(1, 1)
+	s.Clock = clock
+	partial := func(name string, size int) *PartialMetadata {
+		return &PartialMetadata{Hash: testHash(name), Size: size, Data: make([]byte, size), Received: []bool{true, false}}
+	}
+	s.Put(partial("a", 600<<10))
+	s.Put(partial("b", 300<<10))
+	s.Put(partial("c", 300<<10)) //over 1MB, a goes
+	if st := s.Stats(); st.Hashes != 2 || st.Bytes != 600<<10 {
+		t.Errorf("%+v", st)
+	}
+	if s.Take(testHash("a")) != nil {
+		t.Error("a kept")
+	}
+	if m := s.Take(testHash("b")); m == nil || m.Missing() != 1 {
+		t.Error("b", m)
+	}
+	if s.Take(testHash("b")) != nil {
+		t.Error("b taken twice")
+	}
+	clock.Advance(2 * time.Minute)
+	if s.Take(testHash("c")) != nil {
+		t.Error("c expired")
+	}
+	s.Put(partial("big", 2<<20))
+	if st := s.Stats(); st.Hashes != 0 || st.Bytes != 0 {
+		t.Errorf("%+v", st)
+	}
+}
+
+func Test_ResumeMetadata(t *testing.T) {
+	//three pieces, the last one short
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "resumed", "length": 10, "piece length": 16384, "pieces": strings.Repeat("x", 2*PieceSize+100)})
+	partials := NewPartialStore(0, 0)
+	w := &Wire{partials: &partialSlot{partials: partials}}
+	fetch := func(peer *testutil.Peer, timeout time.Duration) (*MetadataResult, error) {
+		hash, addr := scriptedPeer(t, peer)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return w.fromPeer(ctx, hash, addr)
+	}
+
+	// the first peer hangs up after two pieces
+	_, err := fetch(&testutil.Peer{Info: info, CloseAfter: 2}, 300*time.Millisecond)
+	if fetchFailure(err) != FailTimeout {
+		t.Fatal(err)
+	}
+	if st := partials.Stats(); st.Hashes != 1 || st.Bytes != len(info) {
+		t.Fatalf("%+v", st)
+	}
+
+	// the next one is only asked for the third
+	second := testutil.NewPeer(info)
+	r, err := fetch(second, 5*time.Second)
+	if err != nil || !bytes.Equal(r.Info, info) {
+		t.Fatal("resumed", err)
+	}
+	if second.Requests() != 1 || r.Timing.Resumed != 2 {
+		t.Error("requests", second.Requests(), r.Timing.Resumed)
+	}
+	if st := partials.Stats(); st.Hashes != 0 || st.Resumed != 1 || st.Reused != 2 {
+		t.Errorf("%+v", st)
+	}
+
+	// pieces which don't hash to the infohash aren't kept
+	fetch(&testutil.Peer{Info: info, CloseAfter: 1}, 300*time.Millisecond)
+	_, err = fetch(&testutil.Peer{Info: info, Piece: func(i int, m []byte) []byte {
+		m[len(m)-1] ^= 0xff
+		return m
+	}}, 5*time.Second)
+	if fetchFailure(err) != FailHashMismatch || partials.Stats().Hashes != 0 {
+		t.Error("corrupt", err, partials.Stats())
+	}
+}
@@ -0,0 +1,131 @@
+package DHTCrawl
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPeerStoreSize = 100000
+	DefaultPeersPerHash  = 8
+)
+
+type (
+	// PeerStore remembers who announced which hash lately. It holds at most
+	// size hashes, the least recently announced one is forgotten first, and
+	// the last peersPerHash peers of every hash.
+	PeerStore struct {
+		mu           sync.Mutex
+		size         int
+		peersPerHash int
+		hashes       map[Hash]*list.Element
+		lru          *list.List
+	}
+
+	peerEntry struct {
+		hash      Hash
+		peers     []*net.TCPAddr
+		announces int
+		last      time.Time
+	}
+)
+
+func NewPeerStore(size, peersPerHash int) *PeerStore {
+	if size <= 0 {
+		size = DefaultPeerStoreSize
+	}
+	if peersPerHash <= 0 {
+		peersPerHash = DefaultPeersPerHash
+	}
+	return &PeerStore{
+		size:         size,
+		peersPerHash: peersPerHash,
+		hashes:       make(map[Hash]*list.Element),
+		lru:          list.New(),
+	}
+}
+
+// Add records an announce of hash by addr.
+func (s *PeerStore) Add(hash Hash, addr *net.TCPAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.hashes[hash]
+	if !ok {
+		el = s.lru.PushFront(&peerEntry{hash: hash})
+		s.hashes[hash] = el
+		if s.lru.Len() > s.size {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.hashes, oldest.Value.(*peerEntry).hash)
+		}
+	} else {
+		s.lru.MoveToFront(el)
+	}
+	e := el.Value.(*peerEntry)
+	e.announces++
+	e.last = time.Now()
+	if addr == nil {
+		return
+	}
+	for i, p := range e.peers {
+		if p.String() == addr.String() {
+			e.peers = append(e.peers[:i], e.peers[i+1:]...)
+			break
+		}
+	}
+	e.peers = append(e.peers, addr)
+	if len(e.peers) > s.peersPerHash {
+		e.peers = e.peers[len(e.peers)-s.peersPerHash:]
+	}
+}
+
+// Peers returns the known peers of hash, the most recent announcer first.
+func (s *PeerStore) Peers(hash Hash) []*net.TCPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.hashes[hash]
+	if !ok {
+		return nil
+	}
+	peers := el.Value.(*peerEntry).peers
+	out := make([]*net.TCPAddr, 0, len(peers))
+	for i := len(peers) - 1; i >= 0; i-- {
+		out = append(out, peers[i])
+	}
+	return out
+}
+
+// Announces returns how many times hash was announced since it was first seen.
+func (s *PeerStore) Announces(hash Hash) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.hashes[hash]; ok {
+		return el.Value.(*peerEntry).announces
+	}
+	return 0
+}
+
+func (s *PeerStore) Forget(hash Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.hashes[hash]; ok {
+		s.lru.Remove(el)
+		delete(s.hashes, hash)
+	}
+}
+
+func (s *PeerStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+// Reset forgets every hash.
+func (s *PeerStore) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hashes = make(map[Hash]*list.Element)
+	s.lru.Init()
+}
@@ -0,0 +1,243 @@
+package DHTCrawl
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The defaults of PeerScoreConfig.
+const (
+	DefaultBanScore    = -6
+	DefaultBanTime     = 10   //minutes of a first ban, doubled by every ban after it
+	DefaultMaxBanTime  = 1440 //minutes
+	DefaultScoreDecay  = 60   //minutes for a score to halve
+	DefaultScoredPeers = 100000
+
+	scoreThroughput = 64 << 10 //bytes per second of a transfer worth a full bonus
+)
+
+// scoreOf is what an attempt failing for a Fail reason costs its peer, the
+// ones sending corrupt data cost the most.
+var scoreOf = map[string]float64{
+	FailDial:          -1,
+	FailDialTimeout:   -1,
+	FailTimeout:       -1,
+	FailRejected:      -1,
+	FailNotBitTorrent: -2,
+	FailBadPiece:      -3,
+	FailHashMismatch:  -3,
+	FailDecode:        -3,
+	FailInvalid:       -3,
+	FailTooLarge:      -3,
+	FailOther:         -0.5,
+}
+
+type (
+	// PeerScoreConfig scores the peer addresses by how their downloads
+	// went. A success is worth 2 and up to 1 more for its throughput, a
+	// failure costs 0.5 to 3 by its reason, and the scores decay towards 0.
+	// The candidates of a job are tried from the best score, a peer under
+	// ban_score is skipped for ban_time, doubled by each ban up to
+	// max_ban_time.
+	PeerScoreConfig struct {
+		BanScore   float64 `json:"ban_score"`    //negative, 0 is DefaultBanScore
+		BanTime    int     `json:"ban_time"`     //minutes, 0 is DefaultBanTime
+		MaxBanTime int     `json:"max_ban_time"` //minutes, 0 is DefaultMaxBanTime
+		Decay      int     `json:"decay"`        //minutes for a score to halve, 0 is DefaultScoreDecay
+		Size       int     `json:"size"`         //peers remembered, 0 is DefaultScoredPeers
+	}
+
+	// PeerScoreStats counts the scored peers and the bans.
+	PeerScoreStats struct {
+		Peers   int    `json:"peers"`
+		Banned  int    `json:"banned"`  //bans running
+		Bans    uint64 `json:"bans"`    //started
+		Skipped uint64 `json:"skipped"` //candidates not tried for a ban
+	}
+
+	// PeerScores keeps the score of every peer address a download was
+	// attempted from, at most Size of them, the least recently observed
+	// goes first. A zero score, as the one of an unknown peer, is neutral.
+	PeerScores struct {
+		BanScore   float64
+		BanTime    time.Duration
+		MaxBanTime time.Duration
+		Decay      time.Duration
+		Size       int
+		Clock      Clock //nil is SystemClock
+
+		mu      sync.Mutex
+		peers   map[string]*list.Element //of peerScore in lru
+		lru     *list.List
+		bans    uint64
+		skipped uint64
+	}
+
+	// scoreSlot holds the scores of a pool, nil scores nothing.
+	scoreSlot struct {
+		mu     sync.RWMutex
+		scores *PeerScores
+	}
+
+	peerScore struct {
+		key    string
+		score  float64
+		at     time.Time //of score, it decays from there
+		until  time.Time //end of the ban, zero when not banned
+		strike uint      //bans so far, a success forgives them
+	}
+)
+
+// NewPeerScores applies the defaults of cfg.
+func NewPeerScores(cfg *PeerScoreConfig) *PeerScores {
+	s := &PeerScores{
+		BanScore:   cfg.BanScore,
+		BanTime:    time.Duration(cfg.BanTime) * time.Minute,
+		MaxBanTime: time.Duration(cfg.MaxBanTime) * time.Minute,
+		Decay:      time.Duration(cfg.Decay) * time.Minute,
+		Size:       cfg.Size,
+		peers:      map[string]*list.Element{},
+		lru:        list.New(),
+	}
+	if s.BanScore == 0 {
+		s.BanScore = DefaultBanScore
+	}
+	if s.BanTime == 0 {
+		s.BanTime = DefaultBanTime * time.Minute
+	}
+	if s.MaxBanTime == 0 {
+		s.MaxBanTime = DefaultMaxBanTime * time.Minute
+	}
+	if s.Decay == 0 {
+		s.Decay = DefaultScoreDecay * time.Minute
+	}
+	if s.Size == 0 {
+		s.Size = DefaultScoredPeers
+	}
+	return s
+}
+
+// Observe scores the attempt at addr which ended with err, timing and the
+// size of the metadata give the throughput of a success.
+func (s *PeerScores) Observe(addr *net.TCPAddr, err error, timing *FetchTiming, size int) {
+	if s == nil || addr == nil {
+		return
+	}
+	delta := 2.0
+	if err != nil {
+		delta = scoreOf[fetchFailure(err)]
+	} else if timing != nil && timing.Total > 0 {
+		delta += math.Min(1, float64(size)/timing.Total.Seconds()/scoreThroughput)
+	}
+	now := clockOr(s.Clock).Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := addr.String()
+	var p *peerScore
+	if el, ok := s.peers[key]; ok {
+		s.lru.MoveToFront(el)
+		p = el.Value.(*peerScore)
+	} else {
+		if len(s.peers) >= s.Size {
+			s.evict(now)
+		}
+		p = &peerScore{key: key, at: now}
+		s.peers[key] = s.lru.PushFront(p)
+	}
+	p.score = s.decayed(p, now) + delta
+	p.at = now
+	if err == nil {
+		p.strike = 0
+	}
+	if p.score <= s.BanScore && !p.until.After(now) {
+		ban := s.BanTime << p.strike
+		if ban > s.MaxBanTime || ban <= 0 {
+			ban = s.MaxBanTime
+		}
+		// on probation once the ban ends
+		p.until, p.score = now.Add(ban), s.BanScore/2
+		p.strike++
+		s.bans++
+		logWire.Debug("peer banned", "peer", key, "for", ban)
+	}
+}
+
+// Score returns the decayed score of addr, 0 for an unknown peer.
+func (s *PeerScores) Score(addr *net.TCPAddr) float64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.peers[addr.String()]
+	if !ok {
+		return 0
+	}
+	return s.decayed(el.Value.(*peerScore), clockOr(s.Clock).Now())
+}
+
+// Banned tells whether addr is skipped, the ban ends by itself.
+func (s *PeerScores) Banned(addr *net.TCPAddr) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.peers[addr.String()]
+	return ok && el.Value.(*peerScore).until.After(clockOr(s.Clock).Now())
+}
+
+func (s *PeerScores) Stats() PeerScoreStats {
+	now := clockOr(s.Clock).Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := PeerScoreStats{Peers: len(s.peers), Bans: s.bans, Skipped: atomic.LoadUint64(&s.skipped)}
+	for _, el := range s.peers {
+		if el.Value.(*peerScore).until.After(now) {
+			st.Banned++
+		}
+	}
+	return st
+}
+
+// skip counts a candidate not tried for its ban.
+func (s *PeerScores) skip() {
+	atomic.AddUint64(&s.skipped, 1)
+}
+
+func (s *PeerScores) decayed(p *peerScore, now time.Time) float64 {
+	return p.score * math.Exp2(-float64(now.Sub(p.at))/float64(s.Decay))
+}
+
+// evict makes room for a peer in a full table: it forgets the least
+// recently observed peer, and the ones before it as long as they aren't
+// banned and their score decayed to about 0.
+func (s *PeerScores) evict(now time.Time) {
+	for el := s.lru.Back(); el != nil; el = s.lru.Back() {
+		p := el.Value.(*peerScore)
+		if len(s.peers) < s.Size && (p.until.After(now) || math.Abs(s.decayed(p, now)) >= 0.1) {
+			return
+		}
+		s.lru.Remove(el)
+		delete(s.peers, p.key)
+	}
+}
+
+func (s *scoreSlot) set(scores *PeerScores) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scores = scores
+}
+
+func (s *scoreSlot) get() *PeerScores {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.scores
+}
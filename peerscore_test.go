@@ -0,0 +1,74 @@
+package DHTCrawl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_PeerScores(t *testing.T) {
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	s := NewPeerScores(&PeerScoreConfig{Size: 3})
+	s.Clock = clock
+	good := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1}
+	bad := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1}
+	slow := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 1}
+	corrupt := &FetchError{Failure: FailHashMismatch}
+
+	s.Observe(good, nil, &FetchTiming{Total: time.Millisecond}, 64<<10)
+	s.Observe(slow, &FetchError{Failure: FailTimeout}, nil, 0)
+	if got := s.Score(good); got != 3 {
+		t.Error("score of a fast success", got)
+	}
+	s.Observe(bad, corrupt, nil, 0)
+	s.Observe(bad, corrupt, nil, 0)
+	if !s.Banned(bad) || s.Banned(good) || s.Stats().Bans != 1 {
+		t.Errorf("ban %+v", s.Stats())
+	}
+
+	// the best candidate first, the banned one dropped
+	job := NewJob(testHash("scored"), nil)
+	for _, p := range []*net.TCPAddr{slow, bad, good} {
+		job.AddPeer(p)
+	}
+	if a, b, c := job.nextPeer(s), job.nextPeer(s), job.nextPeer(s); a != good || b != slow || c != nil {
+		t.Error("order", a, b, c)
+	}
+	if st := s.Stats(); st.Skipped != 1 || st.Banned != 1 {
+		t.Errorf("%+v", st)
+	}
+
+	// the ban ends by itself, the next one of the peer lasts twice as long
+	clock.Advance(DefaultBanTime*time.Minute + time.Second)
+	if s.Banned(bad) || s.Score(bad) >= 0 {
+		t.Error("ban not over or probation lost", s.Score(bad))
+	}
+	s.Observe(bad, corrupt, nil, 0)
+	s.Observe(bad, corrupt, nil, 0)
+	clock.Advance(DefaultBanTime*time.Minute + time.Second)
+	if !s.Banned(bad) || s.Stats().Bans != 2 {
+		t.Error("second ban shorter than the first")
+	}
+	clock.Advance(DefaultBanTime * time.Minute)
+	if s.Banned(bad) {
+		t.Error("second ban not over")
+	}
+
+	// the scores halve every decay, a full table forgets the faded ones
+	clock.Advance(10 * DefaultScoreDecay * time.Minute)
+	other := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 4), Port: 1}
+	s.Observe(other, nil, nil, 0)
+	if st := s.Stats(); st.Peers != 1 || s.Score(other) != 2 {
+		t.Errorf("sweep %+v", st)
+	}
+
+	// a full table of fresh scores still admits a new peer in place of the
+	// least recently observed one
+	s.Observe(good, nil, nil, 0)
+	s.Observe(slow, nil, nil, 0)
+	s.Observe(other, nil, nil, 0)
+	s.Observe(bad, nil, nil, 0)
+	if st := s.Stats(); st.Peers != 3 || s.Score(bad) != 2 || s.Score(good) != 0 || s.Score(other) == 0 {
+		t.Errorf("eviction %+v", st)
+	}
+}
@@ -0,0 +1,432 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+const (
+	DefaultPostgresBatch         = 500
+	DefaultPostgresFlushInterval = time.Second
+
+	postgresAttempts = 10  //failed flushes before a result is written alone, and dropped if that fails too
+	postgresPending  = 100 //batches buffered at most, Put fails past it
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// PostgresStore keeps results in PostgreSQL with the same tables as the
+// SQLite store. Writes are buffered and flushed with COPY once Batch rows
+// are pending or every FlushInterval; torrents are upserted on the info
+// hash so a refetch replaces the old row. An error from a background flush
+// is returned by the next Put or Flush. Names and paths are cleaned of the
+// invalid UTF-8 and the NUL bytes PostgreSQL refuses, and a result which
+// still fails on its own while the server is up is dropped.
+type PostgresStore struct {
+	Batch int
+
+	pool *pgxpool.Pool
+
+	mu        sync.Mutex
+	results   map[Hash]*MetadataResult
+	attempts  map[Hash]int //failed flushes of the pending results
+	announces []*Announce
+	err       error
+	flushing  sync.Mutex
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+// OpenPostgresStore connects with a pgx DSN, pool settings such as
+// pool_max_conns are taken from it.
+func OpenPostgresStore(dsn string) (*PostgresStore, error) {
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := stdlib.OpenDBFromPool(pool)
+	err = migrate(db, postgresMigrations, "migrations/postgres")
+	db.Close()
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	s := &PostgresStore{
+		Batch:    DefaultPostgresBatch,
+		pool:     pool,
+		results:  make(map[Hash]*MetadataResult),
+		attempts: make(map[Hash]int),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go s.flushLoop(DefaultPostgresFlushInterval)
+	return s, nil
+}
+
+// Pool exposes the connection pool for ad hoc queries.
+func (s *PostgresStore) Pool() *pgxpool.Pool {
+	return s.pool
+}
+
+func (s *PostgresStore) Put(r *MetadataResult) error {
+	s.mu.Lock()
+	if _, ok := s.results[r.Hash]; !ok && len(s.results) >= s.Batch*postgresPending {
+		n := len(s.results)
+		s.mu.Unlock()
+		return fmt.Errorf("postgres buffer full, %d results pending", n)
+	}
+	s.results[r.Hash] = r
+	full := len(s.results) >= s.Batch
+	err := s.err
+	s.err = nil
+	s.mu.Unlock()
+	if full {
+		if e := s.Flush(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *PostgresStore) PutAnnounce(a *Announce) error {
+	s.mu.Lock()
+	if len(s.announces) >= s.Batch*postgresPending {
+		// the oldest go first, like in the queues
+		s.announces = s.announces[1:]
+	}
+	s.announces = append(s.announces, a)
+	full := len(s.announces) >= s.Batch
+	s.mu.Unlock()
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Writable fails when the server is unreachable or is a read only replica.
+func (s *PostgresStore) Writable() error {
+	var readOnly string
+	if err := s.pool.QueryRow(context.Background(), `SHOW transaction_read_only`).Scan(&readOnly); err != nil {
+		return err
+	}
+	if readOnly == "on" {
+		return errors.New("postgres is read only")
+	}
+	return nil
+}
+
+func (s *PostgresStore) Has(hash Hash) (bool, error) {
+	s.mu.Lock()
+	_, pending := s.results[hash]
+	s.mu.Unlock()
+	if pending {
+		return true, nil
+	}
+	var has bool
+	err := s.pool.QueryRow(context.Background(), `SELECT EXISTS (SELECT 1 FROM torrents WHERE hash = $1)`, hash.Hex()).Scan(&has)
+	return has, err
+}
+
+func (s *PostgresStore) Get(hash Hash) (*MetadataResult, error) {
+	s.mu.Lock()
+	r, pending := s.results[hash]
+	s.mu.Unlock()
+	if pending {
+		return r, nil
+	}
+	var data []byte
+	err := s.pool.QueryRow(context.Background(), `SELECT data FROM torrents WHERE hash = $1`, hash.Hex()).Scan(&data)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeStored(hash, data)
+}
+
+func (s *PostgresStore) Iterate(fn func(*MetadataResult) bool) error {
+	rows, err := s.pool.Query(context.Background(), `SELECT hash, data FROM torrents ORDER BY created`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hex string
+		var data []byte
+		if err := rows.Scan(&hex, &data); err != nil {
+			return err
+		}
+		r, err := decodeStoredHex(hex, data)
+		if err != nil {
+			return err
+		}
+		if !fn(r) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// Query only sees flushed results.
+func (s *PostgresStore) Query(q TorrentQuery) ([]*MetadataResult, error) {
+	where, args := q.where(func(n int) string { return fmt.Sprintf("$%d", n) }, func(t time.Time) interface{} { return t })
+	query := `SELECT hash, data FROM torrents` + where + ` ORDER BY created DESC`
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	if q.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET %d", q.Offset)
+	}
+	rows, err := s.pool.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []*MetadataResult{}
+	for rows.Next() {
+		var hex string
+		var data []byte
+		if err := rows.Scan(&hex, &data); err != nil {
+			return nil, err
+		}
+		r, err := decodeStoredHex(hex, data)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// Stale only sees flushed results.
+func (s *PostgresStore) Stale(before time.Time, limit int) ([]Hash, error) {
+	rows, err := s.pool.Query(context.Background(), `SELECT hash FROM torrents WHERE checked IS NULL OR checked <= $1
+		ORDER BY checked NULLS FIRST LIMIT $2`, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := []Hash{}
+	for rows.Next() {
+		var hex string
+		if err := rows.Scan(&hex); err != nil {
+			return nil, err
+		}
+		hash, err := HashFromHex(hex)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+func (s *PostgresStore) flushLoop(interval time.Duration) {
+	defer close(s.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// Flush writes everything buffered. A failed batch is put back and retried
+// with the next flush, the results which failed postgresAttempts times are
+// written one by one.
+func (s *PostgresStore) Flush() error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	s.mu.Lock()
+	results, announces := s.results, s.announces
+	s.results, s.announces = make(map[Hash]*MetadataResult), nil
+	s.mu.Unlock()
+	if len(results) == 0 && len(announces) == 0 {
+		return nil
+	}
+
+	err := s.write(results, announces)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		for h := range results {
+			delete(s.attempts, h)
+		}
+		return nil
+	}
+	stuck := []Hash{}
+	for h, r := range results {
+		if _, ok := s.results[h]; !ok {
+			s.results[h] = r
+		}
+		if s.attempts[h]++; s.attempts[h] >= postgresAttempts {
+			stuck = append(stuck, h)
+		}
+	}
+	s.announces = append(announces, s.announces...)
+	if n := len(s.announces) - s.Batch*postgresPending; n > 0 {
+		s.announces = s.announces[n:]
+	}
+	if len(stuck) > 0 {
+		s.mu.Unlock()
+		s.isolate(stuck)
+		s.mu.Lock()
+	}
+	return err
+}
+
+// isolate writes the results of hashes alone, a result failing while the
+// server answers is at fault and dropped. Called with flushing held.
+func (s *PostgresStore) isolate(hashes []Hash) {
+	for _, h := range hashes {
+		s.mu.Lock()
+		r, ok := s.results[h]
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+		err := s.write(map[Hash]*MetadataResult{h: r}, nil)
+		if err != nil && s.pool.Ping(context.Background()) != nil {
+			// the server is down, not the row
+			return
+		}
+		if err != nil {
+			logSink.Warn("postgres result dropped", "infohash", h, "error", err)
+		}
+		s.mu.Lock()
+		if s.results[h] == r {
+			delete(s.results, h)
+			delete(s.attempts, h)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *PostgresStore) write(results map[Hash]*MetadataResult, announces []*Announce) error {
+	ctx := context.Background()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if len(results) != 0 {
+		torrents := [][]interface{}{}
+		files := [][]interface{}{}
+		hexes := []string{}
+		for _, r := range results {
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			hex := r.Hash.Hex()
+			hexes = append(hexes, hex)
+			var checked interface{} //NULL when never checked
+			if t := r.checked(); !t.IsZero() {
+				checked = t
+			}
+			torrents = append(torrents, []interface{}{hex, pgText(r.Name), r.TotalLength(), pgText(r.Category), r.Type, r.created(), pgJSON(data), r.alive(), checked})
+			for i, f := range r.Files {
+				files = append(files, []interface{}{hex, i, pgText(strings.Join(f.Path, "/")), f.Length})
+			}
+		}
+
+		if _, err := tx.Exec(ctx, `CREATE TEMP TABLE torrents_stage (LIKE torrents INCLUDING DEFAULTS) ON COMMIT DROP`); err != nil {
+			return err
+		}
+		columns := []string{"hash", "name", "length", "category", "type", "created", "data", "alive", "checked"}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"torrents_stage"}, columns, pgx.CopyFromRows(torrents)); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO torrents SELECT * FROM torrents_stage
+			ON CONFLICT (hash) DO UPDATE SET name = excluded.name, length = excluded.length,
+			category = excluded.category, type = excluded.type, data = excluded.data, alive = excluded.alive,
+			checked = excluded.checked`)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM files WHERE hash = ANY($1)`, hexes); err != nil {
+			return err
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"files"}, []string{"hash", "idx", "path", "length"}, pgx.CopyFromRows(files)); err != nil {
+			return err
+		}
+	}
+
+	if len(announces) != 0 {
+		rows := make([][]interface{}, 0, len(announces))
+		for _, a := range announces {
+			peer := ""
+			if a.Peer != nil {
+				peer = a.Peer.String()
+			}
+			geo := a.Geo
+			if geo == nil {
+				geo = &PeerGeo{}
+			}
+			rows = append(rows, []interface{}{a.Hash.Hex(), peer, a.Time, geo.Country, int64(geo.ASN)})
+		}
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"announces"}, []string{"hash", "peer", "seen_at", "country", "asn"}, pgx.CopyFromRows(rows)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// pgText makes s acceptable to a TEXT column: valid UTF-8 without NUL.
+func pgText(s string) string {
+	return strings.ToValidUTF8(strings.ReplaceAll(s, "\x00", ""), "\uFFFD")
+}
+
+// pgJSON drops the \u0000 escapes JSONB refuses from data, json.Marshal
+// already replaced the invalid UTF-8.
+func pgJSON(data []byte) []byte {
+	if !bytes.Contains(data, []byte(`\u0000`)) {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\\' || i+1 == len(data) {
+			out = append(out, data[i])
+			continue
+		}
+		// an escape, copied whole unless it is a NUL
+		if data[i+1] == 'u' && bytes.HasPrefix(data[i:], []byte(`\u0000`)) {
+			i += 5
+			continue
+		}
+		out = append(out, data[i], data[i+1])
+		i++
+	}
+	return out
+}
+
+func (s *PostgresStore) Close() error {
+	close(s.stop)
+	<-s.stopped
+	err := s.Flush()
+	s.pool.Close()
+	return err
+}
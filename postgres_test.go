@@ -0,0 +1,79 @@
+package DHTCrawl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Test_PostgresStore needs a scratch database, e.g.
+// DHTCRAWL_POSTGRES=postgres://localhost/dhtcrawl_test
+func Test_PostgresStore(t *testing.T) {
+	dsn := os.Getenv("DHTCRAWL_POSTGRES")
+	if dsn == "" {
+		t.Skip("DHTCRAWL_POSTGRES is not set")
+	}
+	s, err := OpenPostgresStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Batch = 2
+	h := Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"))
+	r := &MetadataResult{Hash: h, Name: "test", Files: []*File{{Path: []string{"a", "b.mkv"}, Length: 5}, {Path: []string{"c"}, Length: 6}}}
+	r.Categorize()
+	if err := s.Put(r); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := s.Has(h); !has {
+		t.Error("Has buffered hash")
+	}
+	s.PutAnnounce(&Announce{Hash: h, Peer: &net.TCPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 1}, Time: time.Now()})
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// a second flush of the same hash is an upsert
+	if err := s.Put(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Error("Upsert", err)
+	}
+	got, err := s.Get(h)
+	if err != nil || got.Name != "test" || got.Category != CategoryVideo {
+		t.Error("Get stored hash", got, err)
+	}
+	var files int64
+	s.Pool().QueryRow(context.Background(), `SELECT COUNT(1) FROM files WHERE hash = $1`, h.Hex()).Scan(&files)
+	if files != 2 {
+		t.Error("Files", files)
+	}
+	if _, err := s.Get(testHash("unknown")); err != ErrNotFound {
+		t.Error("Get unknown", err)
+	}
+
+	// a name PostgreSQL refuses as is doesn't block the batch
+	bad := &MetadataResult{Hash: testHash("bad"), Name: "bad\x00name\xff", Files: []*File{{Path: []string{"a\x00", "\xfe.mkv"}, Length: 1}}}
+	s.Put(bad)
+	s.Put(&MetadataResult{Hash: testHash("good"), Name: "good"})
+	if err := s.Flush(); err != nil {
+		t.Fatal("invalid UTF-8 and NUL", err)
+	}
+	if got, err := s.Get(testHash("bad")); err != nil || got.Name != "badname\uFFFD" {
+		t.Errorf("cleaned name %q %v", got.Name, err)
+	}
+}
+
+func Test_PostgresText(t *testing.T) {
+	if got := pgText("a\x00b\xffc"); got != "ab\uFFFDc" {
+		t.Errorf("%q", got)
+	}
+	data, _ := json.Marshal(map[string]string{"name": "a\x00b\xff", "path": `c:\u0000`})
+	var v map[string]string
+	if err := json.Unmarshal(pgJSON(data), &v); err != nil || v["name"] != "ab\uFFFD" || v["path"] != `c:\u0000` {
+		t.Errorf("%s %v %v", pgJSON(data), v, err)
+	}
+}
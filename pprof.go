@@ -0,0 +1,32 @@
+package DHTCrawl
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofHandler serves net/http/pprof under /debug/pprof/. Whether it
+// answers is read from the running config on every request, so profiling
+// can be switched on and off with a config reload. With a pprof_token the
+// caller must send it as a bearer token, without one the API keys guard it
+// like the other routes.
+func (s *Server) pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.Crawler.config()
+		if !cfg.Pprof {
+			http.NotFound(w, r)
+			return
+		}
+		if cfg.PprofToken == "" {
+			s.public(mux.ServeHTTP)(w, r)
+		} else if authorized(w, r, cfg.PprofToken) {
+			mux.ServeHTTP(w, r)
+		}
+	})
+}
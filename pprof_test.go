@@ -0,0 +1,44 @@
+package DHTCrawl
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Pprof(t *testing.T) {
+	c := &Crawler{Config: NewDefaultConfig(), Hub: NewHub()}
+	s := NewServer(c, "")
+	get := func(token string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		s.Mux.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := get(""); code != 404 {
+		t.Error("disabled", code)
+	}
+
+	cfg := NewDefaultConfig()
+	cfg.Pprof = true
+	cfg.PprofToken = "secret"
+	c.Config = cfg
+	if code := get(""); code != 401 {
+		t.Error("no token", code)
+	}
+	if code := get("secret"); code != 200 {
+		t.Error("token", code)
+	}
+
+	// without a pprof_token the API keys apply
+	cfg.PprofToken = ""
+	c.Auth = NewAuthenticator([]APIKey{{Name: "ops", Key: "key"}})
+	if code := get(""); code != 401 {
+		t.Error("no key", code)
+	}
+	if code := get("key"); code != 200 {
+		t.Error("key", code)
+	}
+}
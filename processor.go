@@ -0,0 +1,422 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+
+	"github.com/zeebo/bencode"
+)
+
+// Processor is the state machine of a metadata download from one peer, the
+// BitTorrent handshake, the extended handshake, ut_metadata and ut_pex. It
+// doesn't know its transport: Start queues the handshake on the attached
+// conn, the bytes of the peer are fed with Feed or read by Next, which
+// returns the events in the order they happened. A Processor is used by one
+// goroutine at a time, for one download.
+type Processor struct {
+	Hash Hash
+
+	Data []byte //read but not handled yet
+	Size int
+
+	Handler     DataHandler
+	HandlerSize int
+
+	maxMessage  int //limits of the download, see wireLimits
+	maxMetadata int
+	maxItems    int
+	aborted     bool //a limit was broken, the rest of the stream is dropped
+
+	utmetadata int
+	client     string           //of the peer id, sent before EventHandshake
+	metadata   []byte           //metadata_size bytes, filled piece by piece
+	received   []bool           //by piece
+	missing    int              //pieces not received yet
+	resumed    *PartialMetadata //of a previous attempt, until the extended handshake takes it
+	reused     int              //pieces of resumed not requested again
+	exchanged  int              //peers sent over ut_pex
+
+	events []*Event //made by Feed, not taken by Next yet
+	buf    []byte   //of the reads of Next
+
+	Conn io.Writer //transport of what is sent, see Attach
+}
+
+// ErrNotStarted is returned by Feed and Write before Start.
+var ErrNotStarted = errors.New("download not started")
+
+func NewProcessor() *Processor {
+	p := &Processor{}
+	p.maxMessage, p.maxMetadata, p.maxItems = (*wireLimits)(nil).get()
+	return p
+}
+
+// Attach makes conn the transport of the download: what the Processor sends
+// is written to it, and Next reads from it when it is an io.Reader too. A
+// conn which is an io.Closer is closed once the download is over.
+func (p *Processor) Attach(conn io.Writer) {
+	p.Conn = conn
+}
+
+// Feed hands the bytes the peer sent to the state machine, the events they
+// make are queued for Next. It returns ErrNotStarted before Start and
+// net.ErrClosed once a limit was broken, the rest of the stream is dropped
+// then.
+func (p *Processor) Feed(data []byte) error {
+	_, err := p.Write(data)
+	return err
+}
+
+// Next returns the next event of the download. When none is queued it reads
+// from the attached conn until the bytes make one or the read fails, without
+// a conn to read from it returns nil.
+func (p *Processor) Next() (*Event, error) {
+	for len(p.events) == 0 {
+		r, ok := p.Conn.(io.Reader)
+		if !ok || p.aborted {
+			return nil, nil
+		}
+		if p.buf == nil {
+			p.buf = make([]byte, 1024)
+		}
+		n, err := r.Read(p.buf)
+		if n > 0 {
+			if _, err := p.Write(p.buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if err != nil && len(p.events) == 0 {
+			return nil, err
+		}
+	}
+	event := p.events[0]
+	p.events[0] = nil
+	p.events = p.events[1:]
+	return event, nil
+}
+
+// Write hands the messages in data to the handlers, keeping what is left
+// of an incomplete one. A handler must not keep the slice it is given. What
+// is kept is less than a message, which handleHead bounds.
+func (p *Processor) Write(data []byte) (int, error) {
+	if p.aborted {
+		return 0, net.ErrClosed
+	}
+	if p.Handler == nil {
+		return 0, ErrNotStarted
+	}
+	p.Data = append(p.Data, data...)
+	off := 0
+	for len(p.Data)-off >= p.HandlerSize {
+		msg := p.Data[off : off+p.HandlerSize]
+		off += p.HandlerSize
+		p.Handler(msg)
+		if p.aborted {
+			return len(data), nil
+		}
+	}
+	p.Data = append(p.Data[:0], p.Data[off:]...)
+	p.Size = len(p.Data)
+	return len(data), nil
+}
+
+// Resume starts the download from the pieces m holds, only the missing
+// ones are requested if the peer has metadata of the same size. Call it
+// before Start, nil starts from scratch.
+func (p *Processor) Resume(m *PartialMetadata) {
+	p.resumed = m
+}
+
+// Partial returns what the download received so far, for another attempt
+// to resume from. It is the metadata given to Resume when the download
+// didn't add to it, nil once the metadata is complete or without pieces.
+func (p *Processor) Partial() *PartialMetadata {
+	if p.metadata == nil || p.missing == 0 || p.missing == len(p.received) {
+		return p.resumed
+	}
+	return &PartialMetadata{Hash: p.Hash, Size: len(p.metadata), Data: p.metadata, Received: p.received}
+}
+
+func (p *Processor) Start(hash Hash) {
+	p.Hash = hash
+	p.push(p.packetHandshakeData())
+	p.handleHandshake()
+}
+
+func (p *Processor) process(size int, handler DataHandler) {
+	p.HandlerSize = size
+	p.Handler = handler
+}
+
+func (p *Processor) End(reason string) {
+	p.fail(FailOther, reason)
+}
+
+// fail ends the download for one of the Fail reasons.
+func (p *Processor) fail(failure, reason string) {
+	event := NewErrorEvent(reason, p.Hash)
+	event.Failure = failure
+	p.emit(event)
+}
+
+// abort ends the download for an err wrapping ErrLimitExceeded and drops
+// the connection, nothing more of the peer is buffered.
+func (p *Processor) abort(err error) {
+	p.aborted = true
+	p.Data = nil
+	p.close()
+	event := NewErrorEvent(err.Error(), p.Hash)
+	event.Failure, event.Err = FailTooLarge, err
+	p.emit(event)
+}
+
+func (p *Processor) handleHandshake() {
+	p.process(1, func(data []byte) {
+		length := int(data[0])
+		p.process(length+48, func(data []byte) {
+			protocol := data[:length]
+			if string(protocol) != BtProtocol {
+				p.fail(FailNotBitTorrent, "this is not BitTorrent protocol")
+				return
+			}
+			reserved := data[length:]
+			if reserved[5]&0x10 == 0 {
+				p.fail(FailRejected, "peer reject")
+				return
+			}
+			p.client = peerClient(data[length+28:])
+			p.emit(&Event{Type: EventHandshake})
+			p.process(4, p.handleHead)
+			p.push(p.packetExtendedData())
+		})
+	})
+}
+
+func (p *Processor) handleHead(data []byte) {
+	var length uint32
+	binary.Read(bytes.NewReader(data), binary.BigEndian, &length)
+	if int64(length) > int64(p.maxMessage) {
+		p.abort(fmt.Errorf("%w: message of %d bytes, the limit is %d", ErrLimitExceeded, length, p.maxMessage))
+		return
+	}
+	if int(length) > 0 {
+		p.process(int(length), p.handleBody)
+	}
+}
+
+func (p *Processor) handleBody(data []byte) {
+	p.process(4, p.handleHead)
+	//an extended message without its id is ignored
+	if data[0] == BtMessageID && len(data) > 1 {
+		p.handleExtended(data[1], data[2:])
+	}
+}
+
+func (p *Processor) handleExtended(ext byte, data []byte) {
+	if ext == byte(0) {
+		val := make(map[string]interface{})
+		err := decodeBencode(data, &val, p.maxItems)
+		if errors.Is(err, ErrLimitExceeded) {
+			p.abort(err)
+			return
+		}
+		if err != nil {
+			p.fail(FailBadPiece, fmt.Sprintf("decode extended meta info error %s", err.Error()))
+			return
+		}
+		p.handleExtHandshake(val)
+	} else if ext == UtPexID {
+		p.handlePex(data)
+	} else {
+		p.handlePiece(data)
+	}
+}
+
+// handlePex reads the peers added to the swarm (BEP 11), a malformed
+// message is ignored as the download doesn't need it.
+func (p *Processor) handlePex(data []byte) {
+	var msg struct {
+		Added  string `bencode:"added"`
+		Added6 string `bencode:"added6"`
+	}
+	if p.exchanged >= pexMaxPeers || decodeBencode(data, &msg, p.maxItems) != nil {
+		return
+	}
+	peers := append(decodeCompactPeers([]byte(msg.Added), CompactPeerLen), decodeCompactPeers([]byte(msg.Added6), CompactPeer6Len)...)
+	peers = peers[:min(len(peers), pexMaxPeers-p.exchanged)]
+	if len(peers) == 0 {
+		return
+	}
+	p.exchanged += len(peers)
+	p.emit(&Event{Type: EventPeers, Hash: p.Hash, Peers: peers})
+}
+
+func (p *Processor) handleExtHandshake(ext map[string]interface{}) {
+	p.emit(&Event{Type: EventExtended})
+	if size, ok := ext["metadata_size"].(int64); ok {
+		if m, ok := ext["m"].(map[string]interface{}); ok {
+			if meta, ok := m["ut_metadata"].(int64); ok {
+				p.utmetadata = int(meta)
+
+				if p.utmetadata == 0 || size <= 0 {
+					p.fail(FailRejected, fmt.Sprintf("extended invalid metadata_size:%d, ut_metadata:%d", size, p.utmetadata))
+					return
+				}
+				if size > int64(p.maxMetadata) {
+					p.abort(fmt.Errorf("%w: metadata_size %d, the limit is %d", ErrLimitExceeded, size, p.maxMetadata))
+					return
+				}
+
+				pieces := int(math.Ceil(float64(size) / float64(PieceSize)))
+				if r := p.resumed; r != nil && r.Hash == p.Hash && r.Size == int(size) && len(r.Received) == pieces {
+					p.metadata, p.received, p.missing = r.Data, r.Received, r.Missing()
+					p.reused, p.resumed = pieces-p.missing, nil
+				} else {
+					p.metadata = make([]byte, size)
+					p.received = make([]bool, pieces)
+					p.missing = pieces
+				}
+				for i := 0; i < pieces; i++ {
+					if !p.received[i] {
+						p.push(p.packetPieceRequestData(i))
+					}
+				}
+				if p.isDone() {
+					p.handleDone()
+				}
+				return
+			}
+		}
+	}
+	//the peer would never send a piece
+	p.fail(FailRejected, "no ut_metadata in the extended handshake")
+}
+
+func (p *Processor) handlePiece(data []byte) {
+	p.emit(&Event{Type: EventPiece})
+	h, i, err := readPieceHeader(data)
+	if err != nil {
+		p.fail(FailBadPiece, fmt.Sprintf("decode piece dict error, %s", err.Error()))
+		return
+	}
+	piece := data[i:]
+
+	if h.msgType != 1 {
+		p.fail(FailBadPiece, fmt.Sprintf("invalid msg_type: %d", h.msgType))
+		return
+	}
+
+	if h.piece < 0 || h.piece >= int64(len(p.received)) {
+		p.fail(FailBadPiece, "invalid piece")
+		return
+	}
+
+	//every piece is PieceSize but the last one, which holds the rest
+	start := int(h.piece) * PieceSize
+	if len(piece) != min(PieceSize, len(p.metadata)-start) {
+		p.fail(FailBadPiece, "invalid piece size")
+		return
+	}
+
+	copy(p.metadata[start:], piece)
+	if !p.received[h.piece] {
+		p.received[h.piece] = true
+		p.missing--
+	}
+	if p.isDone() {
+		p.handleDone()
+	}
+}
+
+func (p *Processor) isDone() (b bool) {
+	return p.metadata != nil && p.missing == 0
+}
+
+func (p *Processor) handleDone() {
+	p.emit(&Event{Type: EventVerify})
+	data := p.metadata
+	s := sha1.Sum(data)
+	if Hash(s) != p.Hash {
+		p.fail(FailHashMismatch, "metadata hash mismatch")
+		return
+	}
+	result := new(MetadataResult)
+	//an info dictionary may have many files but never a deep nesting
+	err := decodeBencode(data, result, 0)
+	if err != nil {
+		p.fail(FailDecode, fmt.Sprintf("Decode metadata error %s", err.Error()))
+		return
+	}
+	if err := result.Validate(); err != nil {
+		event := NewErrorEvent(err.Error(), p.Hash)
+		event.Failure, event.Err = FailInvalid, err
+		p.emit(event)
+		return
+	}
+	result.Hash = p.Hash
+	result.Info = data
+	p.close()
+	p.emit(&Event{Type: EventDone, Result: result})
+}
+
+func (p *Processor) packetHandshakeData() []byte {
+	data := bytes.NewBuffer([]byte{})
+	data.WriteByte(byte(0x13))
+	data.WriteString(BtProtocol)
+	data.Write(BtReserved)
+	data.Write(p.Hash[:])
+	data.Write([]byte(NewNodeID()))
+	return data.Bytes()
+}
+
+func (p *Processor) packetExtendedData() []byte {
+	body := bytes.NewBuffer([]byte{})
+	body.WriteByte(BtMessageID)
+	body.WriteByte(BtExtendedID)
+
+	meta, _ := bencode.EncodeBytes(map[string]interface{}{"m": map[string]interface{}{"ut_metadata": UtMetadataID, "ut_pex": UtPexID}})
+	body.Write(meta)
+
+	data := bytes.NewBuffer([]byte{})
+	binary.Write(data, binary.BigEndian, uint32(body.Len()))
+	data.Write(body.Bytes())
+
+	return data.Bytes()
+}
+
+func (p *Processor) packetPieceRequestData(i int) []byte {
+	body := bytes.NewBuffer([]byte{})
+	body.WriteByte(BtMessageID)
+	body.WriteByte(byte(p.utmetadata))
+
+	meta, _ := bencode.EncodeBytes(map[string]interface{}{"msg_type": 0, "piece": i})
+	body.Write(meta)
+
+	data := bytes.NewBuffer([]byte{})
+	binary.Write(data, binary.BigEndian, uint32(body.Len()))
+	data.Write(body.Bytes())
+
+	return data.Bytes()
+}
+
+func (p *Processor) push(b []byte) {
+	if p.Conn != nil {
+		p.Conn.Write(b)
+	}
+}
+
+func (p *Processor) emit(event *Event) {
+	p.events = append(p.events, event)
+}
+
+func (p *Processor) close() {
+	if c, ok := p.Conn.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,115 @@
+package DHTCrawl
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+func Test_Processor(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "codec.mkv", "length": 1024, "piece length": 16384, "pieces": ""})
+	hash, addr := fakePeer(t, info)
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+
+	// the conn read by Next
+	conn := dial()
+	defer conn.Close()
+	p := NewProcessor()
+	p.Attach(conn)
+	p.Start(hash)
+	var types []int
+	var result *MetadataResult
+	for result == nil {
+		event, err := p.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Type == EventError {
+			t.Fatal(event.Reason)
+		}
+		types = append(types, event.Type)
+		result = event.Result
+	}
+	want := []int{EventHandshake, EventExtended, EventPiece, EventVerify, EventDone}
+	if len(types) != len(want) {
+		t.Fatal("events", types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Error("events", types)
+		}
+	}
+	if result.Name != "codec.mkv" || !result.Verify() {
+		t.Error("result", result.Name)
+	}
+
+	// the bytes read by the caller, the Processor only writes
+	conn = dial()
+	defer conn.Close()
+	p = NewProcessor()
+	p.Attach(struct{ io.Writer }{conn})
+	p.Start(hash)
+	buf := make([]byte, 512)
+	for result = nil; result == nil; {
+		event, err := p.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event == nil {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Feed(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if event.Type == EventError {
+			t.Fatal(event.Reason)
+		}
+		result = event.Result
+	}
+	if result.Name != "codec.mkv" {
+		t.Error("fed result", result.Name)
+	}
+}
+
+func Test_ProcessorShortMessage(t *testing.T) {
+	hash := testHash("short")
+	p := NewProcessor()
+	if err := p.Feed([]byte{0}); err != ErrNotStarted {
+		t.Error("feed before start", err)
+	}
+	p.Attach(io.Discard)
+	p.Start(hash)
+	peer := &Processor{Hash: hash}
+	handshake, _ := bencode.EncodeBytes(map[string]interface{}{"m": map[string]interface{}{}})
+	data := append(peer.packetHandshakeData(), 0, 0, 0, 1, BtMessageID)
+	data = append(data, 0, 0, 0, byte(len(handshake)+2), BtMessageID, BtExtendedID)
+	if err := p.Feed(append(data, handshake...)); err != nil {
+		t.Fatal(err)
+	}
+	var types []int
+	for {
+		event, _ := p.Next()
+		if event == nil {
+			break
+		}
+		types = append(types, event.Type)
+	}
+	// the extended message of one byte is skipped, the handshake after it read
+	if len(types) != 3 || types[0] != EventHandshake || types[1] != EventExtended || types[2] != EventError {
+		t.Error("events", types)
+	}
+}
@@ -0,0 +1,104 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	dhtcrawlpb "bitbucket.org/AlanYang/DHTCrawl/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// checkFormat validates the message format of a stream sink, "" is json.
+func checkFormat(format string) error {
+	switch format {
+	case "", "json", "protobuf":
+		return nil
+	}
+	return fmt.Errorf("unknown message format %q", format)
+}
+
+func encodeResult(format string, r *MetadataResult) ([]byte, error) {
+	if format == "protobuf" {
+		return r.MarshalProto(), nil
+	}
+	return json.Marshal(r)
+}
+
+func encodeAnnounce(format string, a *Announce) ([]byte, error) {
+	if format == "protobuf" {
+		return a.MarshalProto(), nil
+	}
+	peer := ""
+	if a.Peer != nil {
+		peer = a.Peer.String()
+	}
+	m := map[string]interface{}{"hash": a.Hash.Hex(), "peer": peer, "time": a.Time.Format(time.RFC3339)}
+	if a.Geo != nil {
+		m["geo"] = a.Geo
+	}
+	return json.Marshal(m)
+}
+
+// Proto converts r to the Metadata message of proto/dhtcrawl.proto.
+func (r *MetadataResult) Proto() *dhtcrawlpb.Metadata {
+	m := &dhtcrawlpb.Metadata{
+		Hash:     r.Hash[:],
+		Name:     r.Name,
+		Length:   r.TotalLength(),
+		Category: r.Category,
+		Type:     int32(r.Type),
+		Tags:     r.Tags,
+		Created:  r.Create,
+		Peers:    int32(r.Peers),
+		Schema:   MetadataSchema,
+		Hex:      r.Hash.Hex(),
+		Magnet:   r.Magnet(),
+		Source:   r.Source,
+	}
+	if g := r.SourceGeo; g != nil {
+		m.SourceGeo = &dhtcrawlpb.Geo{Country: g.Country, Asn: uint32(g.ASN), Org: g.Org}
+	}
+	for _, f := range r.Files {
+		m.Files = append(m.Files, &dhtcrawlpb.File{Path: strings.Join(f.Path, "/"), Length: f.Length})
+	}
+	return m
+}
+
+func (r *MetadataResult) MarshalProto() []byte {
+	data, _ := proto.Marshal(r.Proto())
+	return data
+}
+
+// Proto converts a to the Announce message of proto/dhtcrawl.proto.
+func (a *Announce) Proto() *dhtcrawlpb.Announce {
+	m := &dhtcrawlpb.Announce{Hash: a.Hash[:], Time: a.Time.Unix()}
+	if a.Peer != nil {
+		m.Peer = a.Peer.String()
+	}
+	return m
+}
+
+func (a *Announce) MarshalProto() []byte {
+	data, _ := proto.Marshal(a.Proto())
+	return data
+}
+
+func (st *CrawlerStats) Proto() *dhtcrawlpb.Stats {
+	m := &dhtcrawlpb.Stats{
+		Workers:   int32(st.Workers),
+		Busy:      int32(st.Busy),
+		InFlight:  int32(st.InFlight),
+		Succeeded: st.Succeeded,
+		Failed:    st.Failed,
+		Limited:   st.Limited,
+		Filtered:  st.Filtered,
+		Rejected:  st.Rejected,
+		Stored:    int64(st.Stored),
+	}
+	for _, n := range st.Nodes {
+		m.Nodes = append(m.Nodes, &dhtcrawlpb.Node{Addr: n.Addr, Nodes: int32(n.Nodes)})
+	}
+	return m
+}
@@ -0,0 +1,922 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v3.21.12
+// source: dhtcrawl.proto
+
+package dhtcrawlpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type File struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Length        int64                  `protobuf:"varint,2,opt,name=length,proto3" json:"length,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *File) Reset() {
+	*x = File{}
+	mi := &file_dhtcrawl_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *File) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*File) ProtoMessage() {}
+
+func (x *File) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use File.ProtoReflect.Descriptor instead.
+func (*File) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *File) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *File) GetLength() int64 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+type Metadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hash          []byte                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Length        int64                  `protobuf:"varint,3,opt,name=length,proto3" json:"length,omitempty"`
+	Category      string                 `protobuf:"bytes,4,opt,name=category,proto3" json:"category,omitempty"`
+	Type          int32                  `protobuf:"varint,5,opt,name=type,proto3" json:"type,omitempty"`
+	Tags          []string               `protobuf:"bytes,6,rep,name=tags,proto3" json:"tags,omitempty"`
+	Created       string                 `protobuf:"bytes,7,opt,name=created,proto3" json:"created,omitempty"`
+	Peers         int32                  `protobuf:"varint,8,opt,name=peers,proto3" json:"peers,omitempty"`
+	Files         []*File                `protobuf:"bytes,9,rep,name=files,proto3" json:"files,omitempty"`
+	Schema        uint32                 `protobuf:"varint,10,opt,name=schema,proto3" json:"schema,omitempty"`
+	Hex           string                 `protobuf:"bytes,11,opt,name=hex,proto3" json:"hex,omitempty"`
+	Magnet        string                 `protobuf:"bytes,12,opt,name=magnet,proto3" json:"magnet,omitempty"`
+	Source        string                 `protobuf:"bytes,13,opt,name=source,proto3" json:"source,omitempty"`
+	SourceGeo     *Geo                   `protobuf:"bytes,14,opt,name=source_geo,json=sourceGeo,proto3" json:"source_geo,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Metadata) Reset() {
+	*x = Metadata{}
+	mi := &file_dhtcrawl_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Metadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metadata) ProtoMessage() {}
+
+func (x *Metadata) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metadata.ProtoReflect.Descriptor instead.
+func (*Metadata) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Metadata) GetHash() []byte {
+	if x != nil {
+		return x.Hash
+	}
+	return nil
+}
+
+func (x *Metadata) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Metadata) GetLength() int64 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *Metadata) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *Metadata) GetType() int32 {
+	if x != nil {
+		return x.Type
+	}
+	return 0
+}
+
+func (x *Metadata) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Metadata) GetCreated() string {
+	if x != nil {
+		return x.Created
+	}
+	return ""
+}
+
+func (x *Metadata) GetPeers() int32 {
+	if x != nil {
+		return x.Peers
+	}
+	return 0
+}
+
+func (x *Metadata) GetFiles() []*File {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+func (x *Metadata) GetSchema() uint32 {
+	if x != nil {
+		return x.Schema
+	}
+	return 0
+}
+
+func (x *Metadata) GetHex() string {
+	if x != nil {
+		return x.Hex
+	}
+	return ""
+}
+
+func (x *Metadata) GetMagnet() string {
+	if x != nil {
+		return x.Magnet
+	}
+	return ""
+}
+
+func (x *Metadata) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *Metadata) GetSourceGeo() *Geo {
+	if x != nil {
+		return x.SourceGeo
+	}
+	return nil
+}
+
+type Geo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Country       string                 `protobuf:"bytes,1,opt,name=country,proto3" json:"country,omitempty"`
+	Asn           uint32                 `protobuf:"varint,2,opt,name=asn,proto3" json:"asn,omitempty"`
+	Org           string                 `protobuf:"bytes,3,opt,name=org,proto3" json:"org,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Geo) Reset() {
+	*x = Geo{}
+	mi := &file_dhtcrawl_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Geo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Geo) ProtoMessage() {}
+
+func (x *Geo) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Geo.ProtoReflect.Descriptor instead.
+func (*Geo) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Geo) GetCountry() string {
+	if x != nil {
+		return x.Country
+	}
+	return ""
+}
+
+func (x *Geo) GetAsn() uint32 {
+	if x != nil {
+		return x.Asn
+	}
+	return 0
+}
+
+func (x *Geo) GetOrg() string {
+	if x != nil {
+		return x.Org
+	}
+	return ""
+}
+
+type Announce struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hash          []byte                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Peer          string                 `protobuf:"bytes,2,opt,name=peer,proto3" json:"peer,omitempty"`
+	Time          int64                  `protobuf:"varint,3,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Announce) Reset() {
+	*x = Announce{}
+	mi := &file_dhtcrawl_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Announce) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Announce) ProtoMessage() {}
+
+func (x *Announce) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Announce.ProtoReflect.Descriptor instead.
+func (*Announce) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Announce) GetHash() []byte {
+	if x != nil {
+		return x.Hash
+	}
+	return nil
+}
+
+func (x *Announce) GetPeer() string {
+	if x != nil {
+		return x.Peer
+	}
+	return ""
+}
+
+func (x *Announce) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+type StreamRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Category      string                 `protobuf:"bytes,1,opt,name=category,proto3" json:"category,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamRequest) Reset() {
+	*x = StreamRequest{}
+	mi := &file_dhtcrawl_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamRequest) ProtoMessage() {}
+
+func (x *StreamRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamRequest.ProtoReflect.Descriptor instead.
+func (*StreamRequest) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamRequest) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+type GetTorrentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Hash          string                 `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTorrentRequest) Reset() {
+	*x = GetTorrentRequest{}
+	mi := &file_dhtcrawl_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTorrentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTorrentRequest) ProtoMessage() {}
+
+func (x *GetTorrentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTorrentRequest.ProtoReflect.Descriptor instead.
+func (*GetTorrentRequest) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetTorrentRequest) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+type QueryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Category      string                 `protobuf:"bytes,1,opt,name=category,proto3" json:"category,omitempty"`
+	MinSize       int64                  `protobuf:"varint,2,opt,name=min_size,json=minSize,proto3" json:"min_size,omitempty"`
+	MaxSize       int64                  `protobuf:"varint,3,opt,name=max_size,json=maxSize,proto3" json:"max_size,omitempty"`
+	Since         int64                  `protobuf:"varint,4,opt,name=since,proto3" json:"since,omitempty"`
+	Until         int64                  `protobuf:"varint,5,opt,name=until,proto3" json:"until,omitempty"`
+	Offset        int32                  `protobuf:"varint,6,opt,name=offset,proto3" json:"offset,omitempty"`
+	Limit         int32                  `protobuf:"varint,7,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRequest) Reset() {
+	*x = QueryRequest{}
+	mi := &file_dhtcrawl_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRequest) ProtoMessage() {}
+
+func (x *QueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRequest.ProtoReflect.Descriptor instead.
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *QueryRequest) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *QueryRequest) GetMinSize() int64 {
+	if x != nil {
+		return x.MinSize
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetMaxSize() int64 {
+	if x != nil {
+		return x.MaxSize
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetSince() int64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetUntil() int64 {
+	if x != nil {
+		return x.Until
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *QueryRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type TorrentList struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Torrents      []*Metadata            `protobuf:"bytes,1,rep,name=torrents,proto3" json:"torrents,omitempty"`
+	Next          int32                  `protobuf:"varint,2,opt,name=next,proto3" json:"next,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TorrentList) Reset() {
+	*x = TorrentList{}
+	mi := &file_dhtcrawl_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TorrentList) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TorrentList) ProtoMessage() {}
+
+func (x *TorrentList) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TorrentList.ProtoReflect.Descriptor instead.
+func (*TorrentList) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TorrentList) GetTorrents() []*Metadata {
+	if x != nil {
+		return x.Torrents
+	}
+	return nil
+}
+
+func (x *TorrentList) GetNext() int32 {
+	if x != nil {
+		return x.Next
+	}
+	return 0
+}
+
+type StatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
+	mi := &file_dhtcrawl_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsRequest) ProtoMessage() {}
+
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{8}
+}
+
+type Node struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Addr          string                 `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	Nodes         int32                  `protobuf:"varint,2,opt,name=nodes,proto3" json:"nodes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Node) Reset() {
+	*x = Node{}
+	mi := &file_dhtcrawl_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Node) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Node) ProtoMessage() {}
+
+func (x *Node) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Node.ProtoReflect.Descriptor instead.
+func (*Node) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Node) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *Node) GetNodes() int32 {
+	if x != nil {
+		return x.Nodes
+	}
+	return 0
+}
+
+type Stats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Nodes         []*Node                `protobuf:"bytes,1,rep,name=nodes,proto3" json:"nodes,omitempty"`
+	Workers       int32                  `protobuf:"varint,2,opt,name=workers,proto3" json:"workers,omitempty"`
+	Busy          int32                  `protobuf:"varint,3,opt,name=busy,proto3" json:"busy,omitempty"`
+	InFlight      int32                  `protobuf:"varint,4,opt,name=in_flight,json=inFlight,proto3" json:"in_flight,omitempty"`
+	Succeeded     uint64                 `protobuf:"varint,5,opt,name=succeeded,proto3" json:"succeeded,omitempty"`
+	Failed        uint64                 `protobuf:"varint,6,opt,name=failed,proto3" json:"failed,omitempty"`
+	Limited       uint64                 `protobuf:"varint,7,opt,name=limited,proto3" json:"limited,omitempty"`
+	Filtered      uint64                 `protobuf:"varint,8,opt,name=filtered,proto3" json:"filtered,omitempty"`
+	Rejected      uint64                 `protobuf:"varint,9,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	Stored        int64                  `protobuf:"varint,10,opt,name=stored,proto3" json:"stored,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_dhtcrawl_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_dhtcrawl_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_dhtcrawl_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Stats) GetNodes() []*Node {
+	if x != nil {
+		return x.Nodes
+	}
+	return nil
+}
+
+func (x *Stats) GetWorkers() int32 {
+	if x != nil {
+		return x.Workers
+	}
+	return 0
+}
+
+func (x *Stats) GetBusy() int32 {
+	if x != nil {
+		return x.Busy
+	}
+	return 0
+}
+
+func (x *Stats) GetInFlight() int32 {
+	if x != nil {
+		return x.InFlight
+	}
+	return 0
+}
+
+func (x *Stats) GetSucceeded() uint64 {
+	if x != nil {
+		return x.Succeeded
+	}
+	return 0
+}
+
+func (x *Stats) GetFailed() uint64 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *Stats) GetLimited() uint64 {
+	if x != nil {
+		return x.Limited
+	}
+	return 0
+}
+
+func (x *Stats) GetFiltered() uint64 {
+	if x != nil {
+		return x.Filtered
+	}
+	return 0
+}
+
+func (x *Stats) GetRejected() uint64 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+func (x *Stats) GetStored() int64 {
+	if x != nil {
+		return x.Stored
+	}
+	return 0
+}
+
+var File_dhtcrawl_proto protoreflect.FileDescriptor
+
+const file_dhtcrawl_proto_rawDesc = "" +
+	"\n" +
+	"\x0edhtcrawl.proto\x12\bdhtcrawl\"2\n" +
+	"\x04File\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x16\n" +
+	"\x06length\x18\x02 \x01(\x03R\x06length\"\xec\x02\n" +
+	"\bMetadata\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\fR\x04hash\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06length\x18\x03 \x01(\x03R\x06length\x12\x1a\n" +
+	"\bcategory\x18\x04 \x01(\tR\bcategory\x12\x12\n" +
+	"\x04type\x18\x05 \x01(\x05R\x04type\x12\x12\n" +
+	"\x04tags\x18\x06 \x03(\tR\x04tags\x12\x18\n" +
+	"\acreated\x18\a \x01(\tR\acreated\x12\x14\n" +
+	"\x05peers\x18\b \x01(\x05R\x05peers\x12$\n" +
+	"\x05files\x18\t \x03(\v2\x0e.dhtcrawl.FileR\x05files\x12\x16\n" +
+	"\x06schema\x18\n" +
+	" \x01(\rR\x06schema\x12\x10\n" +
+	"\x03hex\x18\v \x01(\tR\x03hex\x12\x16\n" +
+	"\x06magnet\x18\f \x01(\tR\x06magnet\x12\x16\n" +
+	"\x06source\x18\r \x01(\tR\x06source\x12,\n" +
+	"\n" +
+	"source_geo\x18\x0e \x01(\v2\r.dhtcrawl.GeoR\tsourceGeo\"C\n" +
+	"\x03Geo\x12\x18\n" +
+	"\acountry\x18\x01 \x01(\tR\acountry\x12\x10\n" +
+	"\x03asn\x18\x02 \x01(\rR\x03asn\x12\x10\n" +
+	"\x03org\x18\x03 \x01(\tR\x03org\"F\n" +
+	"\bAnnounce\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\fR\x04hash\x12\x12\n" +
+	"\x04peer\x18\x02 \x01(\tR\x04peer\x12\x12\n" +
+	"\x04time\x18\x03 \x01(\x03R\x04time\"+\n" +
+	"\rStreamRequest\x12\x1a\n" +
+	"\bcategory\x18\x01 \x01(\tR\bcategory\"'\n" +
+	"\x11GetTorrentRequest\x12\x12\n" +
+	"\x04hash\x18\x01 \x01(\tR\x04hash\"\xba\x01\n" +
+	"\fQueryRequest\x12\x1a\n" +
+	"\bcategory\x18\x01 \x01(\tR\bcategory\x12\x19\n" +
+	"\bmin_size\x18\x02 \x01(\x03R\aminSize\x12\x19\n" +
+	"\bmax_size\x18\x03 \x01(\x03R\amaxSize\x12\x14\n" +
+	"\x05since\x18\x04 \x01(\x03R\x05since\x12\x14\n" +
+	"\x05until\x18\x05 \x01(\x03R\x05until\x12\x16\n" +
+	"\x06offset\x18\x06 \x01(\x05R\x06offset\x12\x14\n" +
+	"\x05limit\x18\a \x01(\x05R\x05limit\"Q\n" +
+	"\vTorrentList\x12.\n" +
+	"\btorrents\x18\x01 \x03(\v2\x12.dhtcrawl.MetadataR\btorrents\x12\x12\n" +
+	"\x04next\x18\x02 \x01(\x05R\x04next\"\x0e\n" +
+	"\fStatsRequest\"0\n" +
+	"\x04Node\x12\x12\n" +
+	"\x04addr\x18\x01 \x01(\tR\x04addr\x12\x14\n" +
+	"\x05nodes\x18\x02 \x01(\x05R\x05nodes\"\x98\x02\n" +
+	"\x05Stats\x12$\n" +
+	"\x05nodes\x18\x01 \x03(\v2\x0e.dhtcrawl.NodeR\x05nodes\x12\x18\n" +
+	"\aworkers\x18\x02 \x01(\x05R\aworkers\x12\x12\n" +
+	"\x04busy\x18\x03 \x01(\x05R\x04busy\x12\x1b\n" +
+	"\tin_flight\x18\x04 \x01(\x05R\binFlight\x12\x1c\n" +
+	"\tsucceeded\x18\x05 \x01(\x04R\tsucceeded\x12\x16\n" +
+	"\x06failed\x18\x06 \x01(\x04R\x06failed\x12\x18\n" +
+	"\alimited\x18\a \x01(\x04R\alimited\x12\x1a\n" +
+	"\bfiltered\x18\b \x01(\x04R\bfiltered\x12\x1a\n" +
+	"\brejected\x18\t \x01(\x04R\brejected\x12\x16\n" +
+	"\x06stored\x18\n" +
+	" \x01(\x03R\x06stored2\xc0\x02\n" +
+	"\aCrawler\x12@\n" +
+	"\x0fStreamAnnounces\x12\x17.dhtcrawl.StreamRequest\x1a\x12.dhtcrawl.Announce0\x01\x12?\n" +
+	"\x0eStreamMetadata\x12\x17.dhtcrawl.StreamRequest\x1a\x12.dhtcrawl.Metadata0\x01\x12=\n" +
+	"\n" +
+	"GetTorrent\x12\x1b.dhtcrawl.GetTorrentRequest\x1a\x12.dhtcrawl.Metadata\x12>\n" +
+	"\rQueryTorrents\x12\x16.dhtcrawl.QueryRequest\x1a\x15.dhtcrawl.TorrentList\x123\n" +
+	"\bGetStats\x12\x16.dhtcrawl.StatsRequest\x1a\x0f.dhtcrawl.StatsB2Z0bitbucket.org/AlanYang/DHTCrawl/proto;dhtcrawlpbb\x06proto3"
+
+var (
+	file_dhtcrawl_proto_rawDescOnce sync.Once
+	file_dhtcrawl_proto_rawDescData []byte
+)
+
+func file_dhtcrawl_proto_rawDescGZIP() []byte {
+	file_dhtcrawl_proto_rawDescOnce.Do(func() {
+		file_dhtcrawl_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_dhtcrawl_proto_rawDesc), len(file_dhtcrawl_proto_rawDesc)))
+	})
+	return file_dhtcrawl_proto_rawDescData
+}
+
+var file_dhtcrawl_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_dhtcrawl_proto_goTypes = []any{
+	(*File)(nil),              // 0: dhtcrawl.File
+	(*Metadata)(nil),          // 1: dhtcrawl.Metadata
+	(*Geo)(nil),               // 2: dhtcrawl.Geo
+	(*Announce)(nil),          // 3: dhtcrawl.Announce
+	(*StreamRequest)(nil),     // 4: dhtcrawl.StreamRequest
+	(*GetTorrentRequest)(nil), // 5: dhtcrawl.GetTorrentRequest
+	(*QueryRequest)(nil),      // 6: dhtcrawl.QueryRequest
+	(*TorrentList)(nil),       // 7: dhtcrawl.TorrentList
+	(*StatsRequest)(nil),      // 8: dhtcrawl.StatsRequest
+	(*Node)(nil),              // 9: dhtcrawl.Node
+	(*Stats)(nil),             // 10: dhtcrawl.Stats
+}
+var file_dhtcrawl_proto_depIdxs = []int32{
+	0,  // 0: dhtcrawl.Metadata.files:type_name -> dhtcrawl.File
+	2,  // 1: dhtcrawl.Metadata.source_geo:type_name -> dhtcrawl.Geo
+	1,  // 2: dhtcrawl.TorrentList.torrents:type_name -> dhtcrawl.Metadata
+	9,  // 3: dhtcrawl.Stats.nodes:type_name -> dhtcrawl.Node
+	4,  // 4: dhtcrawl.Crawler.StreamAnnounces:input_type -> dhtcrawl.StreamRequest
+	4,  // 5: dhtcrawl.Crawler.StreamMetadata:input_type -> dhtcrawl.StreamRequest
+	5,  // 6: dhtcrawl.Crawler.GetTorrent:input_type -> dhtcrawl.GetTorrentRequest
+	6,  // 7: dhtcrawl.Crawler.QueryTorrents:input_type -> dhtcrawl.QueryRequest
+	8,  // 8: dhtcrawl.Crawler.GetStats:input_type -> dhtcrawl.StatsRequest
+	3,  // 9: dhtcrawl.Crawler.StreamAnnounces:output_type -> dhtcrawl.Announce
+	1,  // 10: dhtcrawl.Crawler.StreamMetadata:output_type -> dhtcrawl.Metadata
+	1,  // 11: dhtcrawl.Crawler.GetTorrent:output_type -> dhtcrawl.Metadata
+	7,  // 12: dhtcrawl.Crawler.QueryTorrents:output_type -> dhtcrawl.TorrentList
+	10, // 13: dhtcrawl.Crawler.GetStats:output_type -> dhtcrawl.Stats
+	9,  // [9:14] is the sub-list for method output_type
+	4,  // [4:9] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_dhtcrawl_proto_init() }
+func file_dhtcrawl_proto_init() {
+	if File_dhtcrawl_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_dhtcrawl_proto_rawDesc), len(file_dhtcrawl_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_dhtcrawl_proto_goTypes,
+		DependencyIndexes: file_dhtcrawl_proto_depIdxs,
+		MessageInfos:      file_dhtcrawl_proto_msgTypes,
+	}.Build()
+	File_dhtcrawl_proto = out.File
+	file_dhtcrawl_proto_goTypes = nil
+	file_dhtcrawl_proto_depIdxs = nil
+}
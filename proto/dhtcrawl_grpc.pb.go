@@ -0,0 +1,280 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v3.21.12
+// source: dhtcrawl.proto
+
+package dhtcrawlpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Crawler_StreamAnnounces_FullMethodName = "/dhtcrawl.Crawler/StreamAnnounces"
+	Crawler_StreamMetadata_FullMethodName  = "/dhtcrawl.Crawler/StreamMetadata"
+	Crawler_GetTorrent_FullMethodName      = "/dhtcrawl.Crawler/GetTorrent"
+	Crawler_QueryTorrents_FullMethodName   = "/dhtcrawl.Crawler/QueryTorrents"
+	Crawler_GetStats_FullMethodName        = "/dhtcrawl.Crawler/GetStats"
+)
+
+// CrawlerClient is the client API for Crawler service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CrawlerClient interface {
+	StreamAnnounces(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Announce], error)
+	StreamMetadata(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Metadata], error)
+	GetTorrent(ctx context.Context, in *GetTorrentRequest, opts ...grpc.CallOption) (*Metadata, error)
+	QueryTorrents(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*TorrentList, error)
+	GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*Stats, error)
+}
+
+type crawlerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCrawlerClient(cc grpc.ClientConnInterface) CrawlerClient {
+	return &crawlerClient{cc}
+}
+
+func (c *crawlerClient) StreamAnnounces(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Announce], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Crawler_ServiceDesc.Streams[0], Crawler_StreamAnnounces_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamRequest, Announce]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Crawler_StreamAnnouncesClient = grpc.ServerStreamingClient[Announce]
+
+func (c *crawlerClient) StreamMetadata(ctx context.Context, in *StreamRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Metadata], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Crawler_ServiceDesc.Streams[1], Crawler_StreamMetadata_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamRequest, Metadata]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Crawler_StreamMetadataClient = grpc.ServerStreamingClient[Metadata]
+
+func (c *crawlerClient) GetTorrent(ctx context.Context, in *GetTorrentRequest, opts ...grpc.CallOption) (*Metadata, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Metadata)
+	err := c.cc.Invoke(ctx, Crawler_GetTorrent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerClient) QueryTorrents(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*TorrentList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TorrentList)
+	err := c.cc.Invoke(ctx, Crawler_QueryTorrents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerClient) GetStats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Crawler_GetStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CrawlerServer is the server API for Crawler service.
+// All implementations must embed UnimplementedCrawlerServer
+// for forward compatibility.
+type CrawlerServer interface {
+	StreamAnnounces(*StreamRequest, grpc.ServerStreamingServer[Announce]) error
+	StreamMetadata(*StreamRequest, grpc.ServerStreamingServer[Metadata]) error
+	GetTorrent(context.Context, *GetTorrentRequest) (*Metadata, error)
+	QueryTorrents(context.Context, *QueryRequest) (*TorrentList, error)
+	GetStats(context.Context, *StatsRequest) (*Stats, error)
+	mustEmbedUnimplementedCrawlerServer()
+}
+
+// UnimplementedCrawlerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCrawlerServer struct{}
+
+func (UnimplementedCrawlerServer) StreamAnnounces(*StreamRequest, grpc.ServerStreamingServer[Announce]) error {
+	return status.Error(codes.Unimplemented, "method StreamAnnounces not implemented")
+}
+func (UnimplementedCrawlerServer) StreamMetadata(*StreamRequest, grpc.ServerStreamingServer[Metadata]) error {
+	return status.Error(codes.Unimplemented, "method StreamMetadata not implemented")
+}
+func (UnimplementedCrawlerServer) GetTorrent(context.Context, *GetTorrentRequest) (*Metadata, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetTorrent not implemented")
+}
+func (UnimplementedCrawlerServer) QueryTorrents(context.Context, *QueryRequest) (*TorrentList, error) {
+	return nil, status.Error(codes.Unimplemented, "method QueryTorrents not implemented")
+}
+func (UnimplementedCrawlerServer) GetStats(context.Context, *StatsRequest) (*Stats, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedCrawlerServer) mustEmbedUnimplementedCrawlerServer() {}
+func (UnimplementedCrawlerServer) testEmbeddedByValue()                 {}
+
+// UnsafeCrawlerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CrawlerServer will
+// result in compilation errors.
+type UnsafeCrawlerServer interface {
+	mustEmbedUnimplementedCrawlerServer()
+}
+
+func RegisterCrawlerServer(s grpc.ServiceRegistrar, srv CrawlerServer) {
+	// If the following call panics, it indicates UnimplementedCrawlerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Crawler_ServiceDesc, srv)
+}
+
+func _Crawler_StreamAnnounces_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CrawlerServer).StreamAnnounces(m, &grpc.GenericServerStream[StreamRequest, Announce]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Crawler_StreamAnnouncesServer = grpc.ServerStreamingServer[Announce]
+
+func _Crawler_StreamMetadata_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CrawlerServer).StreamMetadata(m, &grpc.GenericServerStream[StreamRequest, Metadata]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Crawler_StreamMetadataServer = grpc.ServerStreamingServer[Metadata]
+
+func _Crawler_GetTorrent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTorrentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerServer).GetTorrent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Crawler_GetTorrent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerServer).GetTorrent(ctx, req.(*GetTorrentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Crawler_QueryTorrents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerServer).QueryTorrents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Crawler_QueryTorrents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerServer).QueryTorrents(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Crawler_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Crawler_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerServer).GetStats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Crawler_ServiceDesc is the grpc.ServiceDesc for Crawler service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Crawler_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dhtcrawl.Crawler",
+	HandlerType: (*CrawlerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetTorrent",
+			Handler:    _Crawler_GetTorrent_Handler,
+		},
+		{
+			MethodName: "QueryTorrents",
+			Handler:    _Crawler_QueryTorrents_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Crawler_GetStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamAnnounces",
+			Handler:       _Crawler_StreamAnnounces_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamMetadata",
+			Handler:       _Crawler_StreamMetadata_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "dhtcrawl.proto",
+}
@@ -0,0 +1,5 @@
+// Package dhtcrawlpb holds the protobuf messages and the gRPC service of
+// the crawler, generated from dhtcrawl.proto.
+package dhtcrawlpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative dhtcrawl.proto
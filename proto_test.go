@@ -0,0 +1,61 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func Test_MarshalProto(t *testing.T) {
+	h := Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"))
+	r := &MetadataResult{Hash: h, Name: "test", Files: []*File{{Path: []string{"a", "b.mkv"}, Length: 5}}}
+	b := r.MarshalProto()
+	fields := map[protowire.Number]int{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		if num == 2 {
+			if name, _ := protowire.ConsumeString(b); name != "test" {
+				t.Error("Name", name)
+			}
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			t.Fatal("Bad encoding", n)
+		}
+		b = b[n:]
+		fields[num]++
+	}
+	// hash, name, length, one file, schema, hex and magnet, the zero values are left out
+	if len(fields) != 7 || fields[1] != 1 || fields[3] != 1 || fields[9] != 1 || fields[10] != 1 || fields[11] != 1 || fields[12] != 1 {
+		t.Error("Fields", fields)
+	}
+}
+
+func Test_MetadataJSON(t *testing.T) {
+	h := Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"))
+	r := &MetadataResult{Hash: h, Name: "test", Category: "video", Create: "2026-10-14T09:00:00Z", Source: "1.2.3.4:6881",
+		SourceGeo: &PeerGeo{Country: "DE"}, Files: []*File{{Path: []string{"a.mkv"}, Length: 5}, {Path: []string{"b.mkv"}, Length: 7}}}
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := map[string]interface{}{}
+	json.Unmarshal(data, &rec)
+	for key, want := range map[string]interface{}{
+		"schema": float64(MetadataSchema), "hash": h.Hex(), "hex": h.Hex(), "name": "test", "size": float64(12),
+		"category": "video", "create": "2026-10-14T09:00:00Z", "source": "1.2.3.4:6881", "magnet": r.Magnet(),
+	} {
+		if rec[key] != want {
+			t.Error(key, rec[key])
+		}
+	}
+	if geo, _ := rec["source_geo"].(map[string]interface{}); geo["country"] != "DE" {
+		t.Error("source_geo", rec["source_geo"])
+	}
+	back, err := decodeStored(h, data)
+	if err != nil || back.Name != "test" || len(back.Files) != 2 || back.Source != r.Source {
+		t.Error("round trip", back, err)
+	}
+}
@@ -0,0 +1,133 @@
+package DHTCrawl
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// QueueBlock makes the producer wait until the consumer has room, the
+	// backpressure travels up to the previous stage.
+	QueueBlock QueuePolicy = iota
+	// QueueDropNewest discards the item being pushed when the queue is full.
+	QueueDropNewest
+	// QueueDropOldest evicts the oldest queued item to make room for the new one.
+	QueueDropOldest
+
+	DefaultQueueSize = 1024
+)
+
+type (
+	QueuePolicy int
+
+	// Queue is a bounded channel between two pipeline stages. It never grows
+	// beyond its capacity, what happens on overflow is decided by Policy and
+	// every discarded item is counted.
+	Queue struct {
+		Name   string
+		Policy QueuePolicy
+		// OnDrop is called with every item discarded by the policy.
+		OnDrop func(interface{})
+
+		c       chan interface{}
+		pushed  uint64
+		dropped uint64
+		once    sync.Once
+	}
+
+	QueueStat struct {
+		Name    string `json:"name"`
+		Len     int    `json:"len"`
+		Cap     int    `json:"cap"`
+		Pushed  uint64 `json:"pushed"`
+		Dropped uint64 `json:"dropped"`
+	}
+)
+
+func NewQueue(name string, size int, policy QueuePolicy) *Queue {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	return &Queue{Name: name, Policy: policy, c: make(chan interface{}, size)}
+}
+
+// Push hands v to the next stage, it returns false when v was dropped.
+func (q *Queue) Push(v interface{}) bool {
+	atomic.AddUint64(&q.pushed, 1)
+	switch q.Policy {
+	case QueueDropNewest:
+		select {
+		case q.c <- v:
+			return true
+		default:
+			q.drop(v)
+			return false
+		}
+	case QueueDropOldest:
+		for {
+			select {
+			case q.c <- v:
+				return true
+			default:
+			}
+			select {
+			case old := <-q.c:
+				q.drop(old)
+			default:
+			}
+		}
+	default:
+		q.c <- v
+		return true
+	}
+}
+
+func (q *Queue) drop(v interface{}) {
+	atomic.AddUint64(&q.dropped, 1)
+	if q.OnDrop != nil {
+		q.OnDrop(v)
+	}
+}
+
+// Close ends the queue once its producers are done, consumers ranging over C
+// still receive the items left in it.
+func (q *Queue) Close() {
+	q.once.Do(func() { close(q.c) })
+}
+
+// C is the receiving side for the consumer stage.
+func (q *Queue) C() <-chan interface{} {
+	return q.c
+}
+
+func (q *Queue) Len() int {
+	return len(q.c)
+}
+
+func (q *Queue) Cap() int {
+	return cap(q.c)
+}
+
+func (q *Queue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+func (q *Queue) Stat() QueueStat {
+	return QueueStat{
+		Name:    q.Name,
+		Len:     q.Len(),
+		Cap:     q.Cap(),
+		Pushed:  atomic.LoadUint64(&q.pushed),
+		Dropped: q.Dropped(),
+	}
+}
+
+func (p QueuePolicy) String() string {
+	switch p {
+	case QueueDropNewest:
+		return "drop-newest"
+	case QueueDropOldest:
+		return "drop-oldest"
+	}
+	return "block"
+}
@@ -0,0 +1,26 @@
+package DHTCrawl
+
+import "testing"
+
+func Test_QueuePolicy(t *testing.T) {
+	q := NewQueue("test", 2, QueueDropNewest)
+	q.Push(1)
+	q.Push(2)
+	if q.Push(3) || q.Dropped() != 1 {
+		t.Error("Drop newest has error")
+	}
+	if v := <-q.C(); v != 1 {
+		t.Error("Drop newest evicted", v)
+	}
+
+	evicted := []interface{}{}
+	q = NewQueue("test", 2, QueueDropOldest)
+	q.OnDrop = func(v interface{}) { evicted = append(evicted, v) }
+	q.Push(1)
+	q.Push(2)
+	q.Push(3)
+	if len(evicted) != 1 || evicted[0] != 1 || q.Len() != 2 {
+		t.Error("Drop oldest has error", evicted)
+	}
+	t.Log(q.Stat())
+}
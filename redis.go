@@ -0,0 +1,127 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultRedisPrefix   = "dhtcrawl"
+	DefaultRedisTTL      = 30 * 24 * 3600
+	DefaultRedisClaimTTL = 120
+)
+
+type (
+	// RedisConfig shares the seen hashes of several crawlers through Redis.
+	// TTL is how many seconds a fetched hash stays seen, ClaimTTL how long a
+	// crawler may take to fetch a hash before another one tries it too.
+	RedisConfig struct {
+		Addr     string `json:"addr"`
+		Password string `json:"password"`
+		DB       int    `json:"db"`
+		Prefix   string `json:"prefix"`
+		TTL      int    `json:"ttl"`
+		ClaimTTL int    `json:"claim_ttl"`
+	}
+
+	// RedisSeen is a dedup set shared by crawler processes. As a filter it
+	// skips hashes some crawler already fetched and claims the others, so a
+	// hash announced to every crawler at once is fetched by one of them. As
+	// a sink it marks fetched hashes seen and counts them in a HyperLogLog
+	// per day. When Redis is unreachable every hash is allowed.
+	RedisSeen struct {
+		Client   *redis.Client
+		Prefix   string
+		TTL      time.Duration
+		ClaimTTL time.Duration
+	}
+)
+
+func NewRedisSeen(cfg RedisConfig) (*RedisSeen, error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = DefaultRedisPrefix
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultRedisTTL
+	}
+	if cfg.ClaimTTL <= 0 {
+		cfg.ClaimTTL = DefaultRedisClaimTTL
+	}
+	client := redis.NewClient(&redis.Options{Addr: cfg.Addr, Password: cfg.Password, DB: cfg.DB})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisSeen{
+		Client:   client,
+		Prefix:   cfg.Prefix,
+		TTL:      time.Duration(cfg.TTL) * time.Second,
+		ClaimTTL: time.Duration(cfg.ClaimTTL) * time.Second,
+	}, nil
+}
+
+func (s *RedisSeen) key(kind, hex string) string {
+	return s.Prefix + ":" + kind + ":" + hex
+}
+
+func (s *RedisSeen) countKey(t time.Time) string {
+	return s.key("count", t.UTC().Format("20060102"))
+}
+
+// Seen tells whether hash was fetched by any crawler within TTL.
+func (s *RedisSeen) Seen(hash Hash) (bool, error) {
+	n, err := s.Client.Exists(context.Background(), s.key("seen", hash.Hex())).Result()
+	return n > 0, err
+}
+
+func (s *RedisSeen) AllowHash(hash Hash, _ *net.TCPAddr) bool {
+	ctx := context.Background()
+	hex := hash.Hex()
+	pipe := s.Client.Pipeline()
+	seen := pipe.Exists(ctx, s.key("seen", hex))
+	claimed := pipe.SetNX(ctx, s.key("claim", hex), 1, s.ClaimTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true
+	}
+	return seen.Val() == 0 && claimed.Val()
+}
+
+// Release drops the claim of hash after a failed fetch, the next announce
+// or retry of any crawler may try it again. nil releases nothing.
+func (s *RedisSeen) Release(hash Hash) {
+	if s == nil {
+		return
+	}
+	s.Client.Del(context.Background(), s.key("claim", hash.Hex()))
+}
+
+func (s *RedisSeen) AllowResult(*MetadataResult) bool {
+	return true
+}
+
+func (s *RedisSeen) Put(r *MetadataResult) error {
+	ctx := context.Background()
+	hex := r.Hash.Hex()
+	count := s.countKey(time.Now())
+	pipe := s.Client.TxPipeline()
+	pipe.Set(ctx, s.key("seen", hex), 1, s.TTL)
+	pipe.Del(ctx, s.key("claim", hex))
+	pipe.PFAdd(ctx, count, hex)
+	pipe.Expire(ctx, count, s.TTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Count estimates how many distinct hashes every crawler fetched on the day of t.
+func (s *RedisSeen) Count(t time.Time) (int64, error) {
+	return s.Client.PFCount(context.Background(), s.countKey(t)).Result()
+}
+
+func (s *RedisSeen) Close() error {
+	return s.Client.Close()
+}
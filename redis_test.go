@@ -0,0 +1,68 @@
+package DHTCrawl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func Test_RedisSeen(t *testing.T) {
+	srv := miniredis.RunT(t)
+	a, err := NewRedisSeen(RedisConfig{Addr: srv.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, _ := NewRedisSeen(RedisConfig{Addr: srv.Addr()})
+	defer b.Close()
+
+	h := Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"))
+	if !a.AllowHash(h, nil) {
+		t.Error("First crawler should claim the hash")
+	}
+	if b.AllowHash(h, nil) {
+		t.Error("Second crawler must skip a claimed hash")
+	}
+	if err := a.Put(&MetadataResult{Hash: h, Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	// the claim is gone but the hash is now seen
+	srv.FastForward(time.Duration(DefaultRedisClaimTTL+1) * time.Second)
+	if b.AllowHash(h, nil) {
+		t.Error("Seen hash allowed")
+	}
+	if seen, _ := b.Seen(h); !seen {
+		t.Error("Seen")
+	}
+	if n, _ := b.Count(time.Now()); n != 1 {
+		t.Error("Count", n)
+	}
+
+	// a failed fetch releases the claim, the retry claims it again
+	cfg := NewDefaultConfig()
+	cfg.Redis = &RedisConfig{Addr: srv.Addr()}
+	c, err := NewCrawler(WithConfig(cfg), WithPort(0), WithBootstraps())
+	if err == nil {
+		defer c.closeNodes()
+	}
+	failed := testHash("failed")
+	if err != nil || !c.seen.AllowHash(failed, nil) {
+		t.Fatal("claim", err)
+	}
+	c.Events.Publish(&FetchFailed{Hash: failed})
+	if !c.seen.AllowHash(failed, nil) {
+		t.Error("retry turned away by the claim of the failed fetch")
+	}
+	if err := c.seen.Put(&MetadataResult{Hash: failed, Name: "retried"}); err != nil {
+		t.Fatal(err)
+	}
+	if b.AllowHash(failed, nil) {
+		t.Error("fetched hash allowed")
+	}
+
+	srv.Close()
+	if !a.AllowHash(testHash("unknown"), nil) {
+		t.Error("Hashes must be allowed while Redis is down")
+	}
+}
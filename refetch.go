@@ -0,0 +1,187 @@
+package DHTCrawl
+
+import (
+	"container/list"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRefetchInterval = time.Minute
+	DefaultRefetchAttempts = 5
+	DefaultRefetchBatch    = 100
+	// a failed hash needs this many announces before it is tried again
+	RefetchMinAnnounces = 2
+	refetchMaxFailed    = 50000
+	refetchMaxTried     = 64 //peers remembered as failed for a hash
+)
+
+type (
+	// Refetcher retries hashes whose fetch failed but which keep being
+	// announced. Every Interval it takes up to Batch due hashes, the most
+	// announced first, and queues them again with the latest peers from the
+	// peer store, but the ones which failed it already. The wait doubles after
+	// every attempt and a hash is given up after Attempts tries, or once it
+	// went Interval<<Attempts without failing again. Past refetchMaxFailed
+	// hashes the least recently failed one is forgotten.
+	Refetcher struct {
+		Interval time.Duration
+		Attempts int
+		Batch    int
+		Clock    Clock //of the backoff, nil is SystemClock
+
+		pool   *WireJob
+		peers  *PeerStore
+		mu     sync.Mutex
+		failed map[Hash]*list.Element
+		lru    *list.List //of *failedHash, the most recently failed first
+	}
+
+	failedHash struct {
+		hash     Hash
+		attempts int
+		next     time.Time
+		seen     time.Time       //of the last failure
+		tried    map[string]bool //peers which failed the hash
+	}
+)
+
+func NewRefetcher(pool *WireJob, peers *PeerStore) *Refetcher {
+	return &Refetcher{
+		Interval: DefaultRefetchInterval,
+		Attempts: DefaultRefetchAttempts,
+		Batch:    DefaultRefetchBatch,
+		pool:     pool,
+		peers:    peers,
+		failed:   make(map[Hash]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// observe is called by the pool with every finished download.
+func (r *Refetcher) observe(result *MetadataResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if result.Name != "" {
+		r.remove(result.Hash)
+		return
+	}
+	var f *failedHash
+	if el, ok := r.failed[result.Hash]; ok {
+		f = el.Value.(*failedHash)
+		r.lru.MoveToFront(el)
+	} else {
+		f = &failedHash{hash: result.Hash, tried: map[string]bool{}}
+		r.failed[result.Hash] = r.lru.PushFront(f)
+		if r.lru.Len() > refetchMaxFailed {
+			r.remove(r.lru.Back().Value.(*failedHash).hash)
+		}
+	}
+	if f.attempts >= r.Attempts {
+		r.remove(result.Hash)
+		r.peers.Forget(result.Hash)
+		return
+	}
+	for _, addr := range result.tried {
+		if len(f.tried) < refetchMaxTried {
+			f.tried[addr.String()] = true
+		}
+	}
+	now := clockOr(r.Clock).Now()
+	f.next, f.seen = now.Add(r.Interval<<uint(f.attempts)), now
+	f.attempts++
+}
+
+// rejected counts a retry the limiter or the filters turned away as an
+// attempt, so that it is given up too.
+func (r *Refetcher) rejected(hash Hash) {
+	r.observe(NewErrorResult(hash))
+}
+
+func (r *Refetcher) remove(hash Hash) {
+	if el, ok := r.failed[hash]; ok {
+		r.lru.Remove(el)
+		delete(r.failed, hash)
+	}
+}
+
+// Pending returns how many failed hashes wait for another attempt.
+func (r *Refetcher) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.failed)
+}
+
+// Reset gives up every failed hash waiting for a retry.
+func (r *Refetcher) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = make(map[Hash]*list.Element)
+	r.lru.Init()
+}
+
+// Run schedules the retries every Interval of the clock until stop is
+// closed.
+func (r *Refetcher) Run(stop <-chan struct{}) {
+	clock := clockOr(r.Clock)
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-after(clock, r.Interval):
+			r.schedule(now)
+		}
+	}
+}
+
+func (r *Refetcher) schedule(now time.Time) {
+	type candidate struct {
+		hash      Hash
+		announces int
+		tried     map[string]bool
+	}
+	maxAge := r.Interval << uint(r.Attempts)
+	r.mu.Lock()
+	due := []candidate{}
+	for h, el := range r.failed {
+		f := el.Value.(*failedHash)
+		n := r.peers.Announces(h)
+		if n == 0 || now.Sub(f.seen) > maxAge {
+			// the peer store forgot it or it stopped being announced
+			r.remove(h)
+			continue
+		}
+		if !f.next.After(now) && n >= RefetchMinAnnounces {
+			due = append(due, candidate{h, n, f.tried})
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].announces > due[j].announces })
+	if len(due) > r.Batch {
+		due = due[:r.Batch]
+	}
+	for _, c := range due {
+		peers := []*net.TCPAddr{}
+		r.mu.Lock()
+		for _, p := range r.peers.Peers(c.hash) {
+			if !c.tried[p.String()] {
+				peers = append(peers, p)
+			}
+		}
+		r.mu.Unlock()
+		if len(peers) == 0 {
+			// waits for a peer which didn't fail it yet
+			continue
+		}
+		job := NewJob(c.hash, peers[0])
+		job.retry = true
+		for _, p := range peers[1:] {
+			job.AddPeer(p)
+		}
+		logPipeline.Debug("refetch", "infohash", c.hash, "announces", c.announces, "peers", len(peers))
+		r.pool.Add(job)
+	}
+}
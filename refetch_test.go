@@ -0,0 +1,107 @@
+package DHTCrawl
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_PeerStore(t *testing.T) {
+	s := NewPeerStore(2, 2)
+	h := testHash("12345678901234567890")
+	for i := 1; i <= 3; i++ {
+		s.Add(h, &net.TCPAddr{IP: net.IPv4(1, 1, 1, byte(i)), Port: 1})
+	}
+	peers := s.Peers(h)
+	if len(peers) != 2 || peers[0].IP[15] != 3 || s.Announces(h) != 3 {
+		t.Error("Peer store has error", peers)
+	}
+	s.Add(testHash("a"), nil)
+	s.Add(testHash("b"), nil)
+	if s.Announces(h) != 0 || s.Len() != 2 {
+		t.Error("Least recent hash not evicted")
+	}
+}
+
+func Test_Refetch(t *testing.T) {
+	pool := NewWireJob(0, 4)
+	defer pool.Stop()
+	h := testHash("12345678901234567890")
+	pool.Peers.Add(h, &net.TCPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 1})
+	pool.Peers.Add(h, &net.TCPAddr{IP: net.IPv4(2, 2, 2, 2), Port: 2})
+
+	pool.Refetch.observe(NewErrorResult(h))
+	pool.Refetch.schedule(time.Now())
+	if pool.Jobs.Len() != 0 {
+		t.Error("Retried before the interval")
+	}
+	pool.Refetch.schedule(time.Now().Add(pool.Refetch.Interval * 2))
+	time.Sleep(time.Millisecond * 50)
+	if pool.Jobs.Len() != 1 || pool.Peers.Announces(h) != 2 {
+		t.Error("Failed hash not retried")
+	}
+}
+
+func Test_RefetchBounds(t *testing.T) {
+	pool := NewWireJob(0, 4)
+	defer pool.Stop()
+	r := pool.Refetch
+	h := testHash("tried")
+	bad, fresh := &net.TCPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 1}, &net.TCPAddr{IP: net.IPv4(2, 2, 2, 2), Port: 2}
+	pool.Peers.Add(h, bad)
+	pool.Peers.Add(h, bad)
+
+	// the only peer failed the hash already, nothing is retried until another one announces it
+	failed := NewErrorResult(h)
+	failed.tried = []*net.TCPAddr{bad}
+	r.observe(failed)
+	now := time.Now().Add(r.Interval * 2)
+	r.schedule(now)
+	time.Sleep(20 * time.Millisecond)
+	if pool.Jobs.Len() != 0 {
+		t.Fatal("retried a peer which failed")
+	}
+	pool.Peers.Add(h, fresh)
+	r.schedule(now)
+	time.Sleep(20 * time.Millisecond)
+	var job *Job
+	select {
+	case v := <-pool.Jobs.C():
+		job = v.(*Job)
+	case <-time.After(time.Second):
+		t.Fatal("fresh peer not retried")
+	}
+	if job.Addr.String() != fresh.String() || len(job.Peers()) != 0 {
+		t.Fatal("retried", job.Addr, job.Peers())
+	}
+
+	// a rejected retry counts as an attempt
+	h2 := testHash("limited")
+	r.observe(NewErrorResult(h2))
+	pool.Limiter.SetRate(1, 1)
+	pool.Limiter.Allow()
+	job = NewJob(h2, fresh)
+	job.retry = true
+	pool.Add(job)
+	time.Sleep(20 * time.Millisecond)
+	r.mu.Lock()
+	attempts := r.failed[h2].Value.(*failedHash).attempts
+	r.mu.Unlock()
+	if attempts != 2 {
+		t.Error("attempts", attempts)
+	}
+
+	// a hash which isn't failing nor announced anymore goes
+	r.schedule(now.Add(r.Interval << uint(r.Attempts+2)))
+	if r.Pending() != 0 {
+		t.Error("not expired", r.Pending())
+	}
+
+	// the least recently failed hash makes room
+	for i := 0; i < refetchMaxFailed+1; i++ {
+		r.observe(NewErrorResult(Hash(NewNodeID())))
+	}
+	if r.Pending() != refetchMaxFailed {
+		t.Error("pending", r.Pending())
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package DHTCrawl
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errReusePort = errors.New("SO_REUSEPORT is not supported on this platform")
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errReusePort
+}
@@ -0,0 +1,219 @@
+package DHTCrawl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotatingFile appends to Path and moves it aside to Path.<time> once it
+// grows past MaxSize bytes or is older than MaxAge, zero disables either
+// limit. A single Write never spans two files, so writing one record per
+// call keeps records whole. Writes are buffered for at most a second, the
+// age is checked as often so an idle file still rotates on time.
+type RotatingFile struct {
+	Path    string
+	MaxSize int64
+	MaxAge  time.Duration
+	Gzip    bool //compress rotated files to Path.<time>.gz
+	Keep    int  //rotated files kept, the oldest are removed, 0 keeps all
+
+	mu       sync.Mutex
+	file     *os.File
+	buf      *bufio.Writer
+	size     int64
+	opened   time.Time
+	compress sync.WaitGroup
+	stop     chan struct{}
+	ticked   chan struct{} //closed when tick returned
+	stopOnce sync.Once
+}
+
+const rotateTick = time.Second
+
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration) (*RotatingFile, error) {
+	f := &RotatingFile{Path: path, MaxSize: maxSize, MaxAge: maxAge, stop: make(chan struct{}), ticked: make(chan struct{})}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	go f.tick()
+	return f, nil
+}
+
+// tick flushes the buffer and rotates a file past MaxAge until Close.
+func (f *RotatingFile) tick() {
+	defer close(f.ticked)
+	t := time.NewTicker(rotateTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-t.C:
+		}
+		f.mu.Lock()
+		var err error
+		if f.size > 0 && f.MaxAge > 0 && time.Since(f.opened) >= f.MaxAge {
+			err = f.rotate()
+		} else {
+			err = f.buf.Flush()
+		}
+		f.mu.Unlock()
+		if err != nil {
+			logPipeline.Warn("flushing a rotating file failed", "path", f.Path, "error", err)
+		}
+	}
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, fi.Size()
+	f.buf = bufio.NewWriterSize(file, 64*1024)
+	// an existing file keeps its age across restarts
+	f.opened = fi.ModTime()
+	if fi.Size() == 0 {
+		f.opened = time.Now()
+	}
+	return nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.size > 0 && ((f.MaxSize > 0 && f.size+int64(len(p)) > f.MaxSize) || (f.MaxAge > 0 && time.Since(f.opened) >= f.MaxAge)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.buf.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate moves the current file aside now.
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.buf.Flush(); err != nil {
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	name := f.rotatedName()
+	if err := os.Rename(f.Path, name); err != nil {
+		return err
+	}
+	if f.Gzip {
+		f.compress.Add(1)
+		go func() {
+			defer f.compress.Done()
+			gzipFile(name)
+			f.prune()
+		}()
+	} else {
+		f.prune()
+	}
+	return f.open()
+}
+
+// rotatedName returns a name for the current file which is not taken,
+// several rotations may happen in the same millisecond.
+func (f *RotatingFile) rotatedName() string {
+	base := f.Path + "." + time.Now().Format("20060102-150405.000")
+	name := base
+	for i := 1; ; i++ {
+		_, err := os.Stat(name)
+		_, gzErr := os.Stat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// prune removes the oldest rotated files beyond Keep, their names sort by
+// rotation time.
+func (f *RotatingFile) prune() {
+	if f.Keep <= 0 {
+		return
+	}
+	names, _ := filepath.Glob(f.Path + ".[0-9]*")
+	rotated := []string{}
+	for _, name := range names {
+		//a file being compressed is counted once
+		if base := strings.TrimSuffix(name, ".gz"); base == name || !InArray(rotated, base) {
+			rotated = append(rotated, base)
+		}
+	}
+	sort.Strings(rotated)
+	for _, name := range rotated[:max(len(rotated)-f.Keep, 0)] {
+		os.Remove(name)
+		os.Remove(name + ".gz")
+	}
+}
+
+// gzipFile replaces name by name.gz, name is kept when compression fails.
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(name + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if e := zw.Close(); err == nil {
+		err = e
+	}
+	if e := out.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+func (f *RotatingFile) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buf.Flush()
+}
+
+// Close flushes the file and waits for rotated files to be compressed.
+func (f *RotatingFile) Close() error {
+	f.stopOnce.Do(func() { close(f.stop) })
+	<-f.ticked
+	f.mu.Lock()
+	err := f.buf.Flush()
+	if e := f.file.Close(); err == nil {
+		err = e
+	}
+	f.mu.Unlock()
+	f.compress.Wait()
+	return err
+}
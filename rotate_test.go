@@ -0,0 +1,99 @@
+package DHTCrawl
+
+import (
+	"bufio"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_JSONLRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "results.jsonl")
+	s, err := NewJSONLSink(JSONLConfig{Path: path, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.File.MaxSize = 200
+	for i := 0; i < 10; i++ {
+		if err := s.Put(&MetadataResult{Hash: testHash("01234567890123456789"), Name: "a result of about a hundred bytes"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _ := filepath.Glob(path + ".*.gz")
+	if len(rotated) == 0 {
+		t.Fatal("No rotated file")
+	}
+	lines := 0
+	for _, name := range append(rotated, path) {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sc *bufio.Scanner
+		if name == path {
+			sc = bufio.NewScanner(f)
+		} else {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			sc = bufio.NewScanner(zr)
+		}
+		for sc.Scan() {
+			if sc.Bytes()[0] != '{' || sc.Bytes()[len(sc.Bytes())-1] != '}' {
+				t.Error("Split record", sc.Text())
+			}
+			lines++
+		}
+		f.Close()
+	}
+	if lines != 10 {
+		t.Error("Lines", lines)
+	}
+}
+
+func Test_RotateKeep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	f, err := OpenRotatingFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Keep = 2
+	for i := 0; i < 5; i++ {
+		f.Write([]byte("line\n"))
+		if err := f.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+	rotated, _ := filepath.Glob(path + ".*")
+	if len(rotated) != 2 {
+		t.Error("rotated files kept", rotated)
+	}
+}
+
+func Test_RotateTick(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	f, err := OpenRotatingFile(path, 0, 1500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("line\n"))
+	time.Sleep(rotateTick + 200*time.Millisecond)
+	if data, _ := os.ReadFile(path); string(data) != "line\n" {
+		t.Errorf("not flushed %q", data)
+	}
+	// no more writes, the age still rotates the file
+	time.Sleep(rotateTick)
+	if rotated, _ := filepath.Glob(path + ".*"); len(rotated) != 1 {
+		t.Error("not rotated", rotated)
+	}
+}
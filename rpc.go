@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/zeebo/bencode"
 	"net"
+	"sync/atomic"
 	// "reflect"
 )
 
@@ -28,10 +29,14 @@ type (
 		TCPAddr *net.TCPAddr
 		Token   string
 		Nodes   []*Node
+		Peers   []*net.TCPAddr //values of a get_peers response
+		BFsd    []byte         //BEP 33 bloom filters of the seeds and the
+		BFpe    []byte         //leechers of a scrape get_peers response
 		Tid     string
 	}
 
 	RPC struct {
+		maxItems int64 //values of a packet, 0 is DefaultMaxBencodeItems
 	}
 )
 
@@ -50,6 +55,56 @@ func PacketFindNode(id, target NodeID) []byte {
 	return b
 }
 
+func PacketQueryGetPeers(id NodeID, hash Hash) []byte {
+	d := map[string]interface{}{
+		"t": GenerateTid(),
+		"y": TYPE_QUERY,
+		"q": OP_GET_PEERS,
+		"a": map[string]string{
+			"id":        id.String(),
+			"info_hash": string(hash[:]),
+		},
+	}
+	b, _ := bencode.EncodeBytes(d)
+	return b
+}
+
+// PacketQueryScrape is a get_peers asking for the BEP 33 bloom filters of
+// the swarm too.
+func PacketQueryScrape(id NodeID, hash Hash) []byte {
+	d := map[string]interface{}{
+		"t": GenerateTid(),
+		"y": TYPE_QUERY,
+		"q": OP_GET_PEERS,
+		"a": map[string]interface{}{
+			"id":        id.String(),
+			"info_hash": string(hash[:]),
+			"scrape":    1,
+		},
+	}
+	b, _ := bencode.EncodeBytes(d)
+	return b
+}
+
+// PacketQueryAnnouncePeer announces that a peer of hash listens on the TCP
+// port, with the token of the node's get_peers response.
+func PacketQueryAnnouncePeer(id NodeID, hash Hash, port int, token string) []byte {
+	d := map[string]interface{}{
+		"t": GenerateTid(),
+		"y": TYPE_QUERY,
+		"q": OP_ANNOUNCE_PEER,
+		"a": map[string]interface{}{
+			"id":           id.String(),
+			"info_hash":    string(hash[:]),
+			"port":         port,
+			"implied_port": 0,
+			"token":        token,
+		},
+	}
+	b, _ := bencode.EncodeBytes(d)
+	return b
+}
+
 //response
 //id is self id
 func PacketGetPeers(hash Hash, id NodeID, self NodeID, nodes []byte, token, tid string) []byte {
@@ -66,6 +121,23 @@ func PacketGetPeers(hash Hash, id NodeID, self NodeID, nodes []byte, token, tid
 	return b
 }
 
+// PacketGetPeersValues answers get_peers with the compact peers of values
+// as well as the nodes.
+func PacketGetPeersValues(hash Hash, id NodeID, self NodeID, nodes []byte, values []string, token, tid string) []byte {
+	d := map[string]interface{}{
+		"t": tid,
+		"y": TYPE_RESPONSE,
+		"r": map[string]interface{}{
+			"id":     id.Neighbor(self).String(),
+			"nodes":  bytes.NewBuffer(nodes).String(),
+			"token":  token,
+			"values": values,
+		},
+	}
+	b, _ := bencode.EncodeBytes(d)
+	return b
+}
+
 func PacketAnnucePeer(hash Hash, id NodeID, self NodeID, tid string) []byte {
 	d := map[string]interface{}{
 		"t": tid,
@@ -90,9 +162,15 @@ func NewRPC() *RPC {
 	return &RPC{}
 }
 
+// SetMaxItems bounds the bencoded values of a packet, larger packets are
+// dropped before they are decoded. Zero keeps the default.
+func (r *RPC) SetMaxItems(n int) {
+	atomic.StoreInt64(&r.maxItems, int64(n))
+}
+
 func (r *RPC) HandleGetPeers(args map[string]interface{}) (hash Hash, id NodeID) {
 	if h, ok := args["info_hash"].(string); ok {
-		hash = Hash(h)
+		hash, _ = HashFromBytes([]byte(h))
 	}
 	if i, ok := args["id"].(string); ok {
 		id = NodeID(i)
@@ -103,7 +181,7 @@ func (r *RPC) HandleGetPeers(args map[string]interface{}) (hash Hash, id NodeID)
 //info hash, tcp port, token
 func (r *RPC) HandleAnnoucePeer(args map[string]interface{}) (hash Hash, id NodeID, port int64, token string) {
 	if h, ok := args["info_hash"].(string); ok {
-		hash = Hash(h)
+		hash, _ = HashFromBytes([]byte(h))
 	}
 	if i, ok := args["id"].(string); ok {
 		id = NodeID(i)
@@ -130,6 +208,21 @@ func (r *RPC) HandleFindNode(resp map[string]interface{}) (ns []*Node) {
 	return
 }
 
+// HandleValues reads the compact peers of a get_peers response.
+func (r *RPC) HandleValues(resp map[string]interface{}) (peers []*net.TCPAddr) {
+	values, _ := resp["values"].([]interface{})
+	for _, v := range values {
+		b, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if addr, err := DecodeCompactPeer([]byte(b)); err == nil {
+			peers = append(peers, addr)
+		}
+	}
+	return
+}
+
 func (r *RPC) handlePing(args map[string]interface{}) NodeID {
 	if id, ok := args["id"].(string); ok {
 		return NodeID([]byte(id))
@@ -138,9 +231,13 @@ func (r *RPC) handlePing(args map[string]interface{}) NodeID {
 }
 
 func (r *RPC) parse(data []byte, addr *net.UDPAddr) (*Result, error) {
+	max := int(atomic.LoadInt64(&r.maxItems))
+	if max <= 0 {
+		max = DefaultMaxBencodeItems
+	}
 	v := make(map[string]interface{})
 
-	if err := bencode.DecodeBytes(data, &v); err != nil {
+	if err := decodeBencode(data, &v, max); err != nil {
 		return nil, err
 	}
 
@@ -167,7 +264,7 @@ func (r *RPC) parse(data []byte, addr *net.UDPAddr) (*Result, error) {
 		switch q {
 		case OP_GET_PEERS:
 			hash, id := r.HandleGetPeers(a)
-			if string(hash) != "" && string(id) != "" {
+			if !hash.IsZero() && string(id) != "" {
 				return &Result{Cmd: OP_GET_PEERS, UDPAddr: addr, Hash: hash, ID: id, Tid: t}, nil
 			}
 		case OP_ANNOUNCE_PEER:
@@ -175,7 +272,7 @@ func (r *RPC) parse(data []byte, addr *net.UDPAddr) (*Result, error) {
 			if port == int64(-1) {
 				port = int64(addr.Port)
 			}
-			if string(hash) != "" && IsValidPort(int(port)) && string(id) != "" {
+			if !hash.IsZero() && IsValidPort(int(port)) && string(id) != "" {
 				tcpAddr := &net.TCPAddr{IP: addr.IP, Port: int(port)}
 				return &Result{
 					Cmd:     OP_ANNOUNCE_PEER,
@@ -199,9 +296,14 @@ func (r *RPC) parse(data []byte, addr *net.UDPAddr) (*Result, error) {
 		default:
 		}
 	case TYPE_RESPONSE:
-		//only has find_node response
+		//find_node response, the values and the token of a get_peers response are kept too
 		if a, ok := v["r"].(map[string]interface{}); ok {
-			return &Result{Cmd: OP_FIND_NODE, Nodes: r.HandleFindNode(a), Tid: t}, nil
+			id, _ := a["id"].(string)
+			token, _ := a["token"].(string)
+			seeds, _ := a["BFsd"].(string)
+			leechers, _ := a["BFpe"].(string)
+			return &Result{Cmd: OP_FIND_NODE, UDPAddr: addr, ID: NodeID(id), Nodes: r.HandleFindNode(a), Peers: r.HandleValues(a), Token: token, Tid: t,
+				BFsd: []byte(seeds), BFpe: []byte(leechers)}, nil
 		}
 	case TYPE_ERROR:
 	default:
@@ -0,0 +1,103 @@
+package DHTCrawl
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultScaleInterval = time.Second * 10
+	// below this success rate a backlog is blamed on dead peers, not on a
+	// lack of workers, and the pool does not grow
+	ScaleMinSuccessRate = 0.05
+	scaleMinSamples     = 20
+)
+
+// Scaler grows the worker pool between Min and Max while the fetch queue
+// backs up with every worker busy, and shrinks it while workers sit idle.
+type Scaler struct {
+	Interval time.Duration
+
+	pool     *WireJob
+	mu       sync.Mutex
+	min, max int
+	lastOK   uint64
+	lastFail uint64
+}
+
+func NewScaler(pool *WireJob, min, max int) *Scaler {
+	s := &Scaler{Interval: DefaultScaleInterval, pool: pool}
+	s.SetBounds(min, max)
+	return s
+}
+
+func (s *Scaler) SetBounds(min, max int) {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.min, s.max = min, max
+}
+
+func (s *Scaler) Bounds() (min, max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.min, s.max
+}
+
+// Run rescales the pool every Interval until stop is closed.
+func (s *Scaler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.scale()
+		}
+	}
+}
+
+func (s *Scaler) scale() {
+	ok, fail := s.pool.Fetched()
+	s.mu.Lock()
+	min, max := s.min, s.max
+	dOK, dFail := ok-s.lastOK, fail-s.lastFail
+	s.lastOK, s.lastFail = ok, fail
+	s.mu.Unlock()
+
+	size, busy := s.pool.Workers()
+	depth := s.pool.Jobs.Len()
+	target := s.target(size, busy, depth, dOK, dFail)
+	if target < min {
+		target = min
+	}
+	if target > max {
+		target = max
+	}
+	if target != size {
+		s.pool.Resize(target)
+	}
+}
+
+func (s *Scaler) target(size, busy, depth int, ok, fail uint64) int {
+	switch {
+	case depth > 0 && busy >= size:
+		if n := ok + fail; n >= scaleMinSamples && float64(ok)/float64(n) < ScaleMinSuccessRate {
+			return size
+		}
+		step := depth / 4
+		if step < 1 {
+			step = 1
+		}
+		return size + step
+	case depth == 0 && busy < size/2:
+		return size - (size-busy)/2
+	}
+	return size
+}
@@ -0,0 +1,26 @@
+package DHTCrawl
+
+import "testing"
+
+func Test_ScalerTarget(t *testing.T) {
+	s := &Scaler{}
+	if n := s.target(10, 10, 100, 50, 50); n != 35 {
+		t.Error("Backlog did not grow", n)
+	}
+	if n := s.target(10, 10, 100, 0, 100); n != 10 {
+		t.Error("Dead peers grew the pool", n)
+	}
+	if n := s.target(10, 2, 0, 5, 5); n != 6 {
+		t.Error("Idle pool did not shrink", n)
+	}
+}
+
+func Test_ScalerBounds(t *testing.T) {
+	pool := NewWireJob(2, 4)
+	defer pool.Stop()
+	s := NewScaler(pool, 4, 8)
+	s.scale()
+	if size, _ := pool.Workers(); size != 4 {
+		t.Error("Pool below min", size)
+	}
+}
@@ -0,0 +1,30 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+)
+
+// MetadataSchema is the version of the JSON records of MetadataResult. It
+// changes when a key is renamed, removed or changes meaning, new keys may
+// appear within a version.
+const MetadataSchema = 1
+
+// MarshalJSON encodes the record every sink and API emits, described in
+// the Readme. Besides the fields it always carries the schema, the hex
+// hash, the total size and the magnet link.
+func (m *MetadataResult) MarshalJSON() ([]byte, error) {
+	type plain MetadataResult //without this method
+	return json.Marshal(struct {
+		Schema int `json:"schema"`
+		*plain
+		Hex    string `json:"hex"`
+		Size   int64  `json:"size"`
+		Magnet string `json:"magnet"`
+	}{
+		Schema: MetadataSchema,
+		plain:  (*plain)(m),
+		Hex:    m.Hash.Hex(),
+		Size:   m.TotalLength(),
+		Magnet: m.Magnet(),
+	})
+}
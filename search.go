@@ -0,0 +1,229 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+const (
+	searchBatchSize     = 256
+	searchFlushInterval = time.Second * 2
+)
+
+type (
+	// SearchIndex is an embedded full-text index of torrent names and file
+	// paths, kept up to date as a sink. Writes are batched, a result is
+	// searchable within a couple of seconds.
+	SearchIndex struct {
+		Index bleve.Index
+
+		mu      sync.Mutex
+		batch   *bleve.Batch
+		err     error
+		stop    chan struct{}
+		stopped chan struct{}
+	}
+
+	SearchHit struct {
+		Hash       string              `json:"hash"`
+		Name       string              `json:"name"`
+		Category   string              `json:"category,omitempty"`
+		Length     int64               `json:"length"`
+		Created    string              `json:"created,omitempty"`
+		Magnet     string              `json:"magnet"`
+		Score      float64             `json:"score"`
+		Highlights map[string][]string `json:"highlights,omitempty"`
+	}
+
+	SearchResults struct {
+		Total uint64       `json:"total"`
+		Hits  []*SearchHit `json:"hits"`
+		Next  int          `json:"next,omitempty"`
+	}
+)
+
+func searchMapping() *mapping.IndexMappingImpl {
+	// highlighting needs the stored text and its term vectors
+	text := bleve.NewTextFieldMapping()
+	text.IncludeTermVectors = true
+	keyword := bleve.NewKeywordFieldMapping()
+
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("name", text)
+	doc.AddFieldMappingsAt("files", text)
+	doc.AddFieldMappingsAt("category", keyword)
+	doc.AddFieldMappingsAt("length", bleve.NewNumericFieldMapping())
+	doc.AddFieldMappingsAt("created", bleve.NewDateTimeFieldMapping())
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	return m
+}
+
+// OpenSearchIndex opens the index at path, creating it when missing.
+func OpenSearchIndex(path string) (*SearchIndex, error) {
+	index, err := bleve.Open(path)
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		index, err = bleve.New(path, searchMapping())
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &SearchIndex{
+		Index:   index,
+		batch:   index.NewBatch(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.flushLoop()
+	return s, nil
+}
+
+func (s *SearchIndex) Put(r *MetadataResult) error {
+	paths := make([]string, 0, len(r.Files))
+	for _, f := range r.Files {
+		paths = append(paths, strings.Join(f.Path, "/"))
+	}
+	doc := map[string]interface{}{
+		"name":     r.Name,
+		"files":    strings.Join(paths, "\n"),
+		"category": r.Category,
+		"length":   float64(r.TotalLength()),
+		"created":  r.created(),
+	}
+	s.mu.Lock()
+	err := s.batch.Index(r.Hash.Hex(), doc)
+	full := s.batch.Size() >= searchBatchSize
+	if err == nil {
+		err = s.err
+		s.err = nil
+	}
+	s.mu.Unlock()
+	if full {
+		if e := s.Flush(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *SearchIndex) flushLoop() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(searchFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				s.mu.Lock()
+				s.err = err
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+func (s *SearchIndex) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.batch.Size() == 0 {
+		return nil
+	}
+	err := s.Index.Batch(s.batch)
+	s.batch.Reset()
+	return err
+}
+
+// Search matches text against names and paths, names weigh twice as much.
+// category narrows the hits when it is not empty.
+func (s *SearchIndex) Search(text, category string, offset, limit int) (*SearchResults, error) {
+	name := bleve.NewMatchQuery(text)
+	name.SetField("name")
+	name.SetBoost(2)
+	files := bleve.NewMatchQuery(text)
+	files.SetField("files")
+	var q query.Query = bleve.NewDisjunctionQuery(name, files)
+	if category != "" {
+		term := bleve.NewTermQuery(category)
+		term.SetField("category")
+		q = bleve.NewConjunctionQuery(q, term)
+	}
+	req := bleve.NewSearchRequestOptions(q, limit, offset, false)
+	req.Fields = []string{"name", "category", "length", "created"}
+	req.Highlight = bleve.NewHighlight()
+	req.Highlight.AddField("name")
+	req.Highlight.AddField("files")
+	res, err := s.Index.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &SearchResults{Total: res.Total, Hits: []*SearchHit{}}
+	for _, hit := range res.Hits {
+		h := &SearchHit{Hash: hit.ID, Score: hit.Score, Highlights: hit.Fragments}
+		h.Name, _ = hit.Fields["name"].(string)
+		h.Category, _ = hit.Fields["category"].(string)
+		h.Created, _ = hit.Fields["created"].(string)
+		if length, ok := hit.Fields["length"].(float64); ok {
+			h.Length = int64(length)
+		}
+		hash, _ := HashFromHex(hit.ID)
+		h.Magnet = (&MetadataResult{Hash: hash, Name: h.Name}).Magnet()
+		out.Hits = append(out.Hits, h)
+	}
+	if uint64(offset+len(res.Hits)) < res.Total {
+		out.Next = offset + len(res.Hits)
+	}
+	return out, nil
+}
+
+func (s *SearchIndex) Close() error {
+	close(s.stop)
+	<-s.stopped
+	err := s.Flush()
+	if e := s.Index.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	index := s.Crawler.Search
+	if index == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("the crawler has no search index"))
+		return
+	}
+	v := r.URL.Query()
+	text := strings.TrimSpace(v.Get("q"))
+	if text == "" {
+		writeError(w, http.StatusBadRequest, errors.New("missing q"))
+		return
+	}
+	offset, _ := strconv.Atoi(v.Get("offset"))
+	limit, _ := strconv.Atoi(v.Get("limit"))
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	res, err := index.Search(text, v.Get("category"), offset, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
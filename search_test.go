@@ -0,0 +1,59 @@
+package DHTCrawl
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_SearchIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search")
+	s, err := OpenSearchIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []*MetadataResult{
+		{Name: "Ubuntu 16.04 Desktop", Category: CategorySoftware, Length: 1},
+		{Name: "Some Album", Category: CategoryAudio, Files: []*File{{Path: []string{"ubuntu", "song.mp3"}, Length: 2}}},
+		{Name: "Holiday Video", Category: CategoryVideo, Length: 3},
+	}
+	for i, r := range results {
+		r.Hash = Hash(NewNodeIDFromHex(fmt.Sprintf("%040X", i+1)))
+		if err := s.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	// reopen the existing index
+	if s, err = OpenSearchIndex(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	res, err := s.Search("ubuntu", "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a match in the name ranks above a match in a path
+	if res.Total != 2 || res.Hits[0].Name != "Ubuntu 16.04 Desktop" || res.Hits[1].Name != "Some Album" {
+		t.Fatalf("Hits %+v", res)
+	}
+	if len(res.Hits[0].Highlights["name"]) == 0 || !strings.Contains(res.Hits[0].Highlights["name"][0], "<mark>") {
+		t.Error("Highlights", res.Hits[0].Highlights)
+	}
+	if res, _ := s.Search("ubuntu", CategoryAudio, 0, 10); res.Total != 1 {
+		t.Error("Category", res.Total)
+	}
+	if res, _ := s.Search("ubuntu", "", 0, 1); res.Next != 1 {
+		t.Error("Next", res.Next)
+	}
+
+	srv := NewServer(&Crawler{Config: NewDefaultConfig(), Search: s}, "")
+	w := httptest.NewRecorder()
+	srv.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/search?q=holiday", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "Holiday Video") {
+		t.Error("Search endpoint", w.Code, w.Body.String())
+	}
+}
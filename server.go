@@ -0,0 +1,150 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const DefaultRecentSize = 500
+
+type (
+	// Recent keeps the latest results in a ring for the feeds and the API.
+	Recent struct {
+		mu      sync.RWMutex
+		results []*MetadataResult
+		next    int
+		full    bool
+	}
+
+	// Server is the HTTP interface of a crawler.
+	Server struct {
+		Crawler *Crawler
+		Recent  *Recent
+		Mux     *http.ServeMux
+
+		srv *http.Server
+	}
+)
+
+func NewRecent(size int) *Recent {
+	if size <= 0 {
+		size = DefaultRecentSize
+	}
+	return &Recent{results: make([]*MetadataResult, size)}
+}
+
+func (r *Recent) Put(result *MetadataResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[r.next] = result
+	r.next = (r.next + 1) % len(r.results)
+	if r.next == 0 {
+		r.full = true
+	}
+	return nil
+}
+
+func (r *Recent) Close() error {
+	return nil
+}
+
+// Latest returns up to n results which pass match, newest first. A nil
+// match takes every result.
+func (r *Recent) Latest(n int, match func(*MetadataResult) bool) []*MetadataResult {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	count := r.next
+	if r.full {
+		count = len(r.results)
+	}
+	out := []*MetadataResult{}
+	for i := 0; i < count && len(out) < n; i++ {
+		result := r.results[(r.next-1-i+len(r.results))%len(r.results)]
+		if match == nil || match(result) {
+			out = append(out, result)
+		}
+	}
+	return out
+}
+
+func NewServer(c *Crawler, addr string) *Server {
+	s := &Server{
+		Crawler: c,
+		Recent:  NewRecent(c.Config.RecentSize),
+		Mux:     http.NewServeMux(),
+	}
+	s.srv = &http.Server{Addr: addr, Handler: s.Mux}
+	s.routes()
+	return s
+}
+
+// NewStoreServer serves the query API of an existing store without crawling.
+// The full-text index, the API keys and TLS of cfg apply, the live endpoints
+// stay quiet.
+func NewStoreServer(cfg *DHTConfig, store Store) (*Server, error) {
+	c := &Crawler{Config: cfg, Store: store, Hub: NewHub(), Logger: logPipeline, Auth: newAuthenticator(cfg)}
+	s := NewServer(c, cfg.HTTPAddr)
+	if cfg.TLS != nil {
+		tc, err := LoadTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		s.srv.TLSConfig = tc
+	}
+	if cfg.SearchPath != "" {
+		index, err := OpenSearchIndex(cfg.SearchPath)
+		if err != nil {
+			return nil, err
+		}
+		c.Search = index
+	}
+	return s, nil
+}
+
+func (s *Server) routes() {
+	s.Mux.HandleFunc("GET /{$}", s.public(s.handleDashboard))
+	s.Mux.HandleFunc("/feed.rss", s.public(s.handleRSS))
+	s.Mux.HandleFunc("/feed.atom", s.public(s.handleAtom))
+	s.Mux.HandleFunc("GET /torrents", s.public(s.handleTorrents))
+	s.Mux.HandleFunc("GET /torrents/{infohash}", s.public(s.handleTorrent))
+	s.Mux.HandleFunc("GET /stats", s.public(s.handleStats))
+	s.Mux.HandleFunc("GET /bandwidth", s.public(s.handleBandwidth))
+	s.Mux.HandleFunc("GET /search", s.public(s.handleSearch))
+	s.Mux.HandleFunc("GET /ws", s.public(s.handleWS))
+	s.Mux.HandleFunc("GET /metrics", s.public(metricsHandler(s.Crawler).ServeHTTP))
+	s.Mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.Mux.HandleFunc("GET /readyz", s.handleReadyz)
+	s.Mux.Handle("/debug/pprof/", s.pprofHandler())
+	s.Mux.Handle("/admin/", s.adminHandler())
+}
+
+// Addr returns the configured listen address.
+func (s *Server) Addr() string {
+	return s.srv.Addr
+}
+
+// ListenAndServe blocks until Close, it then returns http.ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves HTTPS when the crawler config has a tls section.
+func (s *Server) Serve(ln net.Listener) error {
+	ln = s.Crawler.Bandwidth.listener(ln, BandwidthHTTP)
+	if s.srv.TLSConfig != nil {
+		return s.srv.ServeTLS(ln, "", "")
+	}
+	return s.srv.Serve(ln)
+}
+
+// Close stops accepting requests and waits for the running ones until ctx
+// is done.
+func (s *Server) Close(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
@@ -1,26 +1,50 @@
 package DHTCrawl
 
 import (
+	"context"
 	"errors"
-	"log"
 	"net"
+	"sync/atomic"
 )
 
 type Session struct {
-	Conn       *net.UDPConn
-	result     chan *Result
+	Conn *net.UDPConn
+	// Results carries parsed packets to the DHT, packets arriving while it is
+	// full are dropped rather than stalling the socket reader.
+	Results    *Queue
 	rpc        *RPC
+	capture    captureSlot
+	bandwidth  bandwidthSlot
 	ExternalIP string
+	closed     int32
 }
 
 func NewSession(port int) (*Session, error) {
+	return newSession(port, false)
+}
+
+// newSession binds port with SO_REUSEPORT when reuse is set, several
+// processes then share it and the kernel spreads the remote nodes over them.
+func newSession(port int, reuse bool) (*Session, error) {
 	var addr *net.UDPAddr
 	if port == 0 {
 		addr = new(net.UDPAddr)
 	} else {
 		addr = &net.UDPAddr{IP: net.IP{0, 0, 0, 0}, Port: port}
 	}
-	conn, err := net.ListenUDP("udp", addr)
+	var (
+		conn *net.UDPConn
+		err  error
+	)
+	if reuse {
+		lc := net.ListenConfig{Control: reusePortControl}
+		var pc net.PacketConn
+		if pc, err = lc.ListenPacket(context.Background(), "udp", addr.String()); err == nil {
+			conn = pc.(*net.UDPConn)
+		}
+	} else {
+		conn, err = net.ListenUDP("udp", addr)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -33,9 +57,9 @@ func NewSession(port int) (*Session, error) {
 		return nil, err
 	}
 
-	session := &Session{Conn: conn, result: make(chan *Result), rpc: NewRPC()}
+	session := &Session{Conn: conn, Results: NewQueue("krpc", DefaultQueueSize, QueueDropNewest), rpc: NewRPC()}
 	session.ExternalIP, _ = session.GetExternalIP()
-	log.Printf("Start Crawl on %s", conn.LocalAddr().String())
+	logDHT.Info("start crawl", "addr", conn.LocalAddr())
 	go session.serve()
 	return session, nil
 }
@@ -78,17 +102,25 @@ func (s *Session) GetExternalIP() (string, error) {
 }
 
 func (s *Session) serve() {
-	buf := make([]byte, 1024)
+	//get_peers responses with many values are larger than 1KB
+	buf := make([]byte, 2048)
 	for {
-		_, addr, err := s.Conn.ReadFromUDP(buf)
+		n, addr, err := s.Conn.ReadFromUDP(buf)
 		if err != nil {
+			if atomic.LoadInt32(&s.closed) == 1 {
+				s.Results.Close()
+				return
+			}
 			continue
 		}
-		r, err := s.rpc.parse(buf, addr)
-		if err != nil {
+		s.capture.get().packet(CaptureIn, addr, buf[:n])
+		s.bandwidth.get().Add(BandwidthDHT, addr.IP, n, 0)
+		var r *Result
+		protect("packet", func() { r, err = s.rpc.parse(buf[:n], addr) })
+		if err != nil || r == nil {
 			continue
 		}
-		s.result <- r
+		s.Results.Push(r)
 	}
 }
 
@@ -96,5 +128,26 @@ func (s *Session) SendTo(data []byte, addr *net.UDPAddr) (int, error) {
 	if len(data) == 0 {
 		return 0, errors.New("Can't send empty []byte")
 	}
-	return s.Conn.WriteToUDP(data, addr)
+	s.capture.get().packet(CaptureOut, addr, data)
+	n, err := s.Conn.WriteToUDP(data, addr)
+	s.bandwidth.get().Add(BandwidthDHT, addr.IP, 0, n)
+	return n, err
+}
+
+// SetCapture records the packets to c from now on, nil stops recording.
+func (s *Session) SetCapture(c *Capture) {
+	s.capture.set(c)
+}
+
+// SetBandwidth counts the packets in b from now on, nil stops counting.
+func (s *Session) SetBandwidth(b *Bandwidth) {
+	s.bandwidth.set(b)
+}
+
+// Close closes the socket, Results is closed once the reader has stopped.
+func (s *Session) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+	return s.Conn.Close()
 }
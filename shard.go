@@ -0,0 +1,210 @@
+package DHTCrawl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+type (
+	// ShardConfig splits the fetch work of a host between Count crawler
+	// processes. With ReusePort they all bind the same DHT ports and the
+	// kernel spreads the remote nodes over them, every process still
+	// answers and walks the DHT as usual. A hash is fetched by the shard it
+	// belongs to by its first bytes, the announces of the hashes of other
+	// shards are sent to their forward socket or dropped without Forward.
+	// Every shard needs its own seed, state_path, http_addr and listen.
+	ShardConfig struct {
+		Index     int    `json:"index"`      //of this process, from 0
+		Count     int    `json:"count"`      //processes sharing the work
+		ReusePort bool   `json:"reuse_port"` //bind the DHT ports with SO_REUSEPORT
+		Forward   string `json:"forward"`    //host:port of the forward socket of shard 0, shard i listens on port+i
+	}
+
+	// ShardStats counts the announces a shard kept, handed to the other
+	// shards and got from them.
+	ShardStats struct {
+		Index     int    `json:"index"`
+		Count     int    `json:"count"`
+		Owned     uint64 `json:"owned"`
+		Forwarded uint64 `json:"forwarded"`
+		Received  uint64 `json:"received"`
+		Dropped   uint64 `json:"dropped"`  //of other shards, without forward or when sending failed
+		Rejected  uint64 `json:"rejected"` //datagrams not sent by the forward socket of a shard
+	}
+
+	// Shard is the Filter of the hashes of one shard. The hashes of the
+	// other shards are counted as filtered by the pool, the ones handed
+	// over by the other shards enter the pool through Serve.
+	Shard struct {
+		Index int
+		Count int
+
+		pool      *WireJob
+		conn      *net.UDPConn   //forward socket, nil without forward
+		peers     []*net.UDPAddr //forward sockets of the shards
+		owned     uint64
+		forwarded uint64
+		received  uint64
+		dropped   uint64
+		rejected  uint64
+	}
+)
+
+// NewShard binds the forward socket of cfg, the announces received on it
+// are added to pool.
+func NewShard(cfg *ShardConfig, pool *WireJob) (*Shard, error) {
+	s := &Shard{Index: cfg.Index, Count: cfg.Count, pool: pool}
+	if cfg.Forward == "" {
+		return s, nil
+	}
+	host, port, err := net.SplitHostPort(cfg.Forward)
+	if err != nil {
+		return nil, err
+	}
+	base, err := strconv.Atoi(port)
+	if err != nil || !IsValidPort(base+cfg.Count-1) {
+		return nil, fmt.Errorf("forward port %q", port)
+	}
+	for i := 0; i < cfg.Count; i++ {
+		addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(base+i)))
+		if err != nil {
+			return nil, err
+		}
+		s.peers = append(s.peers, addr)
+	}
+	if s.conn, err = net.ListenUDP("udp", s.peers[cfg.Index]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Owner returns the shard hash belongs to.
+func (s *Shard) Owner(hash Hash) int {
+	return int(binary.BigEndian.Uint32(hash[:4]) % uint32(s.Count))
+}
+
+// AllowHash keeps the hashes of this shard and forwards the others. A hash
+// without a peer, from an inbound connection, is fetched where it arrived.
+func (s *Shard) AllowHash(hash Hash, peer *net.TCPAddr) bool {
+	owner := s.Owner(hash)
+	if owner == s.Index || peer == nil {
+		atomic.AddUint64(&s.owned, 1)
+		return true
+	}
+	if s.conn == nil {
+		atomic.AddUint64(&s.dropped, 1)
+		metricShard.WithLabelValues("dropped").Inc()
+		return false
+	}
+	if err := forwardAnnounce(s.conn, s.peers[owner], hash, peer); err != nil {
+		atomic.AddUint64(&s.dropped, 1)
+		metricShard.WithLabelValues("dropped").Inc()
+		logPipeline.Debug("shard forward failed", "infohash", hash, "shard", owner, "error", err)
+		return false
+	}
+	atomic.AddUint64(&s.forwarded, 1)
+	metricShard.WithLabelValues("forwarded").Inc()
+	return false
+}
+
+func (s *Shard) AllowResult(*MetadataResult) bool {
+	return true
+}
+
+// Serve adds the announces the other shards forward to the pool until the
+// forward socket is closed. It returns at once without forward.
+func (s *Shard) Serve() error {
+	if s.conn == nil {
+		return nil
+	}
+	buf := make([]byte, 64)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if !s.isPeer(from) {
+			atomic.AddUint64(&s.rejected, 1)
+			metricShard.WithLabelValues("rejected").Inc()
+			logPipeline.Debug("shard forward rejected", "from", from)
+			continue
+		}
+		hash, peer, err := parseForward(buf[:n])
+		if err != nil || s.Owner(hash) != s.Index {
+			continue
+		}
+		atomic.AddUint64(&s.received, 1)
+		metricShard.WithLabelValues("received").Inc()
+		s.pool.Add(NewJob(hash, peer))
+	}
+}
+
+// isPeer tells whether addr is the forward socket of a shard. A socket bound
+// to the unspecified address sends from any local address, only the port is
+// compared for it and the sender must be this host.
+func (s *Shard) isPeer(addr *net.UDPAddr) bool {
+	for _, p := range s.peers {
+		if p.Port != addr.Port {
+			continue
+		}
+		if p.IP.Equal(addr.IP) || (p.IP.IsUnspecified() && addr.IP.IsLoopback()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Shard) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// Addr returns the forward socket, nil without forward.
+func (s *Shard) Addr() net.Addr {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+func (s *Shard) Stats() ShardStats {
+	return ShardStats{
+		Index:     s.Index,
+		Count:     s.Count,
+		Owned:     atomic.LoadUint64(&s.owned),
+		Forwarded: atomic.LoadUint64(&s.forwarded),
+		Received:  atomic.LoadUint64(&s.received),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+		Rejected:  atomic.LoadUint64(&s.rejected),
+	}
+}
+
+// forwardAnnounce sends an announce to the forward socket at addr, as the
+// infohash followed by the compact peer.
+func forwardAnnounce(conn *net.UDPConn, addr *net.UDPAddr, hash Hash, peer *net.TCPAddr) error {
+	compact, err := EncodeCompactPeer(peer)
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteToUDP(append(hash[:], compact...), addr)
+	return err
+}
+
+func parseForward(b []byte) (Hash, *net.TCPAddr, error) {
+	var hash Hash
+	if len(b) <= len(hash) {
+		return hash, nil, errCompactLength
+	}
+	copy(hash[:], b)
+	peer, err := DecodeCompactPeer(b[len(hash):])
+	return hash, peer, err
+}
@@ -0,0 +1,99 @@
+package DHTCrawl
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_ReusePort(t *testing.T) {
+	a, err := newSession(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	port := a.Conn.LocalAddr().(*net.UDPAddr).Port
+	b, err := newSession(port, true)
+	if err != nil {
+		t.Fatal("second bind of the port", err)
+	}
+	b.Close()
+	if c, err := newSession(port, false); err == nil {
+		c.Close()
+		t.Error("bound without SO_REUSEPORT")
+	}
+}
+
+func Test_Shard(t *testing.T) {
+	// two free ports in a row for the forward sockets
+	var base int
+	for i := 0; i < 10 && base == 0; i++ {
+		l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.LocalAddr().(*net.UDPAddr).Port
+		if next, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port + 1}); err == nil {
+			next.Close()
+			base = port
+		}
+		l.Close()
+	}
+	forward := net.JoinHostPort("127.0.0.1", strconv.Itoa(base))
+	pool := NewWireJob(1, 16)
+	defer pool.Stop()
+	s0, err := NewShard(&ShardConfig{Index: 0, Count: 2, Forward: forward}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s0.Close()
+	s1, err := NewShard(&ShardConfig{Index: 1, Count: 2, Forward: forward}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Close()
+	go s1.Serve()
+
+	mine, theirs := testHash("abcd"), testHash("abce")
+	if s0.Owner(mine) != 0 || s0.Owner(theirs) != 1 {
+		t.Fatal("owners", s0.Owner(mine), s0.Owner(theirs))
+	}
+	peer := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	if !s0.AllowHash(mine, peer) || s0.AllowHash(theirs, peer) || !s0.AllowHash(theirs, nil) {
+		t.Error("allowed")
+	}
+	for i := 0; i < 100 && s1.Stats().Received == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := s0.Stats(); st.Owned != 2 || st.Forwarded != 1 {
+		t.Errorf("%+v", st)
+	}
+	if st := s1.Stats(); st.Received != 1 {
+		t.Errorf("%+v", st)
+	}
+
+	// a datagram from anywhere else isn't an announce of a shard
+	stranger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+	if err := forwardAnnounce(stranger, s1.Addr().(*net.UDPAddr), theirs, peer); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && s1.Stats().Rejected == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := s1.Stats(); st.Received != 1 || st.Rejected != 1 {
+		t.Errorf("%+v", st)
+	}
+
+	lone, err := NewShard(&ShardConfig{Index: 0, Count: 2}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lone.AllowHash(theirs, peer) || lone.Stats().Dropped != 1 {
+		t.Error("announce of another shard kept without forward")
+	}
+}
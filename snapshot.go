@@ -0,0 +1,315 @@
+package DHTCrawl
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// SnapshotVersion is written to every snapshot, Restore refuses the others.
+const SnapshotVersion = 1
+
+// The files of a snapshot archive.
+const (
+	snapshotMeta    = "meta.json"
+	snapshotState   = "state.json"   //routing tables and pending jobs, as in state_path
+	snapshotPeers   = "peers.json"   //peer store, the least recently announced hash first
+	snapshotRefetch = "refetch.json" //failed hashes waiting for a retry
+	snapshotStats   = "stats.json"
+)
+
+type (
+	snapshotHeader struct {
+		Version int       `json:"version"`
+		Time    time.Time `json:"time"`
+		Nodes   int       `json:"nodes"`
+	}
+
+	peerState struct {
+		Hash      string    `json:"hash"`
+		Peers     []string  `json:"peers"` //the oldest announcer first
+		Announces int       `json:"announces"`
+		Last      time.Time `json:"last"`
+	}
+
+	refetchState struct {
+		Hash     string    `json:"hash"`
+		Attempts int       `json:"attempts"`
+		Next     time.Time `json:"next"`
+	}
+
+	statsState struct {
+		Succeeded uint64       `json:"succeeded"`
+		Failed    uint64       `json:"failed"`
+		Limited   uint64       `json:"limited"`
+		Filtered  uint64       `json:"filtered"`
+		Shed      uint64       `json:"shed"`
+		Rejected  uint64       `json:"rejected"`
+		Fetch     FetchStats   `json:"fetch"`
+		Nodes     []nodeCounts `json:"nodes"`
+	}
+
+	nodeCounts struct {
+		Queries   uint64 `json:"queries"`
+		Announces uint64 `json:"announces"`
+		Dropped   uint64 `json:"dropped"`
+	}
+)
+
+// Snapshot writes the warm state of c to w as a gzipped tar of JSON files:
+// the routing tables, the jobs queued or downloading, the peer store, the
+// failed hashes waiting for a retry and the counters. It is safe to call
+// while crawling, the pieces are read one after the other.
+func (c *Crawler) Snapshot(w io.Writer) error {
+	if c.Pool == nil {
+		return errNoPool
+	}
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	now := time.Now()
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{snapshotMeta, snapshotHeader{Version: SnapshotVersion, Time: now.UTC(), Nodes: len(c.Nodes)}},
+		{snapshotState, newCrawlerState(c.tables(), c.Pool.Pending())},
+		{snapshotPeers, c.Pool.Peers.snapshot()},
+		{snapshotRefetch, c.Pool.Refetch.snapshot()},
+		{snapshotStats, c.snapshotStats()},
+	}
+	for _, f := range files {
+		data, err := json.Marshal(f.v)
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Restore reads a snapshot of Snapshot into c, before Run. The nodes and
+// IDs of the tables are restored in order, the saved jobs are queued, the
+// peer store and the retries are merged and the counters are added.
+// Unknown files are skipped, a snapshot of another version is refused.
+func (c *Crawler) Restore(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	versioned := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name != snapshotMeta && !versioned {
+			return errors.New("snapshot without " + snapshotMeta)
+		}
+		dec := json.NewDecoder(tr)
+		switch hdr.Name {
+		case snapshotMeta:
+			h := snapshotHeader{}
+			if err := dec.Decode(&h); err != nil {
+				return err
+			}
+			if h.Version != SnapshotVersion {
+				return fmt.Errorf("snapshot version %d, want %d", h.Version, SnapshotVersion)
+			}
+			if h.Nodes != len(c.Nodes) {
+				c.Logger.Warn("snapshot of another node count", "snapshot", h.Nodes, "nodes", len(c.Nodes))
+			}
+			versioned = true
+		case snapshotState:
+			st := crawlerState{}
+			if err := dec.Decode(&st); err != nil {
+				return err
+			}
+			jobs, err := st.restore(c.tables())
+			if err != nil {
+				return err
+			}
+			for _, job := range jobs {
+				c.Pool.Add(job)
+			}
+		case snapshotPeers:
+			peers := []peerState{}
+			if err := dec.Decode(&peers); err != nil {
+				return err
+			}
+			c.Pool.Peers.restore(peers)
+		case snapshotRefetch:
+			failed := []refetchState{}
+			if err := dec.Decode(&failed); err != nil {
+				return err
+			}
+			c.Pool.Refetch.restore(failed)
+		case snapshotStats:
+			st := statsState{}
+			if err := dec.Decode(&st); err != nil {
+				return err
+			}
+			c.restoreStats(&st)
+		}
+	}
+	if !versioned {
+		return errors.New("empty snapshot")
+	}
+	return nil
+}
+
+func (c *Crawler) snapshotStats() *statsState {
+	p := c.Pool
+	st := &statsState{
+		Succeeded: atomic.LoadUint64(&p.succeeded),
+		Failed:    atomic.LoadUint64(&p.failed),
+		Limited:   p.Limited(),
+		Filtered:  p.Filtered(),
+		Shed:      p.Shed(),
+		Rejected:  c.Rejected(),
+		Fetch:     p.Stats(),
+	}
+	for _, node := range c.Nodes {
+		st.Nodes = append(st.Nodes, nodeCounts{
+			Queries:   atomic.LoadUint64(&node.queries),
+			Announces: atomic.LoadUint64(&node.announces),
+			Dropped:   atomic.LoadUint64(&node.dropped),
+		})
+	}
+	return st
+}
+
+func (c *Crawler) restoreStats(st *statsState) {
+	p := c.Pool
+	atomic.AddUint64(&p.succeeded, st.Succeeded)
+	atomic.AddUint64(&p.failed, st.Failed)
+	atomic.AddUint64(&p.limited, st.Limited)
+	atomic.AddUint64(&p.filtered, st.Filtered)
+	atomic.AddUint64(&p.shed, st.Shed)
+	atomic.AddUint64(&c.rejected, st.Rejected)
+	p.counters.restore(st.Fetch)
+	for i, n := range st.Nodes {
+		if i >= len(c.Nodes) {
+			break
+		}
+		atomic.AddUint64(&c.Nodes[i].queries, n.Queries)
+		atomic.AddUint64(&c.Nodes[i].announces, n.Announces)
+		atomic.AddUint64(&c.Nodes[i].dropped, n.Dropped)
+	}
+}
+
+// restore adds the counters of st, the average handshake latency is
+// weighted by the handshakes.
+func (c *fetchCounters) restore(st FetchStats) {
+	atomic.AddUint64(&c.attempts, st.Attempts)
+	atomic.AddUint64(&c.succeeded, st.Succeeded)
+	atomic.AddUint64(&c.handshakes, st.Handshakes)
+	atomic.AddUint64(&c.handshakeNs, uint64(st.HandshakeLatency)*st.Handshakes)
+	atomic.AddUint64(&c.bytesRead, st.BytesRead)
+	atomic.AddUint64(&c.bytesWritten, st.BytesWritten)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures == nil {
+		c.failures = map[string]uint64{}
+		c.byClient = FailureBreakdown{}
+		c.byNetwork = FailureBreakdown{}
+	}
+	for reason, n := range st.Failures {
+		c.failures[reason] += n
+	}
+	for _, b := range []struct{ to, from FailureBreakdown }{{c.byClient, st.ByClient}, {c.byNetwork, st.ByNetwork}} {
+		for group, reasons := range b.from {
+			for reason, n := range reasons {
+				b.to.addN(group, reason, n)
+			}
+		}
+	}
+}
+
+// snapshot returns the entries of the store, the least recently announced
+// first.
+func (s *PeerStore) snapshot() []peerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]peerState, 0, s.lru.Len())
+	for el := s.lru.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*peerEntry)
+		ps := peerState{Hash: e.hash.Hex(), Announces: e.announces, Last: e.last}
+		for _, p := range e.peers {
+			ps.Peers = append(ps.Peers, p.String())
+		}
+		out = append(out, ps)
+	}
+	return out
+}
+
+// restore adds the entries in order, a hash already known keeps its own.
+func (s *PeerStore) restore(entries []peerState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ps := range entries {
+		hash, err := HashFromHex(ps.Hash)
+		if err != nil {
+			continue
+		}
+		if _, ok := s.hashes[hash]; ok {
+			continue
+		}
+		e := &peerEntry{hash: hash, announces: ps.Announces, last: ps.Last}
+		for _, p := range ps.Peers {
+			if addr, err := net.ResolveTCPAddr("tcp", p); err == nil && len(e.peers) < s.peersPerHash {
+				e.peers = append(e.peers, addr)
+			}
+		}
+		s.hashes[hash] = s.lru.PushFront(e)
+		if s.lru.Len() > s.size {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.hashes, oldest.Value.(*peerEntry).hash)
+		}
+	}
+}
+
+func (r *Refetcher) snapshot() []refetchState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]refetchState, 0, len(r.failed))
+	for el := r.lru.Back(); el != nil; el = el.Prev() {
+		f := el.Value.(*failedHash)
+		out = append(out, refetchState{Hash: f.hash.Hex(), Attempts: f.attempts, Next: f.next})
+	}
+	return out
+}
+
+func (r *Refetcher) restore(failed []refetchState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, fs := range failed {
+		hash, err := HashFromHex(fs.Hash)
+		if err != nil || len(r.failed) >= refetchMaxFailed {
+			continue
+		}
+		if _, ok := r.failed[hash]; !ok {
+			now := clockOr(r.Clock).Now()
+			r.failed[hash] = r.lru.PushFront(&failedHash{hash: hash, attempts: fs.Attempts, next: fs.Next, seen: now, tried: map[string]bool{}})
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_Snapshot(t *testing.T) {
+	newCrawler := func() *Crawler {
+		c, err := NewCrawler(WithPort(0), WithNodes(1), WithWorkers(1), WithBootstraps())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			c.closeNodes()
+			c.Pool.Stop()
+		})
+		return c
+	}
+	a := newCrawler()
+	peer := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	a.Nodes[0].Table.Add(&Node{ID: NewNodeID(), Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 6881}})
+	a.Pool.Peers.Add(testHash("old"), peer)
+	a.Pool.Peers.Add(testHash("new"), peer)
+	a.Pool.Peers.Add(testHash("new"), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 6881})
+	a.Pool.Refetch.observe(&MetadataResult{Hash: testHash("failed")})
+	a.Pool.succeeded, a.Pool.filtered, a.Nodes[0].queries = 3, 2, 100
+	a.Pool.counters.done(&FetchError{Failure: FailDial}, peer, "")
+	a.Pool.counters.handshake(10 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := a.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b := newCrawler()
+	if err := b.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if b.Nodes[0].Table.Self.Hex() != a.Nodes[0].Table.Self.Hex() || len(b.Nodes[0].Table.snapshot()) != 1 {
+		t.Error("table not restored")
+	}
+	if b.Pool.Peers.Len() != 2 || len(b.Pool.Peers.Peers(testHash("new"))) != 2 || b.Pool.Peers.Announces(testHash("new")) != 2 {
+		t.Error("peer store not restored", b.Pool.Peers.Len())
+	}
+	// the least recently announced is still the first forgotten
+	if ps := b.Pool.Peers.snapshot(); ps[0].Hash != testHash("old").Hex() {
+		t.Error("order of the peer store", ps)
+	}
+	if b.Pool.Refetch.Pending() != 1 {
+		t.Error("retries not restored")
+	}
+	st := b.Stats()
+	if st.Succeeded != 3 || st.Filtered != 2 || st.Queries != 100 || st.Fetch.Failures[FailDial] != 1 || st.Fetch.HandshakeLatency != 10*time.Millisecond {
+		t.Errorf("stats %+v", st)
+	}
+
+	if err := b.Restore(bytes.NewReader([]byte("not a snapshot"))); err == nil {
+		t.Error("garbage restored")
+	}
+}
@@ -5,7 +5,11 @@ import (
 	//"string"
 	// "fmt"
 	"log"
+	"math/rand"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,17 +22,103 @@ type (
 		Bootstraps      []string
 		Token           *Token
 		HashHandler     HashHandler
+		SampleHandler   func(Hash) //gets the infohash of every get_peers query, nil ignores them
+		Honeypot        *Honeypot  //hands out the listener to get_peers, nil without honeypot
 		MetadataHandler ResultHandler
 		JobPool         *WireJob
 		Handler         Collector
+
+		ids       *NodeIDSource //seeded IDs, nil uses NewNodeID
+		clock     Clock
+		responses *ResponseLimiter
+		throttle  *QueryLimiter
+		closing   chan struct{}
+		closeOnce sync.Once
+		mu        sync.RWMutex
+		paused    int32
+		queries   uint64
+		announces uint64
+		dropped   uint64 //responses over the budget
+		throttled uint64 //queries over the budget of their network
 	}
 
 	DHTConfig struct {
-		RemoteServer  string //fetch metainfo server address
-		Port          int    //DHT UDP listen port
-		TokenValidity int    //token validity (minute)
-		JobSize       int
-		Entries       []string
+		RemoteServer   string   `json:"remote_server"`  //fetch metainfo server address
+		Port           int      `json:"port"`           //DHT UDP listen port
+		TokenValidity  int      `json:"token_validity"` //token validity (minute)
+		Nodes          int      `json:"nodes"`          //DHT nodes run by a crawler, on consecutive ports
+		JobSize        int      `json:"job_size"`
+		MinJobSize     int      `json:"min_job_size"` //with max_job_size the pool scales between these bounds
+		MaxJobSize     int      `json:"max_job_size"`
+		QueueSize      int      `json:"queue_size"`        //capacity of every pipeline queue
+		StatePath      string   `json:"state_path"`        //routing table and pending jobs are saved here on shutdown
+		StoreDriver    string   `json:"store"`             //bolt, sqlite or postgres
+		StorePath      string   `json:"store_path"`        //file the results are persisted to, the DSN for postgres
+		Compression    string   `json:"store_compression"` //zstd or snappy blobs in bolt and sqlite
+		InfoCachePath  string   `json:"info_cache_path"`   //directory of the raw info dictionaries, kept before any sink sees them
+		FetchRate      float64  `json:"fetch_rate"`        //new hashes fetched per second, 0 is unlimited
+		FetchBurst     int      `json:"fetch_burst"`
+		RefetchEvery   int      `json:"refetch_every"`     //seconds between retries of failed popular hashes
+		RefetchTries   int      `json:"refetch_attempts"`  //retries before a hash is given up
+		ConnectTimeout int      `json:"connect_timeout"`   //seconds to wait for a peer to accept, 0 is WireConnectTimeout
+		FetchTimeout   int      `json:"fetch_timeout"`     //seconds a metadata download may take, 0 is WireTimeout
+		MaxMessage     int      `json:"max_message_size"`  //bytes of a peer wire message, 0 is DefaultMaxMessage
+		MaxMetadata    int      `json:"max_metadata_size"` //bytes of the metadata of a torrent, 0 is MaxMetadataSize
+		MaxItems       int      `json:"max_bencode_items"` //values of a KRPC packet or an extended message, 0 is DefaultMaxBencodeItems
+		ResponseRate   float64  `json:"response_rate"`     //get_peers responses per second to one IP, 0 is DefaultResponseRate
+		ResponseBurst  int      `json:"response_burst"`
+		ResponseTotal  float64  `json:"response_rate_total"` //get_peers responses per second of a crawler, 0 is DefaultResponseTotal
+		QueryRate      float64  `json:"query_rate"`          //find_node queries per second to one /24 or /48, 0 is DefaultQueryRate
+		QueryBurst     int      `json:"query_burst"`
+		PeerStoreSize  int      `json:"peer_store_size"` //hashes whose announcing peers are remembered
+		PeersPerHash   int      `json:"peers_per_hash"`
+		PartialSize    int      `json:"partial_store_size"` //megabytes of partial metadata kept for the next attempt, 0 is DefaultPartialStoreSize, -1 keeps none
+		PartialTTL     int      `json:"partial_ttl"`        //minutes, 0 is DefaultPartialTTL
+		Entries        []string `json:"entries"`
+		Seed           int64    `json:"seed"` //non-zero makes the node IDs, the walk and the tokens reproducible
+
+		ContentRules []ContentRule `json:"content_rules"` //drop or tag results by name and file paths
+
+		HTTPAddr   string   `json:"http_addr"`   //listen address of the HTTP server, empty disables it
+		WSOrigins  []string `json:"ws_origins"`  //origins of other sites allowed to open /ws, "*" is any
+		RecentSize int      `json:"recent_size"` //latest results kept for the feeds
+		SearchPath string   `json:"search_path"` //directory of the full-text index, empty disables /search
+		GRPCAddr   string   `json:"grpc_addr"`   //listen address of the gRPC API, empty disables it
+		Pprof      bool     `json:"pprof"`       //serve /debug/pprof on the HTTP server, can be toggled by a reload
+		PprofToken string   `json:"pprof_token"` //bearer token required by /debug/pprof when set, the API keys otherwise
+		AdminToken string   `json:"admin_token"` //bearer token of the /admin API, empty disables it
+
+		Log     *LogConfig       `json:"log,omitempty"`        //levels by subsystem, reloadable, and the format of the logs
+		Tracing *TracingConfig   `json:"tracing,omitempty"`    //export a trace of every metadata download over OTLP
+		StatsD  *StatsDConfig    `json:"statsd,omitempty"`     //send the metrics to a StatsD or DogStatsD agent
+		Memory  *MemoryConfig    `json:"memory,omitempty"`     //shed load instead of running out of memory
+		Scores  *PeerScoreConfig `json:"peer_score,omitempty"` //try the peers which did well first, ban the ones which keep failing
+		Shard   *ShardConfig     `json:"shard,omitempty"`      //split the fetch work with other processes on the same ports
+		Cluster *ClusterConfig   `json:"cluster,omitempty"`    //split the keyspace with the crawlers of other machines, needs redis
+		GeoIP   *GeoIPConfig     `json:"geoip,omitempty"`      //tag announces and sources with their country and ASN
+
+		Listen   *PeerListenerConfig `json:"listen,omitempty"`   //accept BitTorrent connections and fetch the hashes they ask for
+		Capture  *CaptureConfig      `json:"capture,omitempty"`  //record the raw peer wire and KRPC traffic for replay
+		Announce *AnnounceConfig     `json:"announce,omitempty"` //announce the hashes being fetched on the port of listen
+		Honeypot *HoneypotConfig     `json:"honeypot,omitempty"` //answer get_peers with the port of listen
+		LSD      *LSDConfig          `json:"lsd,omitempty"`      //BEP 14 local service discovery on the multicast groups
+
+		AnnounceLog *AnnounceLogConfig `json:"announce_log,omitempty"` //append what is announced to segment files, dhtcrawl backfill replays them
+
+		Trackers *TrackerConfig `json:"trackers,omitempty"` //scrape the seeders and leechers of the torrents before storing them
+		Health   *HealthConfig  `json:"health,omitempty"`   //re-check the swarms of the stored torrents over the DHT
+
+		Auth *AuthConfig `json:"auth,omitempty"` //API keys of the HTTP, WebSocket and gRPC APIs, open without
+		TLS  *TLSConfig  `json:"tls,omitempty"`  //serve HTTPS and gRPC over TLS
+
+		Elastic *ElasticConfig `json:"elastic,omitempty"` //index results into Elasticsearch
+		Kafka   *KafkaConfig   `json:"kafka,omitempty"`   //publish results to a Kafka topic
+		NATS    *NATSConfig    `json:"nats,omitempty"`    //publish results to NATS or JetStream
+		JSONL   *JSONLConfig   `json:"jsonl,omitempty"`   //append results to a JSON lines file
+		Redis   *RedisConfig   `json:"redis,omitempty"`   //share the seen hashes with other crawlers
+		Archive *ArchiveConfig `json:"archive,omitempty"` //upload torrents to S3 or MinIO
+		Webhook *WebhookConfig `json:"webhook,omitempty"` //POST results to HTTP endpoints
+		MQTT    *MQTTConfig    `json:"mqtt,omitempty"`    //publish events to an MQTT broker
 	}
 
 	Collector interface {
@@ -49,11 +139,24 @@ var (
 	DBValueUnIndexID = []byte{0x00, 0x00}
 )
 
+// DBPath is the leveldb of hashes and document ids used by the command line
+// tools, DHTCRAWL_DB overrides the default.
+func DBPath() string {
+	if path := os.Getenv("DHTCRAWL_DB"); path != "" {
+		return path
+	}
+	return "dhtcrawl.leveldb"
+}
+
 func NewDefaultConfig() *DHTConfig {
 	return &DHTConfig{
 		TokenValidity: 5,
 		Port:          2412,
+		Nodes:         1,
 		JobSize:       500,
+		QueueSize:     DefaultQueueSize,
+		RefetchEvery:  int(DefaultRefetchInterval / time.Second),
+		RefetchTries:  DefaultRefetchAttempts,
 		Entries: []string{
 			"67.215.246.10:6881",
 			"212.129.33.50:6881",
@@ -66,88 +169,231 @@ func NewDHT(cfg *DHTConfig) *DHT {
 	if cfg == nil {
 		cfg = NewDefaultConfig()
 	}
-	session, err := NewSession(cfg.Port)
+	pool := NewWireJob(cfg.JobSize, cfg.QueueSize)
+	pool.Limiter.SetRate(cfg.FetchRate, cfg.FetchBurst)
+	d, err := newDHT(cfg, 0, cfg.Port, pool, SystemClock, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return d
+}
+
+// newDHT starts the node-th node on port, several nodes may share one pool.
+// With src the node seeds its IDs and tokens from it instead of cfg.Seed.
+func newDHT(cfg *DHTConfig, node, port int, pool *WireJob, clock Clock, src rand.Source) (*DHT, error) {
+	session, err := newSession(port, cfg.Shard != nil && cfg.Shard.ReusePort)
+	if err != nil {
+		return nil, err
+	}
+	session.rpc.SetMaxItems(cfg.MaxItems)
+	table := NewTable()
+	var ids *NodeIDSource
+	tokens := rand.NewSource(time.Now().UnixNano())
+	if seed := cfg.Seed + int64(node); cfg.Seed != 0 || src != nil {
+		if src != nil {
+			seed = src.Int63()
+		}
+		ids = NewNodeIDSource(seed)
+		table.Self = ids.NodeID()
+		// a stream of its own, the IDs don't depend on how often the token rotated
+		tokens = rand.NewSource(^seed)
+	}
+	responses := newResponseLimiter(cfg)
+	responses.SetClock(clock)
+	throttle := newQueryLimiter(cfg)
+	throttle.SetClock(clock)
 	return &DHT{
 		Session:    session,
-		Table:      NewTable(),
-		Token:      NewToken(cfg.TokenValidity),
-		JobPool:    NewWireJob(cfg.JobSize),
+		Table:      table,
+		ids:        ids,
+		clock:      clock,
+		responses:  responses,
+		throttle:   throttle,
+		Token:      newToken(cfg.TokenValidity, clock, tokens),
+		JobPool:    pool,
 		Bootstraps: cfg.Entries,
-	}
+		closing:    make(chan struct{}),
+	}, nil
 }
 
 func (d *DHT) Run() {
 	go d.Walk()
 	go func() {
-		for data := range d.JobPool.Result {
+		for v := range d.JobPool.Results.C() {
 			if d.MetadataHandler != nil {
-				d.MetadataHandler(data)
+				d.MetadataHandler(v.(*MetadataResult))
 			}
 		}
 	}()
+	d.Serve()
+}
 
+// Serve answers KRPC packets until the session is closed.
+func (d *DHT) Serve() {
 	// d.RPCClient.Start()
 	// defer d.RPCClient.Stop()
 	// dc := Dispatcher.NewServiceClient("FetchMetaInfo", d.RPCClient)
-	for {
-		r := <-d.Session.result
-		switch r.Cmd {
-		case OP_FIND_NODE:
-			for _, node := range r.Nodes {
-				if node.ID.Hex() != d.Table.Self.Hex() && d.Session.ExternalIP != node.Addr.IP.String() {
-					d.Table.Add(node)
-				}
+	for v := range d.Session.Results.C() {
+		r := v.(*Result)
+		if r.Cmd == OP_FIND_NODE {
+			metricResponses.Inc()
+		} else {
+			metricQueries.WithLabelValues(r.Cmd).Inc()
+			atomic.AddUint64(&d.queries, 1)
+			logDHT.Debug("query", "type", r.Cmd, "addr", r.UDPAddr, "tid", r.Tid)
+		}
+		protect("query", func() { d.handle(r) })
+	}
+}
+
+func (d *DHT) handle(r *Result) {
+	switch r.Cmd {
+	case OP_FIND_NODE:
+		for _, node := range r.Nodes {
+			if node.ID.Hex() != d.Table.Self.Hex() && d.Session.ExternalIP != node.Addr.IP.String() {
+				d.Table.Add(node)
 			}
+		}
+
+	case OP_PING:
+		d.Session.SendTo(PacketPong(r.ID, d.Table.Self, r.Tid), r.UDPAddr)
+
+	case OP_GET_PEERS:
+		if d.SampleHandler != nil {
+			d.SampleHandler(r.Hash)
+		}
+		// the response is several times the size of the query, its source
+		// may be spoofed: over the budget the query goes unanswered
+		if !d.responses.Allow(r.UDPAddr.IP) {
+			atomic.AddUint64(&d.dropped, 1)
+			metricResponsesDropped.Inc()
+			return
+		}
+		ns := ConvertByteStream(d.Table.Last)
+		if values := d.Honeypot.values(r.Hash, d.Session.ExternalIP); values != nil {
+			d.Session.SendTo(PacketGetPeersValues(r.Hash, r.ID, d.Table.Self, ns, values, d.Token.Value, r.Tid), r.UDPAddr)
+			return
+		}
+		d.Session.SendTo(PacketGetPeers(r.Hash, r.ID, d.Table.Self, ns, d.Token.Value, r.Tid), r.UDPAddr)
 
-		case OP_PING:
-			d.Session.SendTo(PacketPong(r.ID, d.Table.Self, r.Tid), r.UDPAddr)
-
-		case OP_GET_PEERS:
-			ns := ConvertByteStream(d.Table.Last)
-			d.Session.SendTo(PacketGetPeers(r.Hash, r.ID, d.Table.Self, ns, d.Token.Value, r.Tid), r.UDPAddr)
-
-		case OP_ANNOUNCE_PEER:
-			if d.Token.IsValid(r.Token) {
-				d.Session.SendTo(PacketAnnucePeer(r.Hash, r.ID, d.Table.Self, r.Tid), r.UDPAddr)
-				if d.HashHandler != nil {
-					need := d.HashHandler(r.Hash)
-					if need {
-						//fetch metadata info from tcp port (bep_09, bep_10)
-						d.JobPool.Add(NewJob(r.Hash, r.TCPAddr))
-					}
+	case OP_ANNOUNCE_PEER:
+		if !d.Token.IsValid(r.Token) {
+			logDHT.Debug("announce with a stale token", "infohash", r.Hash, "peer", r.TCPAddr, "tid", r.Tid)
+		} else {
+			atomic.AddUint64(&d.announces, 1)
+			d.Session.SendTo(PacketAnnucePeer(r.Hash, r.ID, d.Table.Self, r.Tid), r.UDPAddr)
+			if d.HashHandler != nil && !d.Paused() {
+				need := d.HashHandler(r.Hash)
+				if need {
+					//fetch metadata info from tcp port (bep_09, bep_10)
+					d.JobPool.Add(NewJob(r.Hash, r.TCPAddr))
 				}
 			}
 		}
 	}
 }
 
+// SetBootstraps replaces the entry nodes used whenever the table runs empty.
+func (d *DHT) SetBootstraps(entries []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Bootstraps = entries
+}
+
 func (d *DHT) Join() {
-	for _, b := range d.Bootstraps {
+	d.mu.RLock()
+	entries := d.Bootstraps
+	d.mu.RUnlock()
+	for _, b := range entries {
 		addr, err := net.ResolveUDPAddr("udp", b)
 		if err != nil {
 			continue
 		}
-		d.Session.SendTo(PacketFindNode(d.Table.Self, NewNodeID()), addr)
+		if d.allowQuery(addr) {
+			d.Session.SendTo(PacketFindNode(d.Table.Self, d.newID()), addr)
+		}
 	}
 }
 
+// allowQuery takes a token of the network of addr, the queries over its
+// budget are counted and not sent.
+func (d *DHT) allowQuery(addr *net.UDPAddr) bool {
+	if d.throttle.Allow(addr.IP) {
+		return true
+	}
+	atomic.AddUint64(&d.throttled, 1)
+	metricQueriesThrottled.Inc()
+	return false
+}
+
+// newID returns a random target, from the seeded source when there is one.
+func (d *DHT) newID() NodeID {
+	if d.ids != nil {
+		return d.ids.NodeID()
+	}
+	return NewNodeID()
+}
+
 func (d *DHT) Walk() {
-	for {
-		if len(d.Table.Nodes) == 0 || d.Table.Nodes == nil {
+	for !d.isClosed() {
+		if d.Paused() {
+			select {
+			case <-d.closing:
+			case <-after(d.clock, time.Millisecond*800):
+			}
+		} else if d.Table.Len() == 0 {
 			d.Join()
-			time.AfterFunc(time.Millisecond*800, d.Walk)
+			select {
+			case <-d.closing:
+			case <-after(d.clock, time.Millisecond*800):
+			}
 		} else {
 			d.Table.Each(func(node *Node, _ int) {
-				d.Session.SendTo(PacketFindNode(node.ID.Neighbor(d.Table.Self), NewNodeID()), node.Addr)
+				if d.allowQuery(node.Addr) {
+					d.Session.SendTo(PacketFindNode(node.ID.Neighbor(d.Table.Self), d.newID()), node.Addr)
+				}
 			})
 			d.Table.Flush()
 		}
 	}
 }
 
+// Queues returns every bounded queue from the socket to the store stage, their
+// stats show where the pipeline is dropping work.
+func (d *DHT) Queues() []*Queue {
+	return append([]*Queue{d.Session.Results}, d.JobPool.Queues()...)
+}
+
+// Close stops walking and closes the UDP socket, Serve returns once the
+// packets already queued are handled.
+func (d *DHT) Close() error {
+	d.closeOnce.Do(func() { close(d.closing) })
+	return d.Session.Close()
+}
+
+// Pause stops walking the DHT and queuing announced hashes, the node keeps
+// answering queries so it stays in the routing tables of its peers.
+func (d *DHT) Pause() {
+	atomic.StoreInt32(&d.paused, 1)
+}
+
+func (d *DHT) Resume() {
+	atomic.StoreInt32(&d.paused, 0)
+}
+
+func (d *DHT) Paused() bool {
+	return atomic.LoadInt32(&d.paused) == 1
+}
+
+func (d *DHT) isClosed() bool {
+	select {
+	case <-d.closing:
+		return true
+	default:
+		return false
+	}
+}
+
 func (d *DHT) HandleHash(h HashHandler) {
 	d.HashHandler = h
 }
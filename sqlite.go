@@ -0,0 +1,261 @@
+package DHTCrawl
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteAnnounceBatch = 256
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// SQLiteStore keeps results in a SQLite file: one torrents row per hash
+// with the full result as JSON, its files in files and the raw announces in
+// announces. Announces are buffered and written in batches. With
+// SetCompression the JSON is a compressed blob, the other columns stay
+// queryable.
+type SQLiteStore struct {
+	db          *sql.DB
+	compression string
+
+	mu        sync.Mutex
+	announces []*Announce
+}
+
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// one connection avoids SQLITE_BUSY between our own writers
+	db.SetMaxOpenConns(1)
+	if err := migrate(db, sqliteMigrations, "migrations/sqlite"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// DB exposes the database for ad hoc queries.
+func (s *SQLiteStore) DB() *sql.DB {
+	return s.db
+}
+
+// SetCompression compresses the results written from now on, it must not
+// be called concurrently with Put.
+func (s *SQLiteStore) SetCompression(codec string) {
+	s.compression = codec
+}
+
+func (s *SQLiteStore) Put(r *MetadataResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	var value interface{} = string(data)
+	if s.compression != CompressNone {
+		if value, err = compressBlob(s.compression, data); err != nil {
+			return err
+		}
+	}
+	hex := r.Hash.Hex()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var checked int64
+	if t := r.checked(); !t.IsZero() {
+		checked = t.Unix()
+	}
+	_, err = tx.Exec(`INSERT INTO torrents (hash, name, length, category, type, created, data, alive, checked) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET name = excluded.name, length = excluded.length,
+		category = excluded.category, type = excluded.type, data = excluded.data, alive = excluded.alive, checked = excluded.checked`,
+		hex, r.Name, r.TotalLength(), r.Category, r.Type, r.created().Unix(), value, r.alive().Unix(), checked)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM files WHERE hash = ?`, hex); err != nil {
+		return err
+	}
+	for i, f := range r.Files {
+		if _, err := tx.Exec(`INSERT INTO files (hash, idx, path, length) VALUES (?, ?, ?, ?)`, hex, i, strings.Join(f.Path, "/"), f.Length); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Len() (n int, err error) {
+	err = s.db.QueryRow(`SELECT COUNT(1) FROM torrents`).Scan(&n)
+	return
+}
+
+// Writable takes the reserved lock and rolls back, it fails when another
+// process holds the database or the file is read only.
+func (s *SQLiteStore) Writable() error {
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(context.Background(), `ROLLBACK`)
+	return err
+}
+
+func (s *SQLiteStore) Has(hash Hash) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(1) FROM torrents WHERE hash = ?`, hash.Hex()).Scan(&n)
+	return n > 0, err
+}
+
+func (s *SQLiteStore) Get(hash Hash) (*MetadataResult, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM torrents WHERE hash = ?`, hash.Hex()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeStored(hash, []byte(data))
+}
+
+func (s *SQLiteStore) Iterate(fn func(*MetadataResult) bool) error {
+	rows, err := s.db.Query(`SELECT hash, data FROM torrents ORDER BY created`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hex, data string
+		if err := rows.Scan(&hex, &data); err != nil {
+			return err
+		}
+		r, err := decodeStoredHex(hex, []byte(data))
+		if err != nil {
+			return err
+		}
+		if !fn(r) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStore) Query(q TorrentQuery) ([]*MetadataResult, error) {
+	where, args := q.where(func(int) string { return "?" }, func(t time.Time) interface{} { return t.Unix() })
+	query := `SELECT hash, data FROM torrents` + where + ` ORDER BY created DESC`
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", q.Limit, q.Offset)
+	} else if q.Offset > 0 {
+		query += fmt.Sprintf(" LIMIT -1 OFFSET %d", q.Offset)
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	results := []*MetadataResult{}
+	for rows.Next() {
+		var hex, data string
+		if err := rows.Scan(&hex, &data); err != nil {
+			return nil, err
+		}
+		r, err := decodeStoredHex(hex, []byte(data))
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// Stale reads the checked column, the results never checked have 0.
+func (s *SQLiteStore) Stale(before time.Time, limit int) ([]Hash, error) {
+	rows, err := s.db.Query(`SELECT hash FROM torrents WHERE checked <= ? ORDER BY checked LIMIT ?`, before.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := []Hash{}
+	for rows.Next() {
+		var hex string
+		if err := rows.Scan(&hex); err != nil {
+			return nil, err
+		}
+		hash, err := HashFromHex(hex)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// PutAnnounce buffers a raw announce, a full buffer is written at once.
+func (s *SQLiteStore) PutAnnounce(a *Announce) error {
+	s.mu.Lock()
+	s.announces = append(s.announces, a)
+	full := len(s.announces) >= sqliteAnnounceBatch
+	s.mu.Unlock()
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered announces.
+func (s *SQLiteStore) Flush() error {
+	s.mu.Lock()
+	batch := s.announces
+	s.announces = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO announces (hash, peer, seen_at, country, asn) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, a := range batch {
+		peer := ""
+		if a.Peer != nil {
+			peer = a.Peer.String()
+		}
+		geo := a.Geo
+		if geo == nil {
+			geo = &PeerGeo{}
+		}
+		if _, err := stmt.Exec(a.Hash.Hex(), peer, a.Time.Unix(), geo.Country, geo.ASN); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Close() error {
+	err := s.Flush()
+	if e := s.db.Close(); err == nil {
+		err = e
+	}
+	return err
+}
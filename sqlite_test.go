@@ -0,0 +1,53 @@
+package DHTCrawl
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_SQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	s, err := OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED"))
+	r := &MetadataResult{Hash: h, Name: "test", Files: []*File{{Path: []string{"a", "b.mkv"}, Length: 5}, {Path: []string{"c"}, Length: 6}}}
+	r.Categorize()
+	if err := s.Put(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(r); err != nil {
+		t.Error("Upsert", err)
+	}
+	s.PutAnnounce(&Announce{Hash: h, Peer: &net.TCPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 1}, Time: time.Now(), Geo: &PeerGeo{Country: "AU", ASN: 13335}})
+	s.Close()
+
+	// reopening must not run the migrations again
+	s, err = OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	got, err := s.Get(h)
+	if err != nil || got.Name != "test" || got.Category != CategoryVideo {
+		t.Error("Get stored hash", got, err)
+	}
+	var files, announces int64
+	s.DB().QueryRow(`SELECT COUNT(1) FROM files WHERE hash = ?`, h.Hex()).Scan(&files)
+	s.DB().QueryRow(`SELECT COUNT(1) FROM announces`).Scan(&announces)
+	if files != 2 || announces != 1 {
+		t.Error("Rows", files, announces)
+	}
+	var country string
+	var asn uint
+	s.DB().QueryRow(`SELECT country, asn FROM announces`).Scan(&country, &asn)
+	if country != "AU" || asn != 13335 {
+		t.Error("announce geo", country, asn)
+	}
+	if _, err := s.Get(testHash("unknown")); err != ErrNotFound {
+		t.Error("Get unknown", err)
+	}
+}
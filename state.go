@@ -0,0 +1,140 @@
+package DHTCrawl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+)
+
+type (
+	// crawlerState is what survives a restart: the id and routing table of
+	// every node and the jobs which were still waiting for a download.
+	crawlerState struct {
+		Tables []tableState `json:"tables"`
+		Jobs   []jobState   `json:"jobs,omitempty"`
+	}
+
+	tableState struct {
+		Self  string `json:"self"`
+		Nodes []byte `json:"nodes"` //compact node info
+	}
+
+	jobState struct {
+		Hash  string   `json:"hash"`
+		Peers []string `json:"peers"`
+	}
+)
+
+func newJobState(job *Job) jobState {
+	js := jobState{Hash: job.Hash.Hex()}
+	if job.Addr != nil {
+		js.Peers = append(js.Peers, job.Addr.String())
+	}
+	for _, p := range job.Peers() {
+		js.Peers = append(js.Peers, p.String())
+	}
+	return js
+}
+
+func (js jobState) job() *Job {
+	hash, err := HashFromHex(js.Hash)
+	if err != nil {
+		return nil
+	}
+	var job *Job
+	for _, p := range js.Peers {
+		addr, err := net.ResolveTCPAddr("tcp", p)
+		if err != nil {
+			continue
+		}
+		if job == nil {
+			job = NewJob(hash, addr)
+		} else {
+			job.AddPeer(addr)
+		}
+	}
+	return job
+}
+
+func (t *Table) snapshot() []*Node {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	seen := make(map[string]bool)
+	nodes := []*Node{}
+	for _, n := range append(t.Nodes[:len(t.Nodes):len(t.Nodes)], t.Last...) {
+		if n.Addr.IP.To4() == nil || seen[n.Addr.String()] {
+			continue
+		}
+		seen[n.Addr.String()] = true
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
+
+func newCrawlerState(tables []*Table, jobs []*Job) crawlerState {
+	st := crawlerState{}
+	for _, t := range tables {
+		st.Tables = append(st.Tables, tableState{Self: t.Self.Hex(), Nodes: ConvertByteStream(t.snapshot())})
+	}
+	for _, job := range jobs {
+		st.Jobs = append(st.Jobs, newJobState(job))
+	}
+	return st
+}
+
+func saveState(path string, tables []*Table, jobs []*Job) error {
+	data, err := json.Marshal(newCrawlerState(tables, jobs))
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadState restores the tables in order and returns the saved jobs, a
+// missing file is not an error.
+func loadState(path string, tables []*Table) ([]*Job, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := crawlerState{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return st.restore(tables)
+}
+
+// restore adds the saved nodes to the tables in order and returns the saved
+// jobs.
+func (st *crawlerState) restore(tables []*Table) ([]*Job, error) {
+	for i, ts := range st.Tables {
+		if i >= len(tables) {
+			break
+		}
+		if self := NewNodeIDFromHex(ts.Self); self != nil {
+			tables[i].Self = self
+		}
+		nodes, err := DecodeNodes(ts.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			tables[i].Add(n)
+		}
+	}
+	jobs := []*Job{}
+	for _, js := range st.Jobs {
+		if job := js.job(); job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
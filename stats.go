@@ -0,0 +1,263 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The reasons a download from a peer fails, the keys of FetchStats.Failures.
+const (
+	FailDial          = "dial"           //the peer refused or the address is unreachable
+	FailDialTimeout   = "dial_timeout"   //the peer did not accept in time
+	FailTimeout       = "timeout"        //connected, but the download took too long
+	FailNotBitTorrent = "not_bittorrent" //the handshake is of another protocol
+	FailRejected      = "rejected"       //no extension protocol or no ut_metadata
+	FailBadPiece      = "bad_piece"      //malformed extended message or metadata piece
+	FailHashMismatch  = "hash_mismatch"  //the metadata does not hash to the infohash
+	FailDecode        = "decode"         //the metadata is not a bencoded info dictionary
+	FailInvalid       = "invalid"        //the info dictionary decodes but fails Validate
+	FailTooLarge      = "too_large"      //a message, the metadata or a bencoded value broke a limit
+	FailOther         = "other"
+)
+
+// FetchError is why a download from a peer failed.
+type FetchError struct {
+	Failure string //one of the Fail reasons
+	Reason  string
+	Err     error        //underlying error, a *MetadataError for FailInvalid, ErrLimitExceeded for FailTooLarge
+	Timing  *FetchTiming //of the attempt, set by fromConn
+}
+
+// FetchTiming times the steps of one attempt to download metadata from a
+// peer, a step the attempt didn't reach is 0.
+type FetchTiming struct {
+	Peer      string          `json:"peer"`
+	Failure   string          `json:"failure,omitempty"` //Fail reason, empty for the attempt which fetched the metadata
+	Dial      time.Duration   `json:"dial"`              //0 on an inbound connection
+	Handshake time.Duration   `json:"handshake"`         //from the connection to the handshake of the peer
+	Extended  time.Duration   `json:"extended"`          //from the handshake to the extended handshake
+	Pieces    []time.Duration `json:"pieces,omitempty"`  //of every piece, since the one before or the extended handshake
+	Resumed   int             `json:"resumed,omitempty"` //pieces received by an attempt before, not requested again
+	Total     time.Duration   `json:"total"`
+}
+
+func (e *FetchError) Error() string {
+	return e.Reason
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// fetchFailure returns the Fail reason of err.
+func fetchFailure(err error) string {
+	var fe *FetchError
+	if errors.As(err, &fe) {
+		return fe.Failure
+	}
+	return FailOther
+}
+
+// fetchGroupsMax bounds the groups of a failure breakdown, the failures of
+// newer ones are counted in the "other" group.
+const fetchGroupsMax = 4096
+
+// FailureBreakdown counts the failed attempts by group, then by reason.
+type FailureBreakdown map[string]map[string]uint64
+
+// FetchStats is a snapshot of the downloads from peers of a pool.
+type FetchStats struct {
+	Attempts         uint64            `json:"attempts"` //peers dialed
+	Succeeded        uint64            `json:"succeeded"`
+	Failed           uint64            `json:"failed"`
+	Failures         map[string]uint64 `json:"failures"`            //failed attempts by reason
+	ByClient         FailureBreakdown  `json:"failures_by_client"`  //by the client in the peer id, "unknown" before the handshake
+	ByNetwork        FailureBreakdown  `json:"failures_by_network"` //by /16 network, /32 for IPv6
+	Handshakes       uint64            `json:"handshakes"`          //peers which completed the BitTorrent handshake
+	HandshakeLatency time.Duration     `json:"handshake_latency"`   //average from the dial to the handshake
+	BytesRead        uint64            `json:"bytes_read"`
+	BytesWritten     uint64            `json:"bytes_written"`
+}
+
+// fetchCounters are shared by the wires of a pool, a nil one counts
+// nothing.
+type fetchCounters struct {
+	attempts     uint64
+	succeeded    uint64
+	handshakes   uint64
+	handshakeNs  uint64 //sum of the handshake latencies
+	bytesRead    uint64
+	bytesWritten uint64
+
+	mu        sync.Mutex
+	failures  map[string]uint64
+	byClient  FailureBreakdown
+	byNetwork FailureBreakdown
+}
+
+func (c *fetchCounters) attempt() {
+	if c != nil {
+		atomic.AddUint64(&c.attempts, 1)
+	}
+}
+
+func (c *fetchCounters) handshake(latency time.Duration) {
+	if c != nil {
+		atomic.AddUint64(&c.handshakes, 1)
+		atomic.AddUint64(&c.handshakeNs, uint64(latency))
+	}
+}
+
+// done counts the outcome of an attempt on addr, client is empty when the
+// peer did not complete the handshake.
+func (c *fetchCounters) done(err error, addr *net.TCPAddr, client string) {
+	if c == nil {
+		return
+	}
+	if err == nil {
+		atomic.AddUint64(&c.succeeded, 1)
+		return
+	}
+	if client == "" {
+		client = "unknown"
+	}
+	reason := fetchFailure(err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures == nil {
+		c.failures = map[string]uint64{}
+		c.byClient = FailureBreakdown{}
+		c.byNetwork = FailureBreakdown{}
+	}
+	c.failures[reason]++
+	c.byClient.add(client, reason)
+	c.byNetwork.add(network(addr.IP), reason)
+}
+
+// count wraps conn to add its traffic to the counters.
+func (c *fetchCounters) count(conn net.Conn) net.Conn {
+	if c == nil {
+		return conn
+	}
+	return &countedConn{Conn: conn, counters: c}
+}
+
+func (c *fetchCounters) stats() FetchStats {
+	st := FetchStats{
+		Attempts:     atomic.LoadUint64(&c.attempts),
+		Succeeded:    atomic.LoadUint64(&c.succeeded),
+		Handshakes:   atomic.LoadUint64(&c.handshakes),
+		BytesRead:    atomic.LoadUint64(&c.bytesRead),
+		BytesWritten: atomic.LoadUint64(&c.bytesWritten),
+		Failures:     map[string]uint64{},
+	}
+	if st.Handshakes > 0 {
+		st.HandshakeLatency = time.Duration(atomic.LoadUint64(&c.handshakeNs) / st.Handshakes)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for reason, n := range c.failures {
+		st.Failures[reason] = n
+		st.Failed += n
+	}
+	st.ByClient = c.byClient.copy()
+	st.ByNetwork = c.byNetwork.copy()
+	return st
+}
+
+func (b FailureBreakdown) add(group, reason string) {
+	b.addN(group, reason, 1)
+}
+
+func (b FailureBreakdown) addN(group, reason string, n uint64) {
+	reasons, ok := b[group]
+	if !ok {
+		if len(b) >= fetchGroupsMax {
+			group = "other"
+			reasons = b[group]
+		}
+		if reasons == nil {
+			reasons = map[string]uint64{}
+			b[group] = reasons
+		}
+	}
+	reasons[reason] += n
+}
+
+func (b FailureBreakdown) copy() FailureBreakdown {
+	c := FailureBreakdown{}
+	for group, reasons := range b {
+		c[group] = map[string]uint64{}
+		for reason, n := range reasons {
+			c[group][reason] = n
+		}
+	}
+	return c
+}
+
+// network returns the /16 of an IPv4 address, the /32 of an IPv6 one.
+func network(ip net.IP) string {
+	bits := 32
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 16
+	}
+	n := &net.IPNet{IP: ip.Mask(net.CIDRMask(bits, len(ip)*8)), Mask: net.CIDRMask(bits, len(ip)*8)}
+	return n.String()
+}
+
+// peerClients names the clients by the code of their Azureus style peer id,
+// -qB4520- is qBittorrent 4.5.2.
+var peerClients = map[string]string{
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FD": "Free Download Manager",
+	"LT": "libtorrent",
+	"lt": "rTorrent",
+	"qB": "qBittorrent",
+	"SD": "Thunder",
+	"TR": "Transmission",
+	"UT": "uTorrent",
+	"UW": "uTorrent Web",
+	"WW": "WebTorrent",
+	"XL": "Xunlei",
+}
+
+// peerClient names the client of a peer id, unknown codes are returned as
+// they are.
+func peerClient(id []byte) string {
+	if len(id) < 8 || id[0] != '-' || id[7] != '-' {
+		return "unknown"
+	}
+	code := string(id[1:3])
+	if name, ok := peerClients[code]; ok {
+		return name
+	}
+	for _, r := range code {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			return "unknown"
+		}
+	}
+	return code
+}
+
+type countedConn struct {
+	net.Conn
+	counters *fetchCounters
+}
+
+func (c *countedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.counters.bytesRead, uint64(n))
+	return n, err
+}
+
+func (c *countedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.counters.bytesWritten, uint64(n))
+	return n, err
+}
@@ -0,0 +1,241 @@
+package DHTCrawl
+
+import (
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	DefaultStatsDPrefix   = "dhtcrawl."
+	DefaultStatsDInterval = 10 //seconds
+
+	statsdPacket = 1432 //bytes of a datagram, under the usual MTU
+)
+
+type (
+	// StatsDConfig exports the metrics of the crawler to a StatsD or a
+	// DogStatsD agent over UDP.
+	StatsDConfig struct {
+		Addr        string             `json:"addr"`         //host:port of the agent
+		Prefix      string             `json:"prefix"`       //of every name, DefaultStatsDPrefix when empty
+		Interval    int                `json:"interval"`     //seconds between flushes, 0 is DefaultStatsDInterval
+		DogStatsD   bool               `json:"dogstatsd"`    //labels become tags, otherwise their values end the names
+		Tags        []string           `json:"tags"`         //key:value tags of every metric, DogStatsD only
+		SampleRates map[string]float64 `json:"sample_rates"` //share of the flushes a metric is sent in, by name without the prefix
+	}
+
+	// StatsD sends the dhtcrawl_ metrics of a Prometheus gatherer
+	// every interval: the counters as the counts added since the last flush,
+	// the gauges as they are and the histograms as timers, in milliseconds
+	// for the _seconds ones. The observations a histogram bucket gained are
+	// one timer at the bucket bound, whose sample rate scales it back to
+	// their number.
+	StatsD struct {
+		Config   StatsDConfig
+		gatherer prometheus.Gatherer
+		conn     net.Conn
+
+		mu   sync.Mutex
+		last map[string]float64 //cumulative values of the counters and buckets, by name and labels
+		rand *rand.Rand
+		quit chan struct{}
+		once sync.Once
+		wg   sync.WaitGroup
+	}
+)
+
+// NewStatsD applies the defaults of cfg, Start starts the flushes.
+func NewStatsD(cfg *StatsDConfig, g prometheus.Gatherer) (*StatsD, error) {
+	c := *cfg
+	if c.Prefix == "" {
+		c.Prefix = DefaultStatsDPrefix
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultStatsDInterval
+	}
+	conn, err := net.Dial("udp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{
+		Config:   c,
+		gatherer: g,
+		conn:     conn,
+		last:     map[string]float64{},
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		quit:     make(chan struct{}),
+	}, nil
+}
+
+// Start flushes every interval until Close.
+func (e *StatsD) Start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(time.Duration(e.Config.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.quit:
+				return
+			case <-ticker.C:
+				if err := e.Flush(); err != nil {
+					logServer.Warn("statsd flush failed", "addr", e.Config.Addr, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the flushes and sends a last one.
+func (e *StatsD) Close() error {
+	e.once.Do(func() { close(e.quit) })
+	e.wg.Wait()
+	err := e.Flush()
+	if cerr := e.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flush gathers the metrics and sends what changed since the last flush.
+func (e *StatsD) Flush() error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	var lines []string
+	for _, f := range families {
+		if !strings.HasPrefix(f.GetName(), "dhtcrawl_") {
+			continue
+		}
+		name := strings.TrimPrefix(f.GetName(), "dhtcrawl_")
+		rate := 1.0
+		if r, ok := e.Config.SampleRates[name]; ok {
+			rate = r
+		}
+		for _, m := range f.GetMetric() {
+			lines = append(lines, e.lines(name, f.GetType(), m, rate)...)
+		}
+	}
+	e.mu.Unlock()
+	return e.send(lines)
+}
+
+// lines returns the StatsD lines of one series, the cumulative values are
+// remembered whether they are sampled or not.
+func (e *StatsD) lines(name string, typ dto.MetricType, m *dto.Metric, rate float64) []string {
+	labels := m.GetLabel()
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	var tags []string
+	key := name
+	for _, l := range labels {
+		key += "," + l.GetName() + "=" + l.GetValue()
+		if e.Config.DogStatsD {
+			tags = append(tags, statsdName(l.GetName())+":"+statsdName(l.GetValue()))
+		} else {
+			name += "." + statsdName(l.GetValue())
+		}
+	}
+	name = e.Config.Prefix + statsdName(name)
+	sampled := rate >= 1 || e.rand.Float64() < rate
+	var lines []string
+	add := func(value, kind string, rate float64) {
+		line := name + ":" + value + "|" + kind
+		if rate < 1 {
+			line += "|@" + strconv.FormatFloat(rate, 'g', 4, 64)
+		}
+		if e.Config.DogStatsD && len(tags)+len(e.Config.Tags) > 0 {
+			line += "|#" + strings.Join(append(append([]string{}, e.Config.Tags...), tags...), ",")
+		}
+		lines = append(lines, line)
+	}
+	switch typ {
+	case dto.MetricType_COUNTER:
+		v := m.GetCounter().GetValue()
+		delta := v - e.last[key]
+		e.last[key] = v
+		if delta > 0 && sampled {
+			add(formatStatsD(delta), "c", rate)
+		}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		kind, scale := "h", 1.0
+		if strings.HasSuffix(name, "_seconds") {
+			kind, scale = "ms", 1000
+		}
+		below, bound := 0.0, 0.0
+		buckets := h.GetBucket()
+		for i := 0; i <= len(buckets); i++ {
+			cumulative := float64(h.GetSampleCount())
+			if i < len(buckets) {
+				cumulative, bound = float64(buckets[i].GetCumulativeCount()), buckets[i].GetUpperBound()
+			}
+			if math.IsInf(bound, 0) {
+				continue
+			}
+			bucketKey := key + ",le=" + formatStatsD(bound)
+			if i == len(buckets) {
+				bucketKey = key + ",le=+Inf"
+			}
+			n := cumulative - below - e.last[bucketKey]
+			e.last[bucketKey] = cumulative - below
+			below = cumulative
+			if n > 0 && sampled {
+				add(formatStatsD(bound*scale), kind, rate/n)
+			}
+		}
+	default:
+		if sampled {
+			add(formatStatsD(m.GetGauge().GetValue()+m.GetUntyped().GetValue()), "g", rate)
+		}
+	}
+	return lines
+}
+
+// send packs the lines into datagrams.
+func (e *StatsD) send(lines []string) error {
+	var packet []byte
+	for _, line := range lines {
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdPacket {
+			if _, err := e.conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) == 0 {
+		return nil
+	}
+	_, err := e.conn.Write(packet)
+	return err
+}
+
+func formatStatsD(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// statsdName replaces the characters of the StatsD syntax.
+func statsdName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,84 @@
+package DHTCrawl
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statsdLines reads the lines of the datagrams sent to conn until none
+// comes for a while.
+func statsdLines(t *testing.T, conn *net.UDPConn) []string {
+	var lines []string
+	buf := make([]byte, 2048)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		if n > statsdPacket {
+			t.Error("datagram of", n)
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func Test_StatsD(t *testing.T) {
+	agent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+	reg := prometheus.NewRegistry()
+	fetches := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "dhtcrawl_fetches_total"}, []string{"result"})
+	handshake := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "dhtcrawl_handshake_seconds", Buckets: []float64{.1, 1}})
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{Name: "dhtcrawl_queued"})
+	other := prometheus.NewCounter(prometheus.CounterOpts{Name: "go_other_total"})
+	reg.MustRegister(fetches, handshake, queued, other)
+
+	s, err := NewStatsD(&StatsDConfig{Addr: agent.LocalAddr().String(), Prefix: "crawl."}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches.WithLabelValues("success").Add(3)
+	queued.Set(7)
+	other.Inc()
+	for _, d := range []float64{.05, .05, .5, 2} {
+		handshake.Observe(d)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"crawl.fetches_total.success:3|c", "crawl.handshake_seconds:100|ms|@0.5", "crawl.handshake_seconds:1000|ms", "crawl.handshake_seconds:1000|ms", "crawl.queued:7|g"}
+	sort.Strings(want)
+	if got := statsdLines(t, agent); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Error("first flush", got)
+	}
+	// only what was added since is counted
+	fetches.WithLabelValues("success").Add(2)
+	fetches.WithLabelValues("failure").Inc()
+	s.Flush()
+	want = []string{"crawl.fetches_total.failure:1|c", "crawl.fetches_total.success:2|c", "crawl.queued:7|g"}
+	if got := statsdLines(t, agent); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Error("second flush", got)
+	}
+
+	s.Config.DogStatsD, s.Config.Tags = true, []string{"env:test"}
+	s.Config.SampleRates = map[string]float64{"queued": 0.25}
+	fetches.WithLabelValues("success").Inc()
+	s.Close()
+	got := statsdLines(t, agent)
+	if len(got) == 0 || got[0] != "crawl.fetches_total:1|c|#env:test,result:success" {
+		t.Error("tags", got)
+	}
+	if len(got) > 1 && got[1] != "crawl.queued:7|g|@0.25|#env:test" {
+		t.Error("sampled gauge", got[1])
+	}
+}
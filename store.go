@@ -0,0 +1,179 @@
+package DHTCrawl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type (
+	// Store persists fetched metadata. It is a Sink, so a Store can be handed
+	// to the crawler directly, and the crawler skips the hashes it Has.
+	Store interface {
+		Sink
+		Has(Hash) (bool, error)
+		// Get returns ErrNotFound for unknown hashes.
+		Get(Hash) (*MetadataResult, error)
+		// Iterate calls fn for every stored result until fn returns false.
+		Iterate(fn func(*MetadataResult) bool) error
+	}
+
+	// TorrentQuery selects stored results, zero fields don't filter. Results
+	// come newest first when the store supports it.
+	TorrentQuery struct {
+		Category string
+		MinSize  int64
+		MaxSize  int64
+		Since    time.Time
+		Until    time.Time
+		Alive    time.Time //only the torrents a peer was seen of since
+		Offset   int
+		Limit    int
+	}
+
+	// QueryStore is implemented by stores which can run a TorrentQuery
+	// without scanning every result.
+	QueryStore interface {
+		Query(TorrentQuery) ([]*MetadataResult, error)
+	}
+
+	// StaleStore is implemented by stores which can list the results whose
+	// swarm was checked longest ago without scanning every result.
+	StaleStore interface {
+		// Stale returns up to limit hashes never checked or last checked
+		// at or before before, the oldest first.
+		Stale(before time.Time, limit int) ([]Hash, error)
+	}
+
+	// WritableStore is implemented by stores which can tell whether a Put
+	// would be accepted right now without writing anything.
+	WritableStore interface {
+		Writable() error
+	}
+
+	// CompressedStore is implemented by stores which can compress the
+	// results they write with one of the Compress codecs, the ones read
+	// are decompressed whatever their codec.
+	CompressedStore interface {
+		SetCompression(codec string)
+	}
+
+	// Announce is a raw announce_peer seen by one of our nodes.
+	Announce struct {
+		Hash Hash
+		Peer *net.TCPAddr
+		Time time.Time
+		Geo  *PeerGeo //of the peer, nil without GeoIP databases
+	}
+
+	// AnnounceSink is implemented by sinks which record raw announces too.
+	AnnounceSink interface {
+		PutAnnounce(*Announce) error
+	}
+
+	// storeFilter keeps hashes which are already stored out of the fetch queue.
+	storeFilter struct {
+		store Store
+	}
+)
+
+func (f storeFilter) AllowHash(hash Hash, _ *net.TCPAddr) bool {
+	has, err := f.store.Has(hash)
+	return err != nil || !has
+}
+
+func (f storeFilter) AllowResult(*MetadataResult) bool {
+	return true
+}
+
+// Match tells whether r passes the filters of q.
+func (q TorrentQuery) Match(r *MetadataResult) bool {
+	if q.Category != "" && r.Category != q.Category {
+		return false
+	}
+	size := r.TotalLength()
+	if (q.MinSize > 0 && size < q.MinSize) || (q.MaxSize > 0 && size > q.MaxSize) {
+		return false
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		created := r.created()
+		if (!q.Since.IsZero() && created.Before(q.Since)) || (!q.Until.IsZero() && !created.Before(q.Until)) {
+			return false
+		}
+	}
+	if !q.Alive.IsZero() && r.alive().Before(q.Alive) {
+		return false
+	}
+	return true
+}
+
+// where builds the SQL condition of q, placeholder returns the parameter
+// marker of the nth argument. created and alive are compared with created(t).
+func (q TorrentQuery) where(placeholder func(int) string, created func(time.Time) interface{}) (string, []interface{}) {
+	conds, args := []string{}, []interface{}{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, cond+placeholder(len(args)))
+	}
+	if q.Category != "" {
+		add("category = ", q.Category)
+	}
+	if q.MinSize > 0 {
+		add("length >= ", q.MinSize)
+	}
+	if q.MaxSize > 0 {
+		add("length <= ", q.MaxSize)
+	}
+	if !q.Since.IsZero() {
+		add("created >= ", created(q.Since))
+	}
+	if !q.Until.IsZero() {
+		add("created < ", created(q.Until))
+	}
+	if !q.Alive.IsZero() {
+		add("alive >= ", created(q.Alive))
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// QueryTorrents runs q on s, by scanning s when it is not a QueryStore.
+func QueryTorrents(s Store, q TorrentQuery) ([]*MetadataResult, error) {
+	if qs, ok := s.(QueryStore); ok {
+		return qs.Query(q)
+	}
+	results := []*MetadataResult{}
+	skip := q.Offset
+	err := s.Iterate(func(r *MetadataResult) bool {
+		if !q.Match(r) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		results = append(results, r)
+		return q.Limit <= 0 || len(results) < q.Limit
+	})
+	return results, err
+}
+
+// OpenStore opens the store backend named by driver at path: "bolt" (the
+// default), "sqlite" or "postgres", whose path is a connection string.
+func OpenStore(driver, path string) (Store, error) {
+	switch driver {
+	case "", "bolt":
+		return OpenBoltStore(path)
+	case "sqlite":
+		return OpenSQLiteStore(path)
+	case "postgres":
+		return OpenPostgresStore(path)
+	}
+	return nil, fmt.Errorf("unknown store driver %q", driver)
+}
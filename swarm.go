@@ -0,0 +1,281 @@
+package DHTCrawl
+
+import (
+	"context"
+	"crypto/sha1"
+	"math"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The defaults of HealthConfig.
+const (
+	DefaultHealthInterval  = 24     //hours between two checks of a torrent
+	DefaultHealthBatch     = 100    //torrents checked in a round
+	DefaultHealthWorkers   = 4      //lookups at once
+	DefaultHealthTimeout   = 30     //seconds of a lookup
+	DefaultHealthDeadAfter = 7 * 24 //hours without a peer before alive=true leaves a torrent out
+
+	healthRound = time.Minute
+	bloomBits   = 2048 //of a BEP 33 filter
+)
+
+type (
+	// HealthConfig re-checks the swarms of the stored torrents: every round
+	// the torrents checked longest ago are looked up again with get_peers
+	// and a BEP 33 scrape, their Swarm and Checked are updated and Alive is
+	// stamped when a peer is still there.
+	HealthConfig struct {
+		Interval  int `json:"interval"`   //hours between two checks of a torrent, 0 is DefaultHealthInterval
+		Batch     int `json:"batch"`      //torrents checked every minute, 0 is DefaultHealthBatch
+		Workers   int `json:"workers"`    //lookups at once, 0 is DefaultHealthWorkers
+		Timeout   int `json:"timeout"`    //seconds of a lookup, 0 is DefaultHealthTimeout
+		DeadAfter int `json:"dead_after"` //hours without a peer a torrent is dead after, 0 is DefaultHealthDeadAfter
+	}
+
+	// Swarm is what a lookup tells of the peers of a torrent. Seeders and
+	// Leechers are the estimates of the BEP 33 filters, 0 when no node sent
+	// them.
+	Swarm struct {
+		Peers     int //distinct peers found
+		Seeders   int
+		Leechers  int
+		Responses int //get_peers answered, 0 means nothing was learnt
+	}
+
+	// SwarmStats counts the checks of a SwarmChecker.
+	SwarmStats struct {
+		Checked uint64 `json:"checked"`
+		Alive   uint64 `json:"alive"`
+		Dead    uint64 `json:"dead"`
+		Failed  uint64 `json:"failed"` //lookups no node answered, the torrent is left as it was
+	}
+
+	// SwarmChecker runs the checks of HealthConfig on a store.
+	SwarmChecker struct {
+		Store    Store
+		Entries  []string      //where the lookups start
+		Interval time.Duration //between two checks of a torrent
+		Batch    int
+		Workers  int
+		Timeout  time.Duration
+		Clock    Clock //of the rounds and the stamps, nil is SystemClock
+
+		scrape func(ctx context.Context, hash Hash, entries []string) (*Swarm, error)
+		stats  SwarmStats
+	}
+
+	// swarmFilter is a BEP 33 bloom filter of the IPs of a swarm.
+	swarmFilter [bloomBits / 8]byte
+)
+
+func NewSwarmChecker(store Store, entries []string, cfg *HealthConfig) *SwarmChecker {
+	c := &SwarmChecker{
+		Store:    store,
+		Entries:  entries,
+		Interval: time.Duration(cfg.Interval) * time.Hour,
+		Batch:    cfg.Batch,
+		Workers:  cfg.Workers,
+		Timeout:  time.Duration(cfg.Timeout) * time.Second,
+		scrape:   ScrapeSwarm,
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultHealthInterval * time.Hour
+	}
+	if c.Batch == 0 {
+		c.Batch = DefaultHealthBatch
+	}
+	if c.Workers == 0 {
+		c.Workers = DefaultHealthWorkers
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultHealthTimeout * time.Second
+	}
+	return c
+}
+
+// deadAfter is how long a torrent without a peer stays alive for cfg.
+func (cfg *HealthConfig) deadAfter() time.Duration {
+	if cfg == nil || cfg.DeadAfter == 0 {
+		return DefaultHealthDeadAfter * time.Hour
+	}
+	return time.Duration(cfg.DeadAfter) * time.Hour
+}
+
+// Run checks a batch every minute of the clock until stop is closed, the
+// lookups running are cancelled.
+func (c *SwarmChecker) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	clock := clockOr(c.Clock)
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-after(clock, healthRound):
+			if err := c.Round(ctx, now); err != nil {
+				logPipeline.Warn("swarm checks failed", "error", err)
+			}
+		}
+	}
+}
+
+// Round checks the Batch torrents due at now which were checked longest
+// ago, the never checked first.
+func (c *SwarmChecker) Round(ctx context.Context, now time.Time) error {
+	due, err := c.due(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	hashes := make(chan Hash)
+	var wg sync.WaitGroup
+	for i := 0; i < c.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for h := range hashes {
+				protect("swarm check", func() {
+					if err := c.Check(ctx, h); err != nil {
+						logPipeline.Warn("swarm check failed", "infohash", h, "error", err)
+					}
+				})
+			}
+		}()
+	}
+	for _, h := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		hashes <- h
+	}
+	close(hashes)
+	wg.Wait()
+	return nil
+}
+
+// due lists the torrents Round checks, a StaleStore is asked for them and
+// the other stores are scanned whole.
+func (c *SwarmChecker) due(ctx context.Context, now time.Time) ([]Hash, error) {
+	if s, ok := c.Store.(StaleStore); ok {
+		return s.Stale(now.Add(-c.Interval), c.Batch)
+	}
+	type candidate struct {
+		hash    Hash
+		checked time.Time
+	}
+	due := []candidate{}
+	sortDue := func() {
+		sort.Slice(due, func(i, j int) bool { return due[i].checked.Before(due[j].checked) })
+	}
+	err := c.Store.Iterate(func(r *MetadataResult) bool {
+		checked := r.checked()
+		if !checked.IsZero() && now.Sub(checked) < c.Interval {
+			return true
+		}
+		due = append(due, candidate{r.Hash, checked})
+		// only the batch is kept of a large store
+		if len(due) >= 2*c.Batch {
+			sortDue()
+			due = due[:c.Batch]
+		}
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortDue()
+	if len(due) > c.Batch {
+		due = due[:c.Batch]
+	}
+	hashes := make([]Hash, len(due))
+	for i, d := range due {
+		hashes[i] = d.hash
+	}
+	return hashes, nil
+}
+
+// Check looks up the swarm of a stored torrent and writes what was found
+// back to the store.
+func (c *SwarmChecker) Check(ctx context.Context, hash Hash) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	swarm, err := c.scrape(ctx, hash, c.Entries)
+	if err != nil {
+		return err
+	}
+	if swarm.Responses == 0 {
+		// the network is down or the entries are gone, not the torrent
+		atomic.AddUint64(&c.stats.Failed, 1)
+		return nil
+	}
+	r, err := c.Store.Get(hash)
+	if err != nil {
+		return err
+	}
+	now := clockOr(c.Clock).Now().UTC().Format(time.RFC3339)
+	r.Swarm = swarm.Size()
+	r.Checked = now
+	atomic.AddUint64(&c.stats.Checked, 1)
+	if r.Swarm > 0 {
+		r.Alive = now
+		atomic.AddUint64(&c.stats.Alive, 1)
+	} else {
+		atomic.AddUint64(&c.stats.Dead, 1)
+	}
+	logPipeline.Debug("swarm checked", "infohash", hash, "peers", swarm.Peers, "seeders", swarm.Seeders, "leechers", swarm.Leechers)
+	return c.Store.Put(r)
+}
+
+func (c *SwarmChecker) Stats() SwarmStats {
+	return SwarmStats{
+		Checked: atomic.LoadUint64(&c.stats.Checked),
+		Alive:   atomic.LoadUint64(&c.stats.Alive),
+		Dead:    atomic.LoadUint64(&c.stats.Dead),
+		Failed:  atomic.LoadUint64(&c.stats.Failed),
+	}
+}
+
+// Size is the larger of the peers found and the estimate of the filters,
+// the lookup only reaches some of the peers of a large swarm.
+func (s *Swarm) Size() int {
+	return max(s.Peers, s.Seeders+s.Leechers)
+}
+
+// Add sets the bits of ip.
+func (f *swarmFilter) Add(ip net.IP) {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	sum := sha1.Sum(ip)
+	for _, index := range []int{int(sum[0]) | int(sum[1])<<8, int(sum[2]) | int(sum[3])<<8} {
+		index %= bloomBits
+		f[index/8] |= 1 << (index % 8)
+	}
+}
+
+// Union adds the bits of another filter of the same size.
+func (f *swarmFilter) Union(other []byte) {
+	for i := range f {
+		f[i] |= other[i]
+	}
+}
+
+// Estimate is the number of IPs added, by the formula of BEP 33.
+func (f *swarmFilter) Estimate() int {
+	zeros := 0
+	for _, b := range f {
+		zeros += 8 - bits.OnesCount8(b)
+	}
+	c := float64(max(zeros, 1)) //a full filter is as large as it can tell
+	m := float64(bloomBits)
+	return int(math.Round(math.Log(c/m) / (2 * math.Log(1-1/m))))
+}
@@ -0,0 +1,131 @@
+package DHTCrawl
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+)
+
+func Test_SwarmFilter(t *testing.T) {
+	var a, b swarmFilter
+	for i := 0; i < 200; i++ {
+		ip := net.IPv4(10, 0, byte(i/256), byte(i))
+		if i < 100 {
+			a.Add(ip)
+		} else {
+			b.Add(ip)
+		}
+	}
+	if n := a.Estimate(); n < 90 || n > 110 {
+		t.Error("estimate of 100", n)
+	}
+	a.Union(b[:])
+	if n := a.Estimate(); n < 180 || n > 220 {
+		t.Error("estimate of the union of 200", n)
+	}
+	if n := (&swarmFilter{}).Estimate(); n != 0 {
+		t.Error("estimate of an empty filter", n)
+	}
+}
+
+func Test_ScrapeSwarm(t *testing.T) {
+	n := testutil.StartNetwork(t, testutil.NetworkConfig{Nodes: 64, Seed: 4, Loopback: true})
+	hash := testHash("swarm")
+	for i := 1; i <= 3; i++ {
+		n.Store(hash, &net.TCPAddr{IP: net.IPv4(10, 9, 0, byte(i)), Port: 6881})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	swarm, err := ScrapeSwarm(ctx, hash, n.Addrs(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swarm.Peers != 3 || swarm.Leechers != 3 || swarm.Seeders != 0 || swarm.Responses == 0 {
+		t.Errorf("%+v", swarm)
+	}
+}
+
+func Test_SwarmChecker(t *testing.T) {
+	bolt, err := OpenBoltStore(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	sqlite, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	alive, dead, unknown := testHash("alive"), testHash("dead"), testHash("unknown")
+	swarms := map[Hash]*Swarm{alive: {Peers: 2, Leechers: 5, Responses: 8}, dead: {Responses: 8}, unknown: {}}
+	for _, store := range []Store{bolt, sqlite} {
+		for _, h := range []Hash{alive, dead, unknown} {
+			store.Put(&MetadataResult{Hash: h, Name: "old", Create: now.Add(-30 * 24 * time.Hour).Format(time.RFC3339)})
+		}
+		c := NewSwarmChecker(store, nil, &HealthConfig{})
+		c.Clock = NewManualClock(now)
+		var scrapes atomic.Int32 //Round scrapes concurrently
+		c.scrape = func(_ context.Context, h Hash, _ []string) (*Swarm, error) {
+			scrapes.Add(1)
+			return swarms[h], nil
+		}
+		if err := c.Round(context.Background(), now); err != nil {
+			t.Fatal(err)
+		}
+		if st := c.Stats(); scrapes.Load() != 3 || st.Checked != 2 || st.Alive != 1 || st.Dead != 1 || st.Failed != 1 {
+			t.Errorf("%T stats %+v", store, st)
+		}
+		r, _ := store.Get(alive)
+		if r.Swarm != 5 || r.alive() != now || r.checked() != now {
+			t.Errorf("%T alive %+v", store, r)
+		}
+		r, _ = store.Get(dead)
+		if r.Swarm != 0 || r.Alive != "" || r.checked() != now {
+			t.Errorf("%T dead %+v", store, r)
+		}
+		// only the torrent no node answered for is due an hour later
+		c.Round(context.Background(), now.Add(time.Hour))
+		if n := scrapes.Load(); n != 4 {
+			t.Errorf("%T scrapes %d", store, n)
+		}
+
+		got, err := QueryTorrents(store, TorrentQuery{Alive: now.Add(-7 * 24 * time.Hour)})
+		if err != nil || len(got) != 1 || got[0].Hash != alive {
+			t.Errorf("%T alive query %d %v", store, len(got), err)
+		}
+	}
+
+	// sqlite answers Round with its checked column, the never checked first
+	if got, err := sqlite.Stale(now.Add(-time.Minute), 10); err != nil || len(got) != 1 || got[0] != unknown {
+		t.Error("stale before the round", got, err)
+	}
+	if got, err := sqlite.Stale(now, 2); err != nil || len(got) != 2 || got[0] != unknown {
+		t.Error("stale batch", got, err)
+	}
+
+	// alive=true keeps dead_after, the torrents checked just now are alive
+	s := NewServer(&Crawler{Config: NewDefaultConfig(), Store: bolt}, "")
+	w := httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/torrents?alive=true", nil))
+	page := apiTorrents{}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Torrents) != 0 {
+		t.Error("alive torrents of 2024", len(page.Torrents))
+	}
+	w = httptest.NewRecorder()
+	s.Mux.ServeHTTP(w, httptest.NewRequest("GET", "/torrents?alive_since="+now.Add(-time.Hour).Format(time.RFC3339), nil))
+	page = apiTorrents{}
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if len(page.Torrents) != 1 || page.Torrents[0].Swarm != 5 {
+		t.Errorf("alive_since %+v", page)
+	}
+}
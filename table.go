@@ -17,8 +17,6 @@ type (
 
 	NodeID []byte
 
-	Hash string
-
 	Node struct {
 		ID   NodeID
 		Addr *net.UDPAddr
@@ -64,16 +62,10 @@ func (n NodeID) Hex() string {
 	return fmt.Sprintf("%X", n)
 }
 
+// Neighbor returns the first 8 bytes of n followed by the rest of target,
+// neither is modified.
 func (n NodeID) Neighbor(target NodeID) NodeID {
-	return append(n[:8], target[8:]...)
-}
-
-func (h Hash) Hex() string {
-	return fmt.Sprintf("%X", []byte(h))
-}
-
-func (h Hash) Magnet() string {
-	return fmt.Sprintf("magnet:?xt=urn:btih:%X", []byte(h))
+	return append(append(NodeID{}, n[:8]...), target[8:]...)
 }
 
 func NewNode() *Node {
@@ -100,6 +92,20 @@ func (t *Table) Add(node *Node) {
 	}
 }
 
+func (t *Table) Len() int {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	return len(t.Nodes)
+}
+
+// Bootstrapped reports whether the table ever learnt a node. Nodes is
+// emptied after every walk round, Last keeps the most recent ones.
+func (t *Table) Bootstrapped() bool {
+	t.Mutex.RLock()
+	defer t.Mutex.RUnlock()
+	return len(t.Last) > 0
+}
+
 func (t *Table) Each(handler EachHandler) {
 	t.Mutex.RLock()
 	nodes := t.Nodes[:]
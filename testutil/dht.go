@@ -0,0 +1,718 @@
+package testutil
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+const (
+	DefaultK       = 8  //bucket size, and nodes in a find_node response
+	lookupAlpha    = 3  //queries in flight in every round of a lookup
+	lookupRounds   = 32 //rounds before a lookup gives up
+	maxSamples     = 20 //infohashes of a sample_infohashes response
+	compactNodeLen = 26
+	bloomBytes     = 256 //of a BEP 33 filter
+)
+
+// ErrNoResponse is returned by Query when the packet or its answer was
+// lost, or the node does not answer.
+var ErrNoResponse = errors.New("no response")
+
+type (
+	// NetworkConfig tunes a simulated DHT. Latency, Jitter and Loss apply to
+	// every packet a node sends.
+	NetworkConfig struct {
+		Nodes    int
+		K        int           //DefaultK when 0
+		Seed     int64         //the node IDs, the jitter and the losses are drawn from it
+		Latency  time.Duration //of every packet
+		Jitter   time.Duration //up to this much is added to Latency
+		Loss     float64       //share of the packets dropped, 0 to 1
+		Loopback bool          //bind UDP ports of 127.0.0.1, so a real DHT can join the nodes
+	}
+
+	// Network is a DHT of lightweight KRPC nodes answering ping, find_node,
+	// get_peers, announce_peer and sample_infohashes (BEP 5, BEP 51).
+	//
+	// In memory the packets are events of a virtual clock, run from the
+	// goroutine calling Query, Lookup or Bootstrap: for one Seed every run
+	// is the same. The methods must not be called concurrently then. Over
+	// loopback the packets are UDP datagrams and latency is real time.
+	Network struct {
+		cfg    NetworkConfig
+		nodes  []*SimNode
+		byAddr map[string]*SimNode
+		sybils []*SimNode
+
+		mu      sync.Mutex
+		rand    *rand.Rand
+		now     time.Duration //virtual clock, in memory
+		events  eventQueue
+		seq     uint64
+		sent    uint64
+		dropped uint64
+		wg      sync.WaitGroup
+	}
+
+	// Contact is a node as found in compact node info.
+	Contact struct {
+		ID   [20]byte
+		Addr *net.UDPAddr
+	}
+
+	// SimNode is a node of a Network. A Sybil node answers lookups with
+	// the other Sybil nodes and drops the announces it is sent.
+	SimNode struct {
+		Contact
+		Sybil bool
+
+		net     *Network
+		conn    *net.UDPConn //over loopback
+		mu      sync.Mutex
+		buckets [161][]Contact //by common prefix length with ID
+		peers   map[[20]byte][]*net.TCPAddr
+		pending map[string]chan response //by transaction ID
+		tid     uint16
+		queries map[string]int
+	}
+
+	// LookupResult is what an iterative lookup found.
+	LookupResult struct {
+		Closest []Contact //nodes which answered, closest first, at most K
+		Peers   []*net.TCPAddr
+		Queries int
+	}
+
+	response struct {
+		from *net.UDPAddr
+		r    map[string]interface{}
+	}
+
+	event struct {
+		at   time.Duration
+		seq  uint64
+		to   *SimNode
+		from *net.UDPAddr
+		data []byte
+	}
+
+	eventQueue []*event
+)
+
+// NewNetwork creates the nodes, they know nobody until Bootstrap.
+func NewNetwork(cfg NetworkConfig) (*Network, error) {
+	if cfg.K <= 0 {
+		cfg.K = DefaultK
+	}
+	n := &Network{cfg: cfg, byAddr: map[string]*SimNode{}, rand: rand.New(rand.NewSource(cfg.Seed))}
+	for i := 0; i < cfg.Nodes; i++ {
+		if _, err := n.addNode(n.randomID(), false); err != nil {
+			n.Close()
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// StartNetwork creates and bootstraps a network which is closed when the
+// test ends.
+func StartNetwork(t testing.TB, cfg NetworkConfig) *Network {
+	t.Helper()
+	n, err := NewNetwork(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	n.Bootstrap()
+	return n
+}
+
+func (n *Network) addNode(id [20]byte, sybil bool) (*SimNode, error) {
+	s := &SimNode{
+		Contact: Contact{ID: id},
+		Sybil:   sybil,
+		net:     n,
+		peers:   map[[20]byte][]*net.TCPAddr{},
+		pending: map[string]chan response{},
+		queries: map[string]int{},
+	}
+	if n.cfg.Loopback {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return nil, err
+		}
+		s.conn, s.Addr = conn, conn.LocalAddr().(*net.UDPAddr)
+		n.wg.Add(1)
+		go s.read()
+	} else {
+		i := len(n.nodes) + 1
+		s.Addr = &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 6881}
+	}
+	n.nodes = append(n.nodes, s)
+	n.byAddr[s.Addr.String()] = s
+	if sybil {
+		n.sybils = append(n.sybils, s)
+	}
+	return s, nil
+}
+
+func (n *Network) randomID() (id [20]byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rand.Read(id[:])
+	return
+}
+
+// Nodes returns every node, the Sybil ones last.
+func (n *Network) Nodes() []*SimNode {
+	return n.nodes
+}
+
+// Addrs returns the ip:port of the first count nodes, to bootstrap from.
+func (n *Network) Addrs(count int) []string {
+	var addrs []string
+	for _, s := range n.nodes[:min(count, len(n.nodes))] {
+		addrs = append(addrs, s.Addr.String())
+	}
+	return addrs
+}
+
+// Stats counts the packets sent by the nodes and those the loss dropped.
+func (n *Network) Stats() (sent, dropped uint64) {
+	return atomic.LoadUint64(&n.sent), atomic.LoadUint64(&n.dropped)
+}
+
+// Close closes the sockets of a loopback network.
+func (n *Network) Close() error {
+	for _, s := range n.nodes {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}
+	n.wg.Wait()
+	return nil
+}
+
+// Bootstrap joins every node through the first one, each looks itself up.
+func (n *Network) Bootstrap() {
+	for _, s := range n.nodes[1:] {
+		s.add(n.nodes[0].Contact)
+		n.Lookup(s, s.ID, false)
+	}
+}
+
+// AddSybils adds count Sybil nodes whose IDs share prefix bits with target
+// and joins them, they surround target in the keyspace.
+func (n *Network) AddSybils(count int, target [20]byte, prefix int) ([]*SimNode, error) {
+	var added []*SimNode
+	for i := 0; i < count; i++ {
+		id := n.randomID()
+		for b := 0; b < prefix; b++ {
+			mask := byte(0x80) >> (b % 8)
+			id[b/8] = id[b/8]&^mask | target[b/8]&mask
+		}
+		s, err := n.addNode(id, true)
+		if err != nil {
+			return added, err
+		}
+		added = append(added, s)
+	}
+	for _, s := range added {
+		s.add(n.nodes[0].Contact)
+		n.Lookup(s, s.ID, false)
+	}
+	return added, nil
+}
+
+// Store puts peer in the store of the K honest nodes closest to hash, as
+// an announce would have.
+func (n *Network) Store(hash [20]byte, peer *net.TCPAddr) {
+	stored := 0
+	for _, s := range n.Closest(hash, len(n.nodes)) {
+		if stored == n.cfg.K {
+			return
+		}
+		if !s.Sybil {
+			s.store(hash, peer)
+			stored++
+		}
+	}
+}
+
+// Closest returns the k nodes closest to target, whatever their tables.
+func (n *Network) Closest(target [20]byte, k int) []*SimNode {
+	nodes := append([]*SimNode(nil), n.nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return closer(nodes[i].ID, nodes[j].ID, target) })
+	return nodes[:min(k, len(nodes))]
+}
+
+// Query sends a query from a node and waits for its response dictionary.
+func (n *Network) Query(from *SimNode, to *net.UDPAddr, q string, args map[string]interface{}) (map[string]interface{}, error) {
+	rs := n.collect(from, []chan response{from.query(to, q, args)})
+	if len(rs) == 0 {
+		return nil, ErrNoResponse
+	}
+	return rs[0].r, nil
+}
+
+// AnnounceTo announces hash to the node at addr from a node: get_peers for
+// a token, then announce_peer with port.
+func (n *Network) AnnounceTo(from *SimNode, to *net.UDPAddr, hash [20]byte, port int) error {
+	r, err := n.Query(from, to, "get_peers", map[string]interface{}{"info_hash": string(hash[:])})
+	if err != nil {
+		return err
+	}
+	token, _ := r["token"].(string)
+	_, err = n.Query(from, to, "announce_peer", map[string]interface{}{
+		"info_hash": string(hash[:]), "port": port, "token": token,
+	})
+	return err
+}
+
+// Lookup walks from a node towards target, with get_peers queries when
+// getPeers is set and find_node ones otherwise.
+func (n *Network) Lookup(from *SimNode, target [20]byte, getPeers bool) *LookupResult {
+	result := &LookupResult{}
+	shortlist := from.closest(target, n.cfg.K)
+	queried, answered, found := map[string]bool{}, []Contact{}, map[string]bool{}
+	q, key := "find_node", "target"
+	if getPeers {
+		q, key = "get_peers", "info_hash"
+	}
+	for round := 0; round < lookupRounds; round++ {
+		var chans []chan response
+		for _, c := range shortlist {
+			if len(chans) == lookupAlpha {
+				break
+			}
+			if !queried[c.Addr.String()] {
+				queried[c.Addr.String()] = true
+				chans = append(chans, from.query(c.Addr, q, map[string]interface{}{key: string(target[:])}))
+			}
+		}
+		if len(chans) == 0 {
+			break
+		}
+		result.Queries += len(chans)
+		for _, r := range n.collect(from, chans) {
+			if id, ok := r.r["id"].(string); ok && len(id) == 20 {
+				answered = append(answered, Contact{ID: [20]byte([]byte(id)), Addr: r.from})
+			}
+			nodes, _ := r.r["nodes"].(string)
+			shortlist = append(shortlist, decodeNodes(nodes)...)
+			values, _ := r.r["values"].([]interface{})
+			for _, v := range values {
+				if peer := decodePeer(v); peer != nil && !found[peer.String()] {
+					found[peer.String()] = true
+					result.Peers = append(result.Peers, peer)
+				}
+			}
+		}
+		shortlist = closestContacts(shortlist, target, len(shortlist))
+	}
+	result.Closest = closestContacts(answered, target, n.cfg.K)
+	return result
+}
+
+// collect waits for the responses of chans: in memory the events run until
+// there is none left, over loopback until every response came or enough
+// time passed for the slowest. The unanswered transactions are forgotten.
+func (n *Network) collect(from *SimNode, chans []chan response) []response {
+	if !n.cfg.Loopback {
+		n.run()
+	}
+	timeout := time.NewTimer(2*(n.cfg.Latency+n.cfg.Jitter) + 200*time.Millisecond)
+	defer timeout.Stop()
+	waiting := n.cfg.Loopback
+	var rs []response
+	for _, ch := range chans {
+		if waiting {
+			select {
+			case r := <-ch:
+				rs = append(rs, r)
+				continue
+			case <-timeout.C:
+				waiting = false
+			}
+		}
+		select {
+		case r := <-ch:
+			rs = append(rs, r)
+		default:
+		}
+	}
+	from.forget(chans)
+	return rs
+}
+
+// run delivers the events of the virtual clock in time order.
+func (n *Network) run() {
+	for {
+		n.mu.Lock()
+		if len(n.events) == 0 {
+			n.mu.Unlock()
+			return
+		}
+		ev := heap.Pop(&n.events).(*event)
+		n.now = ev.at
+		n.mu.Unlock()
+		ev.to.receive(ev.data, ev.from)
+	}
+}
+
+// send delivers msg to addr after the latency, unless it is lost.
+func (n *Network) send(from *SimNode, to *net.UDPAddr, msg map[string]interface{}) {
+	data, err := bencode.EncodeBytes(msg)
+	if err != nil {
+		return
+	}
+	atomic.AddUint64(&n.sent, 1)
+	n.mu.Lock()
+	lost := n.cfg.Loss > 0 && n.rand.Float64() < n.cfg.Loss
+	delay := n.cfg.Latency
+	if n.cfg.Jitter > 0 {
+		delay += time.Duration(n.rand.Int63n(int64(n.cfg.Jitter)))
+	}
+	if lost {
+		n.mu.Unlock()
+		atomic.AddUint64(&n.dropped, 1)
+		return
+	}
+	if !n.cfg.Loopback {
+		if dst := n.byAddr[to.String()]; dst != nil {
+			n.seq++
+			heap.Push(&n.events, &event{at: n.now + delay, seq: n.seq, to: dst, from: from.Addr, data: data})
+		}
+		n.mu.Unlock()
+		return
+	}
+	n.mu.Unlock()
+	if delay == 0 {
+		from.conn.WriteToUDP(data, to)
+		return
+	}
+	time.AfterFunc(delay, func() { from.conn.WriteToUDP(data, to) })
+}
+
+func (s *SimNode) read() {
+	defer s.net.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		s.receive(append([]byte(nil), buf[:n]...), addr)
+	}
+}
+
+// Queries counts the queries of type q the node received.
+func (s *SimNode) Queries(q string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[q]
+}
+
+// Known returns the contacts in the routing table of the node.
+func (s *SimNode) Known() []Contact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var contacts []Contact
+	for _, b := range s.buckets {
+		contacts = append(contacts, b...)
+	}
+	return contacts
+}
+
+// Peers returns the peers announced to the node for hash.
+func (s *SimNode) Peers(hash [20]byte) []*net.TCPAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*net.TCPAddr(nil), s.peers[hash]...)
+}
+
+func (s *SimNode) receive(data []byte, from *net.UDPAddr) {
+	var msg map[string]interface{}
+	if bencode.DecodeBytes(data, &msg) != nil {
+		return
+	}
+	tid, _ := msg["t"].(string)
+	switch msg["y"] {
+	case "q":
+		q, _ := msg["q"].(string)
+		a, _ := msg["a"].(map[string]interface{})
+		id, _ := a["id"].(string)
+		if len(id) != 20 {
+			return
+		}
+		s.add(Contact{ID: [20]byte([]byte(id)), Addr: from})
+		s.mu.Lock()
+		s.queries[q]++
+		s.mu.Unlock()
+		r := s.answer(q, a, from)
+		if r == nil {
+			s.net.send(s, from, map[string]interface{}{"t": tid, "y": "e", "e": []interface{}{204, "Method Unknown"}})
+			return
+		}
+		r["id"] = string(s.ID[:])
+		s.net.send(s, from, map[string]interface{}{"t": tid, "y": "r", "r": r})
+	case "r":
+		r, _ := msg["r"].(map[string]interface{})
+		if id, ok := r["id"].(string); ok && len(id) == 20 {
+			s.add(Contact{ID: [20]byte([]byte(id)), Addr: from})
+		}
+		s.mu.Lock()
+		ch := s.pending[tid]
+		delete(s.pending, tid)
+		s.mu.Unlock()
+		if ch != nil {
+			ch <- response{from: from, r: r}
+		}
+	}
+}
+
+// answer returns the response dictionary of a query, nil for an unknown one.
+func (s *SimNode) answer(q string, a map[string]interface{}, from *net.UDPAddr) map[string]interface{} {
+	target, _ := a["target"].(string)
+	hash, _ := a["info_hash"].(string)
+	switch q {
+	case "ping":
+		return map[string]interface{}{}
+	case "find_node":
+		return map[string]interface{}{"nodes": s.nodes(target)}
+	case "get_peers":
+		r := map[string]interface{}{"token": s.token(from)}
+		if peers := s.Peers(toID(hash)); len(peers) > 0 && !s.Sybil {
+			var values []interface{}
+			for _, p := range peers {
+				values = append(values, string(append(p.IP.To4(), byte(p.Port>>8), byte(p.Port))))
+			}
+			r["values"] = values
+		} else {
+			r["nodes"] = s.nodes(hash)
+		}
+		// BEP 33, every stored peer is a leecher
+		if scrape, _ := a["scrape"].(int64); scrape == 1 && !s.Sybil {
+			r["BFsd"] = string(bloomFilter(nil))
+			r["BFpe"] = string(bloomFilter(s.Peers(toID(hash))))
+		}
+		return r
+	case "announce_peer":
+		if token, _ := a["token"].(string); token == s.token(from) && len(hash) == 20 && !s.Sybil {
+			port, _ := a["port"].(int64)
+			if implied, _ := a["implied_port"].(int64); implied == 1 {
+				port = int64(from.Port)
+			}
+			s.store(toID(hash), &net.TCPAddr{IP: from.IP, Port: int(port)})
+		}
+		return map[string]interface{}{}
+	case "sample_infohashes":
+		s.mu.Lock()
+		hashes := make([][20]byte, 0, len(s.peers))
+		for h := range s.peers {
+			hashes = append(hashes, h)
+		}
+		s.mu.Unlock()
+		sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+		var samples []byte
+		for _, h := range hashes[:min(maxSamples, len(hashes))] {
+			samples = append(samples, h[:]...)
+		}
+		return map[string]interface{}{"nodes": s.nodes(target), "samples": string(samples), "num": len(hashes), "interval": 0}
+	}
+	return nil
+}
+
+// nodes returns the compact info of the K nodes closest to target known by
+// the node, the Sybil nodes only know each other.
+func (s *SimNode) nodes(target string) string {
+	var contacts []Contact
+	if s.Sybil {
+		for _, sybil := range s.net.sybils {
+			contacts = append(contacts, sybil.Contact)
+		}
+		contacts = closestContacts(contacts, toID(target), s.net.cfg.K)
+	} else {
+		contacts = s.closest(toID(target), s.net.cfg.K)
+	}
+	b := make([]byte, 0, len(contacts)*compactNodeLen)
+	for _, c := range contacts {
+		b = append(append(b, c.ID[:]...), c.Addr.IP.To4()...)
+		b = binary.BigEndian.AppendUint16(b, uint16(c.Addr.Port))
+	}
+	return string(b)
+}
+
+// bloomFilter is the BEP 33 filter of the IPs of peers.
+func bloomFilter(peers []*net.TCPAddr) []byte {
+	f := make([]byte, bloomBytes)
+	for _, p := range peers {
+		ip := p.IP.To4()
+		if ip == nil {
+			ip = p.IP
+		}
+		sum := sha1.Sum(ip)
+		for _, index := range []int{int(sum[0]) | int(sum[1])<<8, int(sum[2]) | int(sum[3])<<8} {
+			index %= bloomBytes * 8
+			f[index/8] |= 1 << (index % 8)
+		}
+	}
+	return f
+}
+
+func (s *SimNode) token(from *net.UDPAddr) string {
+	sum := sha1.Sum(append(s.ID[:], from.IP...))
+	return string(sum[:4])
+}
+
+func (s *SimNode) store(hash [20]byte, peer *net.TCPAddr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers[hash] {
+		if p.String() == peer.String() {
+			return
+		}
+	}
+	s.peers[hash] = append(s.peers[hash], peer)
+}
+
+// add puts c in its bucket, a full bucket keeps its older contacts.
+func (s *SimNode) add(c Contact) {
+	if c.ID == s.ID {
+		return
+	}
+	i := commonPrefix(s.ID, c.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for j, known := range s.buckets[i] {
+		if known.ID == c.ID {
+			s.buckets[i][j] = c
+			return
+		}
+	}
+	if len(s.buckets[i]) < s.net.cfg.K {
+		s.buckets[i] = append(s.buckets[i], c)
+	}
+}
+
+func (s *SimNode) closest(target [20]byte, k int) []Contact {
+	return closestContacts(s.Known(), target, k)
+}
+
+func (s *SimNode) query(to *net.UDPAddr, q string, args map[string]interface{}) chan response {
+	ch := make(chan response, 1)
+	s.mu.Lock()
+	s.tid++
+	tid := string([]byte{byte(s.tid >> 8), byte(s.tid)})
+	s.pending[tid] = ch
+	s.mu.Unlock()
+	a := map[string]interface{}{"id": string(s.ID[:])}
+	for k, v := range args {
+		a[k] = v
+	}
+	s.net.send(s, to, map[string]interface{}{"t": tid, "y": "q", "q": q, "a": a})
+	return ch
+}
+
+func (s *SimNode) forget(chans []chan response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tid, ch := range s.pending {
+		for _, c := range chans {
+			if ch == c {
+				delete(s.pending, tid)
+			}
+		}
+	}
+}
+
+func closestContacts(contacts []Contact, target [20]byte, k int) []Contact {
+	seen := map[[20]byte]bool{}
+	unique := make([]Contact, 0, len(contacts))
+	for _, c := range contacts {
+		if !seen[c.ID] {
+			seen[c.ID] = true
+			unique = append(unique, c)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool { return closer(unique[i].ID, unique[j].ID, target) })
+	return unique[:min(k, len(unique))]
+}
+
+// closer reports whether a is closer to target than b.
+func closer(a, b, target [20]byte) bool {
+	for i := range target {
+		if da, db := a[i]^target[i], b[i]^target[i]; da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+func commonPrefix(a, b [20]byte) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			n := 0
+			for ; x&0x80 == 0; x <<= 1 {
+				n++
+			}
+			return i*8 + n
+		}
+	}
+	return 160
+}
+
+func toID(s string) (id [20]byte) {
+	copy(id[:], s)
+	return
+}
+
+func decodeNodes(s string) []Contact {
+	var contacts []Contact
+	for i := 0; i+compactNodeLen <= len(s); i += compactNodeLen {
+		b := []byte(s[i : i+compactNodeLen])
+		port := int(binary.BigEndian.Uint16(b[24:]))
+		if port == 0 {
+			continue
+		}
+		contacts = append(contacts, Contact{ID: [20]byte(b[:20]), Addr: &net.UDPAddr{IP: net.IP(b[20:24]), Port: port}})
+	}
+	return contacts
+}
+
+func decodePeer(v interface{}) *net.TCPAddr {
+	s, ok := v.(string)
+	if !ok || len(s) != 6 {
+		return nil
+	}
+	b := []byte(s)
+	return &net.TCPAddr{IP: net.IP(b[:4]), Port: int(binary.BigEndian.Uint16(b[4:]))}
+}
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
@@ -0,0 +1,137 @@
+package testutil
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_NetworkLookup(t *testing.T) {
+	cfg := NetworkConfig{Nodes: 300, Seed: 1, Latency: 50 * time.Millisecond, Jitter: 20 * time.Millisecond}
+	n := StartNetwork(t, cfg)
+	target := n.randomID()
+	r := n.Lookup(n.Nodes()[7], target, false)
+	want := map[[20]byte]bool{}
+	for _, s := range n.Closest(target, DefaultK) {
+		want[s.ID] = true
+	}
+	found := 0
+	for _, c := range r.Closest {
+		if want[c.ID] {
+			found++
+		}
+	}
+	t.Log(found, "of the closest found in", r.Queries, "queries")
+	if found < DefaultK*3/4 {
+		t.Error("lookup found", found, "of the", DefaultK, "closest")
+	}
+
+	//the same seed replays the same network
+	again := StartNetwork(t, cfg)
+	target2 := again.randomID()
+	r2 := again.Lookup(again.Nodes()[7], target2, false)
+	if target != target2 || r.Queries != r2.Queries || len(r.Closest) != len(r2.Closest) {
+		t.Fatal("runs of one seed differ")
+	}
+	for i := range r.Closest {
+		if r.Closest[i].ID != r2.Closest[i].ID {
+			t.Error("closest differ at", i)
+		}
+	}
+	sent, _ := n.Stats()
+	sent2, _ := again.Stats()
+	if sent != sent2 {
+		t.Error("packets sent", sent, sent2)
+	}
+}
+
+func Test_NetworkPeers(t *testing.T) {
+	n := StartNetwork(t, NetworkConfig{Nodes: 200, Seed: 2, Loss: 0.1})
+	hash := n.randomID()
+	peer := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51413}
+	n.Store(hash, peer)
+	r := n.Lookup(n.Nodes()[3], hash, true)
+	if len(r.Peers) != 1 || r.Peers[0].String() != peer.String() {
+		t.Error("peers", r.Peers)
+	}
+	if _, dropped := n.Stats(); dropped == 0 {
+		t.Error("nothing lost")
+	}
+
+	//announce to a node, then sample it, retrying what the loss drops
+	from, to := n.Nodes()[0], n.Nodes()[1]
+	other := n.randomID()
+	for i := 0; i < 10 && n.AnnounceTo(from, to.Addr, other, 6881) != nil; i++ {
+	}
+	if peers := to.Peers(other); len(peers) != 1 || peers[0].Port != 6881 {
+		t.Fatal("announce not stored", peers)
+	}
+	var sample map[string]interface{}
+	for i := 0; i < 10 && sample == nil; i++ {
+		sample, _ = n.Query(from, to.Addr, "sample_infohashes", map[string]interface{}{"target": string(hash[:])})
+	}
+	samples, _ := sample["samples"].(string)
+	if num, _ := sample["num"].(int64); len(samples)%20 != 0 || num < 1 || !contains(samples, other) {
+		t.Error("samples", len(samples), num)
+	}
+	if to.Queries("sample_infohashes") == 0 || to.Queries("announce_peer") == 0 {
+		t.Error("queries not counted")
+	}
+	if _, err := n.Query(from, to.Addr, "vote", map[string]interface{}{}); err != ErrNoResponse {
+		t.Error("unknown query answered", err)
+	}
+}
+
+func Test_NetworkSybil(t *testing.T) {
+	n := StartNetwork(t, NetworkConfig{Nodes: 200, Seed: 3})
+	hash := n.randomID()
+	n.Store(hash, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51413})
+	if r := n.Lookup(n.Nodes()[5], hash, true); len(r.Peers) != 1 {
+		t.Fatal("peers before the Sybil attack", r.Peers)
+	}
+	sybils, err := n.AddSybils(3*DefaultK, hash, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isSybil := map[[20]byte]bool{}
+	for _, s := range sybils {
+		isSybil[s.ID] = true
+	}
+	//lookups through the honest nodes end among the Sybil nodes
+	r := n.Lookup(n.Nodes()[5], hash, false)
+	surrounded := 0
+	for _, c := range r.Closest {
+		if isSybil[c.ID] {
+			surrounded++
+		}
+	}
+	t.Log(surrounded, "of the closest are Sybil nodes")
+	if surrounded < DefaultK/2 {
+		t.Error("Sybil nodes", surrounded)
+	}
+}
+
+func Test_NetworkLoopback(t *testing.T) {
+	n := StartNetwork(t, NetworkConfig{Nodes: 16, Seed: 4, Loopback: true, Latency: time.Millisecond})
+	for _, s := range n.Nodes() {
+		if len(s.Known()) == 0 {
+			t.Fatal("node not bootstrapped", s.Addr)
+		}
+	}
+	target := n.randomID()
+	if r := n.Lookup(n.Nodes()[9], target, false); len(r.Closest) != DefaultK || r.Closest[0].ID != n.Closest(target, 1)[0].ID {
+		t.Error("lookup over loopback", len(r.Closest))
+	}
+	if _, err := n.Query(n.Nodes()[0], n.Nodes()[1].Addr, "ping", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func contains(samples string, hash [20]byte) bool {
+	for i := 0; i+20 <= len(samples); i += 20 {
+		if samples[i:i+20] == string(hash[:]) {
+			return true
+		}
+	}
+	return false
+}
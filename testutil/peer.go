@@ -0,0 +1,351 @@
+// Package testutil holds in-process BitTorrent peers, DHT networks and
+// trackers, so the crawler can be tested without touching the live network.
+package testutil
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+// The values a well-behaved Peer sends.
+const (
+	PieceSize     = 16384 //bytes of a metadata piece, BEP 9
+	DefaultPeerID = "-qB4520-0123456789ab"
+	MetadataID    = 3 //ut_metadata of the peer in its extended handshake
+)
+
+const (
+	btMessageID  = 20 //extended message, BEP 10
+	btProtocol   = "BitTorrent protocol"
+	handshakeLen = 68
+)
+
+// Peer serves Info over ut_metadata (BEP 9, BEP 10) to every connection.
+// The zero fields make a well-behaved peer, the others script the ways a
+// real one goes wrong. They must not change once the peer is started.
+type Peer struct {
+	Info   []byte
+	PeerID string //20 bytes, DefaultPeerID when empty
+
+	Handshake    []byte    //sent instead of the handshake reply, it may be of another protocol
+	Hash         *[20]byte //infohash of the handshake reply, nil echoes the one asked for
+	NoExtensions bool      //the extension protocol bit of the reserved bytes is cleared
+
+	Extension    map[string]interface{} //sent instead of the extended handshake dictionary
+	RawExtension []byte                 //sent as the extended handshake, malformed bencode too
+	Pex          []*net.TCPAddr         //sent over ut_pex once the client advertised it (BEP 11)
+
+	Reject     bool                                   //every request is answered with msg_type 2
+	Piece      func(piece int, message []byte) []byte //rewrites a piece message, the dictionary and the data, nil drops it
+	CloseAfter int                                    //pieces sent before the connection is closed, 0 never closes
+	Delay      time.Duration                          //waited before every message the peer sends
+	Silent     bool                                   //accepts and never answers
+
+	ln       net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	open     map[net.Conn]struct{}
+	conns    int64
+	requests int64
+}
+
+// NewPeer returns a well-behaved peer of info.
+func NewPeer(info []byte) *Peer {
+	return &Peer{Info: info}
+}
+
+// StartPeer starts p on the loopback interface until the test ends.
+func StartPeer(t testing.TB, p *Peer) *net.TCPAddr {
+	t.Helper()
+	addr, err := p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return addr
+}
+
+// InfoHash returns the SHA-1 of Info, the hash the peer is dialed for.
+func (p *Peer) InfoHash() [20]byte {
+	return sha1.Sum(p.Info)
+}
+
+// Start listens on a loopback port and serves the connections until Close.
+func (p *Peer) Start() (*net.TCPAddr, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p.ln = ln
+	p.mu.Lock()
+	p.open = map[net.Conn]struct{}{}
+	p.mu.Unlock()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&p.conns, 1)
+			p.mu.Lock()
+			if p.open == nil {
+				//closed while accepting
+				p.mu.Unlock()
+				conn.Close()
+				return
+			}
+			p.open[conn] = struct{}{}
+			p.mu.Unlock()
+			p.wg.Add(1)
+			go func() {
+				defer p.wg.Done()
+				p.serve(conn)
+				p.mu.Lock()
+				delete(p.open, conn)
+				p.mu.Unlock()
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr), nil
+}
+
+// Close stops listening, drops the connections and waits for them.
+func (p *Peer) Close() error {
+	var err error
+	if p.ln != nil {
+		err = p.ln.Close()
+	}
+	p.mu.Lock()
+	for conn := range p.open {
+		conn.Close()
+	}
+	p.open = nil
+	p.mu.Unlock()
+	p.wg.Wait()
+	return err
+}
+
+// Conns counts the connections accepted.
+func (p *Peer) Conns() int {
+	return int(atomic.LoadInt64(&p.conns))
+}
+
+// Requests counts the metadata pieces asked for.
+func (p *Peer) Requests() int {
+	return int(atomic.LoadInt64(&p.requests))
+}
+
+func (p *Peer) serve(conn net.Conn) {
+	defer conn.Close()
+	handshake := make([]byte, handshakeLen)
+	if _, err := io.ReadFull(conn, handshake); err != nil {
+		return
+	}
+	if p.Silent {
+		io.Copy(io.Discard, conn)
+		return
+	}
+	if !p.send(conn, p.handshake(handshake[28:48])) {
+		return
+	}
+	p.exchange(conn)
+}
+
+// Connect dials a client listening at addr and seeds hash to it, the peer
+// sends its handshake first. The connection is dropped by Close.
+func (p *Peer) Connect(addr string, hash [20]byte) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	if p.open == nil {
+		p.open = map[net.Conn]struct{}{}
+	}
+	p.open[conn] = struct{}{}
+	p.mu.Unlock()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			conn.Close()
+			p.mu.Lock()
+			delete(p.open, conn)
+			p.mu.Unlock()
+		}()
+		if !p.send(conn, p.handshake(hash[:])) {
+			return
+		}
+		if _, err := io.ReadFull(conn, make([]byte, handshakeLen)); err != nil {
+			return
+		}
+		p.exchange(conn)
+	}()
+	return nil
+}
+
+// exchange answers the metadata requests of a client once the handshakes
+// were exchanged.
+func (p *Peer) exchange(conn net.Conn) {
+	if !p.send(conn, Message(append([]byte{btMessageID, 0}, p.extension()...))) {
+		return
+	}
+	clientID, sent := 1, 0
+	for {
+		body, err := ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		if len(body) < 2 || body[0] != btMessageID {
+			continue
+		}
+		req := map[string]interface{}{}
+		if bencode.DecodeBytes(body[2:], &req) != nil {
+			return
+		}
+		if body[1] == 0 {
+			//the client tells the id its pieces are sent with
+			m, _ := req["m"].(map[string]interface{})
+			if id, ok := m["ut_metadata"].(int64); ok {
+				clientID = int(id)
+			}
+			if id, ok := m["ut_pex"].(int64); ok && len(p.Pex) > 0 {
+				if !p.send(conn, Message(append([]byte{btMessageID, byte(id)}, p.pex()...))) {
+					return
+				}
+			}
+			continue
+		}
+		piece, ok := req["piece"].(int64)
+		if !ok {
+			continue
+		}
+		atomic.AddInt64(&p.requests, 1)
+		message := p.piece(int(piece))
+		if p.Piece != nil {
+			message = p.Piece(int(piece), message)
+		}
+		if message == nil {
+			continue
+		}
+		if !p.send(conn, Message(append([]byte{btMessageID, byte(clientID)}, message...))) {
+			return
+		}
+		if sent++; p.CloseAfter > 0 && sent >= p.CloseAfter {
+			return
+		}
+	}
+}
+
+// send writes b after the delay, it reports whether the write succeeded.
+func (p *Peer) send(conn net.Conn, b []byte) bool {
+	if p.Delay > 0 {
+		time.Sleep(p.Delay)
+	}
+	_, err := conn.Write(b)
+	return err == nil
+}
+
+func (p *Peer) handshake(hash []byte) []byte {
+	if p.Handshake != nil {
+		return p.Handshake
+	}
+	reserved := []byte{0, 0, 0, 0, 0, 0x10, 0, 0}
+	if p.NoExtensions {
+		reserved[5] = 0
+	}
+	if p.Hash != nil {
+		hash = p.Hash[:]
+	}
+	id := p.PeerID
+	if id == "" {
+		id = DefaultPeerID
+	}
+	b := append([]byte{byte(len(btProtocol))}, btProtocol...)
+	b = append(append(b, reserved...), hash...)
+	return append(b, id...)
+}
+
+func (p *Peer) extension() []byte {
+	if p.RawExtension != nil {
+		return p.RawExtension
+	}
+	dict := p.Extension
+	if dict == nil {
+		dict = map[string]interface{}{
+			"m":             map[string]interface{}{"ut_metadata": MetadataID},
+			"metadata_size": len(p.Info),
+		}
+	}
+	b, _ := bencode.EncodeBytes(dict)
+	return b
+}
+
+// pex returns the ut_pex message adding the Pex peers.
+func (p *Peer) pex() []byte {
+	var added, added6 []byte
+	for _, addr := range p.Pex {
+		if ip := addr.IP.To4(); ip != nil {
+			added = binary.BigEndian.AppendUint16(append(added, ip...), uint16(addr.Port))
+		} else {
+			added6 = binary.BigEndian.AppendUint16(append(added6, addr.IP.To16()...), uint16(addr.Port))
+		}
+	}
+	b, _ := bencode.EncodeBytes(map[string]interface{}{"added": string(added), "added6": string(added6), "dropped": ""})
+	return b
+}
+
+// piece returns the data message of piece i, or its reject.
+func (p *Peer) piece(i int) []byte {
+	if p.Reject || i < 0 || i*PieceSize >= len(p.Info) {
+		dict, _ := bencode.EncodeBytes(map[string]interface{}{"msg_type": 2, "piece": i})
+		return dict
+	}
+	return PieceMessage(i, len(p.Info), p.Info[i*PieceSize:min((i+1)*PieceSize, len(p.Info))])
+}
+
+// PieceMessage returns the ut_metadata data message of piece with its
+// dictionary, for Piece hooks which alter one of them.
+func PieceMessage(piece, totalSize int, data []byte) []byte {
+	dict, _ := bencode.EncodeBytes(map[string]interface{}{"msg_type": 1, "piece": piece, "total_size": totalSize})
+	return append(dict, data...)
+}
+
+// Message prefixes body with its length, as every peer wire message is.
+func Message(body []byte) []byte {
+	b := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+// ReadMessage reads one length prefixed message, keep-alives are skipped.
+func ReadMessage(r io.Reader) ([]byte, error) {
+	for {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if length > 1<<20 {
+			return nil, errors.New("message too large")
+		}
+		if length == 0 {
+			continue
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+}
@@ -0,0 +1,205 @@
+package testutil
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+// Swarm is what a Tracker knows of a torrent.
+type Swarm struct {
+	Seeders, Completed, Leechers int
+	Peers                        []*net.TCPAddr //sent to the announces, IPv4 and IPv6
+}
+
+const (
+	udpTrackerMagic = 0x41727101980
+	actionConnect   = 0
+	actionAnnounce  = 1
+	actionScrape    = 2
+	actionError     = 3
+)
+
+// Tracker answers scrapes and announces with Swarms, over UDP (BEP 15)
+// from StartTracker and over HTTP as a handler of /announce and /scrape.
+// Unknown hashes have empty swarms. The fields must not change once it is
+// started.
+type Tracker struct {
+	Swarms    map[[20]byte]Swarm
+	Drop      int    //requests ignored before the first is answered, HTTP ones fail with 503
+	Error     string //sent as an error response to every scrape and announce when set
+	NoCompact bool   //the HTTP peers are a list of dictionaries
+
+	conn      *net.UDPConn
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	ids       map[uint64]bool
+	packets   int64
+	connects  int64
+	scrapes   int64
+	announces int64
+}
+
+// StartTracker starts t on a loopback UDP port until the test ends.
+func StartTracker(tb testing.TB, t *Tracker) *net.UDPAddr {
+	tb.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	t.conn = conn
+	t.ids = map[uint64]bool{}
+	t.wg.Add(1)
+	go t.serve()
+	tb.Cleanup(func() {
+		conn.Close()
+		t.wg.Wait()
+	})
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Connects counts the connect requests answered.
+func (t *Tracker) Connects() int {
+	return int(atomic.LoadInt64(&t.connects))
+}
+
+// Scrapes counts the scrape requests answered.
+func (t *Tracker) Scrapes() int {
+	return int(atomic.LoadInt64(&t.scrapes))
+}
+
+// Announces counts the announce requests answered.
+func (t *Tracker) Announces() int {
+	return int(atomic.LoadInt64(&t.announces))
+}
+
+func (t *Tracker) serve() {
+	defer t.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if atomic.AddInt64(&t.packets, 1) <= int64(t.Drop) || n < 16 {
+			continue
+		}
+		if resp := t.answer(buf[:n]); resp != nil {
+			t.conn.WriteToUDP(resp, addr)
+		}
+	}
+}
+
+func (t *Tracker) answer(req []byte) []byte {
+	connID := binary.BigEndian.Uint64(req)
+	action, tid := binary.BigEndian.Uint32(req[8:]), req[12:16]
+	resp := binary.BigEndian.AppendUint32(nil, action)
+	resp = append(resp, tid...)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case action == actionConnect && connID == udpTrackerMagic:
+		atomic.AddInt64(&t.connects, 1)
+		id := uint64(len(t.ids)+1) << 32
+		t.ids[id] = true
+		return binary.BigEndian.AppendUint64(resp, id)
+	case action == actionAnnounce && t.ids[connID] && len(req) >= 98:
+		if t.Error != "" {
+			resp = binary.BigEndian.AppendUint32(nil, actionError)
+			return append(append(resp, tid...), t.Error...)
+		}
+		atomic.AddInt64(&t.announces, 1)
+		s := t.Swarms[[20]byte(req[16:36])]
+		resp = binary.BigEndian.AppendUint32(resp, 1800) //interval
+		resp = binary.BigEndian.AppendUint32(resp, uint32(s.Leechers))
+		resp = binary.BigEndian.AppendUint32(resp, uint32(s.Seeders))
+		return append(resp, compactPeers(s.Peers, false)...)
+	case action == actionScrape && t.ids[connID]:
+		if t.Error != "" {
+			resp = binary.BigEndian.AppendUint32(nil, actionError)
+			return append(append(resp, tid...), t.Error...)
+		}
+		atomic.AddInt64(&t.scrapes, 1)
+		for hashes := req[16:]; len(hashes) >= 20; hashes = hashes[20:] {
+			s := t.Swarms[[20]byte(hashes[:20])]
+			resp = binary.BigEndian.AppendUint32(resp, uint32(s.Seeders))
+			resp = binary.BigEndian.AppendUint32(resp, uint32(s.Completed))
+			resp = binary.BigEndian.AppendUint32(resp, uint32(s.Leechers))
+		}
+		return resp
+	}
+	return nil
+}
+
+// ServeHTTP answers /announce and /scrape, and their query strings.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt64(&t.packets, 1) <= int64(t.Drop) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		return
+	}
+	var resp map[string]interface{}
+	switch {
+	case t.Error != "":
+		resp = map[string]interface{}{"failure reason": t.Error}
+	case strings.HasSuffix(r.URL.Path, "/scrape"):
+		atomic.AddInt64(&t.scrapes, 1)
+		files := map[string]interface{}{}
+		for _, h := range r.URL.Query()["info_hash"] {
+			if len(h) != 20 {
+				continue
+			}
+			s := t.Swarms[[20]byte([]byte(h))]
+			files[h] = map[string]interface{}{"complete": s.Seeders, "downloaded": s.Completed, "incomplete": s.Leechers}
+		}
+		resp = map[string]interface{}{"files": files}
+	case strings.HasSuffix(r.URL.Path, "/announce"):
+		atomic.AddInt64(&t.announces, 1)
+		h := r.URL.Query().Get("info_hash")
+		if len(h) != 20 {
+			http.Error(w, "bad info_hash", http.StatusBadRequest)
+			return
+		}
+		s := t.Swarms[[20]byte([]byte(h))]
+		resp = map[string]interface{}{"interval": 1800, "complete": s.Seeders, "incomplete": s.Leechers}
+		if t.NoCompact {
+			var peers []interface{}
+			for _, p := range s.Peers {
+				peers = append(peers, map[string]interface{}{"ip": p.IP.String(), "port": p.Port})
+			}
+			resp["peers"] = peers
+		} else {
+			resp["peers"] = string(compactPeers(s.Peers, false))
+			resp["peers6"] = string(compactPeers(s.Peers, true))
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	b, _ := bencode.EncodeBytes(resp)
+	w.Write(b)
+}
+
+// compactPeers returns the 6 byte IPv4 or the 18 byte IPv6 forms of the
+// peers of that family.
+func compactPeers(peers []*net.TCPAddr, ipv6 bool) []byte {
+	var b []byte
+	for _, p := range peers {
+		ip := p.IP.To4()
+		if ipv6 {
+			if ip != nil {
+				continue
+			}
+			ip = p.IP.To16()
+		} else if ip == nil {
+			continue
+		}
+		b = binary.BigEndian.AppendUint16(append(b, ip...), uint16(p.Port))
+	}
+	return b
+}
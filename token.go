@@ -10,21 +10,28 @@ type Token struct {
 	Value    string
 	prev     string
 	Duration time.Duration // Duration * minute
+
+	clock Clock
+	r     *rand.Rand
 }
 
 func NewToken(duration int) *Token {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	t := Token{fmt.Sprintf("%x", r.Int()), "", time.Duration(duration)}
+	return newToken(duration, SystemClock, rand.NewSource(time.Now().UnixNano()))
+}
+
+// newToken rotates the token on clock with values drawn from src.
+func newToken(duration int, clock Clock, src rand.Source) *Token {
+	t := &Token{Duration: time.Duration(duration), clock: clock, r: rand.New(src)}
+	t.Value = fmt.Sprintf("%x", t.r.Int())
 	// go t.refresh()
-	time.AfterFunc(time.Minute*t.Duration, t.refresh)
-	return &t
+	t.clock.AfterFunc(time.Minute*t.Duration, t.refresh)
+	return t
 }
 
 func (t *Token) refresh() {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	t.prev = t.Value
-	t.Value = fmt.Sprintf("%x", r.Int())
-	time.AfterFunc(time.Minute*t.Duration, t.refresh)
+	t.Value = fmt.Sprintf("%x", t.r.Int())
+	t.clock.AfterFunc(time.Minute*t.Duration, t.refresh)
 }
 
 func (t *Token) IsValid(v string) (b bool) {
@@ -0,0 +1,95 @@
+package DHTCrawl
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// MaxTorrentFileSize bounds what ReadTorrent reads, the info dictionary
+// and room for the tracker lists around it.
+const MaxTorrentFileSize = MaxMetadataSize + 1<<20
+
+// TorrentFile is a .torrent file, with the infohashes the DHT and the
+// peers know it by.
+type TorrentFile struct {
+	Info   []byte `bencode:"-"` //info dictionary, byte for byte as in the file
+	Hash   Hash   `bencode:"-"` //SHA-1 of Info
+	HashV2 HashV2 `bencode:"-"` //SHA-256 of Info, zero unless the torrent is v2 or hybrid
+
+	Announce     string     `bencode:"announce"`
+	AnnounceList [][]string `bencode:"announce-list"`
+	Comment      string     `bencode:"comment"`
+	CreatedBy    string     `bencode:"created by"`
+	CreationDate int64      `bencode:"creation date"` //unix seconds
+}
+
+// OpenTorrent reads the .torrent file at path.
+func OpenTorrent(path string) (*TorrentFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTorrent(f)
+}
+
+// ReadTorrent reads a .torrent file of at most MaxTorrentFileSize bytes.
+func ReadTorrent(r io.Reader) (*TorrentFile, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxTorrentFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxTorrentFileSize {
+		return nil, fmt.Errorf("torrent file larger than %d bytes", MaxTorrentFileSize)
+	}
+	return ParseTorrent(data)
+}
+
+// ParseTorrent parses a .torrent file. The infohashes are computed over the
+// info dictionary exactly as it is in data, not over a re-encoding of it.
+func ParseTorrent(data []byte) (*TorrentFile, error) {
+	n, err := scanBencode(data)
+	if err != nil {
+		return nil, err
+	}
+	data = data[:n]
+	info, err := bencodeLookup(data, "info")
+	if err != nil {
+		return nil, err
+	}
+	if len(info) == 0 || info[0] != 'd' {
+		return nil, errors.New("torrent without info dictionary")
+	}
+	t := &TorrentFile{Info: info, Hash: sha1.Sum(info)}
+	//the tracker fields are informative, a file which gets them wrong still
+	//has its infohash
+	decodeBencode(data, t, 0)
+	if version, err := bencodeLookup(info, "meta version"); err == nil && string(version) == "i2e" {
+		t.HashV2 = sha256.Sum256(info)
+	}
+	return t, nil
+}
+
+// V2 reports whether the torrent is v2 or hybrid.
+func (t *TorrentFile) V2() bool {
+	return t.HashV2 != HashV2{}
+}
+
+// Metadata decodes the info dictionary into the result a crawl would have
+// fetched, it fails on metadata Validate rejects.
+func (t *TorrentFile) Metadata() (*MetadataResult, error) {
+	result := new(MetadataResult)
+	if err := decodeBencode(t.Info, result, 0); err != nil {
+		return nil, err
+	}
+	if err := result.Validate(); err != nil {
+		return nil, err
+	}
+	result.Hash = t.Hash
+	result.Info = t.Info
+	return result, nil
+}
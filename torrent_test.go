@@ -0,0 +1,53 @@
+package DHTCrawl
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ParseTorrent(t *testing.T) {
+	// the keys are out of order, a re-encoded info would hash differently
+	info := "d6:lengthi5e4:name5:a.mkv12:piece lengthi16384e6:pieces0:e"
+	unsorted := "d4:name5:a.mkv6:lengthi5e12:piece lengthi16384e6:pieces0:e"
+	for _, info := range []string{info, unsorted} {
+		data := "d8:announce19:udp://tracker:1337/7:comment2:hi4:info" + info + "e"
+		tf, err := ParseTorrent([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(tf.Info) != info || tf.Hash != Hash(sha1.Sum([]byte(info))) || tf.V2() {
+			t.Error("info", string(tf.Info), tf.Hash)
+		}
+		if tf.Announce != "udp://tracker:1337/" || tf.Comment != "hi" {
+			t.Error("announce", tf.Announce, tf.Comment)
+		}
+		r, err := tf.Metadata()
+		if err != nil || r.Name != "a.mkv" || r.Length != 5 || !r.Verify() {
+			t.Error("metadata", r, err)
+		}
+	}
+
+	hybrid := "d9:file treede4:name1:a12:meta versioni2e12:piece lengthi16384ee"
+	path := filepath.Join(t.TempDir(), "hybrid.torrent")
+	os.WriteFile(path, []byte("d4:info"+hybrid+"e"), 0644)
+	tf, err := OpenTorrent(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tf.V2() || tf.HashV2 != HashV2(sha256.Sum256([]byte(hybrid))) || tf.HashV2.Truncated() == tf.Hash {
+		t.Error("v2", tf.HashV2)
+	}
+
+	for _, bad := range []string{"", "d4:infoi1ee", "d8:announce1:xe", "d4:infod4:name1:a"} {
+		if _, err := ParseTorrent([]byte(bad)); err == nil {
+			t.Error("accepted", bad)
+		}
+	}
+	if _, err := ReadTorrent(strings.NewReader("d4:info" + strings.Repeat("x", MaxTorrentFileSize))); err == nil {
+		t.Error("accepted a huge file")
+	}
+}
@@ -0,0 +1,122 @@
+package DHTCrawl
+
+import (
+	"context"
+	"net"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingConfig exports a trace of every metadata download over OTLP/gRPC.
+type TracingConfig struct {
+	Endpoint    string            `json:"endpoint"`     //host:port of the collector
+	Insecure    bool              `json:"insecure"`     //plain gRPC, without TLS
+	Headers     map[string]string `json:"headers"`      //sent with every export, for the collector's auth
+	ServiceName string            `json:"service_name"` //dhtcrawl when empty
+	SampleRatio float64           `json:"sample_ratio"` //share of the downloads traced, 0 traces all of them
+}
+
+const tracerName = "bitbucket.org/AlanYang/DHTCrawl"
+
+// tracer returns the tracer of the provider set by SetupTracing or by the
+// embedding program, spans cost nothing until one is set. It is looked up
+// for every span, the delegate of otel.Tracer only follows the first
+// provider ever set.
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// spanTracer returns the tracer of the span of ctx, the children of a span
+// go to its provider. Without a span it returns or.
+func spanTracer(ctx context.Context, or trace.Tracer) trace.Tracer {
+	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		return span.TracerProvider().Tracer(tracerName)
+	}
+	return or
+}
+
+// SetupTracing makes an OTLP exporter for cfg the process wide tracer
+// provider. The returned func flushes the pending spans and stops it.
+func SetupTracing(ctx context.Context, cfg *TracingConfig) (func(context.Context) error, error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	name := cfg.ServiceName
+	if name == "" {
+		name = "dhtcrawl"
+	}
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(name))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// fetchPhases times the steps of a download from one peer as children of
+// its span, one step runs at a time.
+type fetchPhases struct {
+	ctx   context.Context
+	phase trace.Span
+}
+
+func startFetchPhases(ctx context.Context) *fetchPhases {
+	return &fetchPhases{ctx: ctx}
+}
+
+// next ends the running step and starts the one called name.
+func (f *fetchPhases) next(name string, attrs ...attribute.KeyValue) {
+	f.end(nil)
+	_, f.phase = spanTracer(f.ctx, tracer()).Start(f.ctx, name, trace.WithAttributes(attrs...))
+}
+
+// end ends the running step, failed on err.
+func (f *fetchPhases) end(err error) {
+	if f.phase == nil {
+		return
+	}
+	traceError(f.phase, err)
+	f.phase.End()
+	f.phase = nil
+}
+
+func (f *fetchPhases) event(name string, attrs ...attribute.KeyValue) {
+	if f.phase != nil {
+		f.phase.AddEvent(name, trace.WithAttributes(attrs...))
+	}
+}
+
+func traceError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func hashAttr(hash Hash) attribute.KeyValue {
+	return attribute.String("infohash", hash.Hex())
+}
+
+func peerAttr(addr *net.TCPAddr) attribute.KeyValue {
+	return attribute.String("peer", addr.String())
+}
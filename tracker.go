@@ -0,0 +1,409 @@
+package DHTCrawl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The defaults and limits of the tracker scrapes.
+const (
+	DefaultTrackerTimeout = 5 * time.Second //of the first attempt, doubled by every retry
+	DefaultTrackerRetries = 2
+	TrackerScrapeBatch    = 74 //hashes of a UDP scrape, what fits in a packet
+	TrackerNumWant        = 50 //peers asked for by an announce
+
+	udpTrackerMagic     = 0x41727101980
+	udpConnectionExpiry = time.Minute
+	udpActionConnect    = 0
+	udpActionAnnounce   = 1
+	udpActionScrape     = 2
+	udpActionError      = 3
+)
+
+// ErrTrackerRateLimited is returned instead of asking a tracker over its
+// rate.
+var ErrTrackerRateLimited = errors.New("tracker rate limit reached")
+
+// trackerPeerID is the peer ID of the announces, one per process like the
+// clients have.
+var trackerPeerID = fmt.Sprintf("-DC0100-%012d", rand.Int63n(1e12))
+
+type (
+	// TrackerConfig scrapes the torrents from trackers before they are
+	// stored, for their seeders and leechers. The results waiting are
+	// scraped together, a tracker which doesn't answer holds them for the
+	// timeouts of its attempts.
+	TrackerConfig struct {
+		URLs    []string `json:"urls"`    //udp://host:port/announce, http:// or https:// announce URLs
+		Timeout int      `json:"timeout"` //seconds of the first attempt, 0 is DefaultTrackerTimeout
+		Retries int      `json:"retries"` //attempts after the first, 0 is DefaultTrackerRetries, -1 none
+		Rate    float64  `json:"rate"`    //requests per second to one tracker, 0 is unlimited
+		Burst   int      `json:"burst"`
+	}
+
+	// Tracker is a UDPTracker or an HTTPTracker.
+	Tracker interface {
+		// Scrape returns the swarms of hashes, those the tracker doesn't
+		// know are empty.
+		Scrape(ctx context.Context, hashes []Hash) (map[Hash]*ScrapeResult, error)
+		// Announce asks for the peers of hash, announcing a leecher on port.
+		Announce(ctx context.Context, hash Hash, port int) ([]*net.TCPAddr, error)
+		String() string
+	}
+
+	// ScrapeResult is the swarm of a torrent as a tracker counts it.
+	ScrapeResult struct {
+		Seeders   int
+		Completed int //downloads finished
+		Leechers  int
+	}
+
+	// UDPTracker scrapes one tracker over the UDP tracker protocol (BEP 15).
+	// The connection ID is reused for its minute.
+	UDPTracker struct {
+		Addr    string //host:port
+		Timeout time.Duration
+		Retries int
+		Limiter *Limiter //of the requests, a connect is one too
+
+		mu     sync.Mutex
+		connID uint64
+		connAt time.Time
+	}
+
+	// Scraper scrapes every tracker and keeps the largest swarm of each
+	// hash, the trackers rarely agree.
+	Scraper struct {
+		Trackers []Tracker
+	}
+
+	// TrackerError is the error message a tracker answered with.
+	TrackerError struct {
+		Tracker string
+		Message string
+	}
+)
+
+func (e *TrackerError) Error() string {
+	return fmt.Sprintf("tracker %s: %s", e.Tracker, e.Message)
+}
+
+// NewUDPTracker returns the client of a udp:// tracker URL.
+func NewUDPTracker(rawurl string) (*UDPTracker, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" || u.Port() == "" {
+		return nil, fmt.Errorf("%q is not a udp://host:port tracker", rawurl)
+	}
+	return &UDPTracker{Addr: u.Host, Timeout: DefaultTrackerTimeout, Retries: DefaultTrackerRetries, Limiter: NewLimiter(0, 0)}, nil
+}
+
+// NewTracker returns the client of a udp://, http:// or https:// tracker
+// URL, with the timeouts and the rate of cfg.
+func NewTracker(rawurl string, cfg *TrackerConfig) (Tracker, error) {
+	timeout, retries := DefaultTrackerTimeout, DefaultTrackerRetries
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	if cfg.Retries != 0 {
+		retries = max(cfg.Retries, 0)
+	}
+	if strings.HasPrefix(rawurl, "udp:") {
+		t, err := NewUDPTracker(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		t.Timeout, t.Retries = timeout, retries
+		t.Limiter.SetRate(cfg.Rate, cfg.Burst)
+		return t, nil
+	}
+	t, err := NewHTTPTracker(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	t.Timeout, t.Retries = timeout, retries
+	t.Limiter.SetRate(cfg.Rate, cfg.Burst)
+	return t, nil
+}
+
+// NewScraper returns the scraper of the trackers of cfg.
+func NewScraper(cfg *TrackerConfig) (*Scraper, error) {
+	s := new(Scraper)
+	for _, rawurl := range cfg.URLs {
+		t, err := NewTracker(rawurl, cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.Trackers = append(s.Trackers, t)
+	}
+	return s, nil
+}
+
+func (t *UDPTracker) String() string {
+	return "udp://" + t.Addr
+}
+
+// Scrape asks the tracker for the swarms of hashes, in batches of
+// TrackerScrapeBatch.
+func (t *UDPTracker) Scrape(ctx context.Context, hashes []Hash) (map[Hash]*ScrapeResult, error) {
+	conn, err := net.Dial("udp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	results := make(map[Hash]*ScrapeResult, len(hashes))
+	for len(hashes) > 0 {
+		batch := hashes[:min(len(hashes), TrackerScrapeBatch)]
+		hashes = hashes[len(batch):]
+		if !t.Limiter.Allow() {
+			return results, ErrTrackerRateLimited
+		}
+		id, err := t.connection(ctx, conn)
+		if err != nil {
+			return results, err
+		}
+		req := binary.BigEndian.AppendUint64(nil, id)
+		req = binary.BigEndian.AppendUint32(req, udpActionScrape)
+		req = binary.BigEndian.AppendUint32(req, 0) //transaction ID set by roundTrip
+		for _, h := range batch {
+			req = append(req, h[:]...)
+		}
+		resp, err := t.roundTrip(ctx, conn, req, udpActionScrape)
+		if err != nil {
+			t.forget()
+			return results, err
+		}
+		for i, h := range batch {
+			if len(resp) < 12*(i+1) {
+				break
+			}
+			r := resp[12*i:]
+			results[h] = &ScrapeResult{
+				Seeders:   int(binary.BigEndian.Uint32(r)),
+				Completed: int(binary.BigEndian.Uint32(r[4:])),
+				Leechers:  int(binary.BigEndian.Uint32(r[8:])),
+			}
+		}
+	}
+	return results, nil
+}
+
+// Announce asks for TrackerNumWant peers of hash.
+func (t *UDPTracker) Announce(ctx context.Context, hash Hash, port int) ([]*net.TCPAddr, error) {
+	if !t.Limiter.Allow() {
+		return nil, ErrTrackerRateLimited
+	}
+	conn, err := net.Dial("udp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	id, err := t.connection(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	req := binary.BigEndian.AppendUint64(nil, id)
+	req = binary.BigEndian.AppendUint32(req, udpActionAnnounce)
+	req = binary.BigEndian.AppendUint32(req, 0)
+	req = append(append(req, hash[:]...), trackerPeerID...)
+	req = binary.BigEndian.AppendUint64(req, 0)             //downloaded
+	req = binary.BigEndian.AppendUint64(req, 1)             //left, a leecher is sent the seeders
+	req = binary.BigEndian.AppendUint64(req, 0)             //uploaded
+	req = binary.BigEndian.AppendUint32(req, 0)             //event
+	req = binary.BigEndian.AppendUint32(req, 0)             //IP, the one of the packet
+	req = binary.BigEndian.AppendUint32(req, rand.Uint32()) //key
+	req = binary.BigEndian.AppendUint32(req, TrackerNumWant)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	resp, err := t.roundTrip(ctx, conn, req, udpActionAnnounce)
+	if err != nil {
+		t.forget()
+		return nil, err
+	}
+	if len(resp) < 12 {
+		return nil, errors.New("short announce response")
+	}
+	return decodeCompactPeers(resp[12:], CompactPeerLen), nil
+}
+
+// forget drops the connection ID after a failure, it may have expired on
+// the tracker's side.
+func (t *UDPTracker) forget() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connID = 0
+}
+
+// connection returns the connection ID, connecting when it expired.
+func (t *UDPTracker) connection(ctx context.Context, conn net.Conn) (uint64, error) {
+	t.mu.Lock()
+	id, at := t.connID, t.connAt
+	t.mu.Unlock()
+	if id != 0 && time.Since(at) < udpConnectionExpiry {
+		return id, nil
+	}
+	req := binary.BigEndian.AppendUint64(nil, udpTrackerMagic)
+	req = binary.BigEndian.AppendUint32(req, udpActionConnect)
+	req = binary.BigEndian.AppendUint32(req, 0)
+	resp, err := t.roundTrip(ctx, conn, req, udpActionConnect)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < 8 {
+		return 0, errors.New("short connect response")
+	}
+	id = binary.BigEndian.Uint64(resp)
+	t.mu.Lock()
+	t.connID, t.connAt = id, time.Now()
+	t.mu.Unlock()
+	return id, nil
+}
+
+// roundTrip sends req with a new transaction ID until the answer to it
+// arrives, the wait doubles with every retry. It returns the payload after
+// the action and the transaction ID.
+func (t *UDPTracker) roundTrip(ctx context.Context, conn net.Conn, req []byte, action uint32) ([]byte, error) {
+	tid := rand.Uint32()
+	binary.BigEndian.PutUint32(req[12:], tid)
+	buf := make([]byte, 2048)
+	for attempt := 0; attempt <= t.Retries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(t.Timeout << uint(attempt))
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetDeadline(deadline)
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		for {
+			n, err := conn.Read(buf)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if n < 8 || binary.BigEndian.Uint32(buf[4:]) != tid {
+				continue
+			}
+			switch binary.BigEndian.Uint32(buf) {
+			case action:
+				return append([]byte(nil), buf[8:n]...), nil
+			case udpActionError:
+				return nil, &TrackerError{Tracker: t.String(), Message: string(buf[8:n])}
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("tracker %s: no response after %d attempts", t, t.Retries+1)
+}
+
+// decodeCompactPeers reads the peers of a compact announce response, the
+// invalid ones are skipped.
+func decodeCompactPeers(b []byte, size int) []*net.TCPAddr {
+	var peers []*net.TCPAddr
+	for ; len(b) >= size; b = b[size:] {
+		if addr, err := DecodeCompactPeer(b[:size]); err == nil {
+			peers = append(peers, addr)
+		}
+	}
+	return peers
+}
+
+// Scrape asks every tracker at once, the errors of the trackers which
+// failed are logged.
+func (s *Scraper) Scrape(ctx context.Context, hashes []Hash) map[Hash]*ScrapeResult {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[Hash]*ScrapeResult, len(hashes))
+	for _, t := range s.Trackers {
+		wg.Add(1)
+		go func(t Tracker) {
+			defer wg.Done()
+			got, err := t.Scrape(ctx, hashes)
+			switch {
+			case errors.Is(err, ErrTrackerRateLimited):
+				logPipeline.Debug("scrape skipped", "tracker", t, "hashes", len(hashes), "error", err)
+			case err != nil:
+				logPipeline.Warn("scrape failed", "tracker", t, "hashes", len(hashes), "error", err)
+			}
+			metricScrapes.WithLabelValues(scrapeResult(err)).Inc()
+			mu.Lock()
+			defer mu.Unlock()
+			for h, r := range got {
+				if best, ok := results[h]; !ok || r.Seeders+r.Leechers > best.Seeders+best.Leechers {
+					results[h] = r
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+	return results
+}
+
+func scrapeResult(err error) string {
+	switch {
+	case errors.Is(err, ErrTrackerRateLimited):
+		return "limited"
+	case err != nil:
+		return "failure"
+	}
+	return "success"
+}
+
+// Announce asks every tracker at once for the peers of hash.
+func (s *Scraper) Announce(ctx context.Context, hash Hash, port int) []*net.TCPAddr {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	seen := map[string]bool{}
+	var peers []*net.TCPAddr
+	for _, t := range s.Trackers {
+		wg.Add(1)
+		go func(t Tracker) {
+			defer wg.Done()
+			got, err := t.Announce(ctx, hash, port)
+			if err != nil {
+				logPipeline.Debug("announce failed", "tracker", t, "infohash", hash, "error", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, addr := range got {
+				if !seen[addr.String()] {
+					seen[addr.String()] = true
+					peers = append(peers, addr)
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+	return peers
+}
+
+// scrapeResults sets the swarms of the results from the trackers.
+func (s *Scraper) scrapeResults(ctx context.Context, results []*MetadataResult) {
+	hashes := make([]Hash, len(results))
+	for i, r := range results {
+		hashes[i] = r.Hash
+	}
+	swarms := s.Scrape(ctx, hashes)
+	for _, r := range results {
+		if sw, ok := swarms[r.Hash]; ok {
+			r.Seeders, r.Leechers, r.Completed = sw.Seeders, sw.Leechers, sw.Completed
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package DHTCrawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+)
+
+func startTracker(t *testing.T, tr *testutil.Tracker) *UDPTracker {
+	addr := testutil.StartTracker(t, tr)
+	u, err := NewUDPTracker("udp://" + addr.String() + "/announce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Timeout = 50 * time.Millisecond
+	return u
+}
+
+func Test_UDPTrackerScrape(t *testing.T) {
+	known := testHash("known")
+	swarms := map[[20]byte]testutil.Swarm{known: {Seeders: 10, Completed: 100, Leechers: 3}}
+	hashes := []Hash{known}
+	for i := 0; len(hashes) < TrackerScrapeBatch+10; i++ {
+		hashes = append(hashes, testHash(fmt.Sprint(i)))
+	}
+	tr := &testutil.Tracker{Swarms: swarms, Drop: 1}
+	u := startTracker(t, tr)
+	got, err := u.Scrape(context.Background(), hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(hashes) || *got[known] != (ScrapeResult{Seeders: 10, Completed: 100, Leechers: 3}) {
+		t.Errorf("%d results, %+v", len(got), got[known])
+	}
+	//two batches on one connection ID, the dropped connect was retried
+	if tr.Connects() != 1 || tr.Scrapes() != 2 {
+		t.Error("connects and scrapes", tr.Connects(), tr.Scrapes())
+	}
+	if _, err := u.Scrape(context.Background(), hashes[:1]); err != nil || tr.Connects() != 1 {
+		t.Error("connection ID not reused", err, tr.Connects())
+	}
+
+	var te *TrackerError
+	refusing := startTracker(t, &testutil.Tracker{Error: "unknown torrent"})
+	if _, err := refusing.Scrape(context.Background(), hashes[:1]); !errors.As(err, &te) || te.Message != "unknown torrent" {
+		t.Error("error response", err)
+	}
+	dead := startTracker(t, &testutil.Tracker{Drop: 1 << 30})
+	dead.Retries = 1
+	start := time.Now()
+	if _, err := dead.Scrape(context.Background(), hashes[:1]); err == nil || time.Since(start) < 150*time.Millisecond {
+		t.Error("dead tracker", err, time.Since(start))
+	}
+}
+
+func Test_ScraperLargest(t *testing.T) {
+	h := testHash("swarm")
+	small := startTracker(t, &testutil.Tracker{Swarms: map[[20]byte]testutil.Swarm{h: {Seeders: 1}}})
+	large := startTracker(t, &testutil.Tracker{Swarms: map[[20]byte]testutil.Swarm{h: {Seeders: 5, Leechers: 2, Completed: 9}}})
+	dead := startTracker(t, &testutil.Tracker{Drop: 1 << 30})
+	dead.Retries = 0
+	s := &Scraper{Trackers: []Tracker{small, dead, large}}
+	r := &MetadataResult{Hash: h}
+	s.scrapeResults(context.Background(), []*MetadataResult{r})
+	if r.Seeders != 5 || r.Leechers != 2 || r.Completed != 9 {
+		t.Errorf("%+v", r)
+	}
+
+	if _, err := NewScraper(&TrackerConfig{URLs: []string{"ftp://tracker.example/announce"}}); err == nil {
+		t.Error("ftp tracker accepted")
+	}
+}
+
+func startHTTPTracker(t *testing.T, tr *testutil.Tracker, path string) *HTTPTracker {
+	srv := httptest.NewServer(tr)
+	t.Cleanup(srv.Close)
+	h, err := NewHTTPTracker(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Timeout, h.RetryWait = time.Second, 10*time.Millisecond
+	return h
+}
+
+func Test_HTTPTracker(t *testing.T) {
+	known := testHash("known")
+	peers := []*net.TCPAddr{{IP: net.IPv4(192, 0, 2, 1), Port: 6881}, {IP: net.ParseIP("2001:db8::1"), Port: 51413}}
+	swarms := map[[20]byte]testutil.Swarm{known: {Seeders: 7, Completed: 70, Leechers: 1, Peers: peers}}
+	hashes := []Hash{known}
+	for i := 0; len(hashes) < httpScrapeBatch+5; i++ {
+		hashes = append(hashes, testHash(fmt.Sprint(i)))
+	}
+	//the first request fails with 503 and is retried
+	tr := &testutil.Tracker{Swarms: swarms, Drop: 1}
+	h := startHTTPTracker(t, tr, "/announce?passkey=x")
+	got, err := h.Scrape(context.Background(), hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(hashes) || *got[known] != (ScrapeResult{Seeders: 7, Completed: 70, Leechers: 1}) || tr.Scrapes() != 2 {
+		t.Errorf("%d results in %d scrapes, %+v", len(got), tr.Scrapes(), got[known])
+	}
+	addrs, err := h.Announce(context.Background(), known, 6881)
+	if err != nil || len(addrs) != 2 || addrs[0].String() != peers[0].String() || addrs[1].String() != peers[1].String() {
+		t.Error("compact announce", addrs, err)
+	}
+	dicts := startHTTPTracker(t, &testutil.Tracker{Swarms: swarms, NoCompact: true}, "/announce")
+	if addrs, err := dicts.Announce(context.Background(), known, 6881); err != nil || len(addrs) != 2 {
+		t.Error("dictionary announce", addrs, err)
+	}
+
+	var te *TrackerError
+	refusing := startHTTPTracker(t, &testutil.Tracker{Error: "unregistered torrent"}, "/announce")
+	if _, err := refusing.Announce(context.Background(), known, 6881); !errors.As(err, &te) || te.Message != "unregistered torrent" {
+		t.Error("failure reason", err)
+	}
+	if _, err := startHTTPTracker(t, tr, "/a").Scrape(context.Background(), hashes); err != errNoScrape {
+		t.Error("scrape without a scrape URL", err)
+	}
+	missing := startHTTPTracker(t, tr, "/announce.php")
+	missing.RetryWait = time.Second
+	start := time.Now()
+	if _, err := missing.Scrape(context.Background(), hashes[:1]); err == nil || time.Since(start) > missing.RetryWait/2 {
+		t.Error("a 404 is retried", err, time.Since(start))
+	}
+
+	limited := startHTTPTracker(t, &testutil.Tracker{Swarms: swarms}, "/announce")
+	limited.Limiter.SetRate(1, 1)
+	if _, err := limited.Scrape(context.Background(), hashes); err != ErrTrackerRateLimited {
+		t.Error("second batch over the rate", err)
+	}
+	s := &Scraper{Trackers: []Tracker{limited}}
+	if got := s.Scrape(context.Background(), hashes[:1]); len(got) != 0 {
+		t.Error("scraped over the rate", got)
+	}
+}
+
+func Test_UDPTrackerAnnounce(t *testing.T) {
+	h := testHash("announced")
+	peers := []*net.TCPAddr{{IP: net.IPv4(192, 0, 2, 1), Port: 6881}, {IP: net.IPv4(192, 0, 2, 2), Port: 6882}}
+	udp := startTracker(t, &testutil.Tracker{Swarms: map[[20]byte]testutil.Swarm{h: {Seeders: 2, Peers: peers}}})
+	http := startHTTPTracker(t, &testutil.Tracker{Swarms: map[[20]byte]testutil.Swarm{h: {Peers: peers[1:]}}}, "/announce")
+	s := &Scraper{Trackers: []Tracker{udp, http}}
+	if got := s.Announce(context.Background(), h, 6881); len(got) != 2 {
+		t.Error("peers", got)
+	}
+	if got := MagnetTrackers("magnet:?xt=urn:btih:" + h.Hex() + "&tr=udp%3A%2F%2Ft.example%3A1337&tr=http://t.example/announce"); len(got) != 2 || got[0] != "udp://t.example:1337" {
+		t.Error("magnet trackers", got)
+	}
+}
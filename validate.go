@@ -0,0 +1,83 @@
+package DHTCrawl
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxTotalLength is the largest torrent Validate believes in, a PiB.
+	MaxTotalLength = int64(1) << 50
+	// MaxPieceLength is the largest piece length Validate believes in.
+	MaxPieceLength = int64(1) << 30
+)
+
+// MetadataError is why decoded metadata was rejected, the hash matched but
+// the peer or the cache made a torrent no client could use.
+type MetadataError struct {
+	Field  string //bencode key, files[i] for a file
+	Reason string
+}
+
+func (e *MetadataError) Error() string {
+	return fmt.Sprintf("invalid metadata: %s %s", e.Field, e.Reason)
+}
+
+// Validate checks that m describes a usable torrent: it has a name, its
+// sizes are neither negative nor absurd, it has some content and no path
+// leaves the download directory. It returns a *MetadataError.
+func (m *MetadataResult) Validate() error {
+	if strings.TrimSpace(m.Name) == "" && strings.TrimSpace(m.UName) == "" {
+		return &MetadataError{"name", "is empty"}
+	}
+	for _, name := range []string{m.Name, m.UName} {
+		if traverses(name) {
+			return &MetadataError{"name", "contains .."}
+		}
+	}
+	if m.PieceLength < 0 || m.PieceLength > MaxPieceLength {
+		return &MetadataError{"piece length", fmt.Sprintf("is %d", m.PieceLength)}
+	}
+	if m.Length < 0 || m.Length > MaxTotalLength {
+		return &MetadataError{"length", fmt.Sprintf("is %d", m.Length)}
+	}
+	if len(m.Files) == 0 {
+		if m.Length == 0 {
+			return &MetadataError{"files", "are missing and length is 0"}
+		}
+		return nil
+	}
+	var total int64
+	for i, f := range m.Files {
+		field := fmt.Sprintf("files[%d]", i)
+		if f == nil {
+			return &MetadataError{field, "is not a dictionary"}
+		}
+		if f.Length < 0 || f.Length > MaxTotalLength-total {
+			return &MetadataError{field, fmt.Sprintf("length is %d", f.Length)}
+		}
+		total += f.Length
+		if len(f.Path) == 0 && len(f.UPath) == 0 {
+			return &MetadataError{field, "has no path"}
+		}
+		for _, path := range [][]string{f.Path, f.UPath} {
+			for _, c := range path {
+				if traverses(c) {
+					return &MetadataError{field, "path contains .."}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// traverses reports whether a name or path component, split on either
+// separator, climbs to a parent directory.
+func traverses(name string) bool {
+	for _, c := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if c == ".." {
+			return true
+		}
+	}
+	return false
+}
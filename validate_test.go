@@ -0,0 +1,39 @@
+package DHTCrawl
+
+import (
+	"testing"
+)
+
+func Test_Validate(t *testing.T) {
+	file := func(length int64, path ...string) *File { return &File{Path: path, Length: length} }
+	for _, c := range []struct {
+		m     MetadataResult
+		field string
+	}{
+		{MetadataResult{Name: "a.mkv", Length: 10}, ""},
+		{MetadataResult{Name: "dir", Files: []*File{file(1, "a"), file(0, "b", "c..d")}}, ""},
+		{MetadataResult{UName: "名前", Length: 1}, ""},
+		{MetadataResult{Name: " ", Length: 10}, "name"},
+		{MetadataResult{Name: "../etc", Length: 10}, "name"},
+		{MetadataResult{Name: "a", Length: -1}, "length"},
+		{MetadataResult{Name: "a", Length: MaxTotalLength + 1}, "length"},
+		{MetadataResult{Name: "a", Length: 1, PieceLength: -16384}, "piece length"},
+		{MetadataResult{Name: "a"}, "files"},
+		{MetadataResult{Name: "a", Files: []*File{file(1, "a"), file(-5, "b")}}, "files[1]"},
+		{MetadataResult{Name: "a", Files: []*File{file(MaxTotalLength, "a"), file(MaxTotalLength, "b")}}, "files[1]"},
+		{MetadataResult{Name: "a", Files: []*File{file(1, "x", "..", "passwd")}}, "files[0]"},
+		{MetadataResult{Name: "a", Files: []*File{file(1, `..\x`)}}, "files[0]"},
+		{MetadataResult{Name: "a", Files: []*File{file(1)}}, "files[0]"},
+	} {
+		err := c.m.Validate()
+		if c.field == "" {
+			if err != nil {
+				t.Error(c.m.Name, err)
+			}
+			continue
+		}
+		if me, ok := err.(*MetadataError); !ok || me.Field != c.field {
+			t.Error(c.m.Name, c.field, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWebhookRetries = 5
+	DefaultWebhookTimeout = 10
+	DefaultWebhookWorkers = 4
+
+	webhookCloseTimeout = 30 * time.Second //of Close, CloseContext takes the deadline of its ctx
+
+	WebhookSignatureHeader = "X-DHTCrawl-Signature"
+)
+
+type (
+	// WebhookConfig enables the webhook sink. With Secret every request
+	// carries X-DHTCrawl-Signature: sha256=<hex HMAC of the body>. Deliveries
+	// which fail every retry, are refused with a 4xx other than 408 and 429,
+	// don't fit in the queue or are left when the sink closes, are appended
+	// to the DeadLetter file when it is set.
+	WebhookConfig struct {
+		URLs       []string `json:"urls"`
+		Secret     string   `json:"secret"`
+		Retries    int      `json:"retries"`
+		Timeout    int      `json:"timeout"` //seconds per request
+		Workers    int      `json:"workers"`
+		DeadLetter string   `json:"dead_letter"`
+	}
+
+	// WebhookSink POSTs every result as JSON to the configured URLs. Delivery
+	// runs in the background with exponential backoff, a slow endpoint never
+	// stalls the pipeline.
+	WebhookSink struct {
+		Config WebhookConfig
+		Client *http.Client
+		Queue  *Queue
+
+		dead    *RotatingFile
+		backoff time.Duration //delay before the first retry, doubled every time
+		wg      sync.WaitGroup
+		ctx     context.Context //cancelled when closing runs out of time
+		cancel  context.CancelFunc
+	}
+
+	webhookDelivery struct {
+		Url  string          `json:"url"`
+		Hash string          `json:"hash"`
+		Body json.RawMessage `json:"body"`
+		Err  string          `json:"error,omitempty"`
+	}
+)
+
+func NewWebhookSink(cfg WebhookConfig) (*WebhookSink, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("webhook sink needs at least one url")
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = DefaultWebhookRetries
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultWebhookTimeout
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWebhookWorkers
+	}
+	s := &WebhookSink{
+		Config:  cfg,
+		Client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second},
+		Queue:   NewQueue("webhook", DefaultQueueSize, QueueDropOldest),
+		backoff: time.Second,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if cfg.DeadLetter != "" {
+		dead, err := OpenRotatingFile(cfg.DeadLetter, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		s.dead = dead
+	}
+	s.Queue.OnDrop = func(v interface{}) {
+		d := v.(*webhookDelivery)
+		d.Err = "dropped, queue full"
+		s.deadLetter(d)
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		s.wg.Add(1)
+		go s.deliver()
+	}
+	return s, nil
+}
+
+// Sign returns the signature header value of body.
+func (s *WebhookSink) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.Config.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookSink) Put(r *MetadataResult) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	for _, url := range s.Config.URLs {
+		s.Queue.Push(&webhookDelivery{Url: url, Hash: r.Hash.Hex(), Body: body})
+	}
+	return nil
+}
+
+func (s *WebhookSink) deliver() {
+	defer s.wg.Done()
+	for v := range s.Queue.C() {
+		d := v.(*webhookDelivery)
+		if err := s.send(d); err != nil {
+			d.Err = err.Error()
+			s.deadLetter(d)
+		}
+	}
+}
+
+// send posts d until it is accepted, refused for good, out of retries or
+// the sink is closing.
+func (s *WebhookSink) send(d *webhookDelivery) error {
+	var err error
+	for attempt := 0; attempt < s.Config.Retries; attempt++ {
+		if attempt > 0 {
+			t := time.NewTimer(s.backoff << uint(attempt-1))
+			select {
+			case <-t.C:
+			case <-s.ctx.Done():
+				t.Stop()
+				return fmt.Errorf("%v, closed", err)
+			}
+		}
+		if s.ctx.Err() != nil {
+			return errors.New("closed before delivery")
+		}
+		var retry bool
+		if retry, err = s.post(d); err == nil || !retry {
+			break
+		}
+	}
+	return err
+}
+
+// post sends d once, retry tells whether another attempt may succeed.
+func (s *WebhookSink) post(d *webhookDelivery) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(s.ctx, "POST", d.Url, bytes.NewReader(d.Body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-DHTCrawl-Event", "metadata")
+	req.Header.Set("X-DHTCrawl-Delivery", d.Hash)
+	if s.Config.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, s.Sign(d.Body))
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		code := resp.StatusCode
+		permanent := code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+		return !permanent, fmt.Errorf("webhook %s status %d", d.Url, code)
+	}
+	return false, nil
+}
+
+func (s *WebhookSink) deadLetter(d *webhookDelivery) {
+	if s.dead == nil {
+		return
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	// flushed at once, a crash must not lose what failed
+	s.dead.Write(append(data, '\n'))
+	s.dead.Flush()
+}
+
+// Close is CloseContext with a deadline of 30 seconds.
+func (s *WebhookSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookCloseTimeout)
+	defer cancel()
+	return s.CloseContext(ctx)
+}
+
+// CloseContext waits for the queued deliveries, retries included, until ctx
+// is done. The requests running then are cancelled and the deliveries left
+// go to the dead letter file.
+func (s *WebhookSink) CloseContext(ctx context.Context) error {
+	s.Queue.Close()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+	if s.dead != nil {
+		return s.dead.Close()
+	}
+	return nil
+}
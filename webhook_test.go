@@ -0,0 +1,102 @@
+package DHTCrawl
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_WebhookSink(t *testing.T) {
+	var calls int32
+	var s *WebhookSink
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(WebhookSignatureHeader) != s.Sign(body) {
+			t.Error("Signature", r.Header.Get(WebhookSignatureHeader))
+		}
+		// fail once to exercise the retry
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ok.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	dead := filepath.Join(t.TempDir(), "dead.jsonl")
+	var err error
+	s, err = NewWebhookSink(WebhookConfig{URLs: []string{ok.URL, broken.URL}, Secret: "secret", Retries: 2, Workers: 1, DeadLetter: dead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.backoff = time.Millisecond
+	s.Put(&MetadataResult{Hash: Hash(NewNodeIDFromHex("951B8DA3AB58B22D759F5FBA6D22FFA9E6242CED")), Name: "test"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Error("Deliveries", n)
+	}
+	data, _ := ioutil.ReadFile(dead)
+	if lines := strings.Count(string(data), "\n"); lines != 1 || !strings.Contains(string(data), broken.URL) {
+		t.Error("Dead letters", string(data))
+	}
+}
+
+func Test_WebhookClose(t *testing.T) {
+	var calls int32
+	refused := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer refused.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	dead := filepath.Join(t.TempDir(), "dead.jsonl")
+	s, err := NewWebhookSink(WebhookConfig{URLs: []string{refused.URL}, Retries: 5, Workers: 1, DeadLetter: dead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(&MetadataResult{Hash: testHash("refused")})
+	for i := 0; i < 100 && atomic.LoadInt32(&calls) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// a 400 is not retried and the dead letter is written at once
+	time.Sleep(50 * time.Millisecond)
+	data, _ := ioutil.ReadFile(dead)
+	if n := atomic.LoadInt32(&calls); n != 1 || strings.Count(string(data), "\n") != 1 {
+		t.Error("refused", n, string(data))
+	}
+	s.Close()
+
+	// the deadline stops the retries, what is left is dead lettered
+	s, err = NewWebhookSink(WebhookConfig{URLs: []string{down.URL}, Retries: 5, Workers: 1, DeadLetter: dead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"a", "b", "c"} {
+		s.Put(&MetadataResult{Hash: testHash(h)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	s.CloseContext(ctx)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Error("closed after", d)
+	}
+	data, _ = ioutil.ReadFile(dead)
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Error("dead letters", string(data))
+	}
+}
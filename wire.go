@@ -1,30 +1,36 @@
 package DHTCrawl
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha1"
-	"encoding/binary"
 	"errors"
 	"fmt"
-	"log"
-	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/zeebo/bencode"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	BtProtocol   = "BitTorrent protocol"
 	BtExtendedID = byte(0)
 	BtMessageID  = byte(20)
+	UtMetadataID = byte(1) //of ut_metadata in our extended handshake
+	UtPexID      = byte(2) //of ut_pex in our extended handshake
+
+	pexMaxPeers = 50 //taken from the ut_pex messages of a connection, BEP 11 sends up to 50 a minute
+	pexMaxTried = 32 //peers a pool download adds from ut_pex
 
 	PieceSize       = 1 << 14
-	MaxMetadataSize = (1 << 20) * 15
+	MaxMetadataSize = (1 << 20) * 15 //default of max_metadata_size
+
+	DefaultMaxMessage      = 1 << 18 //bytes of a peer wire message, a piece is 16KiB
+	DefaultMaxBencodeItems = 1 << 12 //values of a KRPC packet or an extended message
 
 	WireConnectTimeout = 2
 	WireTimeout        = 5
@@ -42,18 +48,20 @@ const (
 	EventExtended
 	EventPiece
 	EventDone
+	EventVerify //every piece arrived, the metadata is being checked
+	EventPeers  //the peer sent others of the swarm over ut_pex
 )
 
 var (
 	//[5] = 1 as extension, [7] = 1 as dht
 	BtReserved = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x01}
 
-	VideoTypeExtensions    = []string{"avi", "rmvb", "rm", "asf", "divx", "mpg", "mpeg", "mpe", "wmv", "mp4", "mkv", "vob", "fla", "3gp"}
-	AudioTypeExtensions    = []string{"mp3", "m4a", "wma"}
-	PictureTypeExtensions  = []string{"jpg", "jpeg", "png", "gif", "bmp", "psd", "tiff", "tga", "eps"}
-	DocumentTypeExtensions = []string{"doc", "docx", "pdf", "chm"}
-	ZipTypeExtensions      = []string{"zip", "rar", "7z", "cab", "iso", "gz", "bz2"}
-	ExeTypeExtensions      = []string{"exe"}
+	VideoTypeExtensions    = []string{"avi", "rmvb", "rm", "asf", "divx", "mpg", "mpeg", "mpe", "wmv", "mp4", "mkv", "vob", "fla", "3gp", "mov", "flv", "m4v", "ts", "m2ts", "webm"}
+	AudioTypeExtensions    = []string{"mp3", "m4a", "wma", "flac", "ape", "wav", "ogg", "aac", "opus"}
+	PictureTypeExtensions  = []string{"jpg", "jpeg", "png", "gif", "bmp", "psd", "tiff", "tga", "eps", "webp"}
+	DocumentTypeExtensions = []string{"doc", "docx", "pdf", "chm", "epub", "mobi", "azw3", "djvu", "txt", "xls", "xlsx", "ppt", "pptx"}
+	ZipTypeExtensions      = []string{"zip", "rar", "7z", "cab", "iso", "gz", "bz2", "xz", "tar", "tgz"}
+	ExeTypeExtensions      = []string{"exe", "msi", "dmg", "pkg", "apk", "deb", "rpm", "appimage"}
 )
 
 type (
@@ -84,45 +92,122 @@ type (
 		Files         []*File     `bencode:"files" json:"files,omitempty"`
 
 		Type     int      `json:"datatype,omitempty"`
+		Category string   `json:"category,omitempty"`
 		Create   string   `json:"create,omitempty"`
 		Download []string `json:"download,omitempty"`
-	}
-
-	Event struct {
-		Type   int
-		Hash   Hash
-		Reason string
-		Result *MetadataResult
-	}
+		Tags     []string `json:"tags,omitempty"`
+		Peers    int      `json:"peers,omitempty"` //announces seen for the hash, a rough seeder estimate
+		Info     []byte   `bencode:"-" json:"-"`   //raw bencoded info dictionary, empty for stored results
 
-	Processor struct {
-		Hash Hash
+		Seeders   int `bencode:"-" json:"seeders,omitempty"` //the largest swarm scraped from the trackers
+		Leechers  int `bencode:"-" json:"leechers,omitempty"`
+		Completed int `bencode:"-" json:"completed,omitempty"`
 
-		Data [][]byte
-		Size int
+		Swarm   int    `bencode:"-" json:"swarm,omitempty"`   //peers found by the last health check
+		Alive   string `bencode:"-" json:"alive,omitempty"`   //when a peer was last seen, Create until a check finds one
+		Checked string `bencode:"-" json:"checked,omitempty"` //of the last health check
 
-		Handler     DataHandler
-		HandlerSize int
+		Source    string       `bencode:"-" json:"source,omitempty"`     //peer the metadata came from, empty from the torrent cache
+		SourceGeo *PeerGeo     `bencode:"-" json:"source_geo,omitempty"` //of the source, nil without GeoIP databases
+		Timing    *FetchTiming `bencode:"-" json:"timing,omitempty"`     //of the attempt at the source, nil from the torrent cache
 
-		utmetadata   int
-		metadata     [][]byte
-		metadataSize int
-		pieceLength  int
-
-		event chan *Event
+		tried []*net.TCPAddr //peers which failed, of an error result
+	}
 
-		Conn net.Conn
+	Event struct {
+		Type    int
+		Hash    Hash
+		Reason  string
+		Failure string //Fail reason of an EventError
+		Err     error  //underlying error of an EventError, may be nil
+		Result  *MetadataResult
+		Peers   []*net.TCPAddr //of an EventPeers
 	}
 
 	Wire struct {
 		Processor *Processor
 		Result    chan *MetadataResult
 		Idle      bool
-		Job       chan *Job
+		Jobs      *Queue
+		stopped   chan struct{}
+		quit      chan struct{}
+		quitOnce  sync.Once
 		mu        *sync.RWMutex
+		timeouts  *wireTimeouts        //shared with the pool, nil uses the defaults
+		limits    *wireLimits          //shared with the pool, nil uses the defaults
+		counters  *fetchCounters       //shared with the pool, nil counts nothing
+		events    *Bus                 //of the pool, nil publishes nothing
+		capture   *captureSlot         //of the pool, nil captures nothing
+		scores    *scoreSlot           //of the pool, nil scores nothing
+		bandwidth *bandwidthSlot       //of the pool, nil counts nothing
+		partials  *partialSlot         //of the pool, nil resumes nothing
+		pex       func([]*net.TCPAddr) //takes the peers of the swarm sent over ut_pex, nil drops them
+		// Tracer starts the span of every download, nil is the global
+		// provider
+		Tracer trace.Tracer
+	}
+
+	// wireTimeouts can be changed while the wires are downloading.
+	wireTimeouts struct {
+		connect int64 //nanoseconds, 0 is WireConnectTimeout
+		fetch   int64 //nanoseconds, 0 is WireTimeout
+	}
+
+	// wireLimits bound what a peer can make a wire allocate, they apply from
+	// the next download.
+	wireLimits struct {
+		message  int64 //bytes, 0 is DefaultMaxMessage
+		metadata int64 //bytes, 0 is MaxMetadataSize
+		items    int64 //0 is DefaultMaxBencodeItems
 	}
 )
 
+// ErrLimitExceeded is wrapped by the errors of the peers and packets which
+// break one of the limits, the connection is dropped.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+func (t *wireTimeouts) get() (connect, fetch time.Duration) {
+	connect, fetch = time.Second*WireConnectTimeout, time.Second*WireTimeout
+	if t == nil {
+		return
+	}
+	if v := atomic.LoadInt64(&t.connect); v > 0 {
+		connect = time.Duration(v)
+	}
+	if v := atomic.LoadInt64(&t.fetch); v > 0 {
+		fetch = time.Duration(v)
+	}
+	return
+}
+
+func (t *wireTimeouts) set(connect, fetch time.Duration) {
+	atomic.StoreInt64(&t.connect, int64(connect))
+	atomic.StoreInt64(&t.fetch, int64(fetch))
+}
+
+func (l *wireLimits) get() (message, metadata, items int) {
+	message, metadata, items = DefaultMaxMessage, MaxMetadataSize, DefaultMaxBencodeItems
+	if l == nil {
+		return
+	}
+	if v := atomic.LoadInt64(&l.message); v > 0 {
+		message = int(v)
+	}
+	if v := atomic.LoadInt64(&l.metadata); v > 0 {
+		metadata = int(v)
+	}
+	if v := atomic.LoadInt64(&l.items); v > 0 {
+		items = int(v)
+	}
+	return
+}
+
+func (l *wireLimits) set(message, metadata, items int) {
+	atomic.StoreInt64(&l.message, int64(message))
+	atomic.StoreInt64(&l.metadata, int64(metadata))
+	atomic.StoreInt64(&l.items, int64(items))
+}
+
 func GetMetaType(ext string) int {
 	switch {
 	case InArray(VideoTypeExtensions, ext):
@@ -163,15 +248,30 @@ func NewErrorEvent(reason string, hash Hash) *Event {
 	return &Event{Type: EventError, Reason: reason, Hash: hash}
 }
 
-func NewWire(c chan *MetadataResult) *Wire {
+func NewWire(jobs *Queue, c chan *MetadataResult) *Wire {
+	return newWire(jobs, c, nil)
+}
+
+// newWire makes a worker of pool, whose timeouts, counters and bus it
+// shares. Without a pool it uses the defaults.
+func newWire(jobs *Queue, c chan *MetadataResult, pool *WireJob) *Wire {
 	wire := new(Wire)
+	if pool != nil {
+		wire.timeouts = &pool.timeouts
+		wire.limits = &pool.limits
+		wire.counters = &pool.counters
+		wire.events = pool.Events
+		wire.capture = &pool.capture
+		wire.scores = &pool.scores
+		wire.bandwidth = &pool.bandwidth
+		wire.partials = &pool.partials
+	}
 	wire.Result = c
-	wire.Job = make(chan *Job)
+	wire.Jobs = jobs
+	wire.stopped = make(chan struct{})
+	wire.quit = make(chan struct{})
 	wire.mu = new(sync.RWMutex)
-	wire.Processor = &Processor{
-		Data:  [][]byte{},
-		event: make(chan *Event),
-	}
+	wire.Processor = NewProcessor()
 	wire.Release()
 	go wire.wait()
 	return wire
@@ -189,6 +289,55 @@ func (f Files) Swap(i, j int) {
 	f[i], f[j] = f[j], f[i]
 }
 
+// Torrent rebuilds a .torrent file around the raw info dictionary, nil when
+// the result carries none.
+func (m *MetadataResult) Torrent() []byte {
+	if len(m.Info) == 0 {
+		return nil
+	}
+	torrent := append([]byte("d4:info"), m.Info...)
+	return append(torrent, 'e')
+}
+
+// Magnet returns the magnet link of the result with its display name.
+func (m *MetadataResult) Magnet() string {
+	return m.Hash.Magnet() + "&dn=" + url.QueryEscape(m.Name)
+}
+
+// TotalLength is the size of a single file torrent or the sum of its files.
+func (m *MetadataResult) TotalLength() int64 {
+	if len(m.Files) == 0 {
+		return m.Length
+	}
+	var n int64
+	for _, f := range m.Files {
+		n += f.Length
+	}
+	return n
+}
+
+// created parses Create, results which were never stamped count as now.
+func (m *MetadataResult) created() time.Time {
+	if t, err := time.Parse(time.RFC3339, m.Create); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// alive parses Alive, the results never checked were alive when created.
+func (m *MetadataResult) alive() time.Time {
+	if t, err := time.Parse(time.RFC3339, m.Alive); err == nil {
+		return t
+	}
+	return m.created()
+}
+
+// checked parses Checked, zero when the swarm was never checked.
+func (m *MetadataResult) checked() time.Time {
+	t, _ := time.Parse(time.RFC3339, m.Checked)
+	return t
+}
+
 func (m *MetadataResult) String() string {
 	s := []string{
 		"********************************",
@@ -210,6 +359,13 @@ func (m *MetadataResult) String() string {
 	return strings.Join(s, "\n")
 }
 
+func (w *Wire) tracer() trace.Tracer {
+	if w.Tracer != nil {
+		return w.Tracer
+	}
+	return tracer()
+}
+
 func (w *Wire) Release() {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -229,84 +385,263 @@ func (w *Wire) IsIdle() bool {
 }
 
 func (w *Wire) wait() {
+	defer close(w.stopped)
 	for {
-		job := <-w.Job
-		w.Acquire()
-		w.Download(job.Hash, job.Addr)
+		select {
+		case <-w.quit:
+			return
+		case v, ok := <-w.Jobs.C():
+			if !ok {
+				return
+			}
+			w.Acquire()
+			job := v.(*Job)
+			if protect("fetch", func() { w.Download(job) }) {
+				w.Result <- NewErrorResult(job.Hash)
+			}
+		}
 	}
 }
 
-func (w *Wire) Download(hash Hash, addr *net.TCPAddr) (result *MetadataResult, err error) {
+// Retire makes the wire exit once its current download is over.
+func (w *Wire) Retire() {
+	w.quitOnce.Do(func() { close(w.quit) })
+}
+
+// Download tries every candidate peer of the job in turn, peers attached to
+// the job while it is running are picked up as well
+func (w *Wire) Download(job *Job) (result *MetadataResult, err error) {
 	defer w.Release()
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*(WireTimeout+1)))
-	defer cancel()
-	result, err = w.fromPeer(ctx, hash, addr)
-	if err == nil {
-		w.Result <- result
-		return
+	ctx, span := w.tracer().Start(context.Background(), "fetch", trace.WithAttributes(hashAttr(job.Hash)))
+	defer span.End()
+	w.events.Publish(&FetchStarted{Hash: job.Hash, Peer: job.Addr, Time: time.Now()})
+	tried, failure := 0, ""
+	var (
+		attempts []*FetchTiming
+		failed   []*net.TCPAddr
+	)
+	// the peers of the swarm join the candidates, fromPeer runs on this goroutine
+	seen, added := map[string]bool{}, 0
+	w.pex = func(peers []*net.TCPAddr) {
+		for _, addr := range peers {
+			if added < pexMaxTried && !seen[addr.String()] && job.AddPeer(addr) {
+				seen[addr.String()] = true
+				added++
+			}
+		}
+	}
+	defer func() { w.pex = nil }()
+	scores := w.scores.get()
+	// the announcer goes first, it just said it has the torrent
+	first := job.Addr
+	if first != nil && scores.Banned(first) {
+		scores.skip()
+		first = nil
+	}
+	if first == nil {
+		// a job of the listener, its candidates are inbound connections
+		first = job.nextPeer(scores)
+	}
+	for addr := first; addr != nil; addr = job.nextPeer(scores) {
+		tried++
+		seen[addr.String()] = true
+		result, err = w.download(ctx, job.Hash, addr, job.takeConn(addr))
+		if err == nil {
+			scores.Observe(addr, nil, result.Timing, len(result.Info))
+			logWire.Debug("fetched", "infohash", job.Hash, "peer", addr)
+			result.Source = addr.String()
+			job.Finish()
+			w.Result <- result
+			return
+		}
+		logWire.Debug("fetch from peer failed", "infohash", job.Hash, "peer", addr, "error", err)
+		scores.Observe(addr, err, nil, 0)
+		failed = append(failed, addr)
+		failure = fetchFailure(err)
+		var fe *FetchError
+		if errors.As(err, &fe) && fe.Timing != nil {
+			attempts = append(attempts, fe.Timing)
+		}
 	}
 
-	result, err = w.fromHTTP(hash)
+	result, err = w.fromHTTP(ctx, job.Hash)
+	job.Finish()
 	if err == nil {
+		logWire.Debug("fetched from the torrent cache", "infohash", job.Hash)
 		w.Result <- result
 		return
 	}
+	logWire.Debug("fetch failed", "infohash", job.Hash, "error", err)
+	traceError(span, err)
+	w.events.Publish(&FetchFailed{Hash: job.Hash, Peers: tried, Failure: failure, Attempts: attempts, Err: err, Time: time.Now()})
+	failedResult := NewErrorResult(job.Hash)
+	failedResult.tried = failed
+	w.Result <- failedResult
 	return
 }
 
-func (w *Wire) fromPeer(ctx context.Context, hash Hash, addr *net.TCPAddr) (*MetadataResult, error) {
-	conn, err := net.DialTimeout("tcp", addr.String(), time.Second*WireConnectTimeout)
+func (w *Wire) download(ctx context.Context, hash Hash, addr *net.TCPAddr, conn net.Conn) (*MetadataResult, error) {
+	_, timeout := w.timeouts.get()
+	ctx, cancel := context.WithTimeout(ctx, timeout+time.Second)
+	defer cancel()
+	return w.fromConn(ctx, hash, addr, conn)
+}
+
+// fromPeer downloads the metadata from one peer, its span has a child for
+// every step: dial, handshake, extended_handshake, pieces and verify.
+func (w *Wire) fromPeer(ctx context.Context, hash Hash, addr *net.TCPAddr) (result *MetadataResult, err error) {
+	return w.fromConn(ctx, hash, addr, nil)
+}
+
+// fromConn is fromPeer over the inbound connection of the peer, which is
+// dialed when conn is nil. The timing of the attempt is attached to the
+// result or to the FetchError.
+func (w *Wire) fromConn(ctx context.Context, hash Hash, addr *net.TCPAddr, conn net.Conn) (result *MetadataResult, err error) {
+	ctx, span := spanTracer(ctx, w.tracer()).Start(ctx, "fetch.peer", trace.WithAttributes(hashAttr(hash), peerAttr(addr)))
+	defer span.End()
+	phases := startFetchPhases(ctx)
+	defer func() {
+		phases.end(err)
+		traceError(span, err)
+	}()
+
+	start := time.Now()
+	timing, mark := &FetchTiming{Peer: addr.String()}, start
+	lap := func(phase string) time.Duration {
+		now := time.Now()
+		d := now.Sub(mark)
+		mark = now
+		metricFetchPhase.WithLabelValues(phase).Observe(d.Seconds())
+		return d
+	}
+	defer func() {
+		timing.Total = time.Since(start)
+		metricFetchPhase.WithLabelValues("total").Observe(timing.Total.Seconds())
+		var fe *FetchError
+		if errors.As(err, &fe) {
+			timing.Failure = fe.Failure
+			fe.Timing = timing
+		} else if result != nil {
+			result.Timing = timing
+		}
+	}()
+	connectTimeout, timeout := w.timeouts.get()
+	phases.next("dial")
+	w.counters.attempt()
+	client := "" //of the peer id, set once the peer sent its handshake
+	defer func() { w.counters.done(err, addr, client) }()
+	if conn == nil {
+		conn, err = net.DialTimeout("tcp", addr.String(), connectTimeout)
+		timing.Dial = lap("dial")
+	}
 	if err != nil {
-		return nil, err
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return nil, &FetchError{Failure: FailDialTimeout, Reason: err.Error()}
+		}
+		return nil, &FetchError{Failure: FailDial, Reason: err.Error()}
 	}
+	conn = w.capture.get().conn(w.bandwidth.get().conn(w.counters.count(conn), BandwidthWire), hash, addr)
 	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(time.Second * WireTimeout))
-	w.Processor.Conn = conn
-	w.Processor.Start(hash)
-	go func(conn net.Conn) {
-		var (
-			n   int
-			err error
-		)
-		defer func() {
-			if r := recover(); r != nil {
-				log.Fatal(fmt.Printf("Has panic %s, received n=%d", r, n))
-			}
-		}()
-		for {
-			buf := make([]byte, 1024)
-			n, err = conn.Read(buf)
+	conn.SetDeadline(time.Now().Add(timeout))
+	//every attempt gets a clean processor, the previous peer may have left partial state
+	p := NewProcessor()
+	p.Attach(conn)
+	p.maxMessage, p.maxMetadata, p.maxItems = w.limits.get()
+	partials := w.partials.get()
+	p.Resume(partials.Take(hash))
+	w.Processor = p
+	phases.next("handshake")
+	p.Start(hash)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan *Event)
+	read := make(chan struct{})
+	// once the reader stopped, the pieces received are kept for the next
+	// attempt, unless they turned out bad
+	defer func() {
+		conn.Close()
+		cancel()
+		<-read
+		partials.resume(p.reused)
+		timing.Resumed = p.reused
+		switch fetchFailure(err) {
+		case FailHashMismatch, FailDecode, FailInvalid:
+		default:
 			if err != nil {
-				return
+				partials.Put(p.Partial())
 			}
-			if n < 0 || n > len(buf) {
-				return
+		}
+	}()
+	go func(conn net.Conn) {
+		defer close(read)
+		send := func(event *Event) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
 			}
-			w.Processor.Write(buf[:n])
+		}
+		//malformed peer data fails this download only
+		if protect("peer", func() {
+			for {
+				event, err := p.Next()
+				if err != nil || event == nil || !send(event) {
+					return
+				}
+			}
+		}) {
+			conn.Close()
+			send(NewErrorEvent("panic while reading from the peer", hash))
 		}
 	}(conn)
+	pieces := 0
 	for {
 		select {
-		case event := <-w.Processor.event:
+		case event := <-events:
 			switch event.Type {
 			case EventError:
-				return nil, errors.New(event.Reason)
+				return nil, &FetchError{Failure: event.Failure, Reason: event.Reason, Err: event.Err}
 			case EventDone:
+				span.SetAttributes(attribute.Int("metadata.size", len(event.Result.Info)))
 				return event.Result, nil
 			case EventHandshake:
+				metricHandshake.Observe(time.Since(start).Seconds())
+				w.counters.handshake(time.Since(start))
+				client = p.client
+				timing.Handshake = lap("handshake")
+				phases.next("extended_handshake")
 			case EventExtended:
+				timing.Extended = lap("extended")
+				phases.next("pieces")
 			case EventPiece:
+				timing.Pieces = append(timing.Pieces, lap("piece"))
+				phases.event("piece", attribute.Int("piece", pieces))
+				pieces++
+			case EventVerify:
+				phases.next("verify", attribute.Int("pieces", pieces))
+			case EventPeers:
+				metricPexPeers.Add(float64(len(event.Peers)))
+				if w.pex != nil {
+					w.pex(event.Peers)
+				}
 			}
 		case <-ctx.Done():
-			return nil, errors.New("TCP timeout")
+			return nil, &FetchError{Failure: FailTimeout, Reason: "TCP timeout"}
 		}
 	}
 }
 
-func (w *Wire) fromHTTP(hash Hash) (*MetadataResult, error) {
+func (w *Wire) fromHTTP(ctx context.Context, hash Hash) (result *MetadataResult, err error) {
+	ctx, span := spanTracer(ctx, w.tracer()).Start(ctx, "fetch.http", trace.WithAttributes(hashAttr(hash)))
+	defer func() {
+		traceError(span, err)
+		span.End()
+	}()
 	hex := hash.Hex()
 	url := fmt.Sprintf("%s/%s/%s/%s.torrent", Url, hex[:2], hex[38:], hex)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -319,222 +654,5 @@ func (w *Wire) fromHTTP(hash Hash) (*MetadataResult, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	dec := bencode.NewDecoder(resp.Body)
-	metadata := new(MetaData)
-	err = dec.Decode(metadata)
-	if err != nil {
-		return nil, err
-	}
-	metadata.MetaInfo.Hash = hash
-	return metadata.MetaInfo, nil
-}
-
-func (p *Processor) Write(data []byte) (int, error) {
-	p.Size += len(data)
-	p.Data = append(p.Data, data)
-	for p.Size >= p.HandlerSize {
-		buf := bytes.Join(p.Data, []byte{})
-		p.Size -= p.HandlerSize
-		if p.Size == 0 {
-			p.Data = [][]byte{}
-		} else {
-			p.Data = [][]byte{buf[p.HandlerSize:]}
-		}
-		p.Handler(buf[:p.HandlerSize])
-	}
-	return len(data), nil
-}
-
-func (p *Processor) Start(hash Hash) {
-	p.Hash = hash
-	p.push(p.packetHandshakeData())
-	p.handleHandshake()
-}
-
-func (p *Processor) process(size int, handler DataHandler) {
-	p.HandlerSize = size
-	p.Handler = handler
-}
-
-func (p *Processor) End(reason string) {
-	p.event <- NewErrorEvent(reason, p.Hash)
-}
-
-func (p *Processor) handleHandshake() {
-	p.process(1, func(data []byte) {
-		length := int(data[0])
-		p.process(length+48, func(data []byte) {
-			protocol := data[:length]
-			if string(protocol) != BtProtocol {
-				p.End("this is not BitTorrent protocol")
-				return
-			}
-			reserved := data[length:]
-			if reserved[5]&0x10 == 0 {
-				p.End("peer reject")
-				return
-			}
-			p.event <- &Event{Type: EventHandshake}
-			p.process(4, p.handleHead)
-			p.push(p.packetExtendedData())
-		})
-	})
-}
-
-func (p *Processor) handleHead(data []byte) {
-	var length uint32
-	binary.Read(bytes.NewReader(data), binary.BigEndian, &length)
-	if int(length) > 0 {
-		p.process(int(length), p.handleBody)
-	}
-}
-
-func (p *Processor) handleBody(data []byte) {
-	p.process(4, p.handleHead)
-	if data[0] == BtMessageID {
-		p.handleExtended(data[1], data[2:])
-	}
-}
-
-func (p *Processor) handleExtended(ext byte, data []byte) {
-	if ext == byte(0) {
-		val := make(map[string]interface{})
-		err := bencode.DecodeBytes(data, &val)
-		if err != nil {
-			p.End(fmt.Sprintf("decode extended meta info error %s", err.Error()))
-			return
-		}
-		p.handleExtHandshake(val)
-	} else {
-		p.handlePiece(data)
-	}
-}
-
-func (p *Processor) handleExtHandshake(ext map[string]interface{}) {
-	p.event <- &Event{Type: EventExtended}
-	if size, ok := ext["metadata_size"].(int64); ok {
-		if m, ok := ext["m"].(map[string]interface{}); ok {
-			if meta, ok := m["ut_metadata"].(int64); ok {
-				p.utmetadata = int(meta)
-
-				if p.utmetadata == 0 || size <= 0 || size > MaxMetadataSize {
-					p.End(fmt.Sprintf("extended invalid metadata_size:%d, ut_metadata:%d", size, p.utmetadata))
-					return
-				}
-
-				pieceLength := int(math.Ceil(float64(size) / float64(PieceSize)))
-				p.metadata = make([][]byte, pieceLength)
-				for i := 0; i < pieceLength; i++ {
-					p.push(p.packetPieceRequestData(i))
-				}
-			}
-		}
-	}
-}
-
-func (p *Processor) handlePiece(data []byte) {
-	p.event <- &Event{Type: EventPiece}
-	i := bytes.Index(data, []byte{101, 101}) + 2
-	if i == 1 {
-		p.End("invalid piece info dict")
-		return
-	}
-	info := make(map[string]interface{})
-	err := bencode.DecodeBytes(data[0:i], &info)
-	if err != nil {
-		p.End(fmt.Sprintf("decode piece dict error, %s", err.Error()))
-		return
-	}
-	piece := data[i:]
-
-	if t, ok := info["msg_type"].(int64); !ok || t != int64(1) {
-		p.End(fmt.Sprintf("invalid msg_type: %d", t))
-		return
-	}
-
-	n, ok := info["piece"].(int64)
-	if !ok {
-		p.End("invalid piece")
-		return
-	}
-
-	if len(piece) > PieceSize {
-		p.End("invalid piece size")
-		return
-	}
-
-	p.metadata[int(n)] = piece
-	if p.isDone() {
-		p.handleDone()
-	}
-}
-
-func (p *Processor) isDone() (b bool) {
-	for _, piece := range p.metadata {
-		if len(piece) == 0 {
-			return false
-		}
-	}
-	return true
-}
-
-func (p *Processor) handleDone() {
-	data := bytes.Join(p.metadata, []byte{})
-	s := sha1.Sum(data)
-	if p.Hash.Hex() == fmt.Sprintf("%X", s) {
-		result := new(MetadataResult)
-		decoder := bencode.NewDecoder(bytes.NewReader(data))
-		err := decoder.Decode(&result)
-		if err != nil {
-			p.End(fmt.Sprintf("Decode metadata error %s", err.Error()))
-			return
-		}
-		result.Hash = p.Hash
-		p.Conn.Close()
-		p.event <- &Event{Type: EventDone, Result: result}
-	}
-}
-
-func (p *Processor) packetHandshakeData() []byte {
-	data := bytes.NewBuffer([]byte{})
-	data.WriteByte(byte(0x13))
-	data.WriteString(BtProtocol)
-	data.Write(BtReserved)
-	data.WriteString(string(p.Hash))
-	data.Write([]byte(NewNodeID()))
-	return data.Bytes()
-}
-
-func (p *Processor) packetExtendedData() []byte {
-	body := bytes.NewBuffer([]byte{})
-	body.WriteByte(BtMessageID)
-	body.WriteByte(BtExtendedID)
-
-	meta, _ := bencode.EncodeBytes(map[string]interface{}{"m": map[string]interface{}{"ut_metadata": 1}})
-	body.Write(meta)
-
-	data := bytes.NewBuffer([]byte{})
-	binary.Write(data, binary.BigEndian, uint32(body.Len()))
-	data.Write(body.Bytes())
-
-	return data.Bytes()
-}
-
-func (p *Processor) packetPieceRequestData(i int) []byte {
-	body := bytes.NewBuffer([]byte{})
-	body.WriteByte(BtMessageID)
-	body.WriteByte(byte(p.utmetadata))
-
-	meta, _ := bencode.EncodeBytes(map[string]interface{}{"msg_type": 0, "piece": i})
-	body.Write(meta)
-
-	data := bytes.NewBuffer([]byte{})
-	binary.Write(data, binary.BigEndian, uint32(body.Len()))
-	data.Write(body.Bytes())
-
-	return data.Bytes()
-}
-
-func (p *Processor) push(b []byte) {
-	p.Conn.Write(b)
+	return decodeTorrentFile(resp.Body, hash)
 }
@@ -0,0 +1,340 @@
+package DHTCrawl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/AlanYang/DHTCrawl/testutil"
+	"github.com/zeebo/bencode"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// fakePeer serves info over ut_metadata to every connection until the test
+// ends, it returns the infohash and the address to dial.
+func fakePeer(t *testing.T, info []byte) (Hash, *net.TCPAddr) {
+	return scriptedPeer(t, testutil.NewPeer(info))
+}
+
+func scriptedPeer(t *testing.T, p *testutil.Peer) (Hash, *net.TCPAddr) {
+	addr := testutil.StartPeer(t, p)
+	return Hash(p.InfoHash()), addr
+}
+
+func Test_FetchFromPeer(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "test.mkv", "length": 1024, "piece length": 16384, "pieces": ""})
+	hash, addr := fakePeer(t, info)
+	r, err := (&Wire{}).fromPeer(context.Background(), hash, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "test.mkv" || !r.Verify() {
+		t.Error("result", r.Name)
+	}
+}
+
+func Test_FetchPieces(t *testing.T) {
+	//three pieces, the last one short
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "big.iso", "length": 1 << 30, "piece length": 1 << 20, "pieces": strings.Repeat("x", 2*PieceSize+100)})
+	hash, addr := fakePeer(t, info)
+	r, err := (&Wire{}).fromPeer(context.Background(), hash, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "big.iso" || !bytes.Equal(r.Info, info) {
+		t.Error("result", r.Name, len(r.Info))
+	}
+}
+
+func Test_FetchInvalid(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "../../.bashrc", "length": 10, "piece length": 16384, "pieces": ""})
+	hash, addr := fakePeer(t, info)
+	_, err := (&Wire{}).fromPeer(context.Background(), hash, addr)
+	var me *MetadataError
+	if fetchFailure(err) != FailInvalid || !errors.As(err, &me) || me.Field != "name" {
+		t.Error(err)
+	}
+}
+
+func Test_FetchLimits(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": strings.Repeat("n", 2000), "length": 10, "piece length": 16384, "pieces": ""})
+	hash, addr := fakePeer(t, info)
+	for _, limits := range []*wireLimits{{metadata: 1000}, {message: 1000}} {
+		_, err := (&Wire{limits: limits}).fromPeer(context.Background(), hash, addr)
+		if fetchFailure(err) != FailTooLarge || !errors.Is(err, ErrLimitExceeded) {
+			t.Error(limits, err)
+		}
+	}
+	if _, err := (&Wire{limits: &wireLimits{metadata: 4000}}).fromPeer(context.Background(), hash, addr); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_FetchScripted(t *testing.T) {
+	//three pieces, the last one short
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "scripted", "length": 10, "piece length": 16384, "pieces": strings.Repeat("x", 2*PieceSize+100)})
+	for name, c := range map[string]struct {
+		peer    *testutil.Peer
+		timeout bool //the peer never finishes, the download ends with ctx
+		failure string
+	}{
+		"http":         {peer: &testutil.Peer{Handshake: []byte("HTTP/1.1 400 Bad Request\r\n" + strings.Repeat(" ", 100))}, failure: FailNotBitTorrent},
+		"no extension": {peer: &testutil.Peer{NoExtensions: true}, failure: FailRejected},
+		"no metadata":  {peer: &testutil.Peer{Extension: map[string]interface{}{"m": map[string]interface{}{"ut_pex": 1}}}, failure: FailRejected},
+		"bad dict":     {peer: &testutil.Peer{RawExtension: []byte("d1:m")}, failure: FailBadPiece},
+		"reject":       {peer: &testutil.Peer{Reject: true}, failure: FailBadPiece},
+		"short piece": {peer: &testutil.Peer{Piece: func(i int, m []byte) []byte {
+			return m[:len(m)-1]
+		}}, failure: FailBadPiece},
+		"corrupt piece": {peer: &testutil.Peer{Piece: func(i int, m []byte) []byte {
+			m[len(m)-1] ^= 0xff
+			return m
+		}}, failure: FailHashMismatch},
+		"silent":  {peer: &testutil.Peer{Silent: true}, timeout: true, failure: FailTimeout},
+		"slow":    {peer: &testutil.Peer{Delay: 200 * time.Millisecond}, timeout: true, failure: FailTimeout},
+		"hang up": {peer: &testutil.Peer{CloseAfter: 1}, timeout: true, failure: FailTimeout},
+		"dropped piece": {peer: &testutil.Peer{Piece: func(i int, m []byte) []byte {
+			if i == 1 {
+				return nil
+			}
+			return m
+		}}, timeout: true, failure: FailTimeout},
+	} {
+		c.peer.Info = info
+		hash, addr := scriptedPeer(t, c.peer)
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.timeout {
+			ctx, cancel = context.WithTimeout(ctx, 300*time.Millisecond)
+		}
+		_, err := (&Wire{}).fromPeer(ctx, hash, addr)
+		cancel()
+		if got := fetchFailure(err); got != c.failure {
+			t.Errorf("%s: %s (%v), want %s", name, got, err, c.failure)
+		}
+	}
+
+	peer := testutil.NewPeer(info)
+	peer.Delay = 10 * time.Millisecond
+	hash, addr := scriptedPeer(t, peer)
+	if r, err := (&Wire{}).fromPeer(context.Background(), hash, addr); err != nil || !bytes.Equal(r.Info, info) {
+		t.Fatal("slow peer within the timeout", err)
+	}
+	if peer.Conns() != 1 || peer.Requests() != 3 {
+		t.Error("conns and requests", peer.Conns(), peer.Requests())
+	}
+}
+
+func Test_FetchTimeoutReader(t *testing.T) {
+	// the peer keeps sending after the deadline, the reader must not be left
+	// blocked on the events nobody receives any more
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "slow", "length": 10, "piece length": 16384, "pieces": strings.Repeat("x", 2*PieceSize+100)})
+	hash, addr := scriptedPeer(t, &testutil.Peer{Info: info, Delay: 50 * time.Millisecond})
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+		_, err := (&Wire{}).fromPeer(ctx, hash, addr)
+		cancel()
+		if fetchFailure(err) != FailTimeout {
+			t.Fatal(err)
+		}
+	}
+	n := 0
+	for i := 0; i < 100; i++ {
+		if n = runtime.NumGoroutine(); n <= before {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n > before {
+		t.Error("goroutines left", n-before)
+	}
+}
+
+func Test_FetchPex(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "swarm", "length": 10, "piece length": 16384, "pieces": ""})
+	good := testutil.NewPeer(info)
+	hash, goodAddr := scriptedPeer(t, good)
+	//the peer rejects every piece, it knows one who doesn't
+	bad := &testutil.Peer{Info: info, Reject: true}
+	_, badAddr := scriptedPeer(t, bad)
+	bad.Pex = []*net.TCPAddr{goodAddr, badAddr, {IP: net.ParseIP("::1"), Port: goodAddr.Port}}
+
+	peers := make(chan *net.TCPAddr, 1)
+	peers <- badAddr
+	close(peers)
+	r, err := RaceMetadata(context.Background(), hash, peers, 1)
+	if err != nil || r.Name != "swarm" {
+		t.Fatal("race through the exchanged peer", err)
+	}
+	if bad.Conns() != 1 || good.Conns() != 1 {
+		t.Error("conns", bad.Conns(), good.Conns())
+	}
+
+	w := NewWire(NewQueue("jobs", 1, QueueBlock), make(chan *MetadataResult, 1))
+	if r, err := w.Download(NewJob(hash, badAddr)); err != nil || r.Source != goodAddr.String() {
+		t.Fatal("pool download through the exchanged peer", err)
+	}
+	if bad.Conns() != 2 || good.Conns() != 2 {
+		t.Error("conns", bad.Conns(), good.Conns())
+	}
+}
+
+func Test_FetchTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	w := &Wire{Tracer: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")}
+
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "traced", "length": 40000, "piece length": 16384, "pieces": ""})
+	hash, addr := fakePeer(t, info)
+	ctx, span := w.Tracer.Start(context.Background(), "fetch")
+	if _, err := w.fromPeer(ctx, hash, addr); err != nil {
+		t.Fatal(err)
+	}
+	span.End()
+	//the bad hash fails in the verify step
+	if _, err := w.fromPeer(context.Background(), Hash(NewNodeID()), addr); err == nil || err.Error() != "metadata hash mismatch" {
+		t.Error("mismatch", err)
+	}
+
+	names := map[string]int{}
+	var peer, verify sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		names[s.Name()]++
+		if s.Name() == "fetch.peer" && peer == nil {
+			peer = s
+		}
+		if s.Name() == "verify" && verify == nil {
+			verify = s
+		}
+	}
+	for _, name := range []string{"fetch", "dial", "handshake", "extended_handshake", "pieces", "verify"} {
+		if names[name] == 0 {
+			t.Error("no span", name, names)
+		}
+	}
+	if peer == nil || peer.Parent().SpanID() != span.SpanContext().SpanID() {
+		t.Fatal("fetch.peer is not a child of fetch")
+	}
+	attrs := map[string]string{}
+	for _, kv := range peer.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["infohash"] != hash.Hex() || attrs["peer"] != addr.String() || attrs["metadata.size"] != fmt.Sprint(len(info)) {
+		t.Error("attributes", attrs)
+	}
+	if verify.Parent().SpanID() != peer.SpanContext().SpanID() {
+		t.Error("verify is not a step of fetch.peer")
+	}
+	ended := recorder.Ended()
+	if last := ended[len(ended)-1]; last.Name() != "fetch.peer" || last.Status().Description != "metadata hash mismatch" {
+		t.Error("failed span", last.Name(), last.Status())
+	}
+}
+
+func Test_TracerFollowsProvider(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+	// a second provider replaces the first, as with a second SetupTracing
+	for i := 0; i < 2; i++ {
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		_, span := tracer().Start(context.Background(), "fetch")
+		span.End()
+		if len(recorder.Ended()) != 1 {
+			t.Error("provider", i, "got no span")
+		}
+	}
+}
+
+func Test_FetchStats(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "counted", "length": 1, "piece length": 16384, "pieces": ""})
+	hash, addr := fakePeer(t, info)
+	pool := NewWireJob(0, 0)
+	defer pool.Stop()
+	w := newWire(pool.Jobs, nil, pool)
+	if _, err := w.fromPeer(context.Background(), hash, addr); err != nil {
+		t.Fatal(err)
+	}
+	w.fromPeer(context.Background(), Hash(NewNodeID()), addr)
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	w.fromPeer(context.Background(), hash, closed.Addr().(*net.TCPAddr))
+
+	st := pool.Stats()
+	t.Log(st)
+	if st.Attempts != 3 || st.Succeeded != 1 || st.Failed != 2 || st.Handshakes != 2 {
+		t.Error("counts", st)
+	}
+	if st.Failures[FailHashMismatch] != 1 || st.Failures[FailDial] != 1 {
+		t.Error("failures", st.Failures)
+	}
+	//the dial failed before any peer id
+	if st.ByClient["qBittorrent"][FailHashMismatch] != 1 || st.ByClient["unknown"][FailDial] != 1 {
+		t.Error("by client", st.ByClient)
+	}
+	if st.ByNetwork["127.0.0.0/16"][FailHashMismatch] != 1 || len(st.ByNetwork) != 1 {
+		t.Error("by network", st.ByNetwork)
+	}
+	if st.HandshakeLatency <= 0 || st.BytesRead < uint64(2*len(info)) || st.BytesWritten == 0 {
+		t.Error("handshake and traffic", st)
+	}
+}
+
+func Test_FetchTiming(t *testing.T) {
+	info, _ := bencode.EncodeBytes(map[string]interface{}{"name": "timed", "length": 1, "piece length": 16384, "pieces": ""})
+	hash, addr := fakePeer(t, info)
+	r, err := (&Wire{}).fromPeer(context.Background(), hash, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := r.Timing
+	if tm == nil || tm.Peer != addr.String() || tm.Failure != "" || len(tm.Pieces) != 1 {
+		t.Fatalf("timing %+v", tm)
+	}
+	if steps := tm.Dial + tm.Handshake + tm.Extended + tm.Pieces[0]; tm.Dial <= 0 || tm.Handshake <= 0 || steps > tm.Total {
+		t.Errorf("steps %+v", tm)
+	}
+
+	_, err = (&Wire{}).fromPeer(context.Background(), Hash(NewNodeID()), addr)
+	var fe *FetchError
+	if !errors.As(err, &fe) || fe.Timing == nil || fe.Timing.Failure != FailHashMismatch || fe.Timing.Handshake <= 0 {
+		t.Fatal("failed attempt", err)
+	}
+
+}
+
+func Test_FailureGroups(t *testing.T) {
+	for id, client := range map[string]string{
+		"-UT3550-abcdefghijkl": "uTorrent",
+		"-ZZ0100-abcdefghijkl": "ZZ",
+		"-$$0100-abcdefghijkl": "unknown",
+		"M4-3-6--abcdefghijkl": "unknown",
+	} {
+		if got := peerClient([]byte(id)); got != client {
+			t.Error(id, got)
+		}
+	}
+	for ip, n := range map[string]string{
+		"1.2.3.4":            "1.2.0.0/16",
+		"::ffff:10.20.30.40": "10.20.0.0/16",
+		"2001:db8:1:2::1":    "2001:db8::/32",
+	} {
+		if got := network(net.ParseIP(ip)); got != n {
+			t.Error(ip, got)
+		}
+	}
+	b := FailureBreakdown{}
+	for i := 0; i < fetchGroupsMax+10; i++ {
+		b.add(fmt.Sprint(i), FailDial)
+	}
+	if len(b) != fetchGroupsMax+1 || b["other"][FailDial] != 10 {
+		t.Error("groups", len(b), b["other"])
+	}
+}
@@ -0,0 +1,187 @@
+package DHTCrawl
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteTimeout = time.Second * 10
+	wsPingInterval = time.Second * 30
+	wsQueueSize    = 256
+	wsReadLimit    = 4096 //bytes of a filter sent by the client
+)
+
+type (
+	// wsFilter selects what a WebSocket client receives. It is read from the
+	// query string and can be replaced by the client at any time by sending
+	// it as a JSON message.
+	wsFilter struct {
+		Announces bool     `json:"announces"`
+		Category  string   `json:"category"`
+		MinSize   int64    `json:"min_size"`
+		Keywords  []string `json:"keywords"` //every keyword must be in the name
+	}
+
+	wsMessage struct {
+		Type string      `json:"type"` //metadata or announce
+		Data interface{} `json:"data"`
+	}
+
+	wsAnnounce struct {
+		Hash string   `json:"hash"`
+		Peer string   `json:"peer"`
+		Time string   `json:"time"`
+		Geo  *PeerGeo `json:"geo,omitempty"`
+	}
+)
+
+// wsUpgrader accepts the connections of the origin of the server and of the
+// ones of ws_origins, "*" there allows any. A request without an Origin
+// header isn't from a browser and is accepted.
+func (s *Server) wsUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			for _, allowed := range s.Crawler.config().WSOrigins {
+				if allowed == "*" || strings.EqualFold(allowed, origin) {
+					return true
+				}
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
+	}
+}
+
+func parseWSFilter(r *http.Request) wsFilter {
+	v := r.URL.Query()
+	f := wsFilter{Category: v.Get("category")}
+	f.Announces, _ = strconv.ParseBool(v.Get("announces"))
+	f.MinSize, _ = strconv.ParseInt(v.Get("min_size"), 10, 64)
+	if k := v.Get("keywords"); k != "" {
+		f.Keywords = strings.Fields(k)
+	}
+	return f
+}
+
+func (f *wsFilter) match(r *MetadataResult) bool {
+	if f.Category != "" && r.Category != f.Category {
+		return false
+	}
+	if f.MinSize > 0 && r.TotalLength() < f.MinSize {
+		return false
+	}
+	name := strings.ToLower(r.Name)
+	for _, k := range f.Keywords {
+		if !strings.Contains(name, strings.ToLower(k)) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleWS streams new results, and announces when asked, to a WebSocket
+// client. A client which can't keep up loses events, once it has lost any
+// of a stream it receives it is disconnected so it knows its view is
+// incomplete.
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.wsUpgrader().Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(wsReadLimit)
+
+	var mu sync.Mutex
+	filter := parseWSFilter(r)
+	// the announces are queued only while the client wants them
+	sub := s.Crawler.Hub.Subscribe(wsQueueSize, filter.Announces)
+	defer sub.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			f := wsFilter{}
+			if err := conn.ReadJSON(&f); err != nil {
+				return
+			}
+			mu.Lock()
+			filter = f
+			mu.Unlock()
+			sub.SetAnnounces(f.Announces)
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		var msg interface{}
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		case v, ok := <-sub.C():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"), time.Now().Add(time.Second))
+				return
+			}
+			mu.Lock()
+			f := filter
+			mu.Unlock()
+			switch v := v.(type) {
+			case *MetadataResult:
+				if f.match(v) {
+					msg = wsMessage{Type: "metadata", Data: newAPITorrent(v)}
+				}
+			case *Announce:
+				if f.Announces {
+					a := wsAnnounce{Hash: v.Hash.Hex(), Time: v.Time.Format(time.RFC3339), Geo: v.Geo}
+					if v.Peer != nil {
+						a.Peer = v.Peer.String()
+					}
+					msg = wsMessage{Type: "announce", Data: a}
+				}
+			}
+		}
+		if stream := wsLagging(sub); stream != "" {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer of "+stream), time.Now().Add(time.Second))
+			return
+		}
+		if msg == nil {
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// wsLagging names the stream sub lost events of, empty when it lost none.
+func wsLagging(sub *Subscription) string {
+	switch {
+	case sub.DroppedResults() > 0:
+		return "metadata"
+	case sub.DroppedAnnounces() > 0:
+		return "announces"
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package DHTCrawl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func Test_WS(t *testing.T) {
+	c := &Crawler{Config: NewDefaultConfig(), Hub: NewHub()}
+	ts := httptest.NewServer(NewServer(c, "").Mux)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?category=" + CategoryVideo + "&announces=1"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for c.Hub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	hash := func(i int) Hash { return Hash(NewNodeIDFromHex(fmt.Sprintf("%040X", i))) }
+	c.Hub.Put(&MetadataResult{Hash: hash(1), Name: "song", Category: CategoryAudio})
+	c.Hub.Put(&MetadataResult{Hash: hash(2), Name: "movie", Category: CategoryVideo})
+	c.Hub.PutAnnounce(&Announce{Hash: hash(3), Peer: &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 6881}, Time: time.Now()})
+
+	read := func() map[string]interface{} {
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		msg := map[string]interface{}{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	msg := read()
+	if data, _ := msg["data"].(map[string]interface{}); msg["type"] != "metadata" || data["name"] != "movie" {
+		t.Error("metadata", msg)
+	}
+	msg = read()
+	if data, _ := msg["data"].(map[string]interface{}); msg["type"] != "announce" || data["peer"] != "1.2.3.4:6881" {
+		t.Error("announce", msg)
+	}
+
+	// replace the filter, only names with the keyword and no announces
+	if err := conn.WriteJSON(wsFilter{Keywords: []string{"Live"}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	c.Hub.PutAnnounce(&Announce{Hash: hash(4), Time: time.Now()})
+	c.Hub.Put(&MetadataResult{Hash: hash(5), Name: "movie", Category: CategoryVideo})
+	c.Hub.Put(&MetadataResult{Hash: hash(6), Name: "song live", Category: CategoryAudio})
+	msg = read()
+	if data, _ := msg["data"].(map[string]interface{}); msg["type"] != "metadata" || data["name"] != "song live" {
+		t.Error("keywords", msg)
+	}
+
+	c.Hub.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Error("close", err)
+	}
+}
+
+func Test_WSStreams(t *testing.T) {
+	c := &Crawler{Config: NewDefaultConfig(), Hub: NewHub()}
+	c.Config.WSOrigins = []string{"https://dashboard.example.org"}
+	ts := httptest.NewServer(NewServer(c, "").Mux)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	dial := func(origin string) (*websocket.Conn, error) {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		return conn, err
+	}
+	if _, err := dial("https://evil.example.org"); err == nil {
+		t.Error("other origin accepted")
+	}
+	for _, origin := range []string{"", ts.URL, "https://dashboard.example.org"} {
+		conn, err := dial(origin)
+		if err != nil {
+			t.Fatal(origin, err)
+		}
+		conn.Close()
+	}
+
+	// a client of the metadata only never lags behind the announces
+	conn, err := dial("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for c.Hub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < wsQueueSize*2; i++ {
+		c.Hub.PutAnnounce(&Announce{Hash: testHash("announced"), Time: time.Now()})
+	}
+	c.Hub.Put(&MetadataResult{Hash: testHash("result"), Name: "result"})
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	msg := map[string]interface{}{}
+	if err := conn.ReadJSON(&msg); err != nil || msg["type"] != "metadata" {
+		t.Error("metadata", msg, err)
+	}
+}